@@ -9,6 +9,7 @@ import (
 	"mobile-payment-backend/internal/config"
 	"mobile-payment-backend/internal/database"
 	"mobile-payment-backend/internal/handlers"
+	"mobile-payment-backend/internal/middleware"
 	"mobile-payment-backend/internal/models"
 	"mobile-payment-backend/internal/repositories"
 	"mobile-payment-backend/internal/services"
@@ -37,9 +38,17 @@ func main() {
 	orderRepo := repositories.NewOrderRepository(database.DB)
 
 	// Validate required config
-	if cfg.MastercardMerchantID == "" || cfg.MastercardAPIPassword == "" {
+	if cfg.MastercardMerchantID == "" {
 		log.Fatal("Missing required Mastercard configuration")
 	}
+	if cfg.GatewayAuthMethod() == services.AuthMethodBasic && cfg.MastercardAPIPassword == "" {
+		log.Fatal("Missing required Mastercard configuration")
+	}
+
+	authenticator, err := services.NewAuthenticator(cfg)
+	if err != nil {
+		log.Fatal("Failed to configure gateway authentication:", err)
+	}
 
 	// Create SDK config for mobile app
 	sdkConfig := &models.MobileSDKConfig{
@@ -51,12 +60,12 @@ func main() {
 	}
 
 	// Initialize services
-	gatewayService := services.NewGatewayService(cfg, sessionRepo, transactionRepo, tokenRepo)
+	gatewayService := services.NewGatewayService(cfg, authenticator, sessionRepo, orderRepo, transactionRepo, tokenRepo)
 
 	// Initialize handlers
 	userHandler := handlers.NewUserHandler(userRepo)
 	orderHandler := handlers.NewOrderHandler(orderRepo, userRepo) // NEW
-	sessionHandler := handlers.NewSessionHandler(gatewayService, orderRepo, sessionRepo, sdkConfig)
+	sessionHandler := handlers.NewSessionHandler(gatewayService, orderRepo, sessionRepo, sdkConfig, cfg.SessionTTL())
 	paymentHandler := handlers.NewPaymentHandler(gatewayService)
 
 	// Setup Gin
@@ -78,7 +87,9 @@ func main() {
 		// Order management (NEW)
 		api.POST("/orders", orderHandler.CreateOrder)
 		api.GET("/orders/:id", orderHandler.GetOrder)
+		api.PATCH("/orders/:id/status", orderHandler.UpdateOrderStatus)
 		api.GET("/users/:id/orders", orderHandler.GetOrdersByUser)
+		api.GET("/orders/:id/checkout-config", sessionHandler.GetCheckoutConfig)
 
 		// Session management
 		api.POST("/sessions", sessionHandler.CreateSession)
@@ -89,10 +100,19 @@ func main() {
 		api.POST("/payments/process", paymentHandler.ProcessPayment)
 		api.POST("/payments/refund", paymentHandler.RefundPayment)
 
+		// 3DS challenge results from the ACS redirect
+		api.POST("/webhooks/3ds", paymentHandler.HandleAuthenticationResult)
+
 		// Webhooks (for future use)
 		api.POST("/webhooks/gateway", func(c *gin.Context) {
 			c.JSON(200, gin.H{"received": true})
 		})
+
+		// Admin (requires X-Admin-Key)
+		admin := api.Group("/admin", middleware.RequireAdmin(cfg))
+		{
+			admin.GET("/sessions", sessionHandler.ListSessions)
+		}
 	}
 
 	// Start server
@@ -0,0 +1,7 @@
+package config
+
+// AdminAPIKey is the shared secret admin endpoints expect in the X-Admin-Key
+// header (ADMIN_API_KEY). When unset, admin endpoints reject every request.
+func (c *Config) AdminAPIKey() string {
+	return envString("ADMIN_API_KEY", "")
+}
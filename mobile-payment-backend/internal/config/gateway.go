@@ -0,0 +1,48 @@
+package config
+
+import "time"
+
+// GatewayAuthMethod selects how requests to the gateway are authenticated:
+// "basic" with the merchant API password, or "certificate" with a TLS client
+// certificate (GATEWAY_AUTH_METHOD, default basic).
+func (c *Config) GatewayAuthMethod() string {
+	return envString("GATEWAY_AUTH_METHOD", "basic")
+}
+
+// GatewayClientCertFile is the PEM client certificate used for certificate
+// auth (GATEWAY_CLIENT_CERT_FILE)
+func (c *Config) GatewayClientCertFile() string {
+	return envString("GATEWAY_CLIENT_CERT_FILE", "")
+}
+
+// GatewayClientKeyFile is the PEM private key for GatewayClientCertFile
+// (GATEWAY_CLIENT_KEY_FILE)
+func (c *Config) GatewayClientKeyFile() string {
+	return envString("GATEWAY_CLIENT_KEY_FILE", "")
+}
+
+// GatewayMaxIdleConns caps idle keep-alive connections kept open to the
+// gateway (GATEWAY_MAX_IDLE_CONNS, default 100)
+func (c *Config) GatewayMaxIdleConns() int {
+	return envInt("GATEWAY_MAX_IDLE_CONNS", 100)
+}
+
+// GatewayMaxIdleConnsPerHost caps idle connections per gateway host
+// (GATEWAY_MAX_IDLE_CONNS_PER_HOST, default 20). Go's default of 2 means
+// concurrent calls during a billing run keep opening new TLS connections.
+func (c *Config) GatewayMaxIdleConnsPerHost() int {
+	return envInt("GATEWAY_MAX_IDLE_CONNS_PER_HOST", 20)
+}
+
+// GatewayIdleConnTimeout is how long an idle gateway connection is kept
+// before it is closed (GATEWAY_IDLE_CONN_TIMEOUT, default 90s)
+func (c *Config) GatewayIdleConnTimeout() time.Duration {
+	return envDuration("GATEWAY_IDLE_CONN_TIMEOUT", 90*time.Second)
+}
+
+// GatewayDisableKeepAlives opens a new connection for every gateway request,
+// which can help when debugging connection problems
+// (GATEWAY_DISABLE_KEEP_ALIVES, default false)
+func (c *Config) GatewayDisableKeepAlives() bool {
+	return envBool("GATEWAY_DISABLE_KEEP_ALIVES", false)
+}
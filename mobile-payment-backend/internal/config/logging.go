@@ -0,0 +1,8 @@
+package config
+
+// DebugGateway logs every gateway request and response at debug level
+// (DEBUG_GATEWAY, default false). Bodies are redacted before logging, but the
+// output is verbose, so leave this off in normal operation.
+func (c *Config) DebugGateway() bool {
+	return envBool("DEBUG_GATEWAY", false)
+}
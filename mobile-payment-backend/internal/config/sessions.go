@@ -0,0 +1,18 @@
+package config
+
+import "time"
+
+// SessionTTL is how long a payment session stays usable after it is created
+// (SESSION_TTL, default 30m). Raise it for checkout flows that take longer.
+func (c *Config) SessionTTL() time.Duration {
+	return envDuration("SESSION_TTL", 30*time.Minute)
+}
+
+// VerifySessionBeforePayment retrieves the payment session from the gateway
+// before each payment and rejects sessions that have expired, have no card
+// details or no order, instead of submitting the payment
+// (VERIFY_SESSION_BEFORE_PAYMENT, default false). Costs one extra gateway
+// call per payment.
+func (c *Config) VerifySessionBeforePayment() bool {
+	return envBool("VERIFY_SESSION_BEFORE_PAYMENT", false)
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"fmt"
 	"net/http"
+	"regexp"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -30,57 +31,89 @@ type CreateOrderRequest struct {
 	Amount      float64 `json:"amount" binding:"required,min=0.01"`
 	Currency    string  `json:"currency" binding:"required,len=3"`
 	Description string  `json:"description,omitempty"`
+
+	// Optional merchant-chosen reference; generated when omitted
+	ReferenceID string `json:"reference_id,omitempty"`
+}
+
+// referenceIDPattern is the format accepted for caller-supplied order references
+var referenceIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{2,39}$`)
+
+// maxReferenceAttempts bounds how often a generated reference is regenerated
+// after colliding with an existing order
+const maxReferenceAttempts = 3
+
+// newReferenceID generates a human-readable order reference
+func newReferenceID() string {
+	return fmt.Sprintf("ORD-%d-%s",
+		time.Now().Unix(),
+		uuid.New().String()[:8],
+	)
 }
 
 // CreateOrder creates a new order
 func (h *OrderHandler) CreateOrder(c *gin.Context) {
 	var req CreateOrderRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondValidationError(c, err)
+		return
+	}
+
+	if req.ReferenceID != "" && !referenceIDPattern.MatchString(req.ReferenceID) {
+		respondFieldErrors(c, http.StatusBadRequest, gin.H{
+			"reference_id": "must be 3-40 letters, digits, '-' or '_', starting with a letter or digit",
+		})
 		return
 	}
 
 	// Validate user exists
 	userID, err := uuid.Parse(req.UserID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		respondError(c, http.StatusBadRequest, "invalid user ID")
 		return
 	}
 
 	_, err = h.userRepo.GetByID(c.Request.Context(), userID)
 	if err != nil {
 		if _, ok := err.(*repositories.NotFoundError); ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			respondError(c, http.StatusNotFound, "user not found")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	// Generate unique reference ID
-	referenceID := fmt.Sprintf("ORD-%d-%s",
-		time.Now().Unix(),
-		uuid.New().String()[:8],
-	)
-
 	order := &models.Order{
 		UserID:      userID,
-		ReferenceID: referenceID,
+		ReferenceID: req.ReferenceID,
 		Amount:      req.Amount,
 		Currency:    req.Currency,
 		Description: req.Description,
-		Status:      "pending",
+		Status:      models.OrderStatusPending,
 		Metadata: map[string]interface{}{
 			"created_via": "api",
 			"ip_address":  c.ClientIP(),
 		},
 	}
 
-	if err := h.orderRepo.Create(c.Request.Context(), order); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "failed to create order",
-			"details": err.Error(),
-		})
+	for attempt := 1; ; attempt++ {
+		if req.ReferenceID == "" {
+			order.ReferenceID = newReferenceID()
+		}
+
+		err = h.orderRepo.Create(c.Request.Context(), order)
+		if _, ok := err.(*repositories.DuplicateError); ok && req.ReferenceID == "" && attempt < maxReferenceAttempts {
+			// Generated reference collided; try again with a fresh one
+			continue
+		}
+		break
+	}
+	if err != nil {
+		if _, ok := err.(*repositories.DuplicateError); ok {
+			respondError(c, http.StatusConflict, "an order with this reference ID already exists")
+			return
+		}
+		respondErrorDetails(c, http.StatusInternalServerError, models.ErrorCodeInternal, "failed to create order", gin.H{"cause": err.Error()})
 		return
 	}
 
@@ -97,17 +130,17 @@ func (h *OrderHandler) GetOrder(c *gin.Context) {
 
 	oid, err := uuid.Parse(orderID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid order ID"})
+		respondError(c, http.StatusBadRequest, "invalid order ID")
 		return
 	}
 
 	order, err := h.orderRepo.GetByID(c.Request.Context(), oid)
 	if err != nil {
 		if _, ok := err.(*repositories.NotFoundError); ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+			respondError(c, http.StatusNotFound, "order not found")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -126,13 +159,13 @@ func (h *OrderHandler) GetOrdersByUser(c *gin.Context) {
 
 	uid, err := uuid.Parse(userID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		respondError(c, http.StatusBadRequest, "invalid user ID")
 		return
 	}
 
 	orders, err := h.orderRepo.GetByUserID(c.Request.Context(), uid)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -142,3 +175,58 @@ func (h *OrderHandler) GetOrdersByUser(c *gin.Context) {
 		"count":   len(orders),
 	})
 }
+
+// UpdateOrderStatusRequest moves an order to a new status
+type UpdateOrderStatusRequest struct {
+	Status string `json:"status" binding:"required,oneof=pending paid failed voided refunded"`
+}
+
+// UpdateOrderStatus changes an order's status, rejecting transitions the
+// order lifecycle doesn't allow (e.g. refunded back to pending)
+func (h *OrderHandler) UpdateOrderStatus(c *gin.Context) {
+	oid, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid order ID")
+		return
+	}
+
+	var req UpdateOrderStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	order, err := h.orderRepo.GetByID(c.Request.Context(), oid)
+	if err != nil {
+		if _, ok := err.(*repositories.NotFoundError); ok {
+			respondError(c, http.StatusNotFound, "order not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !models.CanTransitionOrderStatus(order.Status, req.Status) {
+		respondError(c, http.StatusConflict, fmt.Sprintf("cannot change order status from %q to %q", order.Status, req.Status))
+		return
+	}
+
+	if err := h.orderRepo.UpdateStatus(c.Request.Context(), oid, order.Status, req.Status); err != nil {
+		switch err.(type) {
+		case *repositories.NotFoundError:
+			respondError(c, http.StatusNotFound, "order not found")
+		case *repositories.ConflictError:
+			respondError(c, http.StatusConflict, fmt.Sprintf("order status changed from %q while updating it", order.Status))
+		default:
+			respondError(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	order.Status = req.Status
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"order":   order,
+	})
+}
@@ -2,12 +2,13 @@ package handlers
 
 import (
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 
 	"mobile-payment-backend/internal/models"
+	"mobile-payment-backend/internal/repositories"
 	"mobile-payment-backend/internal/services"
 )
 
@@ -28,13 +29,16 @@ type ProcessPaymentRequest struct {
 	Operation string `json:"operation" binding:"required,oneof=PAY AUTHORIZE"`
 	Amount    string `json:"amount,omitempty"`
 	Currency  string `json:"currency,omitempty"`
+
+	// Save the card for reuse if the payment succeeds
+	SaveCard bool `json:"save_card,omitempty"`
 }
 
 // ProcessPayment processes the final payment
 func (h *PaymentHandler) ProcessPayment(c *gin.Context) {
 	var req ProcessPaymentRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondValidationError(c, err)
 		return
 	}
 
@@ -44,15 +48,17 @@ func (h *PaymentHandler) ProcessPayment(c *gin.Context) {
 		Operation: req.Operation,
 		Amount:    req.Amount,
 		Currency:  req.Currency,
+		SaveCard:  req.SaveCard,
 	}
 
 	// Process payment through gateway
-	paymentResp, err := h.gatewayService.ProcessPayment(paymentReq)
+	paymentResp, err := h.gatewayService.ProcessPayment(c.Request.Context(), paymentReq)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "payment processing failed",
-			"details": err.Error(),
-		})
+		if e, ok := err.(*services.ValidationError); ok {
+			respondFieldErrors(c, http.StatusBadRequest, gin.H{e.Field: e.Error()})
+			return
+		}
+		respondErrorDetails(c, http.StatusInternalServerError, models.ErrorCodeInternal, "payment processing failed", gin.H{"cause": err.Error()})
 		return
 	}
 
@@ -66,26 +72,105 @@ func (h *PaymentHandler) ProcessPayment(c *gin.Context) {
 	})
 }
 
-// RefundPayment handles refunds
-func (h *PaymentHandler) RefundPayment(c *gin.Context) {
-	var req struct {
-		OrderID       string `json:"order_id" binding:"required"`
-		TransactionID string `json:"transaction_id" binding:"required"`
-		Amount        string `json:"amount" binding:"required"`
-		Currency      string `json:"currency" binding:"required"`
+// AuthenticationResultRequest names the 3DS authentication that finished for
+// a payment. The ACS redirect posts it as form fields; gateway notifications
+// send JSON. Any result the caller posts is ignored: the endpoint is public,
+// so the outcome is retrieved from the gateway.
+type AuthenticationResultRequest struct {
+	OrderID       string `form:"order.id" json:"order_id" binding:"required"`
+	TransactionID string `form:"transaction.id" json:"transaction_id" binding:"required"`
+}
+
+// HandleAuthenticationResult completes or fails a payment that was waiting
+// for the payer to finish a 3DS challenge
+func (h *PaymentHandler) HandleAuthenticationResult(c *gin.Context) {
+	var req AuthenticationResultRequest
+	if err := c.ShouldBind(&req); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	paymentResp, err := h.gatewayService.CompleteAuthentication(
+		c.Request.Context(),
+		req.OrderID,
+		req.TransactionID,
+	)
+	if err != nil {
+		if _, ok := err.(*repositories.NotFoundError); ok {
+			respondError(c, http.StatusNotFound, err.Error())
+			return
+		}
+		respondErrorDetails(c, http.StatusInternalServerError, models.ErrorCodeInternal, "payment processing failed", gin.H{"cause": err.Error()})
+		return
 	}
 
+	c.JSON(http.StatusOK, gin.H{
+		"success":   paymentResp.Success,
+		"payment":   paymentResp,
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// RefundPaymentRequest refunds a captured order. The idempotency key may be
+// sent in the Idempotency-Key header instead of the body.
+type RefundPaymentRequest struct {
+	OrderID        string `json:"order_id" binding:"required"`
+	TransactionID  string `json:"transaction_id" binding:"required"`
+	Amount         string `json:"amount" binding:"required"`
+	Currency       string `json:"currency" binding:"required"`
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// RefundPayment handles refunds. Retries with the same idempotency key return
+// the original refund instead of refunding again.
+func (h *PaymentHandler) RefundPayment(c *gin.Context) {
+	var req RefundPaymentRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondValidationError(c, err)
 		return
 	}
 
-	// Refund logic here
-	// Similar to ProcessPayment but with REFUND operation
+	idempotencyKey := c.GetHeader("Idempotency-Key")
+	if idempotencyKey == "" {
+		idempotencyKey = req.IdempotencyKey
+	}
+	if idempotencyKey == "" {
+		respondFieldErrors(c, http.StatusBadRequest, gin.H{"idempotency_key": "is required"})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success":   true,
-		"message":   "Refund initiated",
-		"refund_id": uuid.New().String(),
+	amount, err := strconv.ParseFloat(req.Amount, 64)
+	if err != nil || amount <= 0 {
+		respondFieldErrors(c, http.StatusBadRequest, gin.H{"amount": "must be a positive number"})
+		return
+	}
+
+	result, err := h.gatewayService.RefundPayment(c.Request.Context(), &models.RefundRequest{
+		OrderID:        req.OrderID,
+		Amount:         amount,
+		Currency:       req.Currency,
+		IdempotencyKey: idempotencyKey,
+	})
+	if err != nil {
+		switch e := err.(type) {
+		case *services.ValidationError:
+			respondFieldErrors(c, http.StatusBadRequest, gin.H{e.Field: e.Error()})
+		case *services.ConflictError:
+			respondError(c, http.StatusConflict, e.Error())
+		default:
+			respondErrorDetails(c, http.StatusInternalServerError, models.ErrorCodeInternal, "refund failed", gin.H{"cause": err.Error()})
+		}
+		return
+	}
+
+	status := http.StatusOK
+	if !result.Duplicate {
+		status = http.StatusCreated
+	}
+
+	c.JSON(status, gin.H{
+		"success":   result.Refund.Status == models.TransactionStatusSucceeded,
+		"refund_id": result.Refund.ID,
+		"result":    result,
 	})
 }
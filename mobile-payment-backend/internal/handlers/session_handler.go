@@ -3,6 +3,7 @@ package handlers
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -18,6 +19,7 @@ type SessionHandler struct {
 	orderRepo      repositories.OrderRepository
 	sessionRepo    repositories.SessionRepository
 	cfg            *models.MobileSDKConfig
+	sessionTTL     time.Duration
 }
 
 func NewSessionHandler(
@@ -25,12 +27,14 @@ func NewSessionHandler(
 	orderRepo repositories.OrderRepository,
 	sessionRepo repositories.SessionRepository,
 	cfg *models.MobileSDKConfig,
+	sessionTTL time.Duration,
 ) *SessionHandler {
 	return &SessionHandler{
 		gatewayService: gatewayService,
 		orderRepo:      orderRepo,
 		sessionRepo:    sessionRepo,
 		cfg:            cfg,
+		sessionTTL:     sessionTTL,
 	}
 }
 
@@ -40,24 +44,51 @@ type CreateSessionRequest struct {
 	// Amount           float64 `json:"amount" binding:"required,min=0.01"`
 	// Currency         string  `json:"currency" binding:"required,len=3"`
 	UserID string `json:"user_id,omitempty"`
+
+	// 3-D Secure settings; omit for an in-app payment
+	Authentication *SessionAuthenticationRequest `json:"authentication,omitempty"`
+}
+
+// SessionAuthenticationRequest selects how the payer authenticates, e.g.
+// channel PAYER_BROWSER for a web checkout
+type SessionAuthenticationRequest struct {
+	Channel        string `json:"channel,omitempty" binding:"omitempty,oneof=PAYER_APP PAYER_BROWSER MERCHANT_REQUESTED"`
+	Purpose        string `json:"purpose,omitempty" binding:"omitempty,oneof=PAYMENT_TRANSACTION ADD_CARD REFRESH_AUTHENTICATION"`
+	AcceptVersions string `json:"accept_versions,omitempty"`
 }
 
 // CreateSession creates a new payment session
 func (h *SessionHandler) CreateSession(c *gin.Context) {
 	var req CreateSessionRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondValidationError(c, err)
 		return
 	}
 
+	var auth models.AuthenticationParams
+	if req.Authentication != nil {
+		auth = models.AuthenticationParams{
+			AcceptVersions: req.Authentication.AcceptVersions,
+			Channel:        req.Authentication.Channel,
+			Purpose:        req.Authentication.Purpose,
+		}
+		if auth.AcceptVersions != "" && !models.IsValidAcceptVersions(auth.AcceptVersions) {
+			respondFieldErrors(c, http.StatusBadRequest, gin.H{
+				"authentication.accept_versions": "must be 3DS1, 3DS2 or 3DS1,3DS2",
+			})
+			return
+		}
+	}
+	auth = auth.WithDefaults()
+
 	// 1. Get order from database using reference_id
 	order, err := h.orderRepo.GetByReferenceID(c.Request.Context(), req.OrderReferenceID)
 	if err != nil {
 		if _, ok := err.(*repositories.NotFoundError); ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+			respondError(c, http.StatusNotFound, "order not found")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -65,23 +96,20 @@ func (h *SessionHandler) CreateSession(c *gin.Context) {
 	if req.UserID != "" {
 		userID, err := uuid.Parse(req.UserID)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			respondError(c, http.StatusBadRequest, "invalid user ID")
 			return
 		}
 
 		if order.UserID != userID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "order does not belong to user"})
+			respondError(c, http.StatusForbidden, "order does not belong to user")
 			return
 		}
 	}
 
 	// 3. Create session in gateway
-	session, err := h.gatewayService.CreateSession(order, 25)
+	session, err := h.gatewayService.CreateSession(order, 25, h.sessionTTL)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "failed to create payment session",
-			"details": err.Error(),
-		})
+		respondErrorDetails(c, http.StatusInternalServerError, models.ErrorCodeInternal, "failed to create payment session", gin.H{"cause": err.Error()})
 		return
 	}
 
@@ -92,22 +120,18 @@ func (h *SessionHandler) CreateSession(c *gin.Context) {
 		order.ReferenceID, // Use ReferenceID as Gateway Order ID
 		amountStr,
 		order.Currency,
+		auth,
 	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "failed to update session with order details",
-			"details": err.Error(),
-		})
+		respondErrorDetails(c, http.StatusInternalServerError, models.ErrorCodeInternal, "failed to update session with order details", gin.H{"cause": err.Error()})
 		return
 	}
 
 	// 4. Save session to database (link to order)
 	session.OrderDBID = order.ID // Link to order UUID
+	session.AuthenticationParams = &auth
 	if err := h.sessionRepo.Create(c.Request.Context(), session); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "failed to save session",
-			"details": err.Error(),
-		})
+		respondErrorDetails(c, http.StatusInternalServerError, models.ErrorCodeInternal, "failed to save session", gin.H{"cause": err.Error()})
 		return
 	}
 
@@ -136,6 +160,134 @@ func (h *SessionHandler) GetSDKConfig(c *gin.Context) {
 	})
 }
 
+// GetCheckoutConfig returns the SDK config together with an order's amount,
+// currency and, if one is still usable, its latest payment session ID
+func (h *SessionHandler) GetCheckoutConfig(c *gin.Context) {
+	// Registered as /orders/:id/checkout-config to share the wildcard with
+	// GET /orders/:id, but the segment is the order's reference ID
+	referenceID := c.Param("id")
+
+	order, err := h.orderRepo.GetByReferenceID(c.Request.Context(), referenceID)
+	if err != nil {
+		if _, ok := err.(*repositories.NotFoundError); ok {
+			respondError(c, http.StatusNotFound, "order not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if userIDParam := c.Query("user_id"); userIDParam != "" {
+		userID, err := uuid.Parse(userIDParam)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "invalid user ID")
+			return
+		}
+		if order.UserID != userID {
+			respondError(c, http.StatusForbidden, "order does not belong to user")
+			return
+		}
+	}
+
+	config := models.CheckoutConfig{
+		MobileSDKConfig: *h.cfg,
+		OrderID:         order.ReferenceID,
+		Amount:          formatAmount(order.Amount),
+		Currency:        order.Currency,
+		OrderStatus:     order.Status,
+		Description:     order.Description,
+	}
+
+	// Paid or voided orders can't be checked out again, so don't hand out a session
+	if order.Status == models.OrderStatusPending || order.Status == models.OrderStatusFailed {
+		session, err := h.sessionRepo.GetByOrderID(c.Request.Context(), order.ReferenceID)
+		if err != nil {
+			if _, ok := err.(*repositories.NotFoundError); !ok {
+				respondError(c, http.StatusInternalServerError, err.Error())
+				return
+			}
+		} else if isSessionUsable(session) {
+			config.SessionID = session.GatewayID
+			config.SessionExpiresAt = &session.ExpiresAt
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"config":  config,
+	})
+}
+
+// isSessionUsable reports whether a session can still take a payment
+func isSessionUsable(session *models.Session) bool {
+	if session.Status == models.SessionStatusCompleted || session.Status == models.SessionStatusExpired {
+		return false
+	}
+	return time.Now().Before(session.ExpiresAt)
+}
+
+// ListSessions lists payment sessions, newest first, optionally filtered by
+// ?status=, so ops can find abandoned or stuck checkouts (admin endpoint)
+func (h *SessionHandler) ListSessions(c *gin.Context) {
+	status := c.Query("status")
+	if status != "" && !models.IsValidSessionStatus(status) {
+		respondFieldErrors(c, http.StatusBadRequest, gin.H{"status": fmt.Sprintf("unknown session status %q", status)})
+		return
+	}
+	limit, offset := paginationParams(c)
+
+	sessions, err := h.sessionRepo.ListByStatus(c.Request.Context(), status, limit, offset)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	now := time.Now()
+	summaries := make([]models.SessionSummary, 0, len(sessions))
+	for _, session := range sessions {
+		summaries = append(summaries, models.SessionSummary{
+			ID:             session.ID,
+			GatewayID:      session.GatewayID,
+			OrderReference: session.OrderID,
+			UserID:         session.UserID,
+			Amount:         session.Amount,
+			Currency:       session.Currency,
+			Status:         session.Status,
+			CreatedAt:      session.CreatedAt,
+			ExpiresAt:      session.ExpiresAt,
+			AgeSeconds:     int64(now.Sub(session.CreatedAt).Seconds()),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"sessions": summaries,
+		"pagination": gin.H{
+			"limit":  limit,
+			"offset": offset,
+			"count":  len(summaries),
+		},
+	})
+}
+
+// paginationParams reads limit (default 50, max 100) and offset (default 0)
+// from the query string, ignoring invalid values
+func paginationParams(c *gin.Context) (limit, offset int) {
+	limit = 50
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 {
+		if l > 100 {
+			l = 100 // Max 100 records per request
+		}
+		limit = l
+	}
+
+	if o, err := strconv.Atoi(c.Query("offset")); err == nil && o >= 0 {
+		offset = o
+	}
+
+	return limit, offset
+}
+
 // VerifySession verifies if session is still valid
 func (h *SessionHandler) VerifySession(c *gin.Context) {
 	sessionID := c.Param("session_id")
@@ -32,16 +32,14 @@ type CreateUserRequest struct {
 func (h *UserHandler) CreateUser(c *gin.Context) {
 	var req CreateUserRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondValidationError(c, err)
 		return
 	}
 
 	// Check if user already exists
 	existingUser, err := h.userRepo.GetByEmail(c.Request.Context(), req.Email)
 	if existingUser != nil && err == nil {
-		c.JSON(http.StatusConflict, gin.H{
-			"error": "user with this email already exists",
-		})
+		respondError(c, http.StatusConflict, "user with this email already exists")
 		return
 	}
 
@@ -53,10 +51,7 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 	}
 
 	if err := h.userRepo.Create(c.Request.Context(), user); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "failed to create user",
-			"details": err.Error(),
-		})
+		respondErrorDetails(c, http.StatusInternalServerError, models.ErrorCodeInternal, "failed to create user", gin.H{"cause": err.Error()})
 		return
 	}
 
@@ -73,17 +68,17 @@ func (h *UserHandler) GetUser(c *gin.Context) {
 
 	uid, err := uuid.Parse(userID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		respondError(c, http.StatusBadRequest, "invalid user ID")
 		return
 	}
 
 	user, err := h.userRepo.GetByID(c.Request.Context(), uid)
 	if err != nil {
 		if _, ok := err.(*repositories.NotFoundError); ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			respondError(c, http.StatusNotFound, "user not found")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -97,17 +92,17 @@ func (h *UserHandler) GetUser(c *gin.Context) {
 func (h *UserHandler) GetUserByEmail(c *gin.Context) {
 	email := c.Query("email")
 	if email == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "email parameter required"})
+		respondError(c, http.StatusBadRequest, "email parameter required")
 		return
 	}
 
 	user, err := h.userRepo.GetByEmail(c.Request.Context(), email)
 	if err != nil {
 		if _, ok := err.(*repositories.NotFoundError); ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			respondError(c, http.StatusNotFound, "user not found")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+func init() {
+	// Report JSON field names (e.g. "card_id") instead of Go struct field names
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+			if name == "-" {
+				return ""
+			}
+			return name
+		})
+	}
+}
+
+// respondValidationError writes a 400 validation_failed response with the
+// field -> message map built from a ShouldBindJSON error in details.fields,
+// e.g. {"amount": "is required"}
+func respondValidationError(c *gin.Context, err error) {
+	respondFieldErrors(c, http.StatusBadRequest, validationErrors(err))
+}
+
+// validationErrors converts binding errors into client-friendly messages
+// without exposing the validator's internal message format
+func validationErrors(err error) map[string]string {
+	fields := make(map[string]string)
+
+	var validationErrs validator.ValidationErrors
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+
+	switch {
+	case errors.As(err, &validationErrs):
+		for _, fe := range validationErrs {
+			fields[fieldName(fe)] = validationMessage(fe)
+		}
+	case errors.As(err, &typeErr):
+		field := typeErr.Field
+		if field == "" {
+			field = "body"
+		}
+		fields[field] = fmt.Sprintf("must be a %s", typeErr.Type.Kind())
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+		fields["body"] = "must be valid JSON"
+	case errors.Is(err, io.EOF):
+		fields["body"] = "is required"
+	default:
+		fields["body"] = "is invalid"
+	}
+
+	return fields
+}
+
+// fieldName returns the dotted JSON path of the field without the request struct name
+func fieldName(fe validator.FieldError) string {
+	namespace := fe.Namespace()
+	if idx := strings.Index(namespace, "."); idx >= 0 {
+		return namespace[idx+1:]
+	}
+	return fe.Field()
+}
+
+func validationMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "uuid", "uuid4":
+		return "must be a valid UUID"
+	case "email":
+		return "must be a valid email address"
+	case "credit_card":
+		return "must be a valid card number"
+	case "iso4217":
+		return "must be a valid ISO 4217 currency code"
+	case "oneof":
+		return fmt.Sprintf("must be one of: %s", strings.ReplaceAll(fe.Param(), " ", ", "))
+	case "gt":
+		return fmt.Sprintf("must be greater than %s", fe.Param())
+	case "gte":
+		return fmt.Sprintf("must be greater than or equal to %s", fe.Param())
+	case "lt":
+		return fmt.Sprintf("must be less than %s", fe.Param())
+	case "lte":
+		return fmt.Sprintf("must be less than or equal to %s", fe.Param())
+	case "min":
+		if fe.Kind() == reflect.String {
+			return fmt.Sprintf("must be at least %s characters", fe.Param())
+		}
+		return fmt.Sprintf("must be at least %s", fe.Param())
+	case "max":
+		if fe.Kind() == reflect.String {
+			return fmt.Sprintf("must be at most %s characters", fe.Param())
+		}
+		return fmt.Sprintf("must be at most %s", fe.Param())
+	case "len":
+		return fmt.Sprintf("must be exactly %s characters", fe.Param())
+	case "numeric":
+		return "must contain only digits"
+	default:
+		return "is invalid"
+	}
+}
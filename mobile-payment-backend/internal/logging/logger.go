@@ -0,0 +1,25 @@
+// Package logging provides the structured logger used across the mobile
+// backend and helpers that strip card data from anything it logs.
+package logging
+
+import (
+	"io"
+	"log/slog"
+	"os"
+)
+
+// New returns a JSON logger writing to stdout. Debug records are dropped
+// unless debug is true.
+func New(debug bool) *slog.Logger {
+	return NewWithOutput(os.Stdout, debug)
+}
+
+// NewWithOutput is New writing to w instead of stdout
+func NewWithOutput(w io.Writer, debug bool) *slog.Logger {
+	level := slog.LevelInfo
+	if debug {
+		level = slog.LevelDebug
+	}
+
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level}))
+}
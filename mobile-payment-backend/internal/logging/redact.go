@@ -0,0 +1,107 @@
+package logging
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// redacted replaces values that must never appear in logs
+const redacted = "[REDACTED]"
+
+// secretFields are payload keys whose values are dropped entirely: security
+// codes, wallet cryptograms, tokens and credentials
+var secretFields = map[string]bool{
+	"securitycode":            true,
+	"cvv":                     true,
+	"cvc":                     true,
+	"onlinepaymentcryptogram": true,
+	"cryptogram":              true,
+	"token":                   true,
+	"paymenttoken":            true,
+	"password":                true,
+	"apipassword":             true,
+	"authorization":           true,
+}
+
+// panFields are payload keys holding card numbers, logged with only the last
+// four digits
+var panFields = map[string]bool{
+	"number":               true,
+	"cardnumber":           true,
+	"pan":                  true,
+	"devicespecificnumber": true,
+}
+
+// panPattern matches card-number-like digit runs (13-19 digits, optionally
+// separated by spaces or dashes) in free text
+var panPattern = regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)
+
+// MaskPAN keeps the last four digits of a card number
+func MaskPAN(pan string) string {
+	digits := make([]byte, 0, len(pan))
+	for i := 0; i < len(pan); i++ {
+		if pan[i] >= '0' && pan[i] <= '9' {
+			digits = append(digits, pan[i])
+		}
+	}
+	if len(digits) <= 4 {
+		return strings.Repeat("*", len(digits))
+	}
+	return strings.Repeat("*", len(digits)-4) + string(digits[len(digits)-4:])
+}
+
+// RedactPayload returns a gateway request or response body that is safe to
+// log. JSON bodies have sensitive fields masked at any depth; anything else
+// has card-number-like digit runs masked.
+func RedactPayload(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+
+	var payload interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return RedactText(string(body))
+	}
+
+	redactedBody, err := json.Marshal(redactValue("", payload))
+	if err != nil {
+		return redacted
+	}
+	return string(redactedBody)
+}
+
+// RedactText masks card-number-like digit runs in free text
+func RedactText(text string) string {
+	return panPattern.ReplaceAllStringFunc(text, MaskPAN)
+}
+
+func redactValue(key string, value interface{}) interface{} {
+	lowerKey := strings.ToLower(key)
+	if secretFields[lowerKey] {
+		return redacted
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for childKey, child := range v {
+			v[childKey] = redactValue(childKey, child)
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = redactValue(key, child)
+		}
+		return v
+	case string:
+		if panFields[lowerKey] {
+			return MaskPAN(v)
+		}
+		return RedactText(v)
+	default:
+		if panFields[lowerKey] {
+			return redacted
+		}
+		return value
+	}
+}
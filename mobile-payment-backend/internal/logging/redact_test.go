@@ -0,0 +1,100 @@
+package logging
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRedactPayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    map[string]string // dotted path to the expected redacted value
+		leaking []string          // substrings that must not survive
+	}{
+		{
+			name: "nested card fields",
+			body: `{"sourceOfFunds":{"provided":{"card":{"number":"5123450000000008","securityCode":"987","expiry":{"month":"1","year":"39"}}}}}`,
+			want: map[string]string{
+				"sourceOfFunds.provided.card.number":       "************0008",
+				"sourceOfFunds.provided.card.securityCode": redacted,
+				"sourceOfFunds.provided.card.expiry.month": "1",
+			},
+			leaking: []string{"5123450000000008", "987"},
+		},
+		{
+			name:    "card fields inside an array",
+			body:    `{"transaction":[{"sourceOfFunds":{"provided":{"card":{"number":"4508750015741019","securityCode":"4321"}}}}]}`,
+			leaking: []string{"4508750015741019", "4321"},
+		},
+		{
+			name: "PAN in a free-text field",
+			body: `{"error":{"explanation":"Card 5123 4500 0000 0008 was declined"}}`,
+			want: map[string]string{
+				"error.explanation": "Card ************0008 was declined",
+			},
+			leaking: []string{"5123 4500 0000 0008"},
+		},
+		{
+			name: "numeric card number",
+			body: `{"card":{"number":5123450000000008}}`,
+			want: map[string]string{
+				"card.number": redacted,
+			},
+			leaking: []string{"5123450000000008"},
+		},
+		{
+			name: "wallet cryptogram and token",
+			body: `{"token":"9876543210123456","devicePayment":{"onlinePaymentCryptogram":"IA/8pdiWftSsxpFT6wABoDABhgA="}}`,
+			want: map[string]string{
+				"token":                                 redacted,
+				"devicePayment.onlinePaymentCryptogram": redacted,
+			},
+			leaking: []string{"9876543210123456", "IA/8pdiWftSsxpFT6wABoDABhgA="},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := RedactPayload([]byte(tt.body))
+
+			for _, secret := range tt.leaking {
+				if strings.Contains(got, secret) {
+					t.Errorf("redacted payload still contains %q: %s", secret, got)
+				}
+			}
+
+			var payload map[string]interface{}
+			if err := json.Unmarshal([]byte(got), &payload); err != nil {
+				t.Fatalf("redacted payload is not JSON: %v", err)
+			}
+			for path, want := range tt.want {
+				if value := lookup(payload, path); value != want {
+					t.Errorf("%s = %v, want %q", path, value, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRedactPayloadPlainText(t *testing.T) {
+	got := RedactPayload([]byte("upstream rejected card 5123-4500-0000-0008, order 1234"))
+	want := "upstream rejected card ************0008, order 1234"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// lookup follows a dotted path through decoded JSON objects
+func lookup(payload map[string]interface{}, path string) interface{} {
+	var current interface{} = payload
+	for _, key := range strings.Split(path, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = object[key]
+	}
+	return current
+}
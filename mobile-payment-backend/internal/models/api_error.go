@@ -0,0 +1,53 @@
+package models
+
+// ErrorResponse is the body of every API error response:
+//
+//	{"error": {"code": "not_found", "message": "plan not found", "details": {...}}}
+//
+// Code is one of the ErrorCode values and is stable, so clients can switch on
+// it. Message is meant for people and may change. Details, when present,
+// carries data specific to the code.
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// APIError describes what went wrong with a request
+type APIError struct {
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// Error codes returned in APIError.Code
+const (
+	// The request was malformed or broke a business rule (400)
+	ErrorCodeInvalidRequest = "invalid_request"
+	// Request fields failed validation; details.fields maps each field to
+	// what is wrong with it (400). Bulk requests report each item in
+	// details.results instead (422)
+	ErrorCodeValidationFailed = "validation_failed"
+	// Credentials are missing or wrong (401)
+	ErrorCodeUnauthorized = "unauthorized"
+	// The caller may not do this (403)
+	ErrorCodeForbidden = "forbidden"
+	// The resource doesn't exist (404)
+	ErrorCodeNotFound = "not_found"
+	// The request conflicts with the resource's current state (409)
+	ErrorCodeConflict = "conflict"
+	// The request is well formed but can't be processed (422)
+	ErrorCodeUnprocessable = "unprocessable"
+	// The issuer or gateway declined the payment; details.gateway_code and
+	// details.result give the gateway's reason (400)
+	ErrorCodePaymentDeclined = "payment_declined"
+	// Something failed on our side; details.cause may say what (500)
+	ErrorCodeInternal = "internal_error"
+	// The gateway failed or rejected our request (502)
+	ErrorCodeGatewayError = "gateway_error"
+	// A dependency is unavailable; retry later (503)
+	ErrorCodeServiceUnavailable = "service_unavailable"
+)
+
+// NewErrorResponse builds the body of an error response
+func NewErrorResponse(code, message string, details map[string]interface{}) ErrorResponse {
+	return ErrorResponse{Error: APIError{Code: code, Message: message, Details: details}}
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -25,7 +26,7 @@ type Session struct {
 	UserID     uuid.UUID `json:"user_id,omitempty"`
 	Amount     float64   `json:"amount"`
 	Currency   string    `json:"currency"`
-	Status     string    `json:"status"` // created, updated, completed, expired
+	Status     string    `json:"status"` // one of the SessionStatus values
 	APIVersion string    `json:"api_version"`
 	CreatedAt  time.Time `json:"created_at"`
 	ExpiresAt  time.Time `json:"expires_at"`
@@ -34,10 +35,89 @@ type Session struct {
 	AuthenticationParams *AuthenticationParams `json:"authentication_params,omitempty"`
 }
 
+// Session statuses
+const (
+	SessionStatusCreated   = "created"
+	SessionStatusUpdated   = "updated"
+	SessionStatusCompleted = "completed"
+	SessionStatusExpired   = "expired"
+)
+
+// IsValidSessionStatus reports whether status is a known session status
+func IsValidSessionStatus(status string) bool {
+	switch status {
+	case SessionStatusCreated, SessionStatusUpdated, SessionStatusCompleted, SessionStatusExpired:
+		return true
+	}
+	return false
+}
+
+// SessionSummary is a payment session as listed for ops, with how long ago
+// it was created, to spot abandoned or stuck checkouts
+type SessionSummary struct {
+	ID             uuid.UUID `json:"id"`
+	GatewayID      string    `json:"gateway_session_id"`
+	OrderReference string    `json:"order_reference"`
+	UserID         uuid.UUID `json:"user_id,omitempty"`
+	Amount         float64   `json:"amount"`
+	Currency       string    `json:"currency"`
+	Status         string    `json:"status"`
+	CreatedAt      time.Time `json:"created_at"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	AgeSeconds     int64     `json:"age_seconds"`
+}
+
+// AuthenticationParams are the 3-D Secure settings sent to the gateway with
+// a session. Empty fields take the defaults for an in-app payment.
 type AuthenticationParams struct {
-	AcceptVersions string `json:"accept_versions"` // "3DS2"
-	Channel        string `json:"channel"`         // "PAYER_APP"
-	Purpose        string `json:"purpose"`         // "PAYMENT_TRANSACTION"
+	AcceptVersions string `json:"accept_versions"` // "3DS2", "3DS1" or "3DS1,3DS2"
+	Channel        string `json:"channel"`         // one of the AuthenticationChannel values
+	Purpose        string `json:"purpose"`         // one of the AuthenticationPurpose values
+}
+
+// Authentication channels: where the payer authenticates
+const (
+	AuthenticationChannelPayerApp          = "PAYER_APP"
+	AuthenticationChannelPayerBrowser      = "PAYER_BROWSER"
+	AuthenticationChannelMerchantRequested = "MERCHANT_REQUESTED"
+)
+
+// Authentication purposes
+const (
+	AuthenticationPurposePaymentTransaction    = "PAYMENT_TRANSACTION"
+	AuthenticationPurposeAddCard               = "ADD_CARD"
+	AuthenticationPurposeRefreshAuthentication = "REFRESH_AUTHENTICATION"
+)
+
+// Defaults for sessions created by the mobile app
+const (
+	DefaultAuthenticationAcceptVersions = "3DS2"
+	DefaultAuthenticationChannel        = AuthenticationChannelPayerApp
+	DefaultAuthenticationPurpose        = AuthenticationPurposePaymentTransaction
+)
+
+// WithDefaults returns p with empty fields set to the in-app defaults
+func (p AuthenticationParams) WithDefaults() AuthenticationParams {
+	if p.AcceptVersions == "" {
+		p.AcceptVersions = DefaultAuthenticationAcceptVersions
+	}
+	if p.Channel == "" {
+		p.Channel = DefaultAuthenticationChannel
+	}
+	if p.Purpose == "" {
+		p.Purpose = DefaultAuthenticationPurpose
+	}
+	return p
+}
+
+// IsValidAcceptVersions reports whether versions is a 3-D Secure version list
+// the gateway accepts: 3DS1, 3DS2 or both, comma separated
+func IsValidAcceptVersions(versions string) bool {
+	switch strings.ReplaceAll(versions, " ", "") {
+	case "3DS1", "3DS2", "3DS1,3DS2", "3DS2,3DS1":
+		return true
+	}
+	return false
 }
 
 // PaymentRequest for processing payment
@@ -47,6 +127,9 @@ type PaymentRequest struct {
 	Operation string `json:"operation"`          // "PAY" or "AUTHORIZE"
 	Amount    string `json:"amount,omitempty"`   // Optional override
 	Currency  string `json:"currency,omitempty"` // Optional override
+
+	// Save the card as a payment token once the payment succeeds
+	SaveCard bool `json:"save_card,omitempty"`
 }
 
 // PaymentResponse from gateway
@@ -59,6 +142,7 @@ type PaymentResponse struct {
 	Currency        string                 `json:"currency"`
 	Status          string                 `json:"status"`
 	Recommendation  string                 `json:"recommendation,omitempty"`
+	SavedTokenID    string                 `json:"saved_token_id,omitempty"` // set when the card was saved for reuse
 	GatewayResponse map[string]interface{} `json:"gateway_response,omitempty"`
 }
 
@@ -71,6 +155,20 @@ type MobileSDKConfig struct {
 	APIVersion   string `json:"api_version"`
 }
 
+// CheckoutConfig is the SDK config merged with one order's details, so a
+// mobile checkout can start from a single call. SessionID is set when the
+// order already has a payment session that can still be used.
+type CheckoutConfig struct {
+	MobileSDKConfig
+	OrderID          string     `json:"order_id"`
+	Amount           string     `json:"amount"`
+	Currency         string     `json:"currency"`
+	OrderStatus      string     `json:"order_status"`
+	Description      string     `json:"description,omitempty"`
+	SessionID        string     `json:"session_id,omitempty"`
+	SessionExpiresAt *time.Time `json:"session_expires_at,omitempty"`
+}
+
 // Order for session creation
 type Order struct {
 	ID          uuid.UUID              `json:"id"`
@@ -79,12 +177,49 @@ type Order struct {
 	Amount      float64                `json:"amount"`
 	Currency    string                 `json:"currency"`
 	Description string                 `json:"description,omitempty"`
-	Status      string                 `json:"status"` // pending, paid, failed, refunded
+	Status      string                 `json:"status"` // one of the OrderStatus values
 	Metadata    map[string]interface{} `json:"metadata,omitempty"`
 	CreatedAt   time.Time              `json:"created_at"`
 	UpdatedAt   time.Time              `json:"updated_at"`
 }
 
+// Order statuses
+const (
+	OrderStatusPending  = "pending"
+	OrderStatusPaid     = "paid"
+	OrderStatusFailed   = "failed"
+	OrderStatusVoided   = "voided"
+	OrderStatusRefunded = "refunded"
+)
+
+// orderStatusTransitions lists the status changes an order may go through.
+// A failed payment can be retried, so failed orders may return to pending.
+var orderStatusTransitions = map[string][]string{
+	OrderStatusPending: {OrderStatusPaid, OrderStatusFailed, OrderStatusVoided},
+	OrderStatusFailed:  {OrderStatusPending},
+	OrderStatusPaid:    {OrderStatusRefunded},
+}
+
+// IsValidOrderStatus reports whether status is a known order status
+func IsValidOrderStatus(status string) bool {
+	switch status {
+	case OrderStatusPending, OrderStatusPaid, OrderStatusFailed, OrderStatusVoided, OrderStatusRefunded:
+		return true
+	}
+	return false
+}
+
+// CanTransitionOrderStatus reports whether an order may move from one status
+// to another
+func CanTransitionOrderStatus(from, to string) bool {
+	for _, allowed := range orderStatusTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
 // SessionResponse to mobile app
 type SessionResponse struct {
 	SessionID  string          `json:"session_id"`
@@ -124,3 +259,40 @@ type Transaction struct {
 	GatewayResponse      map[string]interface{} `json:"gateway_response,omitempty"`
 	CreatedAt            time.Time              `json:"created_at"`
 }
+
+// Transaction statuses
+const (
+	TransactionStatusPending   = "pending"
+	TransactionStatusSucceeded = "succeeded"
+	TransactionStatusFailed    = "failed"
+
+	// TransactionStatusPendingAuthentication marks a payment waiting for the
+	// payer to finish a 3DS challenge; the ACS callback completes it
+	TransactionStatusPendingAuthentication = "pending_authentication"
+
+	// TransactionStatusProcessing marks a payment whose 3DS result is being
+	// submitted to the gateway
+	TransactionStatusProcessing = "processing"
+)
+
+// TransactionOperationRefund is the Operation of refund transactions
+const TransactionOperationRefund = "REFUND"
+
+// RefundRequest refunds part or all of a captured gateway order. Retrying
+// with the same IdempotencyKey returns the first refund instead of issuing
+// another.
+type RefundRequest struct {
+	OrderID        string
+	Amount         float64
+	Currency       string
+	IdempotencyKey string
+}
+
+// RefundResult is a refund and the order totals after it. Duplicate is set
+// when the idempotency key matched an earlier refund.
+type RefundResult struct {
+	Refund         *Transaction `json:"refund"`
+	Duplicate      bool         `json:"duplicate"`
+	CapturedAmount float64      `json:"captured_amount,omitempty"`
+	RefundedAmount float64      `json:"refunded_amount"`
+}
@@ -17,3 +17,13 @@ type DuplicateError struct {
 func (e *DuplicateError) Error() string {
 	return fmt.Sprintf("duplicate: %s", e.Message)
 }
+
+// ConflictError reports a write that lost a race with another change to the
+// same row
+type ConflictError struct {
+	Message string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("conflict: %s", e.Message)
+}
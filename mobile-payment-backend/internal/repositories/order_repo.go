@@ -8,6 +8,7 @@ import (
     
     "mobile-payment-backend/internal/models"
     "github.com/google/uuid"
+    "github.com/lib/pq"
 )
 
 type OrderRepository interface {
@@ -15,7 +16,7 @@ type OrderRepository interface {
     GetByID(ctx context.Context, id uuid.UUID) (*models.Order, error)
     GetByReferenceID(ctx context.Context, referenceID string) (*models.Order, error)
     GetByUserID(ctx context.Context, userID uuid.UUID) ([]models.Order, error)
-    UpdateStatus(ctx context.Context, id uuid.UUID, status string) error
+    UpdateStatus(ctx context.Context, id uuid.UUID, from, to string) error
 }
 
 type orderRepository struct {
@@ -51,7 +52,7 @@ func (r *orderRepository) Create(ctx context.Context, order *models.Order) error
         metadataJSON = nil
     }
     
-    return r.db.QueryRowContext(ctx, query,
+    err := r.db.QueryRowContext(ctx, query,
         order.ID,
         order.UserID,
         order.ReferenceID,
@@ -61,6 +62,15 @@ func (r *orderRepository) Create(ctx context.Context, order *models.Order) error
         order.Status,
         metadataJSON,
     ).Scan(&order.CreatedAt, &order.UpdatedAt)
+    if err != nil {
+        // Reference IDs are unique; the caller decides whether to retry
+        if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+            return &DuplicateError{Message: "order with this reference ID already exists"}
+        }
+        return err
+    }
+
+    return nil
 }
 
 func (r *orderRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Order, error) {
@@ -194,15 +204,17 @@ func (r *orderRepository) GetByUserID(ctx context.Context, userID uuid.UUID) ([]
     return orders, nil
 }
 
-func (r *orderRepository) UpdateStatus(ctx context.Context, id uuid.UUID, status string) error {
+// UpdateStatus moves an order from one status to another. It changes nothing
+// and returns a ConflictError if the order's status is no longer from, so a
+// concurrent change can't be overwritten.
+func (r *orderRepository) UpdateStatus(ctx context.Context, id uuid.UUID, from, to string) error {
     query := `
         UPDATE orders
         SET status = $1, updated_at = NOW()
-        WHERE id = $2
-        RETURNING updated_at
+        WHERE id = $2 AND status = $3
     `
     
-    result, err := r.db.ExecContext(ctx, query, status, id)
+    result, err := r.db.ExecContext(ctx, query, to, id, from)
     if err != nil {
         return err
     }
@@ -213,7 +225,14 @@ func (r *orderRepository) UpdateStatus(ctx context.Context, id uuid.UUID, status
     }
     
     if rows == 0 {
-        return &NotFoundError{Message: "order not found"}
+        var exists bool
+        if err := r.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM orders WHERE id = $1)`, id).Scan(&exists); err != nil {
+            return err
+        }
+        if !exists {
+            return &NotFoundError{Message: "order not found"}
+        }
+        return &ConflictError{Message: "order status has changed"}
     }
     
     return nil
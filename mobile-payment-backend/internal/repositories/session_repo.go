@@ -12,9 +12,11 @@ import (
 
 type SessionRepository interface {
 	Create(ctx context.Context, session *models.Session) error
+	GetByID(ctx context.Context, id uuid.UUID) (*models.Session, error)
 	GetByGatewayID(ctx context.Context, gatewayID string) (*models.Session, error)
 	GetByOrderID(ctx context.Context, orderID string) (*models.Session, error)
 	UpdateStatus(ctx context.Context, gatewayID, status string) error
+	ListByStatus(ctx context.Context, status string, limit, offset int) ([]models.Session, error)
 	DeleteExpired(ctx context.Context) (int64, error)
 }
 
@@ -66,6 +68,55 @@ func (r *sessionRepository) Create(ctx context.Context, session *models.Session)
 	).Scan(&session.ID, &session.CreatedAt)
 }
 
+func (r *sessionRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Session, error) {
+	query := `
+        SELECT id, gateway_session_id, order_id, user_id, amount, currency,
+               status, api_version, authentication_params, created_at, expires_at
+        FROM sessions
+        WHERE id = $1
+    `
+
+	session := &models.Session{}
+	var authParamsJSON sql.NullString
+	var userID sql.NullString
+
+	err := r.db.QueryRowContext(ctx, query, id).Scan(
+		&session.ID,
+		&session.GatewayID,
+		&session.OrderID,
+		&userID,
+		&session.Amount,
+		&session.Currency,
+		&session.Status,
+		&session.APIVersion,
+		&authParamsJSON,
+		&session.CreatedAt,
+		&session.ExpiresAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, &NotFoundError{Message: "session not found"}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if userID.Valid && userID.String != "" {
+		if uid, err := uuid.Parse(userID.String); err == nil {
+			session.UserID = uid
+		}
+	}
+
+	if authParamsJSON.Valid && authParamsJSON.String != "" {
+		var authParams models.AuthenticationParams
+		if err := json.Unmarshal([]byte(authParamsJSON.String), &authParams); err == nil {
+			session.AuthenticationParams = &authParams
+		}
+	}
+
+	return session, nil
+}
+
 func (r *sessionRepository) GetByGatewayID(ctx context.Context, gatewayID string) (*models.Session, error) {
 	query := `
         SELECT id, gateway_session_id, order_id, user_id, amount, currency,
@@ -192,6 +243,58 @@ func (r *sessionRepository) UpdateStatus(ctx context.Context, gatewayID, status
 	return nil
 }
 
+// ListByStatus pages through sessions with the given status, or all sessions
+// when status is empty, newest first
+func (r *sessionRepository) ListByStatus(ctx context.Context, status string, limit, offset int) ([]models.Session, error) {
+	query := `
+        SELECT id, gateway_session_id, order_id, user_id, amount, currency,
+               status, api_version, created_at, expires_at
+        FROM sessions
+        WHERE ($1 = '' OR status = $1)
+        ORDER BY created_at DESC
+        LIMIT $2 OFFSET $3
+    `
+
+	rows, err := r.db.QueryContext(ctx, query, status, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		var session models.Session
+		var userID sql.NullString
+
+		if err := rows.Scan(
+			&session.ID,
+			&session.GatewayID,
+			&session.OrderID,
+			&userID,
+			&session.Amount,
+			&session.Currency,
+			&session.Status,
+			&session.APIVersion,
+			&session.CreatedAt,
+			&session.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+
+		if userID.Valid && userID.String != "" {
+			if uid, err := uuid.Parse(userID.String); err == nil {
+				session.UserID = uid
+			}
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// DeleteExpired removes unfinished sessions past their expires_at. Expiry is
+// stamped at creation from the configured session TTL, so cleanup follows it.
 func (r *sessionRepository) DeleteExpired(ctx context.Context) (int64, error) {
 	query := `
         DELETE FROM sessions
@@ -15,6 +15,8 @@ type TransactionRepository interface {
 	GetByID(ctx context.Context, id uuid.UUID) (*models.Transaction, error)
 	GetByOrderID(ctx context.Context, orderID string) ([]models.Transaction, error)
 	GetBySessionID(ctx context.Context, sessionID uuid.UUID) ([]models.Transaction, error)
+	ClaimPendingAuthentication(ctx context.Context, orderID string) (*models.Transaction, error)
+	UpdateResult(ctx context.Context, transaction *models.Transaction) error
 }
 
 type transactionRepository struct {
@@ -245,3 +247,104 @@ func (r *transactionRepository) GetBySessionID(ctx context.Context, sessionID uu
 
 	return transactions, nil
 }
+
+// ClaimPendingAuthentication moves the latest transaction on a gateway order
+// that is waiting for 3DS to processing and returns it. Only one caller can
+// claim a transaction, so repeated ACS callbacks don't complete it twice.
+func (r *transactionRepository) ClaimPendingAuthentication(ctx context.Context, orderID string) (*models.Transaction, error) {
+	query := `
+        UPDATE transactions
+        SET status = $2
+        WHERE id = (
+            SELECT id FROM transactions
+            WHERE order_id = $1 AND status = $3
+            ORDER BY created_at DESC
+            LIMIT 1
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING id, session_id, order_id, user_id, amount, currency,
+                  gateway_transaction_id, status, operation, created_at
+    `
+
+	transaction := &models.Transaction{}
+	var userID sql.NullString
+	var sessionID sql.NullString
+
+	err := r.db.QueryRowContext(ctx, query,
+		orderID,
+		models.TransactionStatusProcessing,
+		models.TransactionStatusPendingAuthentication,
+	).Scan(
+		&transaction.ID,
+		&sessionID,
+		&transaction.OrderID,
+		&userID,
+		&transaction.Amount,
+		&transaction.Currency,
+		&transaction.GatewayTransactionID,
+		&transaction.Status,
+		&transaction.Operation,
+		&transaction.CreatedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, &NotFoundError{Message: "no payment awaiting authentication for this order"}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if sessionID.Valid && sessionID.String != "" {
+		if sid, err := uuid.Parse(sessionID.String); err == nil {
+			transaction.SessionID = sid
+		}
+	}
+
+	if userID.Valid && userID.String != "" {
+		if uid, err := uuid.Parse(userID.String); err == nil {
+			transaction.UserID = uid
+		}
+	}
+
+	return transaction, nil
+}
+
+// UpdateResult records the outcome of a transaction: its status, gateway
+// transaction ID and gateway response
+func (r *transactionRepository) UpdateResult(ctx context.Context, transaction *models.Transaction) error {
+	query := `
+        UPDATE transactions
+        SET status = $1, gateway_transaction_id = $2, gateway_response = $3
+        WHERE id = $4
+    `
+
+	var gatewayResponseJSON interface{}
+	if transaction.GatewayResponse != nil {
+		jsonData, err := json.Marshal(transaction.GatewayResponse)
+		if err != nil {
+			return err
+		}
+		gatewayResponseJSON = string(jsonData)
+	}
+
+	result, err := r.db.ExecContext(ctx, query,
+		transaction.Status,
+		transaction.GatewayTransactionID,
+		gatewayResponseJSON,
+		transaction.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return &NotFoundError{Message: "transaction not found"}
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package services
+
+import "fmt"
+
+// ValidationError rejects a request the service can't carry out as given
+type ValidationError struct {
+	// Field names the request field at fault, when there is one
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// ConflictError rejects a request that clashes with work already done or in
+// progress, e.g. a refund retried while the first attempt is still running
+type ConflictError struct {
+	Message string
+}
+
+func (e *ConflictError) Error() string {
+	return e.Message
+}
+
+// GatewayAPIError is a non-2xx response from the gateway
+type GatewayAPIError struct {
+	StatusCode int
+	// Body is the redacted response body
+	Body string
+}
+
+func (e *GatewayAPIError) Error() string {
+	return fmt.Sprintf("API error %d: %s", e.StatusCode, e.Body)
+}
@@ -0,0 +1,25 @@
+package services
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// parseGatewayAmount normalizes an amount from a decoded gateway response.
+// The gateway sends amounts as JSON numbers or strings in several shapes
+// (10, 10.0, "10.00", 1e1); all parse to the same value. A missing or
+// malformed amount is 0.
+func parseGatewayAmount(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case json.Number:
+		f, _ := v.Float64()
+		return f
+	case string:
+		f, _ := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f
+	}
+	return 0
+}
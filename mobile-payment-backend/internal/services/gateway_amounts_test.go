@@ -0,0 +1,44 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestGetFloatAmountShapes(t *testing.T) {
+	tests := []struct {
+		json string
+		want float64
+	}{
+		{`10`, 10},
+		{`10.0`, 10},
+		{`"10"`, 10},
+		{`"10.00"`, 10},
+		{`" 10.00 "`, 10},
+		{`1e1`, 10},
+		{`"1.0E1"`, 10},
+		{`10.5`, 10.5},
+		{`"10.50"`, 10.5},
+		{`null`, 0},
+		{`""`, 0},
+		{`"ten"`, 0},
+	}
+
+	for _, tt := range tests {
+		var response map[string]interface{}
+		if err := json.Unmarshal([]byte(`{"order":{"amount":`+tt.json+`}}`), &response); err != nil {
+			t.Fatalf("amount %s: %v", tt.json, err)
+		}
+		if got := getFloat(response, "order.amount"); got != tt.want {
+			t.Errorf("amount %s = %v, want %v", tt.json, got, tt.want)
+		}
+	}
+
+	// Decoders using UseNumber hand over a json.Number
+	if got := parseGatewayAmount(json.Number("1.05e1")); got != 10.5 {
+		t.Errorf("json.Number 1.05e1 = %v, want 10.5", got)
+	}
+	if got := getFloat(map[string]interface{}{}, "order.amount"); got != 0 {
+		t.Errorf("missing amount = %v, want 0", got)
+	}
+}
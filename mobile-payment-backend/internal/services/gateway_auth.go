@@ -0,0 +1,132 @@
+package services
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"mobile-payment-backend/internal/config"
+)
+
+// Gateway authentication methods selectable with GATEWAY_AUTH_METHOD
+const (
+	AuthMethodBasic       = "basic"
+	AuthMethodCertificate = "certificate"
+)
+
+// Authenticator applies the merchant's gateway credentials. ConfigureClient
+// is called once on the client used for all gateway calls; Authenticate is
+// called on every request.
+type Authenticator interface {
+	ConfigureClient(client *http.Client)
+	Authenticate(req *http.Request)
+}
+
+// NewAuthenticator returns the authenticator selected by config
+func NewAuthenticator(cfg *config.Config) (Authenticator, error) {
+	switch method := cfg.GatewayAuthMethod(); method {
+	case AuthMethodBasic:
+		return &BasicAuthenticator{
+			MerchantID: cfg.MastercardMerchantID,
+			Password:   cfg.MastercardAPIPassword,
+		}, nil
+	case AuthMethodCertificate:
+		return NewCertificateAuthenticator(cfg.GatewayClientCertFile(), cfg.GatewayClientKeyFile())
+	default:
+		return nil, fmt.Errorf("unsupported gateway auth method %q (want %q or %q)", method, AuthMethodBasic, AuthMethodCertificate)
+	}
+}
+
+// BasicAuthenticator sends the merchant API password with HTTP Basic auth
+type BasicAuthenticator struct {
+	MerchantID string
+	Password   string
+}
+
+func (a *BasicAuthenticator) ConfigureClient(client *http.Client) {}
+
+func (a *BasicAuthenticator) Authenticate(req *http.Request) {
+	auth := fmt.Sprintf("merchant.%s:%s", a.MerchantID, a.Password)
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(auth)))
+}
+
+// CertificateAuthenticator identifies the merchant with a TLS client
+// certificate issued by the gateway, so no password is sent
+type CertificateAuthenticator struct {
+	certificate tls.Certificate
+}
+
+// NewCertificateAuthenticator loads the PEM client certificate and private
+// key. It fails if either file is missing or the certificate is not
+// currently valid.
+func NewCertificateAuthenticator(certFile, keyFile string) (*CertificateAuthenticator, error) {
+	certificate, err := loadClientCertificate(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CertificateAuthenticator{certificate: certificate}, nil
+}
+
+func (a *CertificateAuthenticator) ConfigureClient(client *http.Client) {
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok || transport == nil {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	transport.TLSClientConfig.Certificates = []tls.Certificate{a.certificate}
+	client.Transport = transport
+}
+
+func (a *CertificateAuthenticator) Authenticate(req *http.Request) {}
+
+// clientCertRenewalWarning is how long before expiry a client certificate
+// starts producing startup warnings
+const clientCertRenewalWarning = 30 * 24 * time.Hour
+
+// loadClientCertificate loads a PEM certificate and key pair and checks the
+// certificate is currently valid
+func loadClientCertificate(certFile, keyFile string) (tls.Certificate, error) {
+	if certFile == "" || keyFile == "" {
+		return tls.Certificate{}, fmt.Errorf("certificate auth requires GATEWAY_CLIENT_CERT_FILE and GATEWAY_CLIENT_KEY_FILE")
+	}
+	for _, file := range []string{certFile, keyFile} {
+		if _, err := os.Stat(file); err != nil {
+			return tls.Certificate{}, fmt.Errorf("gateway client certificate: %w", err)
+		}
+	}
+
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to load gateway client certificate: %w", err)
+	}
+
+	leaf := certificate.Leaf
+	if leaf == nil {
+		if leaf, err = x509.ParseCertificate(certificate.Certificate[0]); err != nil {
+			return tls.Certificate{}, fmt.Errorf("failed to parse gateway client certificate: %w", err)
+		}
+	}
+
+	now := time.Now()
+	if now.Before(leaf.NotBefore) {
+		return tls.Certificate{}, fmt.Errorf("gateway client certificate %s is not valid until %s",
+			certFile, leaf.NotBefore.Format(time.RFC3339))
+	}
+	if now.After(leaf.NotAfter) {
+		return tls.Certificate{}, fmt.Errorf("gateway client certificate %s expired on %s",
+			certFile, leaf.NotAfter.Format(time.RFC3339))
+	}
+	if leaf.NotAfter.Sub(now) < clientCertRenewalWarning {
+		log.Printf("Warning: gateway client certificate %s expires on %s", certFile, leaf.NotAfter.Format(time.RFC3339))
+	}
+
+	return certificate, nil
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"mobile-payment-backend/internal/models"
+)
+
+// Gateway limits on the order fields we send, in characters
+const (
+	gatewayOrderDescriptionMax    = 127
+	gatewayOrderReferenceMax      = 40
+	gatewayCustomerReferenceMax   = 25
+	gatewayCustomerNoteMax        = 250
+	gatewayStatementDescriptorMax = 22
+)
+
+// gatewayOrderMetadata lists the order metadata keys passed through to the
+// gateway, the order field each one is sent as, and that field's limit
+var gatewayOrderMetadata = []struct {
+	key   string
+	field string
+	max   int
+}{
+	{"customer_reference", "customerReference", gatewayCustomerReferenceMax},
+	{"customer_note", "customerNote", gatewayCustomerNoteMax},
+}
+
+// addOrderDetails copies the order's reference, description and selected
+// metadata onto a gateway order payload, so payments can be reconciled from
+// the statement and the gateway portal. A missing order only costs the
+// details, so the payment goes ahead without them.
+func (s *gatewayService) addOrderDetails(ctx context.Context, orderDBID uuid.UUID, payload map[string]interface{}) {
+	order, err := s.orderRepo.GetByID(ctx, orderDBID)
+	if err != nil {
+		s.logger.Warn("sending payment without order details",
+			"order_db_id", orderDBID,
+			"error", err,
+		)
+		return
+	}
+
+	for field, value := range gatewayOrderFields(order) {
+		payload[field] = value
+	}
+}
+
+// gatewayOrderFields returns the gateway order fields describing order,
+// truncated to the gateway's limits. Empty values are left out.
+func gatewayOrderFields(order *models.Order) map[string]interface{} {
+	fields := map[string]interface{}{}
+
+	if reference := truncateGatewayField(order.ReferenceID, gatewayOrderReferenceMax); reference != "" {
+		fields["reference"] = reference
+	}
+	if description := truncateGatewayField(order.Description, gatewayOrderDescriptionMax); description != "" {
+		fields["description"] = description
+	}
+
+	for _, m := range gatewayOrderMetadata {
+		text, _ := order.Metadata[m.key].(string)
+		if value := truncateGatewayField(text, m.max); value != "" {
+			fields[m.field] = value
+		}
+	}
+
+	// The descriptor is what the cardholder sees on their statement
+	text, _ := order.Metadata["statement_descriptor"].(string)
+	if name := truncateGatewayField(text, gatewayStatementDescriptorMax); name != "" {
+		fields["statementDescriptor"] = map[string]interface{}{"name": name}
+	}
+
+	return fields
+}
+
+// truncateGatewayField trims value and cuts it to at most max characters
+func truncateGatewayField(value string, max int) string {
+	value = strings.TrimSpace(value)
+	if runes := []rune(value); len(runes) > max {
+		value = strings.TrimSpace(string(runes[:max]))
+	}
+	return value
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Session update statuses reported by the gateway
+const (
+	sessionUpdateSuccess  = "SUCCESS"
+	sessionUpdateNoUpdate = "NO_UPDATE"
+	sessionUpdateFailure  = "FAILURE"
+)
+
+// verifyGatewaySession retrieves a payment session from the gateway and
+// checks it can take a payment: it still exists, the payer's card details were
+// added successfully, and an order is attached. A session that fails these
+// checks is reported as a ValidationError on session_id, so the client gets a
+// clear answer instead of a rejection half way through the payment.
+func (s *gatewayService) verifyGatewaySession(sessionID string) error {
+	endpoint := fmt.Sprintf("/api/rest/version/%s/merchant/%s/session/%s",
+		s.cfg.APIVersion, s.cfg.MastercardMerchantID, sessionID)
+
+	body, err := s.makeRequest("GET", endpoint, nil)
+	if err != nil {
+		var apiErr *GatewayAPIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode >= http.StatusBadRequest && apiErr.StatusCode < http.StatusInternalServerError {
+			return &ValidationError{Field: "session_id", Message: "payment session was not found or has expired"}
+		}
+		return fmt.Errorf("failed to retrieve session: %v", err)
+	}
+
+	var session map[string]interface{}
+	if err := json.Unmarshal(body, &session); err != nil {
+		return fmt.Errorf("failed to parse session: %v", err)
+	}
+
+	switch getString(session, "session.updateStatus") {
+	case sessionUpdateSuccess:
+	case sessionUpdateNoUpdate:
+		return &ValidationError{Field: "session_id", Message: "payment session has no card details yet"}
+	case sessionUpdateFailure:
+		return &ValidationError{Field: "session_id", Message: "card details in the payment session were rejected by the gateway"}
+	}
+
+	if getString(session, "order.currency") == "" && getFloat(session, "order.amount") == 0 {
+		return &ValidationError{Field: "session_id", Message: "payment session has no order attached"}
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"strconv"
+
+	"mobile-payment-backend/internal/models"
+)
+
+// saveSessionCard tokenizes the card used in a session after a successful
+// payment and stores it for the order's user, setting response.SavedTokenID.
+// The payment has already gone through, so a card that can't be saved is
+// logged and the payment response returned without a token.
+func (s *gatewayService) saveSessionCard(ctx context.Context, sessionID string, response *models.PaymentResponse) {
+	session, err := s.sessionRepo.GetByGatewayID(ctx, sessionID)
+	if err != nil {
+		s.logger.Warn("not saving card: payment session not found",
+			"session_id", sessionID,
+			"error", err,
+		)
+		return
+	}
+
+	order, err := s.orderRepo.GetByReferenceID(ctx, session.OrderID)
+	if err != nil {
+		s.logger.Warn("not saving card: order not found",
+			"session_id", sessionID,
+			"order_id", session.OrderID,
+			"error", err,
+		)
+		return
+	}
+
+	tokenResp, err := s.tokenizeSession(sessionID)
+	if err != nil {
+		s.logger.Warn("failed to save card",
+			"session_id", sessionID,
+			"error", err,
+		)
+		return
+	}
+
+	gatewayToken := getString(tokenResp, "token")
+
+	// The gateway may hand back a token it already issued for this card
+	if existing, err := s.tokenRepo.GetByGatewayToken(ctx, gatewayToken); err == nil {
+		if existing.UserID == order.UserID {
+			response.SavedTokenID = existing.ID.String()
+		}
+		return
+	}
+
+	// Expiry comes as MMYY; a card without one is still saved, as the
+	// gateway token remains usable
+	expiry := getString(tokenResp, "sourceOfFunds.provided.card.expiry")
+	var expiryMonth, expiryYear int
+	if len(expiry) == 4 {
+		expiryMonth, _ = strconv.Atoi(expiry[:2])
+		if year, err := strconv.Atoi(expiry[2:]); err == nil {
+			expiryYear = 2000 + year
+		}
+	}
+
+	number := getString(tokenResp, "sourceOfFunds.provided.card.number")
+	lastFour := number
+	if len(number) > 4 {
+		lastFour = number[len(number)-4:]
+	}
+
+	token := &models.PaymentToken{
+		UserID:            order.UserID,
+		GatewayToken:      gatewayToken,
+		LastFour:          lastFour,
+		ExpiryMonth:       expiryMonth,
+		ExpiryYear:        expiryYear,
+		CardScheme:        getString(tokenResp, "sourceOfFunds.provided.card.brand"),
+		PaymentMethodType: "card",
+		IsDefault:         true, // only taken when the user has no other card
+	}
+	if err := s.tokenRepo.Create(ctx, token); err != nil {
+		s.logger.Warn("failed to store saved card",
+			"session_id", sessionID,
+			"error", err,
+		)
+		return
+	}
+
+	response.SavedTokenID = token.ID.String()
+}
@@ -0,0 +1,19 @@
+package services
+
+import (
+	"net/http"
+
+	"mobile-payment-backend/internal/config"
+)
+
+// newGatewayTransport returns the connection pool shared by every gateway
+// call. Keeping enough idle connections per host lets busy billing runs reuse
+// connections instead of paying for a TLS handshake on each request.
+func newGatewayTransport(cfg *config.Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = cfg.GatewayMaxIdleConns()
+	transport.MaxIdleConnsPerHost = cfg.GatewayMaxIdleConnsPerHost()
+	transport.IdleConnTimeout = cfg.GatewayIdleConnTimeout()
+	transport.DisableKeepAlives = cfg.GatewayDisableKeepAlives()
+	return transport
+}
@@ -2,54 +2,84 @@ package services
 
 import (
 	"bytes"
-	"encoding/base64"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
+	"math"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
 	"mobile-payment-backend/internal/config"
+	"mobile-payment-backend/internal/logging"
 	"mobile-payment-backend/internal/models"
 	"mobile-payment-backend/internal/repositories"
 )
 
 type GatewayService interface {
-	CreateSession(order *models.Order, authLimit int) (*models.Session, error)
-	UpdateSession(sessionID, orderID, amount, currency string) error
-	ProcessPayment(request *models.PaymentRequest) (*models.PaymentResponse, error)
+	CreateSession(order *models.Order, authLimit int, ttl time.Duration) (*models.Session, error)
+	UpdateSession(sessionID, orderID, amount, currency string, auth models.AuthenticationParams) error
+	ProcessPayment(ctx context.Context, request *models.PaymentRequest) (*models.PaymentResponse, error)
+	CompleteAuthentication(ctx context.Context, orderID, authenticationTransactionID string) (*models.PaymentResponse, error)
 	CreateToken(sessionID string) (string, error)
+	RefundPayment(ctx context.Context, request *models.RefundRequest) (*models.RefundResult, error)
 }
 
 type gatewayService struct {
 	cfg             *config.Config
+	authenticator   Authenticator
 	sessionRepo     repositories.SessionRepository
+	orderRepo       repositories.OrderRepository
 	transactionRepo repositories.TransactionRepository
 	tokenRepo       repositories.TokenRepository
 	httpClient      *http.Client
+	logger          *slog.Logger
 }
 
 func NewGatewayService(
 	cfg *config.Config,
+	authenticator Authenticator,
 	sessionRepo repositories.SessionRepository,
+	orderRepo repositories.OrderRepository,
 	transactionRepo repositories.TransactionRepository,
 	tokenRepo repositories.TokenRepository,
 ) GatewayService {
+	httpClient := &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: newGatewayTransport(cfg),
+	}
+	authenticator.ConfigureClient(httpClient)
+
 	return &gatewayService{
 		cfg:             cfg,
+		authenticator:   authenticator,
 		sessionRepo:     sessionRepo,
+		orderRepo:       orderRepo,
 		transactionRepo: transactionRepo,
 		tokenRepo:       tokenRepo,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		httpClient:      httpClient,
+		logger:          logging.New(cfg.DebugGateway()).With("component", "gateway"),
 	}
 }
 
 // CreateToken creates a payment token from a session (for card-on-file)
 func (s *gatewayService) CreateToken(sessionID string) (string, error) {
+	token, err := s.tokenizeSession(sessionID)
+	if err != nil {
+		return "", err
+	}
+	return getString(token, "token"), nil
+}
+
+// tokenizeSession stores the card in a session at the gateway and returns the
+// gateway's token response, which also describes the card
+func (s *gatewayService) tokenizeSession(sessionID string) (map[string]interface{}, error) {
 	endpoint := fmt.Sprintf("/api/rest/version/%s/merchant/%s/token",
 		s.cfg.APIVersion, s.cfg.MastercardMerchantID)
 
@@ -65,27 +95,23 @@ func (s *gatewayService) CreateToken(sessionID string) (string, error) {
 
 	body, err := s.makeRequest("POST", endpoint, request)
 	if err != nil {
-		return "", fmt.Errorf("failed to create token: %v", err)
-	}
-
-	var response struct {
-		Token   string `json:"token"`
-		Success bool   `json:"success"`
+		return nil, fmt.Errorf("failed to create token: %v", err)
 	}
 
+	var response map[string]interface{}
 	if err := json.Unmarshal(body, &response); err != nil {
-		return "", fmt.Errorf("failed to parse token response: %v", err)
+		return nil, fmt.Errorf("failed to parse token response: %v", err)
 	}
 
-	if !response.Success {
-		return "", fmt.Errorf("gateway failed to create token")
+	if getString(response, "result") != "SUCCESS" || getString(response, "token") == "" {
+		return nil, fmt.Errorf("gateway failed to create token. result: %s", getString(response, "result"))
 	}
 
-	return response.Token, nil
+	return response, nil
 }
 
 // CreateSession creates a new payment session in Mastercard Gateway
-func (s *gatewayService) CreateSession(order *models.Order, authLimit int) (*models.Session, error) {
+func (s *gatewayService) CreateSession(order *models.Order, authLimit int, ttl time.Duration) (*models.Session, error) {
 	endpoint := fmt.Sprintf("/api/rest/version/%s/merchant/%s/session",
 		s.cfg.APIVersion, s.cfg.MastercardMerchantID)
 
@@ -113,25 +139,29 @@ func (s *gatewayService) CreateSession(order *models.Order, authLimit int) (*mod
 		return nil, fmt.Errorf("gateway failed to create session. result: %s", response.Result)
 	}
 
+	now := time.Now()
 	session := &models.Session{
 		GatewayID:  response.Session.ID,
 		OrderID:    order.ReferenceID,
 		Amount:     order.Amount,
 		Currency:   order.Currency,
-		Status:     "created",
+		Status:     models.SessionStatusCreated,
 		APIVersion: s.cfg.APIVersion,
-		CreatedAt:  time.Now(),
-		ExpiresAt:  time.Now().Add(30 * time.Minute), // Sessions expire in 30 mins
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(ttl),
 	}
 
 	return session, nil
 }
 
-// UpdateSession updates session with order details (called from mobile SDK)
-func (s *gatewayService) UpdateSession(sessionID, orderID, amount, currency string) error {
+// UpdateSession updates session with order details and the 3-D Secure
+// settings for the payer's channel (called from mobile SDK)
+func (s *gatewayService) UpdateSession(sessionID, orderID, amount, currency string, auth models.AuthenticationParams) error {
 	endpoint := fmt.Sprintf("/api/rest/version/%s/merchant/%s/session/%s",
 		s.cfg.APIVersion, s.cfg.MastercardMerchantID, sessionID)
 
+	auth = auth.WithDefaults()
+
 	request := map[string]interface{}{
 		"order": map[string]interface{}{
 			"id":       orderID,
@@ -139,9 +169,9 @@ func (s *gatewayService) UpdateSession(sessionID, orderID, amount, currency stri
 			"currency": currency,
 		},
 		"authentication": map[string]interface{}{
-			"acceptVersions": "3DS2",
-			"channel":        "PAYER_APP",
-			"purpose":        "PAYMENT_TRANSACTION",
+			"acceptVersions": strings.ReplaceAll(auth.AcceptVersions, " ", ""),
+			"channel":        auth.Channel,
+			"purpose":        auth.Purpose,
 		},
 	}
 
@@ -152,14 +182,19 @@ func (s *gatewayService) UpdateSession(sessionID, orderID, amount, currency stri
 
 	// If response is empty, assume success
 	if len(body) == 0 {
-		fmt.Println("DEBUG: UpdateSession - Empty response (assumed success)")
+		s.logger.Debug("update session returned an empty response, assuming success",
+			"session_id", sessionID,
+		)
 		return nil
 	}
 
 	// Try to parse as JSON
 	var response map[string]interface{}
 	if err := json.Unmarshal(body, &response); err != nil {
-		fmt.Printf("DEBUG: UpdateSession - Non-JSON response: %s\n", string(body))
+		s.logger.Debug("update session returned a non-JSON response, assuming success",
+			"session_id", sessionID,
+			"body", logging.RedactPayload(body),
+		)
 		return nil // Assume success if not JSON
 	}
 
@@ -173,24 +208,42 @@ func (s *gatewayService) UpdateSession(sessionID, orderID, amount, currency stri
 	return nil
 }
 
-// ProcessPayment processes payment using session ID
-func (s *gatewayService) ProcessPayment(request *models.PaymentRequest) (*models.PaymentResponse, error) {
+// ProcessPayment processes payment using session ID. When the gateway holds
+// the payment for a 3DS challenge it is recorded as pending, to be completed
+// by CompleteAuthentication once the ACS reports the result.
+func (s *gatewayService) ProcessPayment(ctx context.Context, request *models.PaymentRequest) (*models.PaymentResponse, error) {
+	if s.cfg.VerifySessionBeforePayment() {
+		if err := s.verifyGatewaySession(request.SessionID); err != nil {
+			return nil, err
+		}
+	}
+
 	// Generate a simple order ID for Gateway
 	gatewayOrderID := fmt.Sprintf("ORDER%d", time.Now().UnixNano())
 
 	endpoint := fmt.Sprintf("/api/rest/version/%s/merchant/%s/order/%s/transaction/1",
 		s.cfg.APIVersion, s.cfg.MastercardMerchantID, gatewayOrderID)
 
+	order := map[string]interface{}{
+		// "id":       gatewayOrderID, // Use same ID here
+		"amount":   request.Amount,
+		"currency": request.Currency,
+	}
+	if session, err := s.sessionRepo.GetByGatewayID(ctx, request.SessionID); err == nil {
+		s.addOrderDetails(ctx, session.OrderDBID, order)
+	} else {
+		s.logger.Warn("sending payment without order details",
+			"session_id", request.SessionID,
+			"error", err,
+		)
+	}
+
 	payload := map[string]interface{}{
 		"apiOperation": request.Operation,
 		"session": map[string]interface{}{
 			"id": request.SessionID,
 		},
-		"order": map[string]interface{}{
-			// "id":       gatewayOrderID, // Use same ID here
-			"amount":   request.Amount,
-			"currency": request.Currency,
-		},
+		"order": order,
 		"sourceOfFunds": map[string]interface{}{
 			"type": "CARD",
 		},
@@ -209,6 +262,410 @@ func (s *gatewayService) ProcessPayment(request *models.PaymentRequest) (*models
 		return nil, fmt.Errorf("payment failed: %v", err)
 	}
 
+	response, err := parsePaymentResponse(body, gatewayOrderID)
+	if err != nil {
+		return nil, err
+	}
+	s.warnIfNoOrder(response)
+	s.rejectIfNotRecommended(response)
+
+	if requiresAuthentication(response) {
+		if err := s.recordPendingAuthentication(ctx, request, response); err != nil {
+			return nil, err
+		}
+	}
+
+	if request.SaveCard && response.Success {
+		s.saveSessionCard(ctx, request.SessionID, response)
+	}
+
+	return response, nil
+}
+
+// CompleteAuthentication finishes a payment that was waiting for a 3DS
+// challenge. The result is read from the gateway, not taken from the caller.
+// A successful authentication is submitted to the gateway with the payment; a
+// failed one fails the payment without charging.
+func (s *gatewayService) CompleteAuthentication(ctx context.Context, orderID, authenticationTransactionID string) (*models.PaymentResponse, error) {
+	transaction, err := s.transactionRepo.ClaimPendingAuthentication(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	authenticated, err := s.authenticationSucceeded(orderID, authenticationTransactionID)
+	if err != nil {
+		// The result is unknown; leave the payment pending so the callback
+		// can be retried
+		s.releaseAuthentication(ctx, transaction)
+		return nil, fmt.Errorf("failed to retrieve authentication result: %v", err)
+	}
+
+	if !authenticated {
+		transaction.Status = models.TransactionStatusFailed
+		if err := s.transactionRepo.UpdateResult(ctx, transaction); err != nil {
+			return nil, fmt.Errorf("failed to record payment result: %v", err)
+		}
+		return &models.PaymentResponse{
+			Success:       false,
+			GatewayCode:   "AUTHENTICATION_FAILED",
+			TransactionID: transaction.GatewayTransactionID,
+			OrderID:       orderID,
+			Amount:        transaction.Amount,
+			Currency:      transaction.Currency,
+			Status:        transaction.Status,
+		}, nil
+	}
+
+	session, err := s.sessionRepo.GetByID(ctx, transaction.SessionID)
+	if err != nil {
+		s.releaseAuthentication(ctx, transaction)
+		return nil, fmt.Errorf("failed to load payment session: %v", err)
+	}
+
+	// The transaction ID is fixed so a repeated callback replays the same
+	// gateway transaction instead of charging twice
+	endpoint := fmt.Sprintf("/api/rest/version/%s/merchant/%s/order/%s/transaction/2",
+		s.cfg.APIVersion, s.cfg.MastercardMerchantID, orderID)
+
+	order := map[string]interface{}{
+		"amount":   strconv.FormatFloat(transaction.Amount, 'f', 2, 64),
+		"currency": transaction.Currency,
+	}
+	s.addOrderDetails(ctx, session.OrderDBID, order)
+
+	payload := map[string]interface{}{
+		"apiOperation": transaction.Operation,
+		"authentication": map[string]interface{}{
+			"transactionId": authenticationTransactionID,
+		},
+		"session": map[string]interface{}{
+			"id": session.GatewayID,
+		},
+		"order": order,
+		"sourceOfFunds": map[string]interface{}{
+			"type": "CARD",
+		},
+	}
+
+	body, err := s.makeRequest("PUT", endpoint, payload)
+	if err != nil {
+		// The outcome is unknown; leave the payment pending so the callback
+		// can be retried
+		s.releaseAuthentication(ctx, transaction)
+		return nil, fmt.Errorf("payment failed: %v", err)
+	}
+
+	response, err := parsePaymentResponse(body, orderID)
+	if err != nil {
+		s.releaseAuthentication(ctx, transaction)
+		return nil, err
+	}
+	s.warnIfNoOrder(response)
+	s.rejectIfNotRecommended(response)
+
+	transaction.Status = models.TransactionStatusFailed
+	if response.Success {
+		transaction.Status = models.TransactionStatusSucceeded
+	}
+	transaction.GatewayTransactionID = response.TransactionID
+	transaction.GatewayResponse = response.GatewayResponse
+	if err := s.transactionRepo.UpdateResult(ctx, transaction); err != nil {
+		return nil, fmt.Errorf("failed to record payment result: %v", err)
+	}
+
+	if response.Success {
+		if err := s.sessionRepo.UpdateStatus(ctx, session.GatewayID, models.SessionStatusCompleted); err != nil {
+			s.logger.Warn("failed to mark session completed",
+				"session_id", session.GatewayID,
+				"error", err,
+			)
+		}
+	}
+
+	return response, nil
+}
+
+// RefundPayment refunds a captured order at most once per idempotency key.
+// The refund is recorded as pending before the gateway call, so a retry that
+// arrives while it is running is rejected rather than refunding twice, and
+// refunds beyond the order's captured amount are refused.
+func (s *gatewayService) RefundPayment(ctx context.Context, request *models.RefundRequest) (*models.RefundResult, error) {
+	transactions, err := s.transactionRepo.GetByOrderID(ctx, request.OrderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load order transactions: %v", err)
+	}
+
+	gatewayTransactionID := refundTransactionID(request.IdempotencyKey)
+
+	var refund, payment *models.Transaction
+	var refundedLocally float64
+	for i := range transactions {
+		t := &transactions[i]
+		switch {
+		case t.Operation != models.TransactionOperationRefund:
+			payment = t
+		case t.GatewayTransactionID == gatewayTransactionID:
+			refund = t
+		case t.Status == models.TransactionStatusSucceeded:
+			refundedLocally += t.Amount
+		}
+	}
+
+	if refund != nil {
+		if refund.Amount != request.Amount || refund.Currency != request.Currency {
+			return nil, &ConflictError{Message: "idempotency key was already used for a different refund"}
+		}
+		switch refund.Status {
+		case models.TransactionStatusSucceeded:
+			return &models.RefundResult{
+				Refund:         refund,
+				Duplicate:      true,
+				RefundedAmount: refundedLocally + refund.Amount,
+			}, nil
+		case models.TransactionStatusPending, models.TransactionStatusProcessing:
+			return nil, &ConflictError{Message: "a refund with this idempotency key is in progress"}
+		}
+		// A failed refund may be retried under the same key
+	}
+
+	// The gateway's totals include refunds made outside this backend
+	captured, refundedAtGateway, currency, err := s.orderTotals(request.OrderID)
+	if err != nil {
+		return nil, err
+	}
+	refunded := math.Max(refundedLocally, refundedAtGateway)
+
+	if currency != "" && request.Currency != currency {
+		return nil, &ValidationError{Field: "currency", Message: fmt.Sprintf("order was paid in %s", currency)}
+	}
+	if remaining := captured - refunded; request.Amount > remaining+0.005 {
+		return nil, &ValidationError{
+			Field:   "amount",
+			Message: fmt.Sprintf("refund exceeds the refundable amount of %.2f", math.Max(remaining, 0)),
+		}
+	}
+
+	if refund == nil {
+		refund = &models.Transaction{
+			OrderID:              request.OrderID,
+			Amount:               request.Amount,
+			Currency:             request.Currency,
+			GatewayTransactionID: gatewayTransactionID,
+			Status:               models.TransactionStatusPending,
+			Operation:            models.TransactionOperationRefund,
+		}
+		if payment != nil {
+			refund.SessionID = payment.SessionID
+			refund.UserID = payment.UserID
+		}
+		if err := s.transactionRepo.Create(ctx, refund); err != nil {
+			return nil, fmt.Errorf("failed to record refund: %v", err)
+		}
+	} else {
+		refund.Status = models.TransactionStatusPending
+		if err := s.transactionRepo.UpdateResult(ctx, refund); err != nil {
+			return nil, fmt.Errorf("failed to record refund: %v", err)
+		}
+	}
+
+	// The gateway transaction ID comes from the idempotency key, so the
+	// gateway also refuses a second refund under the same key
+	endpoint := fmt.Sprintf("/api/rest/version/%s/merchant/%s/order/%s/transaction/%s",
+		s.cfg.APIVersion, s.cfg.MastercardMerchantID, request.OrderID, gatewayTransactionID)
+
+	payload := map[string]interface{}{
+		"apiOperation": "REFUND",
+		"transaction": map[string]interface{}{
+			"amount":   strconv.FormatFloat(request.Amount, 'f', 2, 64),
+			"currency": request.Currency,
+		},
+	}
+
+	body, err := s.makeRequest("PUT", endpoint, payload)
+	if err != nil {
+		s.releaseRefund(ctx, refund)
+		return nil, fmt.Errorf("refund failed: %v", err)
+	}
+
+	response, err := parsePaymentResponse(body, request.OrderID)
+	if err != nil {
+		s.releaseRefund(ctx, refund)
+		return nil, err
+	}
+
+	refund.Status = models.TransactionStatusFailed
+
+	if response.Success {
+		refund.Status = models.TransactionStatusSucceeded
+		refunded += request.Amount
+	}
+	refund.GatewayResponse = response.GatewayResponse
+	if err := s.transactionRepo.UpdateResult(ctx, refund); err != nil {
+		return nil, fmt.Errorf("failed to record refund result: %v", err)
+	}
+
+	return &models.RefundResult{
+		Refund:         refund,
+		CapturedAmount: captured,
+		RefundedAmount: refunded,
+	}, nil
+}
+
+// releaseRefund records a refund whose gateway outcome is unknown as failed,
+// so a retry under the same idempotency key is not refused as in progress.
+// The retry reuses the gateway transaction ID, which the gateway will not
+// refund twice.
+func (s *gatewayService) releaseRefund(ctx context.Context, refund *models.Transaction) {
+	refund.Status = models.TransactionStatusFailed
+	if err := s.transactionRepo.UpdateResult(ctx, refund); err != nil {
+		s.logger.Error("failed to record refund result",
+			"order_id", refund.OrderID,
+			"error", err,
+		)
+	}
+}
+
+// orderTotals fetches how much of a gateway order has been captured and
+// refunded, and its currency
+func (s *gatewayService) orderTotals(orderID string) (captured, refunded float64, currency string, err error) {
+	endpoint := fmt.Sprintf("/api/rest/version/%s/merchant/%s/order/%s",
+		s.cfg.APIVersion, s.cfg.MastercardMerchantID, orderID)
+
+	body, err := s.makeRequest("GET", endpoint, nil)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("failed to retrieve order: %v", err)
+	}
+
+	var order map[string]interface{}
+	if err := json.Unmarshal(body, &order); err != nil {
+		return 0, 0, "", fmt.Errorf("failed to parse order: %v", err)
+	}
+
+	return getFloat(order, "totalCapturedAmount"), getFloat(order, "totalRefundedAmount"), getString(order, "currency"), nil
+}
+
+// refundTransactionID derives the gateway transaction ID for a refund from its
+// idempotency key, within the gateway's 40 character limit
+func refundTransactionID(idempotencyKey string) string {
+	sum := sha256.Sum256([]byte(idempotencyKey))
+	return "REFUND-" + hex.EncodeToString(sum[:])[:24]
+}
+
+// authenticationSucceeded retrieves a 3DS authentication on the order from
+// the gateway and reports whether the payer authenticated and the gateway
+// recommends proceeding
+func (s *gatewayService) authenticationSucceeded(orderID, authenticationTransactionID string) (bool, error) {
+	endpoint := fmt.Sprintf("/api/rest/version/%s/merchant/%s/order/%s/transaction/%s",
+		s.cfg.APIVersion, s.cfg.MastercardMerchantID, orderID, url.PathEscape(authenticationTransactionID))
+
+	body, err := s.makeRequest("GET", endpoint, nil)
+	if err != nil {
+		return false, err
+	}
+
+	var gatewayResp map[string]interface{}
+	if err := json.Unmarshal(body, &gatewayResp); err != nil {
+		return false, fmt.Errorf("failed to parse authentication response: %v", err)
+	}
+
+	recommendation := getString(gatewayResp, "response.gatewayRecommendation")
+	return gatewayResp["result"] == "SUCCESS" && (recommendation == "" || recommendation == "PROCEED"), nil
+}
+
+// recordPendingAuthentication stores a payment held for a 3DS challenge so
+// the ACS callback can find it by gateway order ID
+func (s *gatewayService) recordPendingAuthentication(ctx context.Context, request *models.PaymentRequest, response *models.PaymentResponse) error {
+	session, err := s.sessionRepo.GetByGatewayID(ctx, request.SessionID)
+	if err != nil {
+		return fmt.Errorf("failed to load payment session: %v", err)
+	}
+
+	transaction := &models.Transaction{
+		SessionID:            session.ID,
+		OrderID:              response.OrderID,
+		UserID:               session.UserID,
+		Amount:               session.Amount,
+		Currency:             session.Currency,
+		GatewayTransactionID: response.TransactionID,
+		Status:               models.TransactionStatusPendingAuthentication,
+		Operation:            request.Operation,
+		GatewayResponse:      response.GatewayResponse,
+	}
+	if err := s.transactionRepo.Create(ctx, transaction); err != nil {
+		return fmt.Errorf("failed to record pending payment: %v", err)
+	}
+
+	return nil
+}
+
+// releaseAuthentication puts a claimed payment back to pending so a repeated
+// ACS callback can complete it
+func (s *gatewayService) releaseAuthentication(ctx context.Context, transaction *models.Transaction) {
+	transaction.Status = models.TransactionStatusPendingAuthentication
+	if err := s.transactionRepo.UpdateResult(ctx, transaction); err != nil {
+		s.logger.Error("failed to release payment awaiting authentication",
+			"order_id", transaction.OrderID,
+			"error", err,
+		)
+	}
+}
+
+// warnIfNoOrder logs a payment response that carries no order, which leaves
+// its amount and currency empty
+func (s *gatewayService) warnIfNoOrder(response *models.PaymentResponse) {
+	if _, ok := response.GatewayResponse["order"].(map[string]interface{}); !ok {
+		s.logger.Warn("gateway payment response has no order, amount left at zero",
+			"order_id", response.OrderID,
+			"gateway_code", response.GatewayCode,
+		)
+	}
+}
+
+// recommendationDoNotProceed is the gateway's risk assessment advising the
+// merchant against a payment, even one the issuer approved
+const recommendationDoNotProceed = "DO_NOT_PROCEED"
+
+// rejectIfNotRecommended declines an approved PAY or AUTHORIZE that the
+// gateway recommends not proceeding with, voiding it so the payer is not
+// charged and no funds stay held. A failed void is logged for manual reversal;
+// the payment is declined either way.
+func (s *gatewayService) rejectIfNotRecommended(response *models.PaymentResponse) {
+	if !response.Success || response.Recommendation != recommendationDoNotProceed {
+		return
+	}
+
+	response.Success = false
+	response.GatewayCode = recommendationDoNotProceed
+
+	endpoint := fmt.Sprintf("/api/rest/version/%s/merchant/%s/order/%s/transaction/void-%s",
+		s.cfg.APIVersion, s.cfg.MastercardMerchantID, response.OrderID, response.TransactionID)
+	payload := map[string]interface{}{
+		"apiOperation": "VOID",
+		"transaction": map[string]interface{}{
+			"targetTransactionId": response.TransactionID,
+		},
+	}
+
+	if _, err := s.makeRequest("PUT", endpoint, payload); err != nil {
+		s.logger.Error("failed to void payment the gateway recommended against; reverse it manually",
+			"order_id", response.OrderID,
+			"transaction_id", response.TransactionID,
+			"error", err,
+		)
+		return
+	}
+	response.Status = "VOIDED"
+}
+
+// requiresAuthentication reports whether the gateway is holding a payment
+// until the payer completes a 3DS challenge
+func requiresAuthentication(response *models.PaymentResponse) bool {
+	return getString(response.GatewayResponse, "response.gatewayCode") == "AUTHENTICATION_IN_PROGRESS" ||
+		getString(response.GatewayResponse, "transaction.authenticationStatus") == "AUTHENTICATION_PENDING"
+}
+
+// parsePaymentResponse converts a gateway PAY/AUTHORIZE response
+func parsePaymentResponse(body []byte, gatewayOrderID string) (*models.PaymentResponse, error) {
 	var gatewayResp map[string]interface{}
 	if err := json.Unmarshal(body, &gatewayResp); err != nil {
 		return nil, fmt.Errorf("failed to parse payment response: %v", err)
@@ -224,21 +681,9 @@ func (s *gatewayService) ProcessPayment(request *models.PaymentRequest) (*models
 		GatewayResponse: gatewayResp,
 	}
 
-	// Parse amount
-	if amountVal, ok := gatewayResp["order"].(map[string]interface{})["amount"]; ok {
-		switch amt := amountVal.(type) {
-		case float64:
-			response.Amount = amt
-		case string:
-			if parsedAmt, err := strconv.ParseFloat(amt, 64); err == nil {
-				response.Amount = parsedAmt
-			}
-		}
-	}
-
-	if curr, ok := gatewayResp["order"].(map[string]interface{})["currency"]; ok {
-		response.Currency = curr.(string)
-	}
+	// Error responses carry no order, leaving the amount and currency empty
+	response.Amount = getFloat(gatewayResp, "order.amount")
+	response.Currency = getString(gatewayResp, "order.currency")
 
 	return response, nil
 }
@@ -247,9 +692,6 @@ func (s *gatewayService) ProcessPayment(request *models.PaymentRequest) (*models
 func (s *gatewayService) makeRequest(method, endpoint string, payload interface{}) ([]byte, error) {
 	url := fmt.Sprintf("https://%s%s", s.cfg.MastercardHost, endpoint)
 
-	fmt.Printf("DEBUG: Making request to: %s\n", url) // ADD THIS
-	fmt.Printf("DEBUG: Merchant ID: %s\n", s.cfg.MastercardMerchantID)
-
 	var body []byte
 	var err error
 
@@ -260,15 +702,18 @@ func (s *gatewayService) makeRequest(method, endpoint string, payload interface{
 		}
 	}
 
+	s.logger.Debug("gateway request",
+		"method", method,
+		"url", url,
+		"body", logging.RedactPayload(body),
+	)
+
 	req, err := http.NewRequest(method, url, bytes.NewBuffer(body))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 
-	// Basic Auth
-	auth := fmt.Sprintf("merchant.%s:%s", s.cfg.MastercardMerchantID, s.cfg.MastercardAPIPassword)
-	encodedAuth := base64.StdEncoding.EncodeToString([]byte(auth))
-	req.Header.Set("Authorization", "Basic "+encodedAuth)
+	s.authenticator.Authenticate(req)
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := s.httpClient.Do(req)
@@ -282,16 +727,36 @@ func (s *gatewayService) makeRequest(method, endpoint string, payload interface{
 		return nil, fmt.Errorf("failed to read response: %v", err)
 	}
 
+	s.logger.Debug("gateway response",
+		"method", method,
+		"url", url,
+		"status", resp.StatusCode,
+		"body", logging.RedactPayload(respBody),
+	)
+
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("API error %d: %s", resp.StatusCode, string(respBody))
+		return nil, &GatewayAPIError{StatusCode: resp.StatusCode, Body: logging.RedactPayload(respBody)}
 	}
 
-	fmt.Printf("DEBUG: Response Status: %d\n", resp.StatusCode) // ADD THIS
-	fmt.Printf("DEBUG: Response Body: %s\n", string(respBody))
-
 	return respBody, nil
 }
 
+// getFloat reads a number the gateway may send as a JSON number or string
+func getFloat(m map[string]interface{}, path string) float64 {
+	keys := strings.Split(path, ".")
+	var current interface{} = m
+
+	for _, key := range keys {
+		currentMap, ok := current.(map[string]interface{})
+		if !ok {
+			return 0
+		}
+		current = currentMap[key]
+	}
+
+	return parseGatewayAmount(current)
+}
+
 // Helper to safely get string from map
 func getString(m map[string]interface{}, path string) string {
 	keys := strings.Split(path, ".")
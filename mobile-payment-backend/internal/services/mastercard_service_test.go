@@ -0,0 +1,320 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"mobile-payment-backend/internal/config"
+	"mobile-payment-backend/internal/logging"
+	"mobile-payment-backend/internal/models"
+	"mobile-payment-backend/internal/repositories"
+)
+
+// newTestGatewayService returns a gateway service whose requests are answered
+// by handler and whose log records are written to logs
+func newTestGatewayService(t *testing.T, handler http.HandlerFunc, logs io.Writer) *gatewayService {
+	t.Helper()
+
+	srv := httptest.NewTLSServer(handler)
+	t.Cleanup(srv.Close)
+
+	cfg := &config.Config{
+		MastercardMerchantID:  "TESTMERCHANT",
+		MastercardAPIPassword: "secret",
+		MastercardHost:        strings.TrimPrefix(srv.URL, "https://"),
+	}
+	return &gatewayService{
+		cfg:           cfg,
+		authenticator: &BasicAuthenticator{MerchantID: cfg.MastercardMerchantID, Password: cfg.MastercardAPIPassword},
+		httpClient:    srv.Client(),
+		logger:        logging.NewWithOutput(logs, cfg.DebugGateway()).With("component", "gateway"),
+	}
+}
+
+// cardPayload is a session update carrying a full card number and CVV
+func cardPayload() map[string]interface{} {
+	return map[string]interface{}{
+		"sourceOfFunds": map[string]interface{}{
+			"provided": map[string]interface{}{
+				"card": map[string]interface{}{
+					"number":       "5123450000000008",
+					"securityCode": "987",
+				},
+			},
+		},
+	}
+}
+
+func TestGatewayDebugLogging(t *testing.T) {
+	t.Setenv("DEBUG_GATEWAY", "true")
+
+	var logs bytes.Buffer
+	service := newTestGatewayService(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"result":"SUCCESS","token":"9123456789012345","sourceOfFunds":{"provided":{"card":{"number":"5123450000000008"}}}}`)
+	}, &logs)
+
+	if _, err := service.makeRequest("PUT", "/api/rest/version/100/merchant/TESTMERCHANT/session/SESSION1", cardPayload()); err != nil {
+		t.Fatalf("makeRequest: %v", err)
+	}
+
+	var messages []string
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("log line is not JSON: %q", line)
+		}
+		messages = append(messages, record["msg"].(string))
+
+		if record["level"] != "DEBUG" || record["component"] != "gateway" {
+			t.Errorf("record = %v, want a DEBUG record from the gateway component", record)
+		}
+		body, _ := record["body"].(string)
+		if !strings.Contains(body, "************0008") {
+			t.Errorf("%s body has no masked card number: %s", record["msg"], body)
+		}
+		if record["msg"] == "gateway request" && !strings.Contains(body, `"securityCode":"[REDACTED]"`) {
+			t.Errorf("request body has an unredacted security code: %s", body)
+		}
+	}
+	if strings.Join(messages, ",") != "gateway request,gateway response" {
+		t.Errorf("logged %v, want the request and the response", messages)
+	}
+
+	for _, secret := range []string{"5123450000000008", "9123456789012345", "secret"} {
+		if strings.Contains(logs.String(), secret) {
+			t.Errorf("logs contain %q:\n%s", secret, logs.String())
+		}
+	}
+}
+
+func TestGatewayDebugLoggingOff(t *testing.T) {
+	t.Setenv("DEBUG_GATEWAY", "false")
+
+	var logs bytes.Buffer
+	service := newTestGatewayService(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"result":"SUCCESS"}`)
+	}, &logs)
+
+	if _, err := service.makeRequest("PUT", "/api/rest/version/100/merchant/TESTMERCHANT/session/SESSION1", cardPayload()); err != nil {
+		t.Fatalf("makeRequest: %v", err)
+	}
+	if logs.Len() != 0 {
+		t.Errorf("logged with DEBUG_GATEWAY off:\n%s", logs.String())
+	}
+}
+
+// noSessionRepo knows no sessions, so payments go out without order details
+type noSessionRepo struct {
+	repositories.SessionRepository
+}
+
+func (noSessionRepo) GetByGatewayID(ctx context.Context, gatewayID string) (*models.Session, error) {
+	return nil, errors.New("session not found")
+}
+
+func TestProcessPaymentWithoutOrder(t *testing.T) {
+	const noOrderWarning = "gateway payment response has no order"
+
+	tests := []struct {
+		name       string
+		reply      string
+		wantAmount float64
+		wantWarn   bool
+	}{
+		{"order missing", `{"result":"ERROR","error":{"cause":"INVALID_REQUEST"}}`, 0, true},
+		{"order null", `{"result":"FAILURE","gatewayCode":"DECLINED","order":null}`, 0, true},
+		{"order not an object", `{"result":"FAILURE","gatewayCode":"DECLINED","order":"ORDER1"}`, 0, true},
+		{"order without an amount", `{"result":"FAILURE","gatewayCode":"DECLINED","order":{"currency":"USD"}}`, 0, false},
+		{"order with an amount", `{"result":"SUCCESS","gatewayCode":"APPROVED","order":{"amount":"10.50","currency":"USD"}}`, 10.5, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			service := newTestGatewayService(t, func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, tt.reply)
+			}, &logs)
+			service.sessionRepo = noSessionRepo{}
+
+			response, err := service.ProcessPayment(context.Background(), &models.PaymentRequest{
+				SessionID: "SESSION1",
+				Operation: "PAY",
+				Amount:    "10.50",
+				Currency:  "USD",
+			})
+			if err != nil {
+				t.Fatalf("ProcessPayment: %v", err)
+			}
+			if response.Amount != tt.wantAmount {
+				t.Errorf("amount = %v, want %v", response.Amount, tt.wantAmount)
+			}
+			if warned := strings.Contains(logs.String(), noOrderWarning); warned != tt.wantWarn {
+				t.Errorf("warned about the missing order = %v, want %v:\n%s", warned, tt.wantWarn, logs.String())
+			}
+		})
+	}
+}
+
+// pendingAuthenticationRepo holds one payment waiting for a 3DS challenge
+type pendingAuthenticationRepo struct {
+	repositories.TransactionRepository
+
+	transaction models.Transaction
+}
+
+func (r *pendingAuthenticationRepo) ClaimPendingAuthentication(ctx context.Context, orderID string) (*models.Transaction, error) {
+	if r.transaction.OrderID != orderID || r.transaction.Status != models.TransactionStatusPendingAuthentication {
+		return nil, &repositories.NotFoundError{Message: "no payment awaiting authentication"}
+	}
+	claimed := r.transaction
+	return &claimed, nil
+}
+
+func (r *pendingAuthenticationRepo) UpdateResult(ctx context.Context, transaction *models.Transaction) error {
+	r.transaction = *transaction
+	return nil
+}
+
+// oneSessionRepo knows a single session by ID
+type oneSessionRepo struct {
+	repositories.SessionRepository
+
+	session models.Session
+}
+
+func (r oneSessionRepo) GetByID(ctx context.Context, id uuid.UUID) (*models.Session, error) {
+	return &r.session, nil
+}
+
+func (r oneSessionRepo) UpdateStatus(ctx context.Context, gatewayID, status string) error {
+	return nil
+}
+
+// noOrderRepo knows no orders
+type noOrderRepo struct {
+	repositories.OrderRepository
+}
+
+func (noOrderRepo) GetByID(ctx context.Context, id uuid.UUID) (*models.Order, error) {
+	return nil, &repositories.NotFoundError{Message: "order not found"}
+}
+
+func TestCompleteAuthenticationUsesGatewayResult(t *testing.T) {
+	tests := []struct {
+		name           string
+		authentication string
+		wantPayment    bool
+		wantStatus     string
+	}{
+		{"authenticated", `{"result":"SUCCESS","response":{"gatewayRecommendation":"PROCEED"}}`, true, models.TransactionStatusSucceeded},
+		{"not recommended", `{"result":"SUCCESS","response":{"gatewayRecommendation":"DO_NOT_PROCEED"}}`, false, models.TransactionStatusFailed},
+		{"authentication failed", `{"result":"FAILURE","response":{"gatewayRecommendation":"PROCEED"}}`, false, models.TransactionStatusFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paid := false
+			service := newTestGatewayService(t, func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/order/ORDER1/transaction/3DS1"):
+					io.WriteString(w, tt.authentication)
+				case r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/order/ORDER1/transaction/2"):
+					paid = true
+					io.WriteString(w, `{"result":"SUCCESS","gatewayCode":"APPROVED","order":{"amount":"10.00","currency":"USD"},"transaction":{"id":"2"}}`)
+				default:
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}, io.Discard)
+			transactions := &pendingAuthenticationRepo{transaction: models.Transaction{
+				OrderID:   "ORDER1",
+				Amount:    10,
+				Currency:  "USD",
+				Status:    models.TransactionStatusPendingAuthentication,
+				Operation: "PAY",
+			}}
+			service.transactionRepo = transactions
+			service.sessionRepo = oneSessionRepo{session: models.Session{GatewayID: "SESSION1"}}
+			service.orderRepo = noOrderRepo{}
+
+			if _, err := service.CompleteAuthentication(context.Background(), "ORDER1", "3DS1"); err != nil {
+				t.Fatalf("CompleteAuthentication: %v", err)
+			}
+			if paid != tt.wantPayment {
+				t.Errorf("payment sent = %v, want %v", paid, tt.wantPayment)
+			}
+			if transactions.transaction.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", transactions.transaction.Status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// refundRepo records refunds on one gateway order
+type refundRepo struct {
+	repositories.TransactionRepository
+
+	transactions []models.Transaction
+}
+
+func (r *refundRepo) GetByOrderID(ctx context.Context, orderID string) ([]models.Transaction, error) {
+	return append([]models.Transaction(nil), r.transactions...), nil
+}
+
+func (r *refundRepo) Create(ctx context.Context, transaction *models.Transaction) error {
+	transaction.ID = uuid.New()
+	r.transactions = append(r.transactions, *transaction)
+	return nil
+}
+
+func (r *refundRepo) UpdateResult(ctx context.Context, transaction *models.Transaction) error {
+	for i := range r.transactions {
+		if r.transactions[i].ID == transaction.ID {
+			r.transactions[i] = *transaction
+		}
+	}
+	return nil
+}
+
+func TestRefundWithUnreadableResponseCanBeRetried(t *testing.T) {
+	refunds := 0
+	service := newTestGatewayService(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			io.WriteString(w, `{"totalCapturedAmount":10.00,"totalRefundedAmount":0,"currency":"USD"}`)
+			return
+		}
+		refunds++
+		if refunds == 1 {
+			io.WriteString(w, `<html>proxy error</html>`)
+			return
+		}
+		io.WriteString(w, `{"result":"SUCCESS","transaction":{"id":"REFUND-1"}}`)
+	}, io.Discard)
+	transactions := &refundRepo{}
+	service.transactionRepo = transactions
+
+	request := &models.RefundRequest{OrderID: "ORDER1", Amount: 10, Currency: "USD", IdempotencyKey: "key-1"}
+	if _, err := service.RefundPayment(context.Background(), request); err == nil {
+		t.Fatal("RefundPayment succeeded on an unreadable gateway response")
+	}
+	if status := transactions.transactions[0].Status; status != models.TransactionStatusFailed {
+		t.Errorf("refund status = %s, want failed", status)
+	}
+
+	result, err := service.RefundPayment(context.Background(), request)
+	if err != nil {
+		t.Fatalf("retried RefundPayment: %v", err)
+	}
+	if result.Refund.Status != models.TransactionStatusSucceeded || len(transactions.transactions) != 1 {
+		t.Errorf("retry = %s with %d refunds recorded, want one succeeded refund", result.Refund.Status, len(transactions.transactions))
+	}
+}
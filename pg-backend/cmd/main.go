@@ -7,6 +7,7 @@ import (
 	"pg-backend/internal/config"
 	"pg-backend/internal/database"
 	"pg-backend/internal/handlers"
+	"pg-backend/internal/middleware"
 	"pg-backend/internal/repositories"
 	"pg-backend/internal/services"
 	"pg-backend/internal/worker"
@@ -50,19 +51,46 @@ func main() {
 	planRepo := repositories.NewPlanRepository()
 	subscriptionRepo := repositories.NewSubscriptionRepository()
 	billingRepo := repositories.NewBillingRepository()
+	eventRepo := repositories.NewEventRepository()
+	creditRepo := repositories.NewCreditRepository()
+	disputeRepo := repositories.NewDisputeRepository()
+	lockRepo := repositories.NewLockRepository()
 
 	// Initialize services
-	mastercardService := services.NewMastercardService(cfg)
+	gatewayBreaker := services.NewGatewayBreaker(cfg)
+	var mastercardService services.MastercardService
+	if cfg.MockGateway() {
+		log.Println("Warning: MOCK_GATEWAY is set; payments are simulated and nothing is sent to the gateway")
+		mastercardService = services.NewMockMastercardService(cfg)
+	} else {
+		authenticator, err := services.NewAuthenticator(cfg)
+		if err != nil {
+			log.Fatal("Failed to configure gateway authentication:", err)
+		}
+		mastercardService = services.NewMastercardService(cfg, authenticator, gatewayBreaker)
+	}
+	eventService := services.NewEventService(eventRepo)
+	capturePolicy := services.CapturePolicy{MaxAttempts: cfg.CaptureMaxAttempts(), VoidOnFailure: cfg.VoidOnCaptureFailure()}
+	amountLimits := services.NewAmountLimits(cfg)
+	currencies := services.NewSupportedCurrencies(cfg)
+	paymentService := services.NewPaymentService(mastercardService, userRepo, cardRepo, transactionRepo, services.NewFXRateSource(cfg), services.NewPaymentRouter(cfg), capturePolicy, amountLimits, currencies)
+	transactionService := services.NewTransactionService(transactionRepo, disputeRepo, eventService)
+	creditService := services.NewCreditService(creditRepo, userRepo)
+	disputeService := services.NewDisputeService(disputeRepo, transactionRepo, eventService)
 
 	// NEW: Initialize subscription services
-	planService := services.NewPlanService(planRepo)
+	planService := services.NewPlanService(planRepo, amountLimits, currencies)
 	billingService := services.NewBillingService(
 		transactionRepo,
 		billingRepo,
 		cardRepo,
 		subscriptionRepo,
+		planRepo,
 		userRepo,
+		creditRepo,
 		mastercardService,
+		eventService,
+		cfg,
 	)
 	subscriptionService := services.NewSubscriptionService(
 		subscriptionRepo,
@@ -70,16 +98,24 @@ func main() {
 		cardRepo,
 		billingRepo,
 		transactionRepo,
+		creditRepo,
 		mastercardService,
+		eventService,
+		cfg,
 	)
 
 	// Initialize handlers
 	cardHandler := handlers.NewCardHandler(mastercardService, userRepo, cardRepo)
-	paymentHandler := handlers.NewPaymentHandler(mastercardService, userRepo, cardRepo, transactionRepo)
-	authorizationHandler := handlers.NewAuthorizationHandler(mastercardService, userRepo, cardRepo, transactionRepo)
+	paymentHandler := handlers.NewPaymentHandler(mastercardService, userRepo, cardRepo, transactionRepo, paymentService)
+	authorizationHandler := handlers.NewAuthorizationHandler(mastercardService, userRepo, cardRepo, transactionRepo, paymentService)
+	transactionHandler := handlers.NewTransactionHandler(transactionService)
+	creditHandler := handlers.NewCreditHandler(creditService)
+	disputeHandler := handlers.NewDisputeHandler(disputeService)
+	webhookHandler := handlers.NewWebhookHandler(disputeService, cfg)
+	gatewayHandler := handlers.NewGatewayHandler(mastercardService)
 
 	// NEW: Initialize subscription handlers
-	planHandler := handlers.NewPlanHandler(planService)
+	planHandler := handlers.NewPlanHandler(planService, cfg)
 	subscriptionHandler := handlers.NewSubscriptionHandler(subscriptionService)
 	billingHandler := handlers.NewBillingHandler(billingService)
 
@@ -89,6 +125,7 @@ func main() {
 		userRepo,
 		cardRepo, // Uses existing CardRepository (now handles Google Pay too)
 		transactionRepo,
+		paymentService,
 	)
 
 	// NEW: Initialize worker
@@ -98,14 +135,18 @@ func main() {
 	billingWorker := worker.NewBillingWorker(
 		subscriptionService,
 		billingService,
+		lockRepo,
+		gatewayBreaker,
 		cfg,
 	)
 
-	// Register worker
-	workerManager.RegisterWorker(billingWorker)
+	// Register worker; there is nothing to bill without subscriptions
+	if cfg.SubscriptionsEnabled() {
+		workerManager.RegisterWorker(billingWorker)
+	}
 
 	// NEW: Initialize worker handler
-	workerHandler := handlers.NewWorkerHandler(workerManager)
+	workerHandler := handlers.NewWorkerHandler(workerManager, cfg)
 
 	// Start worker in background
 	go func() {
@@ -122,11 +163,23 @@ func main() {
 		userRepo,
 		cardRepo,
 		transactionRepo,
+		paymentService,
 	)
 
 	// Setup Gin router
 	router := gin.Default()
 
+	// Health check. A down gateway degrades the service without taking it
+	// out of rotation; reads and non-gateway operations still work.
+	router.GET("/health", func(c *gin.Context) {
+		breaker := gatewayBreaker.State()
+		status := "ok"
+		if breaker.State != services.BreakerClosed {
+			status = "degraded"
+		}
+		c.JSON(200, gin.H{"status": status, "gateway_breaker": breaker})
+	})
+
 	// API routes
 	api := router.Group("/api/v1")
 	{
@@ -135,7 +188,10 @@ func main() {
 
 		// Card endpoints
 		api.POST("/cards/verify", cardHandler.VerifyAndSaveCard)
+		api.POST("/cards/import-token", cardHandler.ImportToken)
 		api.GET("/users/:user_id/cards", cardHandler.GetUserCards)
+		api.GET("/users/:user_id/default-payment-method", cardHandler.GetDefaultPaymentMethod)
+		api.GET("/cards/:card_id", cardHandler.GetCard)
 		api.DELETE("/cards", cardHandler.DeleteCard)
 
 		// Payment endpoints
@@ -147,47 +203,102 @@ func main() {
 		api.POST("/capture", authorizationHandler.Capture)
 		api.POST("/void", authorizationHandler.Void)
 		api.POST("/update-authorization", authorizationHandler.UpdateAuthorization)
+		api.GET("/authorizations/:order_id/balance", authorizationHandler.GetAuthorizationBalance)
 
 		// Transaction endpoints
 		api.GET("/users/:user_id/transactions", paymentHandler.GetTransactions)
+		api.GET("/users/:user_id/transactions/export", transactionHandler.ExportTransactions)
+		api.GET("/users/:user_id/transactions/payment-methods", transactionHandler.GetPaymentMethodVolume)
+		api.GET("/users/:user_id/refundable-transactions", transactionHandler.GetRefundableTransactions)
+		api.GET("/transactions", paymentHandler.GetTransactionsByReference)
+		api.GET("/transactions/by-order/:order_id", paymentHandler.GetTransactionsByGatewayOrder)
 		api.GET("/transactions/:transaction_id", paymentHandler.GetTransactionByID)
-
-		// NEW: Plan endpoints
-		api.GET("/plans", planHandler.GetPlans)
-		api.GET("/plans/:id", planHandler.GetPlan)
-		api.POST("/plans", planHandler.CreatePlan)
-		api.PUT("/plans/:id", planHandler.UpdatePlan)
-		api.DELETE("/plans/:id", planHandler.DeletePlan)
-		api.GET("/plans/currency/:currency", planHandler.GetPlansByCurrency)
-
-		// NEW: Subscription endpoints
-		api.POST("/subscriptions", subscriptionHandler.CreateSubscription)
-		api.GET("/subscriptions/:id", subscriptionHandler.GetSubscription)
-		api.GET("/users/:user_id/subscriptions", subscriptionHandler.GetUserSubscriptions)
-		api.POST("/subscriptions/:id/cancel", subscriptionHandler.CancelSubscription)
-		api.PUT("/subscriptions/:id/card", subscriptionHandler.UpdateSubscriptionCard)
+		api.GET("/transactions/:transaction_id/disputes", disputeHandler.GetTransactionDisputes)
+		api.GET("/transactions/:transaction_id/timeline", transactionHandler.GetTimeline)
+
+		// Dispute endpoints
+		api.GET("/disputes", disputeHandler.GetDisputes)
+
+		// Gateway webhooks
+		api.POST("/webhooks/mastercard", webhookHandler.HandleGatewayNotification)
+
+		subscriptions := api.Group("", middleware.RequireFeature("subscriptions", cfg.SubscriptionsEnabled()))
+		{
+			// NEW: Plan endpoints
+			subscriptions.GET("/plans", planHandler.GetPlans)
+			subscriptions.GET("/plans/:id", planHandler.GetPlan)
+			subscriptions.POST("/plans", planHandler.CreatePlan)
+			subscriptions.POST("/plans/bulk", planHandler.CreatePlans)
+			subscriptions.PUT("/plans/:id", planHandler.UpdatePlan)
+			subscriptions.DELETE("/plans/:id", planHandler.DeletePlan)
+			subscriptions.POST("/plans/:id/activate", planHandler.ActivatePlan)
+			subscriptions.POST("/plans/:id/deactivate", planHandler.DeactivatePlan)
+			subscriptions.GET("/plans/currency/:currency", planHandler.GetPlansByCurrency)
+
+			// NEW: Subscription endpoints
+			subscriptions.POST("/subscriptions", subscriptionHandler.CreateSubscription)
+			subscriptions.GET("/subscriptions/:id", subscriptionHandler.GetSubscription)
+			subscriptions.GET("/users/:user_id/subscriptions", subscriptionHandler.GetUserSubscriptions)
+			subscriptions.POST("/users/:user_id/subscriptions/update-card", subscriptionHandler.UpdateCardForUser)
+			subscriptions.POST("/subscriptions/:id/cancel", subscriptionHandler.CancelSubscription)
+			subscriptions.PUT("/subscriptions/:id/card", subscriptionHandler.UpdateSubscriptionCard)
+			subscriptions.PATCH("/subscriptions/:id/metadata", subscriptionHandler.UpdateSubscriptionMetadata)
+			subscriptions.DELETE("/subscriptions/:id/metadata/:key", subscriptionHandler.DeleteSubscriptionMetadataKey)
+			subscriptions.POST("/subscriptions/:id/change-plan", subscriptionHandler.ChangePlan)
+
+			// Subscription billing endpoints
+			subscriptions.GET("/subscriptions/:id/billing-history", billingHandler.GetSubscriptionBillingHistory)
+			subscriptions.GET("/subscriptions/:id/transactions", billingHandler.GetSubscriptionTransactions)
+			subscriptions.POST("/billing/process", billingHandler.ProcessBillingAttempts)
+			subscriptions.POST("/billing-attempts/:id/authenticate", billingHandler.CompleteAuthentication)
+		}
 
 		// NEW: Billing endpoints
 		api.POST("/billing/manual", billingHandler.CreateManualPayment)
 		api.GET("/users/:user_id/billing-history", billingHandler.GetBillingHistory)
-		api.GET("/subscriptions/:id/billing-history", billingHandler.GetSubscriptionBillingHistory)
-		api.POST("/billing/process", billingHandler.ProcessBillingAttempts)
+		api.GET("/users/:user_id/credit-balance", creditHandler.GetCreditBalance)
 
 		// NEW: Add worker endpoints
 		api.GET("/worker/status", workerHandler.GetWorkerStatus)
-		api.POST("/worker/restart", workerHandler.RestartWorkers)
 
 		// NEW: Google Pay endpoints
-		api.POST("/pay/google-pay", googlePayHandler.Pay)
-		api.POST("/pay/google-pay/test", googlePayHandler.TestGooglePay)
-		api.GET("/users/:user_id/google-pay-cards", googlePayHandler.GetUserGooglePayCards)
-		api.DELETE("/google-pay/cards", googlePayHandler.DeleteGooglePayCard)
-		api.POST("/pay/google-pay/simulate", googlePayHandler.SimulateGooglePay)
-
-		api.POST("/pay/apple-pay", applePayHandler.Pay)
-		api.POST("/pay/apple-pay/test", applePayHandler.TestApplePay)
-		api.GET("/users/:user_id/apple-pay-cards", applePayHandler.GetUserApplePayCards)
-		api.DELETE("/apple-pay/cards", applePayHandler.DeleteApplePayCard)
+		googlePay := api.Group("", middleware.RequireFeature("google_pay", cfg.GooglePayEnabled()))
+		{
+			googlePay.POST("/pay/google-pay", googlePayHandler.Pay)
+			googlePay.POST("/pay/google-pay/test", googlePayHandler.TestGooglePay)
+			googlePay.GET("/users/:user_id/google-pay-cards", googlePayHandler.GetUserGooglePayCards)
+			googlePay.DELETE("/google-pay/cards", googlePayHandler.DeleteGooglePayCard)
+			googlePay.POST("/pay/google-pay/simulate", googlePayHandler.SimulateGooglePay)
+		}
+
+		applePay := api.Group("", middleware.RequireFeature("apple_pay", cfg.ApplePayEnabled()))
+		{
+			applePay.POST("/pay/apple-pay", applePayHandler.Pay)
+			applePay.POST("/pay/apple-pay/test", applePayHandler.TestApplePay)
+			applePay.GET("/users/:user_id/apple-pay-cards", applePayHandler.GetUserApplePayCards)
+			applePay.DELETE("/apple-pay/cards", applePayHandler.DeleteApplePayCard)
+		}
+
+		// Admin endpoints
+		admin := api.Group("/admin", middleware.RequireAdmin(cfg))
+		{
+			admin.PATCH("/transactions/:id/status", transactionHandler.UpdateTransactionStatus)
+			admin.GET("/transactions/:id/gateway-response", transactionHandler.GetGatewayResponse)
+			admin.POST("/users/:user_id/credits", creditHandler.GrantCredit)
+			admin.POST("/gateway/test", gatewayHandler.TestConnection)
+			admin.POST("/worker/restart", workerHandler.RestartWorkers)
+			admin.POST("/worker/:name/pause", workerHandler.PauseWorker)
+			admin.POST("/worker/:name/resume", workerHandler.ResumeWorker)
+
+			adminSubscriptions := admin.Group("", middleware.RequireFeature("subscriptions", cfg.SubscriptionsEnabled()))
+			{
+				adminSubscriptions.POST("/subscriptions/import", subscriptionHandler.ImportSubscriptions)
+				adminSubscriptions.GET("/subscriptions/stats", subscriptionHandler.GetSubscriptionStats)
+				adminSubscriptions.GET("/subscriptions/upcoming", subscriptionHandler.GetUpcomingBilling)
+				adminSubscriptions.POST("/billing/run-cycle", workerHandler.RunBillingCycle)
+				adminSubscriptions.GET("/billing-attempts", billingHandler.ListBillingAttempts)
+			}
+		}
 
 	}
 
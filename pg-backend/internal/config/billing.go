@@ -0,0 +1,102 @@
+package config
+
+import "time"
+
+// BillingItemTimeout bounds how long the billing worker spends on a single
+// subscription or billing attempt, including the gateway call
+// (BILLING_ITEM_TIMEOUT, default 60s). Zero or negative values fall back to
+// the default, since they would cancel every charge before it is sent.
+func (c *Config) BillingItemTimeout() time.Duration {
+	if timeout := envDuration("BILLING_ITEM_TIMEOUT", 60*time.Second); timeout > 0 {
+		return timeout
+	}
+	return 60 * time.Second
+}
+
+// BillingCycleTimeout bounds a billing cycle started on demand from the
+// admin API (BILLING_CYCLE_TIMEOUT, default 10m).
+func (c *Config) BillingCycleTimeout() time.Duration {
+	return envDuration("BILLING_CYCLE_TIMEOUT", 10*time.Minute)
+}
+
+// BillingLookAhead is how far ahead of now a billing cycle claims
+// subscriptions, so charges due just after the cycle runs aren't left for the
+// next one (BILLING_LOOKAHEAD, default 5m). Negative values fall back to the
+// default; 0 only bills subscriptions already due.
+func (c *Config) BillingLookAhead() time.Duration {
+	if window := envDuration("BILLING_LOOKAHEAD", 5*time.Minute); window >= 0 {
+		return window
+	}
+	return 5 * time.Minute
+}
+
+// BillingDueBatchSize is how many due subscriptions a billing cycle claims
+// (BILLING_DUE_BATCH_SIZE, default 100).
+func (c *Config) BillingDueBatchSize() int {
+	if size := envInt("BILLING_DUE_BATCH_SIZE", 100); size > 0 {
+		return size
+	}
+	return 100
+}
+
+// BillingRetrySchedule is the dunning cadence for subscription charges
+// (BILLING_RETRY_SCHEDULE, comma separated durations). Entry N is the wait
+// before attempt N, counted from when attempt N-1 failed; the first entry
+// stands for the initial charge, which runs when the subscription is due.
+// A charge is attempted at most len(schedule) times, so
+// "0h,24h,72h,168h,336h" allows four retries. The default keeps the original
+// backoff of one day, then four days.
+func (c *Config) BillingRetrySchedule() []time.Duration {
+	return envDurationList("BILLING_RETRY_SCHEDULE", []time.Duration{0, 24 * time.Hour, 96 * time.Hour})
+}
+
+// NonRetryableDeclineCodes lists decline codes whose failed billing attempts
+// are never retried (NON_RETRYABLE_DECLINE_CODES, comma separated). Canonical
+// codes and raw gateway codes such as EXPIRED_CARD are both accepted.
+func (c *Config) NonRetryableDeclineCodes() []string {
+	return envList("NON_RETRYABLE_DECLINE_CODES", []string{
+		"card_declined",
+		"insufficient_funds",
+		"invalid_card",
+		"expired_card",
+		"do_not_contact",
+	})
+}
+
+// BillingStartupDelay is how long the billing worker waits after starting
+// before its first cycle (BILLING_STARTUP_DELAY, default 0).
+func (c *Config) BillingStartupDelay() time.Duration {
+	if delay := envDuration("BILLING_STARTUP_DELAY", 0); delay > 0 {
+		return delay
+	}
+	return 0
+}
+
+// BillingStartupJitter adds a random wait of up to this long to the startup
+// delay, so instances restarted together by a deploy don't all run their
+// first cycle at once (BILLING_STARTUP_JITTER, default 0).
+func (c *Config) BillingStartupJitter() time.Duration {
+	if jitter := envDuration("BILLING_STARTUP_JITTER", 0); jitter > 0 {
+		return jitter
+	}
+	return 0
+}
+
+// BillingCycleLock makes each billing cycle take a database lock first, so
+// only one instance bills at a time and the others skip the cycle
+// (BILLING_CYCLE_LOCK, default true).
+func (c *Config) BillingCycleLock() bool {
+	return envBool("BILLING_CYCLE_LOCK", true)
+}
+
+// BillingProcessingTimeout is how long a billing attempt may stay in
+// processing before the billing worker assumes whoever was charging it
+// crashed and releases it (BILLING_PROCESSING_TIMEOUT, default 15m). Keep it
+// well above BillingItemTimeout so attempts still being charged aren't
+// released.
+func (c *Config) BillingProcessingTimeout() time.Duration {
+	if timeout := envDuration("BILLING_PROCESSING_TIMEOUT", 15*time.Minute); timeout > 0 {
+		return timeout
+	}
+	return 15 * time.Minute
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envString returns the value of key, or fallback when it is unset or empty
+func envString(key, fallback string) string {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// envInt returns key parsed as an int, or fallback when unset or invalid
+func envInt(key string, fallback int) int {
+	value, err := strconv.Atoi(strings.TrimSpace(os.Getenv(key)))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// envBool returns key parsed as a bool, or fallback when unset or invalid
+func envBool(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(strings.TrimSpace(os.Getenv(key)))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// envDuration returns key parsed with time.ParseDuration (e.g. "30s", "5m"),
+// or fallback when unset or invalid
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(strings.TrimSpace(os.Getenv(key)))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// envDurationList returns key as a comma separated list of durations (e.g.
+// "0h,24h,72h"), or fallback when unset or when any entry is invalid
+func envDurationList(key string, fallback []time.Duration) []time.Duration {
+	parts := envList(key, nil)
+	if len(parts) == 0 {
+		return fallback
+	}
+
+	values := make([]time.Duration, 0, len(parts))
+	for _, part := range parts {
+		value, err := time.ParseDuration(part)
+		if err != nil || value < 0 {
+			return fallback
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+// envList returns key split on commas with blanks dropped, or fallback when unset
+func envList(key string, fallback []string) []string {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return fallback
+	}
+
+	var values []string
+	for _, part := range strings.Split(raw, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			values = append(values, part)
+		}
+	}
+	return values
+}
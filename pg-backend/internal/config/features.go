@@ -0,0 +1,21 @@
+package config
+
+// ApplePayEnabled turns on the Apple Pay endpoints (ENABLE_APPLE_PAY,
+// default true). While off they answer 404 with code feature_disabled.
+func (c *Config) ApplePayEnabled() bool {
+	return envBool("ENABLE_APPLE_PAY", true)
+}
+
+// GooglePayEnabled turns on the Google Pay endpoints (ENABLE_GOOGLE_PAY,
+// default true). While off they answer 404 with code feature_disabled.
+func (c *Config) GooglePayEnabled() bool {
+	return envBool("ENABLE_GOOGLE_PAY", true)
+}
+
+// SubscriptionsEnabled turns on the plan, subscription and subscription
+// billing endpoints and runs the billing worker (ENABLE_SUBSCRIPTIONS,
+// default true). While off the endpoints answer 404 with code
+// feature_disabled.
+func (c *Config) SubscriptionsEnabled() bool {
+	return envBool("ENABLE_SUBSCRIPTIONS", true)
+}
@@ -0,0 +1,74 @@
+package config
+
+import "time"
+
+// GatewayAuthMethod selects how requests to the gateway are authenticated:
+// "basic" with the merchant API password, or "certificate" with a TLS client
+// certificate (GATEWAY_AUTH_METHOD). The default is certificate when
+// GATEWAY_CLIENT_CERT_FILE is set and basic otherwise.
+func (c *Config) GatewayAuthMethod() string {
+	fallback := "basic"
+	if c.GatewayClientCertFile() != "" {
+		fallback = "certificate"
+	}
+	return envString("GATEWAY_AUTH_METHOD", fallback)
+}
+
+// GatewayClientCertFile is the PEM client certificate presented to the
+// gateway for certificate auth, i.e. mutual TLS (GATEWAY_CLIENT_CERT_FILE)
+func (c *Config) GatewayClientCertFile() string {
+	return envString("GATEWAY_CLIENT_CERT_FILE", "")
+}
+
+// GatewayClientKeyFile is the PEM private key for GatewayClientCertFile
+// (GATEWAY_CLIENT_KEY_FILE)
+func (c *Config) GatewayClientKeyFile() string {
+	return envString("GATEWAY_CLIENT_KEY_FILE", "")
+}
+
+// GatewayMaxIdleConns caps idle keep-alive connections kept open to the
+// gateway (GATEWAY_MAX_IDLE_CONNS, default 100)
+func (c *Config) GatewayMaxIdleConns() int {
+	return envInt("GATEWAY_MAX_IDLE_CONNS", 100)
+}
+
+// GatewayMaxIdleConnsPerHost caps idle connections per gateway host
+// (GATEWAY_MAX_IDLE_CONNS_PER_HOST, default 20). Go's default of 2 means
+// concurrent calls during a billing run keep opening new TLS connections.
+func (c *Config) GatewayMaxIdleConnsPerHost() int {
+	return envInt("GATEWAY_MAX_IDLE_CONNS_PER_HOST", 20)
+}
+
+// GatewayIdleConnTimeout is how long an idle gateway connection is kept
+// before it is closed (GATEWAY_IDLE_CONN_TIMEOUT, default 90s)
+func (c *Config) GatewayIdleConnTimeout() time.Duration {
+	return envDuration("GATEWAY_IDLE_CONN_TIMEOUT", 90*time.Second)
+}
+
+// GatewayDisableKeepAlives opens a new connection for every gateway request,
+// which can help when debugging connection problems
+// (GATEWAY_DISABLE_KEEP_ALIVES, default false)
+func (c *Config) GatewayDisableKeepAlives() bool {
+	return envBool("GATEWAY_DISABLE_KEEP_ALIVES", false)
+}
+
+// GatewayBreakerThreshold is how many gateway requests in a row may fail
+// before the circuit breaker opens and further requests fail fast
+// (GATEWAY_BREAKER_THRESHOLD, default 5). Set it to 0 to disable the breaker.
+func (c *Config) GatewayBreakerThreshold() int {
+	return envInt("GATEWAY_BREAKER_THRESHOLD", 5)
+}
+
+// GatewayBreakerCooldown is how long an open circuit breaker waits before
+// letting a single trial request through (GATEWAY_BREAKER_COOLDOWN, default 30s)
+func (c *Config) GatewayBreakerCooldown() time.Duration {
+	return envDuration("GATEWAY_BREAKER_COOLDOWN", 30*time.Second)
+}
+
+// MockGateway replaces the gateway with an in-memory simulation, so payments
+// and subscription billing can be tried out offline (MOCK_GATEWAY, default
+// false). Outcomes are picked by sentinel amounts: one ending in .51 is
+// declined, for example. Never enable it in production.
+func (c *Config) MockGateway() bool {
+	return envBool("MOCK_GATEWAY", false)
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"strconv"
+	"strings"
+)
+
+// StatementDescriptor is the merchant default shown on cardholder statements
+// for subscription charges whose plan does not set its own
+// (MERCHANT_STATEMENT_DESCRIPTOR, default empty: use the gateway profile).
+func (c *Config) StatementDescriptor() string {
+	return envString("MERCHANT_STATEMENT_DESCRIPTOR", "")
+}
+
+// FXRates is the exchange rate table used when a charge presented in one
+// currency settles in another (FX_RATES, comma separated FROM/TO=rate pairs,
+// e.g. "USD/LKR=300.5,EUR/USD=1.08"). Invalid entries are ignored. When no
+// rates are configured every conversion is 1:1.
+func (c *Config) FXRates() map[string]float64 {
+	rates := make(map[string]float64)
+	for _, entry := range envList("FX_RATES", nil) {
+		pair, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			continue
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || rate <= 0 {
+			continue
+		}
+		rates[strings.ToUpper(strings.TrimSpace(pair))] = rate
+	}
+	return rates
+}
+
+// PaymentRoutes maps routing keys to the statement descriptor used for their
+// charges, so a marketplace can charge on behalf of several storefronts
+// (PAYMENT_ROUTES, comma separated key=descriptor pairs, e.g.
+// "books=ACME*BOOKS,games=ACME*GAMES"). Keys are case-insensitive; invalid
+// entries are ignored.
+func (c *Config) PaymentRoutes() map[string]string {
+	routes := make(map[string]string)
+	for _, entry := range envList("PAYMENT_ROUTES", nil) {
+		key, descriptor, ok := strings.Cut(entry, "=")
+		key, descriptor = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(descriptor)
+		if !ok || key == "" || descriptor == "" {
+			continue
+		}
+		routes[key] = descriptor
+	}
+	return routes
+}
+
+// AmountLimit bounds a single transaction's amount in one currency. A zero
+// Min or Max leaves that side unbounded.
+type AmountLimit struct {
+	Min float64
+	Max float64
+}
+
+// AmountLimits caps single transaction amounts per currency (AMOUNT_LIMITS,
+// comma separated CURRENCY=max or CURRENCY=min:max entries, e.g.
+// "USD=5000,LKR=100:1000000"). Invalid entries are ignored; currencies
+// without an entry are not limited.
+func (c *Config) AmountLimits() map[string]AmountLimit {
+	limits := make(map[string]AmountLimit)
+	for _, entry := range envList("AMOUNT_LIMITS", nil) {
+		currency, bounds, ok := strings.Cut(entry, "=")
+		currency = strings.ToUpper(strings.TrimSpace(currency))
+		if !ok || currency == "" {
+			continue
+		}
+
+		var limit AmountLimit
+		var err error
+		if min, max, hasMin := strings.Cut(bounds, ":"); hasMin {
+			if limit.Min, err = strconv.ParseFloat(strings.TrimSpace(min), 64); err != nil {
+				continue
+			}
+			bounds = max
+		}
+		if limit.Max, err = strconv.ParseFloat(strings.TrimSpace(bounds), 64); err != nil {
+			continue
+		}
+		if limit.Min < 0 || limit.Max <= 0 || limit.Min > limit.Max {
+			continue
+		}
+		limits[currency] = limit
+	}
+	return limits
+}
+
+// SupportedCurrencies are the currencies our acquirer settles, upper-cased
+// (SUPPORTED_CURRENCIES, comma separated ISO 4217 codes, e.g. "LKR,USD").
+// Empty, the default, allows every valid currency.
+func (c *Config) SupportedCurrencies() []string {
+	currencies := envList("SUPPORTED_CURRENCIES", nil)
+	for i, currency := range currencies {
+		currencies[i] = strings.ToUpper(currency)
+	}
+	return currencies
+}
+
+// CaptureMaxAttempts is how many times a capture is tried before it is
+// reported as failed (CAPTURE_MAX_ATTEMPTS, default 3).
+func (c *Config) CaptureMaxAttempts() int {
+	if attempts := envInt("CAPTURE_MAX_ATTEMPTS", 3); attempts > 0 {
+		return attempts
+	}
+	return 3
+}
+
+// VoidOnCaptureFailure releases an authorization once every capture attempt
+// has failed, so the customer's funds aren't held by a capture we can't
+// complete (VOID_ON_CAPTURE_FAILURE, default false). Capture requests may
+// override it with void_on_capture_failure.
+func (c *Config) VoidOnCaptureFailure() bool {
+	return envBool("VOID_ON_CAPTURE_FAILURE", false)
+}
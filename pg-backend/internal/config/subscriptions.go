@@ -0,0 +1,89 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+// Trial eligibility policies for TrialEligibility
+const (
+	// TrialPerPlan gives a user one free trial per plan
+	TrialPerPlan = "per_plan"
+	// TrialPerUser gives a user one free trial across all plans
+	TrialPerUser = "per_user"
+)
+
+// DefaultTrialPeriodDays is the trial length given to new plans that don't
+// specify one (DEFAULT_TRIAL_PERIOD_DAYS, default 0: no trial).
+func (c *Config) DefaultTrialPeriodDays() int {
+	if days := envInt("DEFAULT_TRIAL_PERIOD_DAYS", 0); days > 0 {
+		return days
+	}
+	return 0
+}
+
+// TrialEligibility decides who still gets a plan's free trial when they
+// subscribe (TRIAL_ELIGIBILITY, default per_plan). A user who has already
+// trialed or paid for the plan (per_plan), or for any plan (per_user), is
+// charged immediately instead of getting another trial.
+func (c *Config) TrialEligibility() string {
+	if strings.EqualFold(envString("TRIAL_ELIGIBILITY", TrialPerPlan), TrialPerUser) {
+		return TrialPerUser
+	}
+	return TrialPerPlan
+}
+
+// MaxActiveSubscriptionsPerUser caps how many active or trialing
+// subscriptions a single user may hold (MAX_ACTIVE_SUBSCRIPTIONS_PER_USER,
+// default 0: unlimited).
+func (c *Config) MaxActiveSubscriptionsPerUser() int {
+	if limit := envInt("MAX_ACTIVE_SUBSCRIPTIONS_PER_USER", 0); limit > 0 {
+		return limit
+	}
+	return 0
+}
+
+// Initial charge policies for AnchoredInitialCharge
+const (
+	// AnchoredChargeProrate charges the part of a period left until the anchor
+	AnchoredChargeProrate = "prorate"
+	// AnchoredChargeSkip charges nothing until the anchor
+	AnchoredChargeSkip = "skip"
+)
+
+// AnchoredInitialCharge decides what a subscription created with a billing
+// cycle anchor is charged before its first full period starts at the anchor
+// (ANCHORED_INITIAL_CHARGE, default prorate).
+func (c *Config) AnchoredInitialCharge() string {
+	if strings.EqualFold(envString("ANCHORED_INITIAL_CHARGE", AnchoredChargeProrate), AnchoredChargeSkip) {
+		return AnchoredChargeSkip
+	}
+	return AnchoredChargeProrate
+}
+
+// IncompleteSubscriptionExpiry is how long a new subscription may wait for
+// its first charge to succeed before it expires as incomplete_expired
+// (INCOMPLETE_SUBSCRIPTION_EXPIRY, default 23h).
+func (c *Config) IncompleteSubscriptionExpiry() time.Duration {
+	if window := envDuration("INCOMPLETE_SUBSCRIPTION_EXPIRY", 23*time.Hour); window > 0 {
+		return window
+	}
+	return 23 * time.Hour
+}
+
+// RenewalReminderWindow is how long before a renewal charge the customer is
+// sent a subscription.renewal_reminder event (RENEWAL_REMINDER_WINDOW,
+// default 168h). Set it to 0 to turn reminders off.
+func (c *Config) RenewalReminderWindow() time.Duration {
+	if window := envDuration("RENEWAL_REMINDER_WINDOW", 7*24*time.Hour); window >= 0 {
+		return window
+	}
+	return 7 * 24 * time.Hour
+}
+
+// RenewalReminderIntervals lists the billing intervals whose renewals get a
+// reminder (RENEWAL_REMINDER_INTERVALS, comma separated, default year).
+// Short intervals renew too often for a reminder to be useful.
+func (c *Config) RenewalReminderIntervals() []string {
+	return envList("RENEWAL_REMINDER_INTERVALS", []string{"year"})
+}
@@ -0,0 +1,8 @@
+package config
+
+// WebhookSecret is the notification secret configured for this merchant on
+// the gateway, sent with every notification in the X-Notification-Secret
+// header (MASTERCARD_WEBHOOK_SECRET). When unset, notifications are refused.
+func (c *Config) WebhookSecret() string {
+	return envString("MASTERCARD_WEBHOOK_SECRET", "")
+}
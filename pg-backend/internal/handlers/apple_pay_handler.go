@@ -19,6 +19,7 @@ type ApplePayHandler struct {
 	userRepo          repositories.UserRepository
 	cardRepo          repositories.CardRepository
 	transactionRepo   repositories.TransactionRepository
+	paymentService    services.PaymentService
 }
 
 func NewApplePayHandler(
@@ -26,12 +27,14 @@ func NewApplePayHandler(
 	userRepo repositories.UserRepository,
 	cardRepo repositories.CardRepository,
 	transactionRepo repositories.TransactionRepository,
+	paymentService services.PaymentService,
 ) *ApplePayHandler {
 	return &ApplePayHandler{
 		mastercardService: mastercardService,
 		userRepo:          userRepo,
 		cardRepo:          cardRepo,
 		transactionRepo:   transactionRepo,
+		paymentService:    paymentService,
 	}
 }
 
@@ -58,97 +61,82 @@ type ApplePayRequest struct {
 
 // ApplePayResponse represents Apple Pay payment response
 type ApplePayResponse struct {
-	Success        bool   `json:"success"`
-	Message        string `json:"message"`
-	TransactionID  string `json:"transaction_id,omitempty"`
-	OrderID        string `json:"order_id,omitempty"`
-	Amount         string `json:"amount,omitempty"`
-	Currency       string `json:"currency,omitempty"`
-	Status         string `json:"status,omitempty"`
-	WalletProvider string `json:"wallet_provider,omitempty"`
-	CardID         string `json:"card_id,omitempty"`
-	IsSimulated    bool   `json:"is_simulated,omitempty"`
-	UsedFallback   bool   `json:"used_fallback,omitempty"`
+	Success        bool          `json:"success"`
+	Message        string        `json:"message"`
+	TransactionID  string        `json:"transaction_id,omitempty"`
+	OrderID        string        `json:"order_id,omitempty"`
+	Amount         *models.Money `json:"amount,omitempty"`
+	Currency       string        `json:"currency,omitempty"`
+	Status         string        `json:"status,omitempty"`
+	WalletProvider string        `json:"wallet_provider,omitempty"`
+	CardID         string        `json:"card_id,omitempty"`
+	IsSimulated    bool          `json:"is_simulated,omitempty"`
+	UsedFallback   bool          `json:"used_fallback,omitempty"`
 }
 
 // Pay processes an Apple Pay payment
 func (h *ApplePayHandler) Pay(c *gin.Context) {
 	var req ApplePayRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondValidationError(c, err)
 		return
 	}
 
-	// Validate user exists
-	userID, err := uuid.Parse(req.UserID)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
-		return
-	}
-
-	_, err = h.userRepo.GetUserByID(c.Request.Context(), userID)
-	if err != nil {
-		if _, ok := err.(*repositories.NotFoundError); ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	chargeReq, ok := chargeRequest(c, req.UserID, "")
+	if !ok {
 		return
 	}
+	userID := chargeReq.UserID
+	chargeReq.Amount = req.Amount
+	chargeReq.Currency = req.Currency
+	chargeReq.PaymentMethodType = models.PaymentMethodTypeApplePay
+	chargeReq.WalletProvider = models.WalletProviderApplePay
 
-	var paymentResp *services.PaymentResponse
 	var usedFallback bool
 	var isSimulated bool
 
 	// Determine which payment method to use
 	if req.PaymentToken != "" {
-		// Method 1: Try with encrypted payment token (requires Device Payments privilege)
-		paymentResp, err = h.processWithPaymentToken(req)
-		if err != nil && strings.Contains(err.Error(), "Missing merchant privilege") {
-			// Fallback to simulation if privilege missing
-			usedFallback = true
-			paymentResp, err = h.simulateApplePay(req)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error":   "Apple Pay payment failed",
-					"details": err.Error(),
-				})
-				return
-			}
-			isSimulated = true
-		} else if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":   "Apple Pay payment failed",
-				"details": err.Error(),
-			})
-			return
-		}
+		// Method 1: Encrypted payment token (requires Device Payments privilege)
+		chargeReq.Source = services.PaymentSourceWalletToken
+		chargeReq.WalletToken = req.PaymentToken
 	} else if req.CardNumber != "" && req.Cryptogram != "" {
 		// Method 2: Use decrypted card details (for testing)
-		paymentResp, err = h.processWithCardDetails(req)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":   "Apple Pay payment failed",
-				"details": err.Error(),
-			})
-			return
+		chargeReq.Source = services.PaymentSourceDevicePayment
+		chargeReq.Card = &services.CardDetails{
+			Number:      req.CardNumber,
+			ExpiryMonth: req.ExpiryMonth,
+			ExpiryYear:  req.ExpiryYear,
+			Cryptogram:  req.Cryptogram,
+			ECI:         req.EciIndicator,
 		}
 	} else {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "either payment_token or (card_number + cryptogram) required",
-		})
+		respondError(c, http.StatusBadRequest, "either payment_token or (card_number + cryptogram) required")
 		return
 	}
-
-	// Validate payment response
-	if paymentResp.Result != "SUCCESS" && paymentResp.GatewayCode != "APPROVED" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":  "Apple Pay payment declined",
-			"code":   paymentResp.GatewayCode,
-			"result": paymentResp.Result,
-		})
+	chargeReq.DevicePaymentData = applePayDeviceData(req, false)
+
+	result, err := h.paymentService.Charge(c.Request.Context(), chargeReq)
+	if err != nil && chargeReq.Source == services.PaymentSourceWalletToken &&
+		strings.Contains(err.Error(), "Missing merchant privilege") {
+		// Fallback to simulation with a test card if privilege missing
+		usedFallback = true
+		isSimulated = true
+		chargeReq.Source = services.PaymentSourceCard
+		chargeReq.Card = &services.CardDetails{
+			Number:      "4111111111111111", // Test Visa
+			ExpiryMonth: "12",
+			ExpiryYear:  "2028",
+			CVV:         "123",
+		}
+		chargeReq.DevicePaymentData = applePayDeviceData(req, true)
+		result, err = h.paymentService.Charge(c.Request.Context(), chargeReq)
+	}
+	if err != nil {
+		respondPaymentError(c, err, "Apple Pay payment")
 		return
 	}
+	paymentResp := result.Response
 
 	// Save Apple Pay as payment method if requested
 	var savedCardID uuid.UUID
@@ -162,20 +150,13 @@ func (h *ApplePayHandler) Pay(c *gin.Context) {
 		}
 	}
 
-	// Save transaction to database
-	transaction := h.createApplePayTransactionModel(userID, req, paymentResp, isSimulated)
-	err = h.transactionRepo.CreateTransaction(c.Request.Context(), transaction)
-	if err != nil {
-		fmt.Printf("Warning: Failed to save Apple Pay transaction: %v\n", err)
-	}
-
 	// Prepare response
 	response := ApplePayResponse{
 		Success:        paymentResp.Result == "SUCCESS",
 		Message:        "Apple Pay payment processed successfully",
 		TransactionID:  paymentResp.Transaction.ID,
 		OrderID:        paymentResp.Order.ID,
-		Amount:         utils.ConvertToString(paymentResp.Order.Amount),
+		Amount:         models.NewMoney(paymentResp.Order.Amount.Float64(), paymentResp.Order.Currency),
 		Currency:       paymentResp.Order.Currency,
 		Status:         paymentResp.Transaction.Status,
 		WalletProvider: "APPLE_PAY",
@@ -195,41 +176,6 @@ func (h *ApplePayHandler) Pay(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
-// processWithPaymentToken handles encrypted payment token
-func (h *ApplePayHandler) processWithPaymentToken(req ApplePayRequest) (*services.PaymentResponse, error) {
-	// Note: You'll need to add this method to your MastercardService interface
-	// For now, we'll simulate it
-	return nil, fmt.Errorf("Missing merchant privilege 'Device Payments'")
-}
-
-// processWithCardDetails handles decrypted card details
-func (h *ApplePayHandler) processWithCardDetails(req ApplePayRequest) (*services.PaymentResponse, error) {
-	// Use Google Pay method as fallback (similar structure)
-	return h.mastercardService.PayWithGooglePay(
-		req.CardNumber,
-		req.ExpiryMonth,
-		req.ExpiryYear,
-		req.Cryptogram,
-		req.EciIndicator,
-		req.Amount,
-		req.Currency,
-	)
-}
-
-// simulateApplePay simulates Apple Pay for testing
-func (h *ApplePayHandler) simulateApplePay(req ApplePayRequest) (*services.PaymentResponse, error) {
-	// Use test data for simulation
-	// You can add Apple Pay test constants to services/mastercard_service.go
-	return h.mastercardService.PayWithCard(
-		"4111111111111111", // Test Visa
-		"12",
-		"2028",
-		"123",
-		req.Amount,
-		req.Currency,
-	)
-}
-
 // Helper methods to create models
 func (h *ApplePayHandler) createApplePayCardModel(userID uuid.UUID, req ApplePayRequest, paymentResp *services.PaymentResponse) *models.Card {
 	card := &models.Card{
@@ -256,7 +202,9 @@ func (h *ApplePayHandler) createApplePayCardModel(userID uuid.UUID, req ApplePay
 	return card
 }
 
-func (h *ApplePayHandler) createApplePayTransactionModel(userID uuid.UUID, req ApplePayRequest, paymentResp *services.PaymentResponse, isSimulated bool) *models.Transaction {
+// applePayDeviceData is the device payment data recorded with an Apple Pay
+// transaction
+func applePayDeviceData(req ApplePayRequest, isSimulated bool) map[string]interface{} {
 	deviceData := map[string]interface{}{
 		"is_simulated": isSimulated,
 	}
@@ -270,17 +218,7 @@ func (h *ApplePayHandler) createApplePayTransactionModel(userID uuid.UUID, req A
 		deviceData["has_payment_token"] = true
 	}
 
-	return &models.Transaction{
-		UserID:               userID,
-		Amount:               utils.MustParseFloat(req.Amount),
-		Currency:             req.Currency,
-		Status:               paymentResp.Transaction.Status,
-		GatewayTransactionID: paymentResp.Transaction.ID,
-		Type:                 "manual",
-		WalletProvider:       models.WalletProviderApplePay,
-		PaymentMethodType:    models.PaymentMethodTypeApplePay,
-		DevicePaymentData:    deviceData,
-	}
+	return deviceData
 }
 
 // Helper extraction functions
@@ -309,7 +247,7 @@ func extractCardScheme(req ApplePayRequest) string {
 	if req.CardNumber == "" {
 		return "VISA" // Default for simulation
 	}
-	return getCardScheme(req.CardNumber)
+	return services.DetectCardScheme(req.CardNumber)
 }
 
 // TestApplePay for Postman testing
@@ -322,24 +260,24 @@ func (h *ApplePayHandler) TestApplePay(c *gin.Context) {
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondValidationError(c, err)
 		return
 	}
 
 	// Validate user exists
 	userID, err := uuid.Parse(req.UserID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		respondError(c, http.StatusBadRequest, "invalid user ID")
 		return
 	}
 
 	_, err = h.userRepo.GetUserByID(c.Request.Context(), userID)
 	if err != nil {
 		if _, ok := err.(*repositories.NotFoundError); ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			respondError(c, http.StatusNotFound, "user not found")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -377,10 +315,7 @@ func (h *ApplePayHandler) TestApplePay(c *gin.Context) {
 	)
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "Apple Pay test payment failed",
-			"details": err.Error(),
-		})
+		respondErrorDetails(c, http.StatusInternalServerError, models.ErrorCodeInternal, "Apple Pay test payment failed", gin.H{"cause": err.Error()})
 		return
 	}
 
@@ -391,7 +326,11 @@ func (h *ApplePayHandler) TestApplePay(c *gin.Context) {
 		Currency:             req.Currency,
 		Status:               paymentResp.Transaction.Status,
 		GatewayTransactionID: paymentResp.Transaction.ID,
-		Type:                 "test",
+		GatewayOrderID:       paymentResp.Order.ID,
+		GatewayResponse:      paymentResp.Raw,
+		CorrelationID:        paymentResp.CorrelationID,
+		GatewayCorrelationID: paymentResp.GatewayCorrelationID,
+		Type:                 models.TransactionTypeTest,
 		WalletProvider:       models.WalletProviderApplePay,
 		PaymentMethodType:    models.PaymentMethodTypeApplePay,
 		DevicePaymentData: map[string]interface{}{
@@ -412,7 +351,7 @@ func (h *ApplePayHandler) TestApplePay(c *gin.Context) {
 		Message:        fmt.Sprintf("Apple Pay test payment processed (%s)", req.TestType),
 		TransactionID:  paymentResp.Transaction.ID,
 		OrderID:        paymentResp.Order.ID,
-		Amount:         utils.ConvertToString(paymentResp.Order.Amount),
+		Amount:         models.NewMoney(paymentResp.Order.Amount.Float64(), paymentResp.Order.Currency),
 		Currency:       paymentResp.Order.Currency,
 		Status:         paymentResp.Transaction.Status,
 		WalletProvider: models.WalletProviderApplePay,
@@ -428,7 +367,7 @@ func (h *ApplePayHandler) GetUserApplePayCards(c *gin.Context) {
 
 	uid, err := uuid.Parse(userID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		respondError(c, http.StatusBadRequest, "invalid user ID")
 		return
 	}
 
@@ -436,17 +375,17 @@ func (h *ApplePayHandler) GetUserApplePayCards(c *gin.Context) {
 	_, err = h.userRepo.GetUserByID(c.Request.Context(), uid)
 	if err != nil {
 		if _, ok := err.(*repositories.NotFoundError); ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			respondError(c, http.StatusNotFound, "user not found")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	// Get all cards for user
 	allCards, err := h.cardRepo.GetCardsByUserID(c.Request.Context(), uid)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -458,7 +397,7 @@ func (h *ApplePayHandler) GetUserApplePayCards(c *gin.Context) {
 		}
 	}
 
-	c.JSON(http.StatusOK, applePayCards)
+	c.JSON(http.StatusOK, newCardResponses(applePayCards))
 }
 
 // DeleteApplePayCard deletes a user's Apple Pay card
@@ -469,19 +408,19 @@ func (h *ApplePayHandler) DeleteApplePayCard(c *gin.Context) {
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondValidationError(c, err)
 		return
 	}
 
 	userID, err := uuid.Parse(req.UserID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		respondError(c, http.StatusBadRequest, "invalid user ID")
 		return
 	}
 
 	cardID, err := uuid.Parse(req.CardID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid card ID"})
+		respondError(c, http.StatusBadRequest, "invalid card ID")
 		return
 	}
 
@@ -489,10 +428,10 @@ func (h *ApplePayHandler) DeleteApplePayCard(c *gin.Context) {
 	_, err = h.userRepo.GetUserByID(c.Request.Context(), userID)
 	if err != nil {
 		if _, ok := err.(*repositories.NotFoundError); ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			respondError(c, http.StatusNotFound, "user not found")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -500,22 +439,22 @@ func (h *ApplePayHandler) DeleteApplePayCard(c *gin.Context) {
 	card, err := h.cardRepo.GetCardByID(c.Request.Context(), cardID)
 	if err != nil {
 		if _, ok := err.(*repositories.NotFoundError); ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "card not found"})
+			respondError(c, http.StatusNotFound, "card not found")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	// Verify card belongs to user
 	if card.UserID != userID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "card does not belong to user"})
+		respondError(c, http.StatusForbidden, "card does not belong to user")
 		return
 	}
 
 	// Verify it's an Apple Pay card
 	if card.PaymentMethodType != models.PaymentMethodTypeApplePay {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "card is not an Apple Pay payment method"})
+		respondError(c, http.StatusBadRequest, "card is not an Apple Pay payment method")
 		return
 	}
 
@@ -523,10 +462,10 @@ func (h *ApplePayHandler) DeleteApplePayCard(c *gin.Context) {
 	err = h.cardRepo.DeleteCard(c.Request.Context(), cardID)
 	if err != nil {
 		if _, ok := err.(*repositories.NotFoundError); ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "card not found"})
+			respondError(c, http.StatusNotFound, "card not found")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -6,10 +6,8 @@
 		"pg-backend/internal/models"
 		"pg-backend/internal/repositories"
 		"pg-backend/internal/services"
-		"pg-backend/pkg/utils"
 
 		"github.com/gin-gonic/gin"
-		"github.com/google/uuid"
 	)
 
 	type AuthorizationHandler struct {
@@ -17,6 +15,7 @@
 		userRepo          repositories.UserRepository
 		cardRepo          repositories.CardRepository
 		transactionRepo   repositories.TransactionRepository
+		paymentService    services.PaymentService
 	}
 
 	func NewAuthorizationHandler(
@@ -24,12 +23,14 @@
 		userRepo repositories.UserRepository,
 		cardRepo repositories.CardRepository,
 		transactionRepo repositories.TransactionRepository,
+		paymentService services.PaymentService,
 	) *AuthorizationHandler {
 		return &AuthorizationHandler{
 			mastercardService: mastercardService,
 			userRepo:          userRepo,
 			cardRepo:          cardRepo,
 			transactionRepo:   transactionRepo,
+			paymentService:    paymentService,
 		}
 	}
 
@@ -44,211 +45,185 @@
 		Amount      string `json:"amount" binding:"required"`
 		Currency    string `json:"currency" binding:"required"`
 		Description string `json:"description,omitempty"`
+
+		// Merchant's own order number, searchable in the gateway's merchant portal
+		MerchantReference string `json:"merchant_reference,omitempty" binding:"omitempty,max=40"`
+
+		// routing_key or sub_merchant picks the storefront the funds are held for
+		Metadata map[string]string `json:"metadata,omitempty"`
 	}
 
 	// AuthorizeResponse for authorization response
 	type AuthorizeResponse struct {
-		Success       bool   `json:"success"`
-		Message       string `json:"message"`
-		TransactionID string `json:"transaction_id,omitempty"`
-		OrderID       string `json:"order_id,omitempty"`
-		Amount        string `json:"amount,omitempty"`
-		Currency      string `json:"currency,omitempty"`
-		Status        string `json:"status,omitempty"`
-		Type          string `json:"type,omitempty"` // "authorization"
+		Success       bool                   `json:"success"`
+		Message       string                 `json:"message"`
+		TransactionID string                 `json:"transaction_id,omitempty"`
+		OrderID       string                 `json:"order_id,omitempty"`
+		Amount        *models.Money          `json:"amount,omitempty"`
+		Currency      string                 `json:"currency,omitempty"`
+		Status        string                 `json:"status,omitempty"`
+		Type          models.TransactionType `json:"type,omitempty"`
+
+		MerchantReference string `json:"merchant_reference,omitempty"`
 	}
 
 	// Authorize holds funds without charging
 	func (h *AuthorizationHandler) Authorize(c *gin.Context) {
 		var req AuthorizeRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			respondValidationError(c, err)
 			return
 		}
 
-		// Validate user exists
-		userID, err := uuid.Parse(req.UserID)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		chargeReq, ok := chargeRequest(c, req.UserID, req.CardID)
+		if !ok {
 			return
 		}
-
-		_, err = h.userRepo.GetUserByID(c.Request.Context(), userID)
-		if err != nil {
-			if _, ok := err.(*repositories.NotFoundError); ok {
-				c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
-				return
+		chargeReq.Amount = req.Amount
+		chargeReq.Currency = req.Currency
+		chargeReq.MerchantReference = req.MerchantReference
+		chargeReq.Metadata = req.Metadata
+		if req.CardID == "" {
+			chargeReq.Source = services.PaymentSourceCard
+			chargeReq.Card = &services.CardDetails{
+				Number:      req.CardNumber,
+				ExpiryMonth: req.ExpiryMonth,
+				ExpiryYear:  req.ExpiryYear,
+				CVV:         req.CVV,
 			}
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
 		}
 
-		var authResp *services.PaymentResponse
-		var cardID uuid.UUID
-		var card *models.Card
-
-		// Check if using saved card or new card
-		if req.CardID != "" {
-			// Authorize with saved card (using token)
-			cardID, err = uuid.Parse(req.CardID)
-			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid card ID"})
-				return
-			}
-
-			// Get card from database
-			card, err = h.cardRepo.GetCardByID(c.Request.Context(), cardID)
-			if err != nil {
-				if _, ok := err.(*repositories.NotFoundError); ok {
-					c.JSON(http.StatusNotFound, gin.H{"error": "card not found"})
-					return
-				}
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-
-			// Verify card belongs to user
-			if card.UserID != userID {
-				c.JSON(http.StatusForbidden, gin.H{"error": "card does not belong to user"})
-				return
-			}
-
-			// Authorize with token
-			authResp, err = h.mastercardService.AuthorizeWithToken(
-				card.GatewayToken,
-				req.Amount,
-				req.Currency,
-			)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error":   "authorization failed",
-					"details": err.Error(),
-				})
-				return
-			}
-
-		} else {
-			// Authorize with new card details
-			if req.CardNumber == "" || req.ExpiryMonth == "" || req.ExpiryYear == "" || req.CVV == "" {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "card details required when not using saved card"})
-				return
-			}
-
-			authResp, err = h.mastercardService.AuthorizeWithCard(
-				req.CardNumber,
-				req.ExpiryMonth,
-				req.ExpiryYear,
-				req.CVV,
-				req.Amount,
-				req.Currency,
-			)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error":   "authorization failed",
-					"details": err.Error(),
-				})
-				return
-			}
-		}
-
-		// Validate authorization response
-		if authResp.Result != "SUCCESS" && authResp.GatewayCode != "APPROVED" {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error":  "authorization declined",
-				"code":   authResp.GatewayCode,
-				"result": authResp.Result,
-			})
-			return
-		}
-
-		// Save authorization transaction to database
-		transaction := &models.Transaction{
-			UserID:               userID,
-			Amount:               utils.MustParseFloat(req.Amount),
-			Currency:             req.Currency,
-			Status:               authResp.Transaction.Status,
-			GatewayTransactionID: authResp.Transaction.ID,
-			Type:                 "authorization",
-			// Store order ID for future capture/void
-		}
-
-		// If using saved card, set card ID
-		if req.CardID != "" {
-			transaction.CardID = cardID
-		}
-
-		// Save authorization to database
-		err = h.transactionRepo.CreateTransaction(c.Request.Context(), transaction)
+		// The recorded authorization keeps the gateway order ID for later
+		// captures and voids
+		result, err := h.paymentService.Authorize(c.Request.Context(), chargeReq)
 		if err != nil {
-			// Log error but still return success
-			println("Warning: Failed to save authorization to database:", err.Error())
+			respondPaymentError(c, err, "authorization")
+			return
 		}
 
+		authResp := result.Response
 		response := AuthorizeResponse{
 			Success:       authResp.Result == "SUCCESS",
 			Message:       "Funds authorized successfully",
 			TransactionID: authResp.Transaction.ID,
 			OrderID:       authResp.Order.ID,
-			Amount:        utils.ConvertToString(authResp.Order.Amount),
+			Amount:        models.NewMoney(authResp.Order.Amount.Float64(), authResp.Order.Currency),
 			Currency:      authResp.Order.Currency,
 			Status:        authResp.Transaction.Status,
-			Type:          "authorization",
+			Type:          models.TransactionTypeAuthorization,
+
+			MerchantReference: req.MerchantReference,
 		}
 
 		c.JSON(http.StatusOK, response)
 	}
 
-	// CaptureRequest for capturing authorized funds
+	// CaptureRequest for capturing authorized funds. An authorization can be
+	// captured in several parts, e.g. for split shipments.
 	type CaptureRequest struct {
 		OrderID  string `json:"order_id" binding:"required"`
 		Amount   string `json:"amount" binding:"required"`
 		Currency string `json:"currency" binding:"required"`
+
+		// Release whatever is left of the authorization once this capture succeeds
+		VoidRemainder bool `json:"void_remainder,omitempty"`
+
+		// Release the authorization if every capture attempt fails. Defaults to
+		// the VOID_ON_CAPTURE_FAILURE setting.
+		VoidOnCaptureFailure *bool `json:"void_on_capture_failure,omitempty"`
 	}
 
 	// Capture captures previously authorized funds
 	func (h *AuthorizationHandler) Capture(c *gin.Context) {
 		var req CaptureRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			respondValidationError(c, err)
 			return
 		}
 
-		captureResp, err := h.mastercardService.CaptureAuthorization(
-			req.OrderID,
-			req.Amount,
-			req.Currency,
-		)
+		result, err := h.paymentService.Capture(c.Request.Context(), services.CaptureRequest{
+			OrderID:       req.OrderID,
+			Amount:        req.Amount,
+			Currency:      req.Currency,
+			VoidRemainder: req.VoidRemainder,
+			VoidOnFailure: req.VoidOnCaptureFailure,
+		})
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":   "capture failed",
-				"details": err.Error(),
-			})
+			switch e := err.(type) {
+			case *services.ConflictError:
+				respondError(c, http.StatusConflict, e.Error())
+			case *services.CaptureFailedError:
+				details := gin.H{
+					"attempts":          e.Attempts,
+					"remaining_balance": e.Remaining,
+				}
+				status, code, message := http.StatusInternalServerError, models.ErrorCodeInternal, "capture failed"
+				if e.Err != nil {
+					details["cause"] = e.Err.Error()
+				} else {
+					status, code, message = http.StatusBadRequest, models.ErrorCodePaymentDeclined, "capture declined"
+					details["gateway_code"] = e.GatewayCode
+					details["result"] = e.Result
+				}
+				if e.Void != nil {
+					details["authorization_voided"] = true
+					details["void_transaction_id"] = e.Void.GatewayTransactionID
+					details["remaining_balance"] = 0
+				} else if e.VoidErr != nil {
+					details["authorization_voided"] = false
+					details["void_error"] = e.VoidErr.Error()
+				}
+				respondErrorDetails(c, status, code, message, details)
+			default:
+				respondPaymentError(c, err, "capture")
+			}
 			return
 		}
 
-		// Save capture transaction to database
-		captureTransaction := &models.Transaction{
-			Amount:               utils.MustParseFloat(req.Amount),
-			Currency:             req.Currency,
-			Status:               captureResp.Transaction.Status,
-			GatewayTransactionID: captureResp.Transaction.ID,
-			Type:                 "capture",
-			// Note: We would need to lookup the original authorization to set userID/cardID
+		captureResp := result.Response
+		response := gin.H{
+			"success":           true,
+			"message":           "Funds captured successfully",
+			"transaction_id":    captureResp.Transaction.ID,
+			"authorization_id":  result.Authorization.ID,
+			"amount":            models.NewMoney(captureResp.Transaction.Amount.Float64(), captureResp.Transaction.Currency),
+			"currency":          captureResp.Transaction.Currency,
+			"status":            captureResp.Transaction.Status,
+			"captured_amount":   models.NewMoney(result.Captured, result.Authorization.Currency),
+			"remaining_balance": models.NewMoney(result.Remaining, result.Authorization.Currency),
+		}
+		if result.Void != nil {
+			response["void_transaction_id"] = result.Void.GatewayTransactionID
+		} else if result.VoidErr != nil {
+			response["message"] = "Funds captured, but the remaining balance could not be voided"
+			response["void_error"] = result.VoidErr.Error()
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+
+	// GetAuthorizationBalance reports how much of an authorization has been
+	// captured and how much is still available
+	func (h *AuthorizationHandler) GetAuthorizationBalance(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		authorization, err := h.transactionRepo.GetAuthorizationByGatewayOrderID(ctx, c.Param("order_id"))
+		if err != nil {
+			if _, ok := err.(*repositories.NotFoundError); ok {
+				respondError(c, http.StatusNotFound, "authorization not found")
+				return
+			}
+			respondError(c, http.StatusInternalServerError, err.Error())
+			return
 		}
 
-		// Save capture to database
-		if h.transactionRepo != nil {
-			_ = h.transactionRepo.CreateTransaction(c.Request.Context(), captureTransaction)
+		balance, err := h.transactionRepo.GetAuthorizationBalance(ctx, authorization)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, err.Error())
+			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"success":        captureResp.Result == "SUCCESS",
-			"message":        "Funds captured successfully",
-			"transaction_id": captureResp.Transaction.ID,
-			"amount":         captureResp.Transaction.Amount,
-			"currency":       captureResp.Transaction.Currency,
-			"status":         captureResp.Transaction.Status,
-		})
+		c.JSON(http.StatusOK, balance)
 	}
 
 	// VoidRequest for voiding an authorization
@@ -260,24 +235,49 @@
 	func (h *AuthorizationHandler) Void(c *gin.Context) {
 		var req VoidRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			respondValidationError(c, err)
 			return
 		}
 
-		voidResp, err := h.mastercardService.VoidAuthorization(req.OrderID)
+		ctx := c.Request.Context()
+
+		authorization, err := h.transactionRepo.GetAuthorizationByGatewayOrderID(ctx, req.OrderID)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":   "void failed",
-				"details": err.Error(),
+			if _, ok := err.(*repositories.NotFoundError); !ok {
+				respondError(c, http.StatusInternalServerError, err.Error())
+				return
+			}
+			// Authorizations made before they were recorded can still be voided
+			authorization = nil
+		}
+
+		if authorization == nil {
+			voidResp, err := h.mastercardService.VoidAuthorization(req.OrderID)
+			if err != nil {
+				respondErrorDetails(c, http.StatusInternalServerError, models.ErrorCodeInternal, "void failed", gin.H{"cause": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"success":        voidResp.Result == "SUCCESS",
+				"message":        "Authorization voided successfully",
+				"transaction_id": voidResp.Transaction.ID,
+				"status":         voidResp.Transaction.Status,
 			})
 			return
 		}
 
+		voidTransaction, err := h.paymentService.VoidAuthorization(ctx, authorization)
+		if err != nil {
+			respondErrorDetails(c, http.StatusInternalServerError, models.ErrorCodeInternal, "void failed", gin.H{"cause": err.Error()})
+			return
+		}
+
 		c.JSON(http.StatusOK, gin.H{
-			"success":        voidResp.Result == "SUCCESS",
+			"success":        true,
 			"message":        "Authorization voided successfully",
-			"transaction_id": voidResp.Transaction.ID,
-			"status":         voidResp.Transaction.Status,
+			"transaction_id": voidTransaction.GatewayTransactionID,
+			"status":         voidTransaction.Status,
 		})
 	}
 
@@ -292,7 +292,7 @@
 	func (h *AuthorizationHandler) UpdateAuthorization(c *gin.Context) {
 		var req UpdateAuthorizationRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			respondValidationError(c, err)
 			return
 		}
 
@@ -302,10 +302,7 @@
 			req.Currency,
 		)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":   "update authorization failed",
-				"details": err.Error(),
-			})
+			respondErrorDetails(c, http.StatusInternalServerError, models.ErrorCodeInternal, "update authorization failed", gin.H{"cause": err.Error()})
 			return
 		}
 
@@ -313,7 +310,7 @@
 			"success":        updateResp.Result == "SUCCESS",
 			"message":        "Authorization updated successfully",
 			"transaction_id": updateResp.Transaction.ID,
-			"amount":         updateResp.Transaction.Amount,
+			"amount":         models.NewMoney(updateResp.Transaction.Amount.Float64(), updateResp.Transaction.Currency),
 			"currency":       updateResp.Transaction.Currency,
 			"status":         updateResp.Transaction.Status,
 		})
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"strconv"
 
+	"pg-backend/internal/models"
 	"pg-backend/internal/services"
 
 	"github.com/gin-gonic/gin"
@@ -33,20 +34,20 @@ type CreateManualPaymentRequest struct {
 func (h *BillingHandler) CreateManualPayment(c *gin.Context) {
 	var req CreateManualPaymentRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondValidationError(c, err)
 		return
 	}
 
 	// Parse UUIDs
 	userID, err := uuid.Parse(req.UserID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		respondError(c, http.StatusBadRequest, "invalid user ID")
 		return
 	}
 
 	cardID, err := uuid.Parse(req.CardID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid card ID"})
+		respondError(c, http.StatusBadRequest, "invalid card ID")
 		return
 	}
 
@@ -64,6 +65,10 @@ func (h *BillingHandler) CreateManualPayment(c *gin.Context) {
 		req.Description,
 	)
 	if err != nil {
+		if e, ok := err.(*services.ValidationError); ok {
+			respondFieldErrors(c, http.StatusBadRequest, gin.H{e.Field: e.Error()})
+			return
+		}
 		status := http.StatusInternalServerError
 		switch {
 		case err.Error() == "card does not belong to user":
@@ -73,7 +78,7 @@ func (h *BillingHandler) CreateManualPayment(c *gin.Context) {
 		case err.Error() == "payment declined":
 			status = http.StatusBadRequest
 		}
-		c.JSON(status, gin.H{"error": err.Error()})
+		respondError(c, status, err.Error())
 		return
 	}
 
@@ -86,49 +91,86 @@ func (h *BillingHandler) GetBillingHistory(c *gin.Context) {
 
 	uid, err := uuid.Parse(userID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		respondError(c, http.StatusBadRequest, "invalid user ID")
 		return
 	}
 
-	// Parse pagination parameters
-	limit := 50
-	offset := 0
+	limit, offset := paginationParams(c)
 
-	if limitStr := c.DefaultQuery("limit", "50"); limitStr != "" {
-		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
-			if l > 100 {
-				l = 100 // Max 100 records per request
-			}
-			limit = l
+	transactions, err := h.billingService.GetBillingHistory(c.Request.Context(), uid, limit, offset)
+	if err != nil {
+		if err.Error() == "user not found" {
+			respondError(c, http.StatusNotFound, "user not found")
+			return
 		}
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
 	}
 
-	if offsetStr := c.DefaultQuery("offset", "0"); offsetStr != "" {
-		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
-			offset = o
-		}
+	response := gin.H{
+		"transactions": transactions,
+		"pagination": gin.H{
+			"limit":  limit,
+			"offset": offset,
+			"count":  len(transactions),
+		},
 	}
 
-	transactions, err := h.billingService.GetBillingHistory(c.Request.Context(), uid, limit, offset)
+	c.JSON(http.StatusOK, response)
+}
+
+// GetSubscriptionTransactions pages through a subscription's transactions,
+// e.g. GET /subscriptions/:id/transactions?type=recurring&limit=20&offset=40
+func (h *BillingHandler) GetSubscriptionTransactions(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
 	if err != nil {
-		if err.Error() == "user not found" {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		respondError(c, http.StatusBadRequest, "invalid subscription ID")
+		return
+	}
+
+	limit, offset := paginationParams(c)
+	transactionType := models.TransactionType(c.Query("type"))
+
+	transactions, err := h.billingService.GetSubscriptionTransactions(c.Request.Context(), id, transactionType, limit, offset)
+	if err != nil {
+		if _, ok := err.(*services.NotFoundError); ok {
+			respondError(c, http.StatusNotFound, err.Error())
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		if e, ok := err.(*services.ValidationError); ok {
+			respondFieldErrors(c, http.StatusBadRequest, gin.H{e.Field: e.Error()})
+			return
+		}
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	response := gin.H{
+	c.JSON(http.StatusOK, gin.H{
 		"transactions": transactions,
 		"pagination": gin.H{
 			"limit":  limit,
 			"offset": offset,
 			"count":  len(transactions),
 		},
+	})
+}
+
+// paginationParams reads limit (default 50, max 100) and offset (default 0)
+// from the query string, ignoring invalid values
+func paginationParams(c *gin.Context) (limit, offset int) {
+	limit = 50
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 {
+		if l > 100 {
+			l = 100 // Max 100 records per request
+		}
+		limit = l
 	}
 
-	c.JSON(http.StatusOK, response)
+	if o, err := strconv.Atoi(c.Query("offset")); err == nil && o >= 0 {
+		offset = o
+	}
+
+	return limit, offset
 }
 
 // GetSubscriptionBillingHistory gets billing history for a subscription
@@ -137,19 +179,82 @@ func (h *BillingHandler) GetSubscriptionBillingHistory(c *gin.Context) {
 
 	id, err := uuid.Parse(subscriptionID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid subscription ID"})
+		respondError(c, http.StatusBadRequest, "invalid subscription ID")
 		return
 	}
 
 	attempts, err := h.billingService.GetSubscriptionBillingHistory(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	c.JSON(http.StatusOK, attempts)
 }
 
+// ListBillingAttempts lists billing attempts across all subscriptions,
+// optionally filtered by ?status= (admin endpoint)
+func (h *BillingHandler) ListBillingAttempts(c *gin.Context) {
+	limit, offset := paginationParams(c)
+	status := models.BillingAttemptStatus(c.Query("status"))
+
+	attempts, err := h.billingService.ListBillingAttempts(c.Request.Context(), status, limit, offset)
+	if err != nil {
+		if e, ok := err.(*services.ValidationError); ok {
+			respondFieldErrors(c, http.StatusBadRequest, gin.H{e.Field: e.Error()})
+			return
+		}
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"billing_attempts": attempts,
+		"pagination": gin.H{
+			"limit":  limit,
+			"offset": offset,
+			"count":  len(attempts),
+		},
+	})
+}
+
+// CompleteAuthenticationRequest identifies the customer resuming a charge
+type CompleteAuthenticationRequest struct {
+	UserID string `json:"user_id" binding:"required,uuid4"`
+}
+
+// CompleteAuthentication resumes a subscription charge held in
+// requires_action once the customer has completed the 3-D Secure challenge
+// named by the attempt's challenge_reference
+func (h *BillingHandler) CompleteAuthentication(c *gin.Context) {
+	attemptID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid billing attempt ID")
+		return
+	}
+
+	var req CompleteAuthenticationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+	userID, _ := uuid.Parse(req.UserID)
+
+	attempt, err := h.billingService.CompleteAuthentication(c.Request.Context(), attemptID, userID)
+	if err != nil {
+		if e, ok := err.(*services.ConflictError); ok {
+			respondError(c, http.StatusConflict, e.Error())
+			return
+		}
+		respondPaymentError(c, err, "payment")
+		return
+	}
+
+	// The issuer may ask for another challenge, in which case the attempt is
+	// still in requires_action with a new challenge_reference
+	c.JSON(http.StatusOK, attempt)
+}
+
 // ProcessBillingAttempts processes pending billing attempts (admin endpoint)
 func (h *BillingHandler) ProcessBillingAttempts(c *gin.Context) {
 	limit := 50
@@ -164,7 +269,7 @@ func (h *BillingHandler) ProcessBillingAttempts(c *gin.Context) {
 
 	processed, err := h.billingService.ProcessPendingBillingAttempts(c.Request.Context(), limit)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
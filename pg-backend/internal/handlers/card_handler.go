@@ -1,12 +1,14 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"time"
 
 	"pg-backend/internal/models"
 	"pg-backend/internal/repositories"
 	"pg-backend/internal/services"
-	"pg-backend/pkg/utils"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -50,28 +52,31 @@ type VerifyAndSaveCardResponse struct {
 	LastFour     string `json:"last_four,omitempty"`
 }
 
-// VerifyAndSaveCard verifies and saves a card
+// VerifyAndSaveCard verifies a card, tokenizes it and saves it. A declined
+// verification is a 400, a failed gateway call a 502 (503 while the gateway
+// breaker is open) and a failed save a 500, or a 503 if the database was
+// briefly unavailable.
 func (h *CardHandler) VerifyAndSaveCard(c *gin.Context) {
 	var req VerifyAndSaveCardRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondValidationError(c, err)
 		return
 	}
 
 	// Validate user exists
 	userID, err := uuid.Parse(req.UserID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		respondError(c, http.StatusBadRequest, "invalid user ID")
 		return
 	}
 
 	_, err = h.userRepo.GetUserByID(c.Request.Context(), userID)
 	if err != nil {
 		if _, ok := err.(*repositories.NotFoundError); ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			respondError(c, http.StatusNotFound, "user not found")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -84,19 +89,13 @@ func (h *CardHandler) VerifyAndSaveCard(c *gin.Context) {
 		req.Currency,
 	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "card verification failed",
-			"details": err.Error(),
-		})
+		respondCardGatewayError(c, "card verification failed", err)
 		return
 	}
 
 	// Check if verification was successful
 	if verifyResp.GatewayCode != "APPROVED" && verifyResp.Response.GatewayCode != "APPROVED" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "card verification declined",
-			"code":  verifyResp.GatewayCode,
-		})
+		respondErrorDetails(c, http.StatusBadRequest, models.ErrorCodePaymentDeclined, "card verification declined", gin.H{"gateway_code": verifyResp.GatewayCode})
 		return
 	}
 
@@ -108,32 +107,45 @@ func (h *CardHandler) VerifyAndSaveCard(c *gin.Context) {
 		req.CVV,
 	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "failed to create payment token",
-			"details": err.Error(),
-		})
+		respondCardGatewayError(c, "card was verified but could not be tokenized", err)
+		return
+	}
+	if tokenResp.Token == "" {
+		respondErrorDetails(c, http.StatusBadGateway, models.ErrorCodeGatewayError, "card was verified but the gateway returned no token", nil)
 		return
 	}
 
-	expiryMonth := tokenResp.SourceOfFunds.Provided.Card.Expiry[:2]       // First 2 chars for month
-	expiryYear := "20" + tokenResp.SourceOfFunds.Provided.Card.Expiry[2:] // Last 2 chars for year
+	expiryMonth, expiryYear, err := services.ParseCardExpiry(tokenResp.SourceOfFunds.Provided.Card.Expiry)
+	if err != nil {
+		respondErrorDetails(c, http.StatusBadGateway, models.ErrorCodeGatewayError, "gateway returned an unrecognised card expiry", gin.H{"cause": err.Error()})
+		return
+	}
 
 	// Step 3: Save card to database
 	card := &models.Card{
 		UserID:       userID,
 		GatewayToken: tokenResp.Token,
 		LastFour:     tokenResp.SourceOfFunds.Provided.Card.Last4,
-		ExpiryMonth:  utils.MustParseInt(expiryMonth),
-		ExpiryYear:   utils.MustParseInt(expiryYear),
+		ExpiryMonth:  expiryMonth,
+		ExpiryYear:   expiryYear,
 		Scheme:       tokenResp.SourceOfFunds.Provided.Card.Scheme,
 		IsDefault:    req.MakeDefault,
+
+		// The verification is the initial transaction that stored the card
+		StoredCredentialReference: verifyResp.AuthorizationResponse.TransactionIdentifier,
 	}
 
-	err = h.cardRepo.CreateCard(c.Request.Context(), card)
+	// Nothing needs undoing at the gateway if the save fails; an unsaved
+	// token is harmless, and the caller can still save it with ImportToken
+	err = h.saveCard(c.Request.Context(), card)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "failed to save card",
-			"details": err.Error(),
+		status := http.StatusInternalServerError
+		if repositories.IsTransientError(err) {
+			status = http.StatusServiceUnavailable
+		}
+		respondErrorDetails(c, status, errorCode(status), "card was verified but could not be saved", gin.H{
+			"cause":         err.Error(),
+			"gateway_token": card.GatewayToken,
 		})
 		return
 	}
@@ -149,6 +161,163 @@ func (h *CardHandler) VerifyAndSaveCard(c *gin.Context) {
 	c.JSON(http.StatusCreated, response)
 }
 
+// Saving a card is retried this many times on transient database errors,
+// waiting cardSaveRetryDelay longer after each attempt
+const (
+	cardSaveAttempts   = 3
+	cardSaveRetryDelay = 100 * time.Millisecond
+)
+
+// saveCard inserts card, retrying transient database errors. Before a retry
+// it checks whether the failed insert landed after all, so a lost reply
+// doesn't save the card twice.
+func (h *CardHandler) saveCard(ctx context.Context, card *models.Card) error {
+	for attempt := 1; ; attempt++ {
+		err := h.cardRepo.CreateCard(ctx, card)
+		if err == nil || !repositories.IsTransientError(err) || attempt == cardSaveAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(attempt) * cardSaveRetryDelay):
+		}
+
+		if saved, lookupErr := h.cardRepo.GetCardByGatewayToken(ctx, card.GatewayToken); lookupErr == nil {
+			*card = *saved
+			return nil
+		}
+	}
+}
+
+// respondCardGatewayError reports a gateway call that failed while saving a
+// card: 503 while the circuit breaker is open, otherwise 502
+func respondCardGatewayError(c *gin.Context, message string, err error) {
+	status := http.StatusBadGateway
+	details := gin.H{"cause": err.Error()}
+	var apiErr *services.GatewayAPIError
+	switch {
+	case errors.As(err, &apiErr):
+		details["gateway_status"] = apiErr.StatusCode
+		details["gateway_error"] = apiErr.Detail()
+		details["correlation_id"] = apiErr.Trace.CorrelationID
+	case errors.Is(err, services.ErrGatewayUnavailable):
+		status = http.StatusServiceUnavailable
+	}
+
+	respondErrorDetails(c, status, errorCode(status), message, details)
+}
+
+// ImportTokenRequest saves a card from an existing gateway token
+type ImportTokenRequest struct {
+	UserID       string `json:"user_id" binding:"required,uuid4"`
+	GatewayToken string `json:"gateway_token" binding:"required"`
+	MakeDefault  bool   `json:"make_default"`
+}
+
+// ImportToken saves a card from a token the merchant already holds at the
+// gateway, such as one the mobile backend created from a payment session, so
+// it can be charged and back subscriptions like a verified card. The card
+// details come from the gateway, not the caller.
+func (h *CardHandler) ImportToken(c *gin.Context) {
+	var req ImportTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	userID, err := uuid.Parse(req.UserID)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid user ID")
+		return
+	}
+
+	_, err = h.userRepo.GetUserByID(c.Request.Context(), userID)
+	if err != nil {
+		if _, ok := err.(*repositories.NotFoundError); ok {
+			respondError(c, http.StatusNotFound, "user not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// A token can only be saved once; it would otherwise be shared between users
+	existing, err := h.cardRepo.GetCardByGatewayToken(c.Request.Context(), req.GatewayToken)
+	if err == nil {
+		respondErrorDetails(c, http.StatusConflict, models.ErrorCodeConflict, "token is already saved", gin.H{"card_id": existing.ID})
+		return
+	}
+	if _, ok := err.(*repositories.NotFoundError); !ok {
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	tokenResp, err := h.mastercardService.RetrieveToken(c.Request.Context(), req.GatewayToken)
+	if err != nil {
+		if _, ok := err.(*services.NotFoundError); ok {
+			respondError(c, http.StatusNotFound, "token not found at gateway")
+			return
+		}
+		respondErrorDetails(c, http.StatusInternalServerError, models.ErrorCodeInternal, "failed to retrieve payment token", gin.H{"cause": err.Error()})
+		return
+	}
+
+	tokenCard := tokenResp.SourceOfFunds.Provided.Card
+	expiryMonth, expiryYear, err := services.ParseCardExpiry(tokenCard.Expiry)
+	if err != nil {
+		respondErrorDetails(c, http.StatusUnprocessableEntity, models.ErrorCodeUnprocessable, "gateway token has no usable card expiry", gin.H{"cause": err.Error()})
+		return
+	}
+
+	// No stored-credential reference is known for an imported token; the
+	// first cardholder-initiated charge on the card records one
+	card := &models.Card{
+		UserID:       userID,
+		GatewayToken: req.GatewayToken,
+		LastFour:     tokenCard.Last4,
+		ExpiryMonth:  expiryMonth,
+		ExpiryYear:   expiryYear,
+		Scheme:       tokenCard.Scheme,
+		IsDefault:    req.MakeDefault,
+	}
+	if card.LastFour == "" && len(tokenCard.Number) >= 4 {
+		card.LastFour = tokenCard.Number[len(tokenCard.Number)-4:]
+	}
+
+	if err := h.cardRepo.CreateCard(c.Request.Context(), card); err != nil {
+		respondErrorDetails(c, http.StatusInternalServerError, models.ErrorCodeInternal, "failed to save card", gin.H{"cause": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, VerifyAndSaveCardResponse{
+		Success:      true,
+		Message:      "Card saved from gateway token",
+		CardID:       card.ID.String(),
+		GatewayToken: card.GatewayToken,
+		LastFour:     card.LastFour,
+	})
+}
+
+// CardResponse is a saved card with its derived expiry status
+type CardResponse struct {
+	models.Card
+	models.CardExpiryStatus
+}
+
+// newCardResponses adds expiry status to each card for wallet UIs
+func newCardResponses(cards []models.Card) []CardResponse {
+	responses := make([]CardResponse, 0, len(cards))
+	for i := range cards {
+		responses = append(responses, CardResponse{
+			Card:             cards[i],
+			CardExpiryStatus: cards[i].ExpiryStatus(),
+		})
+	}
+	return responses
+}
+
 // GetUserCardsRequest for getting user's cards
 type GetUserCardsRequest struct {
 	UserID string `json:"user_id" binding:"required,uuid4"`
@@ -160,7 +329,7 @@ func (h *CardHandler) GetUserCards(c *gin.Context) {
 
 	uid, err := uuid.Parse(userID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		respondError(c, http.StatusBadRequest, "invalid user ID")
 		return
 	}
 
@@ -168,21 +337,131 @@ func (h *CardHandler) GetUserCards(c *gin.Context) {
 	_, err = h.userRepo.GetUserByID(c.Request.Context(), uid)
 	if err != nil {
 		if _, ok := err.(*repositories.NotFoundError); ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			respondError(c, http.StatusNotFound, "user not found")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	// Get user's cards
 	cards, err := h.cardRepo.GetCardsByUserID(c.Request.Context(), uid)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, newCardResponses(cards))
+}
+
+// DefaultPaymentMethodResponse is a user's default payment method for
+// one-tap checkout. The gateway token is left out.
+type DefaultPaymentMethodResponse struct {
+	Type           string    `json:"type"` // one of the PaymentMethodType values
+	WalletProvider string    `json:"wallet_provider,omitempty"`
+	CardID         uuid.UUID `json:"card_id"`
+	Scheme         string    `json:"scheme"`
+	LastFour       string    `json:"last_four"`
+	ExpiryMonth    int       `json:"expiry_month"`
+	ExpiryYear     int       `json:"expiry_year"`
+	models.CardExpiryStatus
+}
+
+// GetDefaultPaymentMethod gets the card a user has set as their default
+func (h *CardHandler) GetDefaultPaymentMethod(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid user ID")
+		return
+	}
+
+	card, err := h.cardRepo.GetDefaultCardByUserID(c.Request.Context(), userID)
+	if err != nil {
+		if _, ok := err.(*repositories.NotFoundError); ok {
+			respondError(c, http.StatusNotFound, "no default payment method set")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Cards saved before wallets were supported have no type
+	methodType := card.PaymentMethodType
+	if methodType == "" {
+		methodType = models.PaymentMethodTypeCard
+	}
+
+	c.JSON(http.StatusOK, DefaultPaymentMethodResponse{
+		Type:             methodType,
+		WalletProvider:   card.WalletProvider,
+		CardID:           card.ID,
+		Scheme:           card.Scheme,
+		LastFour:         card.LastFour,
+		ExpiryMonth:      card.ExpiryMonth,
+		ExpiryYear:       card.ExpiryYear,
+		CardExpiryStatus: card.ExpiryStatus(),
+	})
+}
+
+// SavedCardResponse is a single saved card. The gateway token and wallet
+// device data are left out.
+type SavedCardResponse struct {
+	ID                uuid.UUID `json:"id"`
+	UserID            uuid.UUID `json:"user_id"`
+	Scheme            string    `json:"scheme"`
+	LastFour          string    `json:"last_four"`
+	ExpiryMonth       int       `json:"expiry_month"`
+	ExpiryYear        int       `json:"expiry_year"`
+	IsDefault         bool      `json:"is_default"`
+	PaymentMethodType string    `json:"payment_method_type"`
+	WalletProvider    string    `json:"wallet_provider,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+	models.CardExpiryStatus
+}
+
+// GetCard gets one of a user's saved cards. The owner is given by the
+// user_id query parameter; a card belonging to anyone else is reported as not
+// found.
+func (h *CardHandler) GetCard(c *gin.Context) {
+	cardID, err := uuid.Parse(c.Param("card_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid card ID")
+		return
+	}
+
+	userID, err := uuid.Parse(c.Query("user_id"))
+	if err != nil {
+		respondFieldErrors(c, http.StatusBadRequest, gin.H{"user_id": "must be a valid UUID"})
 		return
 	}
 
-	c.JSON(http.StatusOK, cards)
+	card, err := h.cardRepo.GetCardByID(c.Request.Context(), cardID)
+	if err != nil {
+		if _, ok := err.(*repositories.NotFoundError); ok {
+			respondError(c, http.StatusNotFound, "card not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if card.UserID != userID {
+		respondError(c, http.StatusNotFound, "card not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, SavedCardResponse{
+		ID:                card.ID,
+		UserID:            card.UserID,
+		Scheme:            card.Scheme,
+		LastFour:          card.LastFour,
+		ExpiryMonth:       card.ExpiryMonth,
+		ExpiryYear:        card.ExpiryYear,
+		IsDefault:         card.IsDefault,
+		PaymentMethodType: card.PaymentMethodType,
+		WalletProvider:    card.WalletProvider,
+		CreatedAt:         card.CreatedAt,
+		CardExpiryStatus:  card.ExpiryStatus(),
+	})
 }
 
 // DeleteCardRequest for deleting a card
@@ -195,19 +474,19 @@ type DeleteCardRequest struct {
 func (h *CardHandler) DeleteCard(c *gin.Context) {
 	var req DeleteCardRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondValidationError(c, err)
 		return
 	}
 
 	userID, err := uuid.Parse(req.UserID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		respondError(c, http.StatusBadRequest, "invalid user ID")
 		return
 	}
 
 	cardID, err := uuid.Parse(req.CardID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid card ID"})
+		respondError(c, http.StatusBadRequest, "invalid card ID")
 		return
 	}
 
@@ -215,10 +494,10 @@ func (h *CardHandler) DeleteCard(c *gin.Context) {
 	_, err = h.userRepo.GetUserByID(c.Request.Context(), userID)
 	if err != nil {
 		if _, ok := err.(*repositories.NotFoundError); ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			respondError(c, http.StatusNotFound, "user not found")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -226,10 +505,10 @@ func (h *CardHandler) DeleteCard(c *gin.Context) {
 	err = h.cardRepo.DeleteCard(c.Request.Context(), cardID)
 	if err != nil {
 		if _, ok := err.(*repositories.NotFoundError); ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "card not found"})
+			respondError(c, http.StatusNotFound, "card not found")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
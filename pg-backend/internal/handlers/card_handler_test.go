@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"pg-backend/internal/models"
+	"pg-backend/internal/repositories"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// fakeCardRepo serves a single saved card
+type fakeCardRepo struct {
+	repositories.CardRepository
+	card models.Card
+}
+
+func (r *fakeCardRepo) GetCardByID(ctx context.Context, id uuid.UUID) (*models.Card, error) {
+	if id != r.card.ID {
+		return nil, &repositories.NotFoundError{Message: "card not found"}
+	}
+	card := r.card
+	return &card, nil
+}
+
+func TestGetCardOmitsGatewayToken(t *testing.T) {
+	card := models.Card{
+		ID:                uuid.New(),
+		UserID:            uuid.New(),
+		GatewayToken:      "9000000000000001",
+		LastFour:          "0001",
+		ExpiryMonth:       12,
+		ExpiryYear:        2030,
+		Scheme:            "VISA",
+		PaymentMethodType: models.PaymentMethodTypeGooglePay,
+		DevicePaymentData: map[string]interface{}{"cryptogram": "AAAAAAAAAAAAAAAAAAAA"},
+		GooglePayToken:    "encrypted-wallet-token",
+	}
+	handler := NewCardHandler(nil, nil, &fakeCardRepo{card: card})
+
+	router := gin.New()
+	router.GET("/cards/:card_id", handler.GetCard)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cards/"+card.ID.String()+"?user_id="+card.UserID.String(), nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body.String())
+	}
+	for _, secret := range []string{"gateway_token", card.GatewayToken, "device_payment_data", "encrypted-wallet-token"} {
+		if strings.Contains(w.Body.String(), secret) {
+			t.Errorf("response contains %q: %s", secret, w.Body.String())
+		}
+	}
+	if !strings.Contains(w.Body.String(), `"last_four":"0001"`) {
+		t.Errorf("response = %s, want the card's last four digits", w.Body.String())
+	}
+}
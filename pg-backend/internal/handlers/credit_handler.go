@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"net/http"
+
+	"pg-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type CreditHandler struct {
+	creditService services.CreditService
+}
+
+func NewCreditHandler(creditService services.CreditService) *CreditHandler {
+	return &CreditHandler{
+		creditService: creditService,
+	}
+}
+
+// GrantCreditRequest represents account credit issued to a user, e.g. proration
+type GrantCreditRequest struct {
+	Amount         float64 `json:"amount" binding:"required,gt=0"`
+	Currency       string  `json:"currency" binding:"required,len=3"`
+	Description    string  `json:"description" binding:"max=255"`
+	SubscriptionID string  `json:"subscription_id,omitempty" binding:"omitempty,uuid"`
+}
+
+// GetCreditBalance returns the user's available credit per currency
+func (h *CreditHandler) GetCreditBalance(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid user ID")
+		return
+	}
+
+	balances, err := h.creditService.GetBalances(c.Request.Context(), userID)
+	if err != nil {
+		if _, ok := err.(*services.NotFoundError); ok {
+			respondError(c, http.StatusNotFound, err.Error())
+			return
+		}
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":  userID,
+		"balances": balances,
+	})
+}
+
+// GrantCredit adds account credit that is spent on the user's next charges (admin only)
+func (h *CreditHandler) GrantCredit(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid user ID")
+		return
+	}
+
+	var req GrantCreditRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	var subscriptionID uuid.NullUUID
+	if req.SubscriptionID != "" {
+		subscriptionID = uuid.NullUUID{UUID: uuid.MustParse(req.SubscriptionID), Valid: true}
+	}
+
+	entry, err := h.creditService.GrantCredit(
+		c.Request.Context(), userID, req.Amount, req.Currency, req.Description, subscriptionID,
+	)
+	if err != nil {
+		switch err.(type) {
+		case *services.ValidationError:
+			respondError(c, http.StatusBadRequest, err.Error())
+		case *services.NotFoundError:
+			respondError(c, http.StatusNotFound, err.Error())
+		default:
+			respondError(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, entry)
+}
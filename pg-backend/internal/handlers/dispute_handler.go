@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http"
+
+	"pg-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type DisputeHandler struct {
+	disputeService services.DisputeService
+}
+
+func NewDisputeHandler(disputeService services.DisputeService) *DisputeHandler {
+	return &DisputeHandler{
+		disputeService: disputeService,
+	}
+}
+
+// GetDisputes lists recent disputes, optionally filtered with ?status=
+func (h *DisputeHandler) GetDisputes(c *gin.Context) {
+	disputes, err := h.disputeService.ListDisputes(c.Request.Context(), c.Query("status"))
+	if err != nil {
+		if _, ok := err.(*services.ValidationError); ok {
+			respondFieldErrors(c, http.StatusBadRequest, gin.H{"status": err.Error()})
+			return
+		}
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"disputes": disputes,
+		"count":    len(disputes),
+	})
+}
+
+// GetTransactionDisputes lists the disputes raised against a transaction
+func (h *DisputeHandler) GetTransactionDisputes(c *gin.Context) {
+	transactionID, err := uuid.Parse(c.Param("transaction_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid transaction ID")
+		return
+	}
+
+	disputes, err := h.disputeService.GetTransactionDisputes(c.Request.Context(), transactionID)
+	if err != nil {
+		if _, ok := err.(*services.NotFoundError); ok {
+			respondError(c, http.StatusNotFound, err.Error())
+			return
+		}
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"transaction_id": transactionID,
+		"disputes":       disputes,
+	})
+}
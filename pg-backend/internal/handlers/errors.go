@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net/http"
+
+	"pg-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// respondError writes an error response with the code that goes with status,
+// e.g. {"error": {"code": "not_found", "message": "plan not found"}}
+func respondError(c *gin.Context, status int, message string) {
+	respondErrorDetails(c, status, errorCode(status), message, nil)
+}
+
+// respondErrorDetails writes an error response with an explicit code and
+// code-specific details
+func respondErrorDetails(c *gin.Context, status int, code, message string, details gin.H) {
+	c.JSON(status, models.NewErrorResponse(code, message, details))
+}
+
+// respondFieldErrors writes a validation failure with a field -> message map
+// in details.fields
+func respondFieldErrors(c *gin.Context, status int, fields interface{}) {
+	respondErrorDetails(c, status, models.ErrorCodeValidationFailed, "request validation failed", gin.H{"fields": fields})
+}
+
+// errorCode is the default error code for an HTTP status
+func errorCode(status int) string {
+	switch status {
+	case http.StatusUnauthorized:
+		return models.ErrorCodeUnauthorized
+	case http.StatusForbidden:
+		return models.ErrorCodeForbidden
+	case http.StatusNotFound:
+		return models.ErrorCodeNotFound
+	case http.StatusConflict:
+		return models.ErrorCodeConflict
+	case http.StatusUnprocessableEntity:
+		return models.ErrorCodeUnprocessable
+	case http.StatusBadGateway:
+		return models.ErrorCodeGatewayError
+	case http.StatusServiceUnavailable:
+		return models.ErrorCodeServiceUnavailable
+	}
+	if status >= http.StatusInternalServerError {
+		return models.ErrorCodeInternal
+	}
+	return models.ErrorCodeInvalidRequest
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"pg-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// serve runs handler for a single request and returns the response
+func serve(handler gin.HandlerFunc, body string) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.POST("/", handler)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// decodeEnvelope checks that body is exactly {"error": {...}} with a code and
+// message, and returns the error object
+func decodeEnvelope(t *testing.T, body []byte) map[string]interface{} {
+	t.Helper()
+
+	var envelope map[string]map[string]interface{}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		t.Fatalf("response is not an error envelope: %v: %s", err, body)
+	}
+	if len(envelope) != 1 || envelope["error"] == nil {
+		t.Fatalf("response has keys other than error: %s", body)
+	}
+
+	apiErr := envelope["error"]
+	for key := range apiErr {
+		if key != "code" && key != "message" && key != "details" {
+			t.Errorf("error has unexpected key %q: %s", key, body)
+		}
+	}
+	if code, _ := apiErr["code"].(string); code == "" {
+		t.Errorf("error has no code: %s", body)
+	}
+	if message, _ := apiErr["message"].(string); message == "" {
+		t.Errorf("error has no message: %s", body)
+	}
+	return apiErr
+}
+
+func TestRespondErrorCodes(t *testing.T) {
+	tests := []struct {
+		status int
+		code   string
+	}{
+		{http.StatusBadRequest, models.ErrorCodeInvalidRequest},
+		{http.StatusUnauthorized, models.ErrorCodeUnauthorized},
+		{http.StatusForbidden, models.ErrorCodeForbidden},
+		{http.StatusNotFound, models.ErrorCodeNotFound},
+		{http.StatusConflict, models.ErrorCodeConflict},
+		{http.StatusUnprocessableEntity, models.ErrorCodeUnprocessable},
+		{http.StatusInternalServerError, models.ErrorCodeInternal},
+		{http.StatusNotImplemented, models.ErrorCodeInternal},
+		{http.StatusBadGateway, models.ErrorCodeGatewayError},
+		{http.StatusServiceUnavailable, models.ErrorCodeServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			w := serve(func(c *gin.Context) {
+				respondError(c, tt.status, "something went wrong")
+			}, "")
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			apiErr := decodeEnvelope(t, w.Body.Bytes())
+			if apiErr["code"] != tt.code {
+				t.Errorf("code = %v, want %s", apiErr["code"], tt.code)
+			}
+			if apiErr["message"] != "something went wrong" {
+				t.Errorf("message = %v", apiErr["message"])
+			}
+			if _, ok := apiErr["details"]; ok {
+				t.Errorf("details present without any to report: %s", w.Body)
+			}
+		})
+	}
+}
+
+func TestRespondErrorDetails(t *testing.T) {
+	w := serve(func(c *gin.Context) {
+		respondErrorDetails(c, http.StatusBadRequest, models.ErrorCodePaymentDeclined, "payment declined",
+			gin.H{"gateway_code": "DECLINED", "result": "FAILURE"})
+	}, "")
+
+	apiErr := decodeEnvelope(t, w.Body.Bytes())
+	if apiErr["code"] != models.ErrorCodePaymentDeclined {
+		t.Errorf("code = %v, want %s", apiErr["code"], models.ErrorCodePaymentDeclined)
+	}
+	details, _ := apiErr["details"].(map[string]interface{})
+	if details["gateway_code"] != "DECLINED" || details["result"] != "FAILURE" {
+		t.Errorf("details = %v", apiErr["details"])
+	}
+}
+
+func TestRespondFieldErrors(t *testing.T) {
+	w := serve(func(c *gin.Context) {
+		respondFieldErrors(c, http.StatusBadRequest, gin.H{"interval": "must be one of: month, year"})
+	}, "")
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", w.Code)
+	}
+	apiErr := decodeEnvelope(t, w.Body.Bytes())
+	if apiErr["code"] != models.ErrorCodeValidationFailed {
+		t.Errorf("code = %v, want %s", apiErr["code"], models.ErrorCodeValidationFailed)
+	}
+	details, _ := apiErr["details"].(map[string]interface{})
+	fields, _ := details["fields"].(map[string]interface{})
+	if len(fields) != 1 || fields["interval"] != "must be one of: month, year" {
+		t.Errorf("details.fields = %v", details["fields"])
+	}
+}
+
+func TestRespondValidationError(t *testing.T) {
+	type request struct {
+		Amount   float64 `json:"amount" binding:"required,gt=0"`
+		Currency string  `json:"currency" binding:"required,iso4217"`
+		CardID   string  `json:"card_id" binding:"required,uuid4"`
+		Customer struct {
+			Email string `json:"email" binding:"required,email"`
+		} `json:"customer"`
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		fields map[string]string
+	}{
+		{
+			name: "missing and invalid fields",
+			body: `{"amount": -1, "currency": "ZZZ", "customer": {"email": "nope"}}`,
+			fields: map[string]string{
+				"amount":         "must be greater than 0",
+				"currency":       "must be a valid ISO 4217 currency code",
+				"card_id":        "is required",
+				"customer.email": "must be a valid email address",
+			},
+		},
+		{
+			name:   "wrong type",
+			body:   `{"amount": "ten"}`,
+			fields: map[string]string{"amount": "must be a float64"},
+		},
+		{
+			name:   "malformed JSON",
+			body:   `{"amount": `,
+			fields: map[string]string{"body": "must be valid JSON"},
+		},
+		{
+			name:   "empty body",
+			body:   ``,
+			fields: map[string]string{"body": "is required"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(func(c *gin.Context) {
+				var req request
+				if err := c.ShouldBindJSON(&req); err != nil {
+					respondValidationError(c, err)
+					return
+				}
+				c.Status(http.StatusNoContent)
+			}, tt.body)
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400", w.Code)
+			}
+			apiErr := decodeEnvelope(t, w.Body.Bytes())
+			if apiErr["code"] != models.ErrorCodeValidationFailed {
+				t.Errorf("code = %v, want %s", apiErr["code"], models.ErrorCodeValidationFailed)
+			}
+			details, _ := apiErr["details"].(map[string]interface{})
+			fields, _ := details["fields"].(map[string]interface{})
+			if len(fields) != len(tt.fields) {
+				t.Errorf("details.fields = %v, want %v", fields, tt.fields)
+			}
+			for field, want := range tt.fields {
+				if fields[field] != want {
+					t.Errorf("details.fields[%q] = %v, want %q", field, fields[field], want)
+				}
+			}
+		})
+	}
+}
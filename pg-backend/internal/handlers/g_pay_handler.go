@@ -3,8 +3,6 @@ package handlers
 import (
 	"fmt"
 	"net/http"
-	"strconv"
-	"strings"
 
 	"pg-backend/internal/models"
 	"pg-backend/internal/repositories"
@@ -20,6 +18,7 @@ type GooglePayHandler struct {
 	userRepo          repositories.UserRepository
 	cardRepo          repositories.CardRepository
 	transactionRepo   repositories.TransactionRepository
+	paymentService    services.PaymentService
 }
 
 func NewGooglePayHandler(
@@ -27,12 +26,14 @@ func NewGooglePayHandler(
 	userRepo repositories.UserRepository,
 	cardRepo repositories.CardRepository,
 	transactionRepo repositories.TransactionRepository,
+	paymentService services.PaymentService,
 ) *GooglePayHandler {
 	return &GooglePayHandler{
 		mastercardService: mastercardService,
 		userRepo:          userRepo,
 		cardRepo:          cardRepo,
 		transactionRepo:   transactionRepo,
+		paymentService:    paymentService,
 	}
 }
 
@@ -53,167 +54,67 @@ type GooglePayRequest struct {
 
 // GooglePayResponse represents Google Pay payment response
 type GooglePayResponse struct {
-	Success        bool   `json:"success"`
-	Message        string `json:"message"`
-	TransactionID  string `json:"transaction_id,omitempty"`
-	OrderID        string `json:"order_id,omitempty"`
-	Amount         string `json:"amount,omitempty"`
-	Currency       string `json:"currency,omitempty"`
-	Status         string `json:"status,omitempty"`
-	WalletProvider string `json:"wallet_provider,omitempty"`
-	CardID         string `json:"card_id,omitempty"`
-	IsSimulated    bool   `json:"is_simulated,omitempty"` // NEW: Indicates if simulated
+	Success        bool          `json:"success"`
+	Message        string        `json:"message"`
+	TransactionID  string        `json:"transaction_id,omitempty"`
+	OrderID        string        `json:"order_id,omitempty"`
+	Amount         *models.Money `json:"amount,omitempty"`
+	Currency       string        `json:"currency,omitempty"`
+	Status         string        `json:"status,omitempty"`
+	WalletProvider string        `json:"wallet_provider,omitempty"`
+	CardID         string        `json:"card_id,omitempty"`
+	IsSimulated    bool          `json:"is_simulated,omitempty"` // NEW: Indicates if simulated
 }
 
 // Pay processes a Google Pay payment
 func (h *GooglePayHandler) Pay(c *gin.Context) {
 	var req GooglePayRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondValidationError(c, err)
 		return
 	}
 
-	// Validate user exists
-	userID, err := uuid.Parse(req.UserID)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
-		return
-	}
-
-	_, err = h.userRepo.GetUserByID(c.Request.Context(), userID)
-	if err != nil {
-		if _, ok := err.(*repositories.NotFoundError); ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	chargeReq, ok := chargeRequest(c, req.UserID, req.CardID)
+	if !ok {
 		return
 	}
-
-	var paymentResp *services.PaymentResponse
-	var cardID uuid.UUID
-	var card *models.Card
-
-	// Check if using saved Google Pay card or new Google Pay details
-	if req.CardID != "" {
-		// Pay with saved Google Pay card
-		cardID, err = uuid.Parse(req.CardID)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid card ID"})
-			return
-		}
-
-		// Get saved Google Pay card from database
-		card, err = h.cardRepo.GetCardByID(c.Request.Context(), cardID)
-		if err != nil {
-			if _, ok := err.(*repositories.NotFoundError); ok {
-				c.JSON(http.StatusNotFound, gin.H{"error": "Google Pay card not found"})
-				return
-			}
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		// Verify card belongs to user
-		if card.UserID != userID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Google Pay card does not belong to user"})
-			return
-		}
-
-		// Verify it's a Google Pay payment method
-		if card.PaymentMethodType != "google_pay" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "card is not a Google Pay payment method"})
-			return
-		}
-
-		// Extract device payment data from saved card
-		var cryptogram, eci string
-		if card.DevicePaymentData != nil {
-			if c, ok := card.DevicePaymentData["cryptogram"].(string); ok {
-				cryptogram = c
-			}
-			if e, ok := card.DevicePaymentData["eci_indicator"].(string); ok {
-				eci = e
-			}
-		}
-
-		// Use card details for payment
-		paymentResp, err = h.mastercardService.PayWithGooglePay(
-			card.GatewayToken, // Use token for saved cards
-			fmt.Sprintf("%02d", card.ExpiryMonth),
-			strconv.Itoa(card.ExpiryYear),
-			cryptogram,
-			eci,
-			req.Amount,
-			req.Currency,
-		)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":   "Google Pay payment failed",
-				"details": err.Error(),
-			})
-			return
-		}
-
-	} else {
-		// Pay with new Google Pay details (merchant-decrypted flow)
-		if req.CardNumber == "" || req.ExpiryMonth == "" || req.ExpiryYear == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "card details required when not using saved Google Pay"})
-			return
-		}
-
-		// Process payment with provided Google Pay details
-		paymentResp, err = h.mastercardService.PayWithGooglePay(
-			req.CardNumber,
-			req.ExpiryMonth,
-			req.ExpiryYear,
-			req.Cryptogram,
-			req.EciIndicator,
-			req.Amount,
-			req.Currency,
-		)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":   "Google Pay payment failed",
-				"details": err.Error(),
-			})
-			return
+	chargeReq.Amount = req.Amount
+	chargeReq.Currency = req.Currency
+	chargeReq.PaymentMethodType = models.PaymentMethodTypeGooglePay
+	chargeReq.WalletProvider = models.WalletProviderGooglePay
+	chargeReq.DevicePaymentData = map[string]interface{}{
+		"cryptogram":    req.Cryptogram,
+		"eci_indicator": req.EciIndicator,
+	}
+	if req.CardID == "" {
+		// New Google Pay details (merchant-decrypted flow). Saved Google Pay
+		// cards hold a gateway token and are charged through the token path,
+		// since the cryptogram from the original payment is single use.
+		chargeReq.Source = services.PaymentSourceDevicePayment
+		chargeReq.Card = &services.CardDetails{
+			Number:      req.CardNumber,
+			ExpiryMonth: req.ExpiryMonth,
+			ExpiryYear:  req.ExpiryYear,
+			Cryptogram:  req.Cryptogram,
+			ECI:         req.EciIndicator,
 		}
 	}
 
-	// Validate payment response
-	if paymentResp.Result != "SUCCESS" && paymentResp.GatewayCode != "APPROVED" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":  "Google Pay payment declined",
-			"code":   paymentResp.GatewayCode,
-			"result": paymentResp.Result,
-		})
+	result, err := h.paymentService.Charge(c.Request.Context(), chargeReq)
+	if err != nil {
+		respondPaymentError(c, err, "Google Pay payment")
 		return
 	}
+	paymentResp := result.Response
 
 	// Save Google Pay as payment method if requested
 	var savedCardID uuid.UUID
 	if req.SavePayment && req.CardID == "" && req.CardNumber != "" {
-		// Create Google Pay card record
-		card := &models.Card{
-			UserID:            userID,
-			GatewayToken:      req.CardNumber, // For now, store card number. In production, use token.
-			LastFour:          req.CardNumber[len(req.CardNumber)-4:],
-			ExpiryMonth:       utils.MustParseInt(req.ExpiryMonth),
-			ExpiryYear:        utils.MustParseInt(req.ExpiryYear),
-			Scheme:            getCardScheme(req.CardNumber),
-			IsDefault:         false, // Don't set as default automatically
-			PaymentMethodType: "google_pay",
-			WalletProvider:    "GOOGLE_PAY",
-			DevicePaymentData: map[string]interface{}{
-				"cryptogram":    req.Cryptogram,
-				"eci_indicator": req.EciIndicator,
-			},
-		}
-
-		// Save to database
-		err = h.cardRepo.CreateCard(c.Request.Context(), card)
+		card, err := h.tokenizeGooglePayCard(chargeReq.UserID, req)
 		if err != nil {
+			// Log error but don't fail payment
+			fmt.Printf("Warning: Failed to tokenize Google Pay card: %v\n", err)
+		} else if err = h.cardRepo.CreateCard(c.Request.Context(), card); err != nil {
 			// Log error but don't fail payment
 			fmt.Printf("Warning: Failed to save Google Pay card: %v\n", err)
 		} else {
@@ -221,48 +122,19 @@ func (h *GooglePayHandler) Pay(c *gin.Context) {
 		}
 	}
 
-	// Save transaction to database
-	transaction := &models.Transaction{
-		UserID:               userID,
-		Amount:               utils.MustParseFloat(req.Amount),
-		Currency:             req.Currency,
-		Status:               paymentResp.Transaction.Status,
-		GatewayTransactionID: paymentResp.Transaction.ID,
-		Type:                 "manual",
-		WalletProvider:       "GOOGLE_PAY",
-		PaymentMethodType:    "google_pay",
-		DevicePaymentData: map[string]interface{}{
-			"cryptogram":    req.Cryptogram,
-			"eci_indicator": req.EciIndicator,
-			"is_simulated":  false, // Default to false
-		},
-	}
-
-	// Check if this was a simulated payment
-	if strings.Contains(paymentResp.Result, "simulated") || paymentResp.Order.WalletProvider == "" {
-		// It was simulated
-		transaction.DevicePaymentData["is_simulated"] = true
-	}
-
-	// Save transaction to database
-	err = h.transactionRepo.CreateTransaction(c.Request.Context(), transaction)
-	if err != nil {
-		fmt.Printf("Warning: Failed to save Google Pay transaction: %v\n", err)
-	}
-
 	response := GooglePayResponse{
 		Success:        paymentResp.Result == "SUCCESS",
 		Message:        "Google Pay payment processed successfully",
 		TransactionID:  paymentResp.Transaction.ID,
 		OrderID:        paymentResp.Order.ID,
-		Amount:         utils.ConvertToString(paymentResp.Order.Amount),
+		Amount:         models.NewMoney(paymentResp.Order.Amount.Float64(), paymentResp.Order.Currency),
 		Currency:       paymentResp.Order.Currency,
 		Status:         paymentResp.Transaction.Status,
 		WalletProvider: "GOOGLE_PAY",
 	}
 
 	// Check if simulated
-	if strings.Contains(paymentResp.Result, "simulated") || paymentResp.Order.WalletProvider == "" {
+	if simulated, _ := result.Transaction.DevicePaymentData["is_simulated"].(bool); simulated {
 		response.IsSimulated = true
 		response.Message = "Google Pay payment simulated (Device Payments privilege not enabled)"
 	}
@@ -275,6 +147,43 @@ func (h *GooglePayHandler) Pay(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
+// tokenizeGooglePayCard stores the device PAN from a Google Pay payment with
+// the gateway and returns a card record holding the resulting token, so the
+// PAN itself is never saved and later charges go through PayWithToken
+func (h *GooglePayHandler) tokenizeGooglePayCard(userID uuid.UUID, req GooglePayRequest) (*models.Card, error) {
+	tokenResp, err := h.mastercardService.CreatePaymentToken(req.CardNumber, req.ExpiryMonth, req.ExpiryYear, "")
+	if err != nil {
+		return nil, err
+	}
+	if tokenResp.Token == "" {
+		return nil, fmt.Errorf("gateway returned no token")
+	}
+
+	lastFour := tokenResp.SourceOfFunds.Provided.Card.Last4
+	if lastFour == "" && len(req.CardNumber) >= 4 {
+		lastFour = req.CardNumber[len(req.CardNumber)-4:]
+	}
+	scheme := tokenResp.SourceOfFunds.Provided.Card.Scheme
+	if scheme == "" {
+		scheme = services.DetectCardScheme(req.CardNumber)
+	}
+
+	return &models.Card{
+		UserID:            userID,
+		GatewayToken:      tokenResp.Token,
+		LastFour:          lastFour,
+		ExpiryMonth:       utils.MustParseInt(req.ExpiryMonth),
+		ExpiryYear:        utils.MustParseInt(req.ExpiryYear),
+		Scheme:            scheme,
+		IsDefault:         false, // Don't set as default automatically
+		PaymentMethodType: "google_pay",
+		WalletProvider:    "GOOGLE_PAY",
+		DevicePaymentData: map[string]interface{}{
+			"eci_indicator": req.EciIndicator,
+		},
+	}, nil
+}
+
 // TestGooglePay processes a test Google Pay payment (for Postman testing)
 func (h *GooglePayHandler) TestGooglePay(c *gin.Context) {
 	var req struct {
@@ -285,24 +194,24 @@ func (h *GooglePayHandler) TestGooglePay(c *gin.Context) {
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondValidationError(c, err)
 		return
 	}
 
 	// Validate user exists
 	userID, err := uuid.Parse(req.UserID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		respondError(c, http.StatusBadRequest, "invalid user ID")
 		return
 	}
 
 	_, err = h.userRepo.GetUserByID(c.Request.Context(), userID)
 	if err != nil {
 		if _, ok := err.(*repositories.NotFoundError); ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			respondError(c, http.StatusNotFound, "user not found")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -336,20 +245,13 @@ func (h *GooglePayHandler) TestGooglePay(c *gin.Context) {
 		req.Currency,
 	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "Google Pay test payment failed",
-			"details": err.Error(),
-		})
+		respondErrorDetails(c, http.StatusInternalServerError, models.ErrorCodeInternal, "Google Pay test payment failed", gin.H{"cause": err.Error()})
 		return
 	}
 
 	// Validate payment response
 	if paymentResp.Result != "SUCCESS" && paymentResp.GatewayCode != "APPROVED" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":  "Google Pay test payment declined",
-			"code":   paymentResp.GatewayCode,
-			"result": paymentResp.Result,
-		})
+		respondErrorDetails(c, http.StatusBadRequest, models.ErrorCodePaymentDeclined, "Google Pay test payment declined", gin.H{"gateway_code": paymentResp.GatewayCode, "result": paymentResp.Result})
 		return
 	}
 
@@ -360,7 +262,11 @@ func (h *GooglePayHandler) TestGooglePay(c *gin.Context) {
 		Currency:             req.Currency,
 		Status:               paymentResp.Transaction.Status,
 		GatewayTransactionID: paymentResp.Transaction.ID,
-		Type:                 "test",
+		GatewayOrderID:       paymentResp.Order.ID,
+		GatewayResponse:      paymentResp.Raw,
+		CorrelationID:        paymentResp.CorrelationID,
+		GatewayCorrelationID: paymentResp.GatewayCorrelationID,
+		Type:                 models.TransactionTypeTest,
 		WalletProvider:       "GOOGLE_PAY",
 		PaymentMethodType:    "google_pay",
 		DevicePaymentData: map[string]interface{}{
@@ -380,7 +286,7 @@ func (h *GooglePayHandler) TestGooglePay(c *gin.Context) {
 		Message:        "Google Pay test payment processed successfully",
 		TransactionID:  paymentResp.Transaction.ID,
 		OrderID:        paymentResp.Order.ID,
-		Amount:         utils.ConvertToString(paymentResp.Order.Amount),
+		Amount:         models.NewMoney(paymentResp.Order.Amount.Float64(), paymentResp.Order.Currency),
 		Currency:       paymentResp.Order.Currency,
 		Status:         paymentResp.Transaction.Status,
 		WalletProvider: "GOOGLE_PAY",
@@ -395,7 +301,7 @@ func (h *GooglePayHandler) GetUserGooglePayCards(c *gin.Context) {
 
 	uid, err := uuid.Parse(userID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		respondError(c, http.StatusBadRequest, "invalid user ID")
 		return
 	}
 
@@ -403,17 +309,17 @@ func (h *GooglePayHandler) GetUserGooglePayCards(c *gin.Context) {
 	_, err = h.userRepo.GetUserByID(c.Request.Context(), uid)
 	if err != nil {
 		if _, ok := err.(*repositories.NotFoundError); ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			respondError(c, http.StatusNotFound, "user not found")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	// Get all cards for user
 	allCards, err := h.cardRepo.GetCardsByUserID(c.Request.Context(), uid)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -425,7 +331,7 @@ func (h *GooglePayHandler) GetUserGooglePayCards(c *gin.Context) {
 		}
 	}
 
-	c.JSON(http.StatusOK, googlePayCards)
+	c.JSON(http.StatusOK, newCardResponses(googlePayCards))
 }
 
 // DeleteGooglePayCard deletes a user's Google Pay card
@@ -436,19 +342,19 @@ func (h *GooglePayHandler) DeleteGooglePayCard(c *gin.Context) {
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondValidationError(c, err)
 		return
 	}
 
 	userID, err := uuid.Parse(req.UserID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		respondError(c, http.StatusBadRequest, "invalid user ID")
 		return
 	}
 
 	cardID, err := uuid.Parse(req.CardID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid card ID"})
+		respondError(c, http.StatusBadRequest, "invalid card ID")
 		return
 	}
 
@@ -456,10 +362,10 @@ func (h *GooglePayHandler) DeleteGooglePayCard(c *gin.Context) {
 	_, err = h.userRepo.GetUserByID(c.Request.Context(), userID)
 	if err != nil {
 		if _, ok := err.(*repositories.NotFoundError); ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			respondError(c, http.StatusNotFound, "user not found")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -467,22 +373,22 @@ func (h *GooglePayHandler) DeleteGooglePayCard(c *gin.Context) {
 	card, err := h.cardRepo.GetCardByID(c.Request.Context(), cardID)
 	if err != nil {
 		if _, ok := err.(*repositories.NotFoundError); ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "card not found"})
+			respondError(c, http.StatusNotFound, "card not found")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	// Verify card belongs to user
 	if card.UserID != userID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "card does not belong to user"})
+		respondError(c, http.StatusForbidden, "card does not belong to user")
 		return
 	}
 
 	// Verify it's a Google Pay card
 	if card.PaymentMethodType != "google_pay" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "card is not a Google Pay payment method"})
+		respondError(c, http.StatusBadRequest, "card is not a Google Pay payment method")
 		return
 	}
 
@@ -490,10 +396,10 @@ func (h *GooglePayHandler) DeleteGooglePayCard(c *gin.Context) {
 	err = h.cardRepo.DeleteCard(c.Request.Context(), cardID)
 	if err != nil {
 		if _, ok := err.(*repositories.NotFoundError); ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "card not found"})
+			respondError(c, http.StatusNotFound, "card not found")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -503,27 +409,6 @@ func (h *GooglePayHandler) DeleteGooglePayCard(c *gin.Context) {
 	})
 }
 
-// Helper function to determine card scheme
-func getCardScheme(cardNumber string) string {
-	if len(cardNumber) < 1 {
-		return "UNKNOWN"
-	}
-
-	firstDigit := cardNumber[0]
-	switch firstDigit {
-	case '4':
-		return "VISA"
-	case '5':
-		return "MASTERCARD"
-	case '3':
-		return "AMEX"
-	case '6':
-		return "DISCOVER"
-	default:
-		return "UNKNOWN"
-	}
-}
-
 // SimulateGooglePay simulates Google Pay without Device Payments privilege
 func (h *GooglePayHandler) SimulateGooglePay(c *gin.Context) {
 	var req struct {
@@ -536,24 +421,24 @@ func (h *GooglePayHandler) SimulateGooglePay(c *gin.Context) {
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondValidationError(c, err)
 		return
 	}
 
 	// Validate user exists
 	userID, err := uuid.Parse(req.UserID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		respondError(c, http.StatusBadRequest, "invalid user ID")
 		return
 	}
 
 	_, err = h.userRepo.GetUserByID(c.Request.Context(), userID)
 	if err != nil {
 		if _, ok := err.(*repositories.NotFoundError); ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			respondError(c, http.StatusNotFound, "user not found")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -565,22 +450,17 @@ func (h *GooglePayHandler) SimulateGooglePay(c *gin.Context) {
 		"123", // Dummy CVV
 		req.Amount,
 		req.Currency,
+		"",
+		"",
 	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "Google Pay simulation failed",
-			"details": err.Error(),
-		})
+		respondErrorDetails(c, http.StatusInternalServerError, models.ErrorCodeInternal, "Google Pay simulation failed", gin.H{"cause": err.Error()})
 		return
 	}
 
 	// Validate payment response
 	if paymentResp.Result != "SUCCESS" && paymentResp.GatewayCode != "APPROVED" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":  "Google Pay simulation declined",
-			"code":   paymentResp.GatewayCode,
-			"result": paymentResp.Result,
-		})
+		respondErrorDetails(c, http.StatusBadRequest, models.ErrorCodePaymentDeclined, "Google Pay simulation declined", gin.H{"gateway_code": paymentResp.GatewayCode, "result": paymentResp.Result})
 		return
 	}
 
@@ -591,7 +471,11 @@ func (h *GooglePayHandler) SimulateGooglePay(c *gin.Context) {
 		Currency:             req.Currency,
 		Status:               paymentResp.Transaction.Status,
 		GatewayTransactionID: paymentResp.Transaction.ID,
-		Type:                 "manual",
+		GatewayOrderID:       paymentResp.Order.ID,
+		GatewayResponse:      paymentResp.Raw,
+		CorrelationID:        paymentResp.CorrelationID,
+		GatewayCorrelationID: paymentResp.GatewayCorrelationID,
+		Type:                 models.TransactionTypeManual,
 		WalletProvider:       "GOOGLE_PAY",
 		PaymentMethodType:    "google_pay",
 		DevicePaymentData: map[string]interface{}{
@@ -610,7 +494,7 @@ func (h *GooglePayHandler) SimulateGooglePay(c *gin.Context) {
 		Message:        "Google Pay payment simulated successfully (Device Payments privilege not enabled)",
 		TransactionID:  paymentResp.Transaction.ID,
 		OrderID:        paymentResp.Order.ID,
-		Amount:         utils.ConvertToString(paymentResp.Order.Amount),
+		Amount:         models.NewMoney(paymentResp.Order.Amount.Float64(), paymentResp.Order.Currency),
 		Currency:       paymentResp.Order.Currency,
 		Status:         paymentResp.Transaction.Status,
 		WalletProvider: "GOOGLE_PAY",
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"pg-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type GatewayHandler struct {
+	mastercardService services.MastercardService
+}
+
+func NewGatewayHandler(mastercardService services.MastercardService) *GatewayHandler {
+	return &GatewayHandler{
+		mastercardService: mastercardService,
+	}
+}
+
+// TestConnection checks the configured gateway credentials with a harmless
+// authenticated call, e.g. after rotating the API password (admin only).
+// Nothing is charged.
+func (h *GatewayHandler) TestConnection(c *gin.Context) {
+	result, err := h.mastercardService.TestConnection(c.Request.Context())
+	if err != nil {
+		details := gin.H{}
+		status := http.StatusBadGateway
+		var apiErr *services.GatewayAPIError
+		switch {
+		case errors.As(err, &apiErr):
+			// 401/403 here means the credentials were rejected
+			details["gateway_status"] = apiErr.StatusCode
+			details["gateway_error"] = apiErr.Detail()
+			details["correlation_id"] = apiErr.Trace.CorrelationID
+		case errors.Is(err, services.ErrGatewayUnavailable):
+			status = http.StatusServiceUnavailable
+		}
+
+		respondErrorDetails(c, status, errorCode(status), err.Error(), details)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"result":  result,
+	})
+}
@@ -1,8 +1,8 @@
 package handlers
 
 import (
-	"fmt"
 	"net/http"
+	"strings"
 
 	"pg-backend/internal/models"
 	"pg-backend/internal/repositories"
@@ -18,6 +18,7 @@ type PaymentHandler struct {
 	userRepo          repositories.UserRepository
 	cardRepo          repositories.CardRepository
 	transactionRepo   repositories.TransactionRepository
+	paymentService    services.PaymentService
 }
 
 func NewPaymentHandler(
@@ -25,12 +26,14 @@ func NewPaymentHandler(
 	userRepo repositories.UserRepository,
 	cardRepo repositories.CardRepository,
 	transactionRepo repositories.TransactionRepository,
+	paymentService services.PaymentService,
 ) *PaymentHandler {
 	return &PaymentHandler{
 		mastercardService: mastercardService,
 		userRepo:          userRepo,
 		cardRepo:          cardRepo,
 		transactionRepo:   transactionRepo,
+		paymentService:    paymentService,
 	}
 }
 
@@ -57,24 +60,42 @@ type PayRequest struct {
 	Amount      string `json:"amount" binding:"required"`
 	Currency    string `json:"currency" binding:"required"`
 	Description string `json:"description,omitempty"`
+
+	// Merchant's own order number, searchable in the gateway's merchant portal
+	MerchantReference string `json:"merchant_reference,omitempty" binding:"omitempty,max=40"`
+
+	// Amount and Currency are what the customer is charged (the presentment
+	// currency). Set this when the merchant settles in a different currency
+	// to record the converted amount and exchange rate.
+	SettlementCurrency string `json:"settlement_currency,omitempty" binding:"omitempty,iso4217"`
+
+	// Marketplaces set routing_key (or sub_merchant) to charge on behalf of
+	// one of their storefronts
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
 // PayResponse represents payment response
 type PayResponse struct {
-	Success       bool   `json:"success"`
-	Message       string `json:"message"`
-	TransactionID string `json:"transaction_id,omitempty"`
-	OrderID       string `json:"order_id,omitempty"`
-	Amount        string `json:"amount,omitempty"`
-	Currency      string `json:"currency,omitempty"`
-	Status        string `json:"status,omitempty"`
+	Success       bool          `json:"success"`
+	Message       string        `json:"message"`
+	TransactionID string        `json:"transaction_id,omitempty"`
+	OrderID       string        `json:"order_id,omitempty"`
+	Amount        *models.Money `json:"amount,omitempty"`
+	Currency      string        `json:"currency,omitempty"`
+	Status        string        `json:"status,omitempty"`
+
+	MerchantReference string `json:"merchant_reference,omitempty"`
+
+	SettlementCurrency string        `json:"settlement_currency,omitempty"`
+	SettlementAmount   *models.Money `json:"settlement_amount,omitempty"`
+	FXRate             float64       `json:"fx_rate,omitempty"`
 }
 
 // CreateUser creates a new user
 func (h *PaymentHandler) CreateUser(c *gin.Context) {
 	var req CreateUserRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondValidationError(c, err)
 		return
 	}
 
@@ -84,7 +105,7 @@ func (h *PaymentHandler) CreateUser(c *gin.Context) {
 		if _, ok := err.(*repositories.DuplicateError); ok {
 			status = http.StatusConflict
 		}
-		c.JSON(status, gin.H{"error": err.Error()})
+		respondError(c, status, err.Error())
 		return
 	}
 
@@ -101,139 +122,98 @@ func (h *PaymentHandler) CreateUser(c *gin.Context) {
 func (h *PaymentHandler) Pay(c *gin.Context) {
 	var req PayRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondValidationError(c, err)
 		return
 	}
 
-	// Validate user exists
-	userID, err := uuid.Parse(req.UserID)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+	chargeReq, ok := chargeRequest(c, req.UserID, req.CardID)
+	if !ok {
 		return
 	}
+	chargeReq.Amount = req.Amount
+	chargeReq.Currency = req.Currency
+	chargeReq.MerchantReference = req.MerchantReference
+	chargeReq.SettlementCurrency = req.SettlementCurrency
+	chargeReq.Metadata = req.Metadata
+	if req.CardID == "" {
+		chargeReq.Source = services.PaymentSourceCard
+		chargeReq.Card = &services.CardDetails{
+			Number:      req.CardNumber,
+			ExpiryMonth: req.ExpiryMonth,
+			ExpiryYear:  req.ExpiryYear,
+			CVV:         req.CVV,
+		}
+	}
 
-	_, err = h.userRepo.GetUserByID(c.Request.Context(), userID)
+	result, err := h.paymentService.Charge(c.Request.Context(), chargeReq)
 	if err != nil {
-		if _, ok := err.(*repositories.NotFoundError); ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondPaymentError(c, err, "payment")
 		return
 	}
 
-	var paymentResp *services.PaymentResponse
-	var cardID uuid.UUID
-	var card *models.Card
+	paymentResp := result.Response
+	response := PayResponse{
+		Success:       paymentResp.Result == "SUCCESS",
+		Message:       "Payment processed successfully",
+		TransactionID: paymentResp.Transaction.ID,
+		OrderID:       paymentResp.Order.ID,
+		Amount:        models.NewMoney(paymentResp.Order.Amount.Float64(), paymentResp.Order.Currency),
+		Currency:      paymentResp.Order.Currency,
+		Status:        paymentResp.Transaction.Status,
 
-	// Check if using saved card or new card
-	if req.CardID != "" {
-		// Pay with saved card (using token)
-		cardID, err = uuid.Parse(req.CardID)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid card ID"})
-			return
-		}
+		MerchantReference: req.MerchantReference,
 
-		// Get card from database
-		card, err = h.cardRepo.GetCardByID(c.Request.Context(), cardID)
-		if err != nil {
-			if _, ok := err.(*repositories.NotFoundError); ok {
-				c.JSON(http.StatusNotFound, gin.H{"error": "card not found"})
-				return
-			}
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
+		SettlementCurrency: result.Transaction.SettlementCurrency,
+		SettlementAmount:   models.NewMoney(result.Transaction.SettlementAmount, result.Transaction.SettlementCurrency),
+		FXRate:             result.Transaction.FXRate,
+	}
 
-		// Verify card belongs to user
-		if card.UserID != userID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "card does not belong to user"})
-			return
-		}
+	c.JSON(http.StatusOK, response)
+}
 
-		// Pay with token
-		paymentResp, err = h.mastercardService.PayWithToken(
-			card.GatewayToken,
-			req.Amount,
-			req.Currency,
-		)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":   "payment failed",
-				"details": err.Error(),
-			})
-			return
-		}
+// chargeRequest starts a ChargeRequest from the user and optional saved card
+// IDs in a request, writing a 400 response if either is malformed. A card ID
+// makes the saved card the payment source.
+func chargeRequest(c *gin.Context, userID, cardID string) (services.ChargeRequest, bool) {
+	var req services.ChargeRequest
 
-	} else {
-		// Pay with new card details
-		if req.CardNumber == "" || req.ExpiryMonth == "" || req.ExpiryYear == "" || req.CVV == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "card details required when not using saved card"})
-			return
-		}
+	var err error
+	if req.UserID, err = uuid.Parse(userID); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid user ID")
+		return req, false
+	}
 
-		paymentResp, err = h.mastercardService.PayWithCard(
-			req.CardNumber,
-			req.ExpiryMonth,
-			req.ExpiryYear,
-			req.CVV,
-			req.Amount,
-			req.Currency,
-		)
+	if cardID != "" {
+		id, err := uuid.Parse(cardID)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":   "payment failed",
-				"details": err.Error(),
-			})
-			return
+			respondError(c, http.StatusBadRequest, "invalid card ID")
+			return req, false
 		}
+		req.Source = services.PaymentSourceSavedCard
+		req.CardID = uuid.NullUUID{UUID: id, Valid: true}
 	}
 
-	// Validate payment response
-	if paymentResp.Result != "SUCCESS" && paymentResp.GatewayCode != "APPROVED" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":  "payment declined",
-			"code":   paymentResp.GatewayCode,
-			"result": paymentResp.Result,
-		})
-		return
-	}
-
-	// Save transaction to database
-	transaction := &models.Transaction{
-		UserID:               userID,
-		Amount:               utils.MustParseFloat(req.Amount),
-		Currency:             req.Currency,
-		Status:               paymentResp.Transaction.Status,
-		GatewayTransactionID: paymentResp.Transaction.ID,
-		Type:                 "manual",
-	}
-
-	// If using saved card, set card ID
-	if req.CardID != "" {
-		transaction.CardID = cardID
-	}
+	return req, true
+}
 
-	// Save transaction to database
-	err = h.transactionRepo.CreateTransaction(c.Request.Context(), transaction)
-	if err != nil {
-		// Log error but still return payment success
-		fmt.Printf("Warning: Failed to save transaction to database: %v\n", err)
-		// Continue - payment was successful even if DB save failed
+// respondPaymentError maps a PaymentService error to a response. operation
+// names what was attempted, e.g. "payment" or "authorization".
+func respondPaymentError(c *gin.Context, err error, operation string) {
+	switch e := err.(type) {
+	case *services.ValidationError:
+		respondFieldErrors(c, http.StatusBadRequest, gin.H{e.Field: e.Error()})
+	case *services.NotFoundError:
+		respondError(c, http.StatusNotFound, e.Error())
+	case *services.ForbiddenError:
+		respondError(c, http.StatusForbidden, e.Error())
+	case *services.PaymentDeclinedError:
+		respondErrorDetails(c, http.StatusBadRequest, models.ErrorCodePaymentDeclined, operation+" declined", gin.H{"gateway_code": e.GatewayCode, "result": e.Result})
+	case *services.AmountMismatchError:
+		// The order may have been charged; it is held for review, not failed
+		respondErrorDetails(c, http.StatusBadGateway, models.ErrorCodeGatewayError, operation+" held for review", gin.H{"order_id": e.OrderID})
+	default:
+		respondErrorDetails(c, http.StatusInternalServerError, models.ErrorCodeInternal, operation+" failed", gin.H{"cause": err.Error()})
 	}
-
-	response := PayResponse{
-		Success:       paymentResp.Result == "SUCCESS",
-		Message:       "Payment processed successfully",
-		TransactionID: paymentResp.Transaction.ID,
-		OrderID:       paymentResp.Order.ID,
-		Amount:        utils.ConvertToString(paymentResp.Order.Amount),
-		Currency:      paymentResp.Order.Currency,
-		Status:        paymentResp.Transaction.Status,
-	}
-
-	c.JSON(http.StatusOK, response)
 }
 
 // RefundRequest represents refund request
@@ -247,7 +227,7 @@ type RefundRequest struct {
 func (h *PaymentHandler) Refund(c *gin.Context) {
 	var req RefundRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondValidationError(c, err)
 		return
 	}
 
@@ -257,10 +237,7 @@ func (h *PaymentHandler) Refund(c *gin.Context) {
 		req.Currency,
 	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "refund failed",
-			"details": err.Error(),
-		})
+		respondErrorDetails(c, http.StatusInternalServerError, models.ErrorCodeInternal, "refund failed", gin.H{"cause": err.Error()})
 		return
 	}
 
@@ -270,7 +247,11 @@ func (h *PaymentHandler) Refund(c *gin.Context) {
 		Currency:             req.Currency,
 		Status:               refundResp.Transaction.Status,
 		GatewayTransactionID: refundResp.Transaction.ID,
-		Type:                 "refund",
+		GatewayOrderID:       refundResp.Order.ID,
+		GatewayResponse:      refundResp.Raw,
+		CorrelationID:        refundResp.CorrelationID,
+		GatewayCorrelationID: refundResp.GatewayCorrelationID,
+		Type:                 models.TransactionTypeRefund,
 		// Note: We don't have userID or cardID for refunds without additional logic
 	}
 
@@ -283,7 +264,7 @@ func (h *PaymentHandler) Refund(c *gin.Context) {
 		"success":        refundResp.Result == "SUCCESS",
 		"message":        "Refund processed",
 		"transaction_id": refundResp.Transaction.ID,
-		"amount":         refundResp.Transaction.Amount,
+		"amount":         models.NewMoney(refundResp.Transaction.Amount.Float64(), refundResp.Transaction.Currency),
 		"currency":       refundResp.Transaction.Currency,
 	})
 }
@@ -293,13 +274,14 @@ type GetTransactionsRequest struct {
 	UserID string `json:"user_id" binding:"required,uuid4"`
 }
 
-// GetTransactions gets all transactions for a user
+// GetTransactions gets all transactions for a user, optionally only those
+// made with ?payment_method_type= and/or ?wallet_provider=
 func (h *PaymentHandler) GetTransactions(c *gin.Context) {
 	userID := c.Param("user_id")
 
 	uid, err := uuid.Parse(userID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		respondError(c, http.StatusBadRequest, "invalid user ID")
 		return
 	}
 
@@ -307,17 +289,34 @@ func (h *PaymentHandler) GetTransactions(c *gin.Context) {
 	_, err = h.userRepo.GetUserByID(c.Request.Context(), uid)
 	if err != nil {
 		if _, ok := err.(*repositories.NotFoundError); ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			respondError(c, http.StatusNotFound, "user not found")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	filter := repositories.TransactionFilter{
+		PaymentMethodType: strings.ToLower(c.Query("payment_method_type")),
+		WalletProvider:    strings.ToUpper(c.Query("wallet_provider")),
+	}
+	switch filter.PaymentMethodType {
+	case "", models.PaymentMethodTypeCard, models.PaymentMethodTypeGooglePay, models.PaymentMethodTypeApplePay:
+	default:
+		respondFieldErrors(c, http.StatusBadRequest, gin.H{"payment_method_type": "must be one of: card, google_pay, apple_pay"})
+		return
+	}
+	switch filter.WalletProvider {
+	case "", models.WalletProviderGooglePay, models.WalletProviderApplePay:
+	default:
+		respondFieldErrors(c, http.StatusBadRequest, gin.H{"wallet_provider": "must be one of: GOOGLE_PAY, APPLE_PAY"})
 		return
 	}
 
 	// Get user's transactions
-	transactions, err := h.transactionRepo.GetTransactionsByUserID(c.Request.Context(), uid)
+	transactions, err := h.transactionRepo.GetTransactionsByUserID(c.Request.Context(), uid, filter)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -330,20 +329,58 @@ func (h *PaymentHandler) GetTransactionByID(c *gin.Context) {
 
 	tid, err := uuid.Parse(transactionID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid transaction ID"})
+		respondError(c, http.StatusBadRequest, "invalid transaction ID")
 		return
 	}
 
 	transaction, err := h.transactionRepo.GetTransactionByID(c.Request.Context(), tid)
 	if err != nil {
 		if _, ok := err.(*repositories.NotFoundError); ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "transaction not found"})
+			respondError(c, http.StatusNotFound, "transaction not found")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	c.JSON(http.StatusOK, transaction)
 }
 
+// GetTransactionsByReference finds transactions by the merchant's own order
+// reference, e.g. GET /transactions?merchant_reference=ORD-1001
+func (h *PaymentHandler) GetTransactionsByReference(c *gin.Context) {
+	reference := c.Query("merchant_reference")
+	if reference == "" {
+		respondFieldErrors(c, http.StatusBadRequest, gin.H{"merchant_reference": "is required"})
+		return
+	}
+
+	transactions, err := h.transactionRepo.GetTransactionsByMerchantReference(c.Request.Context(), reference)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if transactions == nil {
+		transactions = []models.Transaction{}
+	}
+
+	c.JSON(http.StatusOK, transactions)
+}
+
+// GetTransactionsByGatewayOrder returns every transaction recorded against a
+// gateway order ID, e.g. GET /transactions/by-order/ORDER-123, so support can
+// go from the gateway portal to our records
+func (h *PaymentHandler) GetTransactionsByGatewayOrder(c *gin.Context) {
+	transactions, err := h.transactionRepo.GetTransactionsByGatewayOrderID(c.Request.Context(), c.Param("order_id"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if transactions == nil {
+		transactions = []models.Transaction{}
+	}
+
+	c.JSON(http.StatusOK, transactions)
+}
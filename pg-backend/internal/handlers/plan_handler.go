@@ -2,7 +2,10 @@ package handlers
 
 import (
 	"net/http"
+	"strings"
+	"time"
 
+	"pg-backend/internal/config"
 	"pg-backend/internal/models"
 	"pg-backend/internal/services"
 
@@ -12,58 +15,130 @@ import (
 
 type PlanHandler struct {
 	planService services.PlanService
+	cfg         *config.Config
 }
 
-func NewPlanHandler(planService services.PlanService) *PlanHandler {
+func NewPlanHandler(planService services.PlanService, cfg *config.Config) *PlanHandler {
 	return &PlanHandler{
 		planService: planService,
+		cfg:         cfg,
 	}
 }
 
 // CreatePlanRequest represents plan creation request
 type CreatePlanRequest struct {
-	Name            string  `json:"name" binding:"required"`
-	Amount          float64 `json:"amount" binding:"required,gt=0"`
-	Currency        string  `json:"currency" binding:"required,iso4217"`
-	Interval        string  `json:"interval" binding:"required,oneof=day week month year"`
-	TrialPeriodDays int     `json:"trial_period_days" binding:"gte=0"`
-	Description     string  `json:"description"`
-	IsActive        bool    `json:"is_active"`
+	Name                string  `json:"name" binding:"required"`
+	Amount              float64 `json:"amount" binding:"required,gt=0"`
+	Currency            string  `json:"currency" binding:"required,iso4217"`
+	Interval            string  `json:"interval" binding:"required,oneof=day week month year"`
+	TrialPeriodDays     *int    `json:"trial_period_days" binding:"omitempty,gte=0"` // omitted uses the configured default
+	Description         string  `json:"description"`
+	StatementDescriptor string  `json:"statement_descriptor" binding:"omitempty,max=22"`
+	IsActive            bool    `json:"is_active"`
+
+	// Merchant-defined key/value data, e.g. feature flags or tier codes
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
 // CreatePlan creates a new subscription plan
 func (h *PlanHandler) CreatePlan(c *gin.Context) {
 	var req CreatePlanRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondValidationError(c, err)
 		return
 	}
 
+	plan := h.newPlan(req)
+	if err := h.planService.CreatePlan(c.Request.Context(), plan); err != nil {
+		switch e := err.(type) {
+		case *services.ValidationError:
+			respondFieldErrors(c, http.StatusBadRequest, gin.H{e.Field: e.Error()})
+			return
+		case *services.DuplicateError:
+			respondError(c, http.StatusConflict, err.Error())
+			return
+		}
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusCreated, plan)
+}
+
+// newPlan builds the plan described by a creation request
+func (h *PlanHandler) newPlan(req CreatePlanRequest) *models.Plan {
 	// Default currency to LKR if not specified or invalid
 	if req.Currency == "" {
 		req.Currency = "LKR"
 	}
 
-	plan := &models.Plan{
-		Name:            req.Name,
-		Amount:          req.Amount,
-		Currency:        req.Currency,
-		Interval:        req.Interval,
-		TrialPeriodDays: req.TrialPeriodDays,
-		Description:     req.Description,
-		IsActive:        req.IsActive,
+	trialPeriodDays := h.cfg.DefaultTrialPeriodDays()
+	if req.TrialPeriodDays != nil {
+		trialPeriodDays = *req.TrialPeriodDays
 	}
 
-	if err := h.planService.CreatePlan(c.Request.Context(), plan); err != nil {
-		status := http.StatusInternalServerError
-		if _, ok := err.(*services.DuplicateError); ok {
-			status = http.StatusConflict
+	return &models.Plan{
+		Name:                req.Name,
+		Amount:              req.Amount,
+		Currency:            req.Currency,
+		Interval:            req.Interval,
+		TrialPeriodDays:     trialPeriodDays,
+		Description:         req.Description,
+		StatementDescriptor: req.StatementDescriptor,
+		IsActive:            req.IsActive,
+		Metadata:            req.Metadata,
+	}
+}
+
+// CreatePlansRequest represents a bulk plan creation request
+type CreatePlansRequest struct {
+	Plans []CreatePlanRequest `json:"plans" binding:"required,min=1,max=100,dive"`
+
+	// "all_or_nothing" (default) creates every plan or none of them;
+	// "best_effort" creates each valid plan and reports the rest
+	Mode string `json:"mode" binding:"omitempty,oneof=all_or_nothing best_effort"`
+}
+
+// CreatePlans creates a catalog of plans in one request. The response
+// reports each plan as created, conflict, failed or not_created.
+func (h *PlanHandler) CreatePlans(c *gin.Context) {
+	var req CreatePlansRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	plans := make([]*models.Plan, len(req.Plans))
+	for i, item := range req.Plans {
+		plans[i] = h.newPlan(item)
+	}
+
+	allOrNothing := req.Mode != "best_effort"
+	results, err := h.planService.CreatePlans(c.Request.Context(), plans, allOrNothing)
+	if err != nil {
+		switch err.(type) {
+		case *services.ValidationError:
+			respondErrorDetails(c, http.StatusUnprocessableEntity, models.ErrorCodeValidationFailed, err.Error(), gin.H{"results": results})
+			return
+		case *services.ConflictError:
+			respondErrorDetails(c, http.StatusConflict, models.ErrorCodeConflict, err.Error(), gin.H{"results": results})
+			return
 		}
-		c.JSON(status, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	c.JSON(http.StatusCreated, plan)
+	created := 0
+	for _, result := range results {
+		if result.Status == "created" {
+			created++
+		}
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"created": created,
+		"results": results,
+	})
 }
 
 // GetPlan gets a plan by ID
@@ -72,17 +147,17 @@ func (h *PlanHandler) GetPlan(c *gin.Context) {
 
 	id, err := uuid.Parse(planID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid plan ID"})
+		respondError(c, http.StatusBadRequest, "invalid plan ID")
 		return
 	}
 
 	plan, err := h.planService.GetPlan(c.Request.Context(), id)
 	if err != nil {
 		if _, ok := err.(*services.NotFoundError); ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "plan not found"})
+			respondError(c, http.StatusNotFound, "plan not found")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -95,7 +170,7 @@ func (h *PlanHandler) GetPlans(c *gin.Context) {
 
 	plans, err := h.planService.GetAllPlans(c.Request.Context(), activeOnly)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -104,13 +179,21 @@ func (h *PlanHandler) GetPlans(c *gin.Context) {
 
 // UpdatePlanRequest represents plan update request
 type UpdatePlanRequest struct {
-	Name            string  `json:"name" binding:"required"`
-	Amount          float64 `json:"amount" binding:"required,gt=0"`
-	Currency        string  `json:"currency" binding:"required,iso4217"`
-	Interval        string  `json:"interval" binding:"required,oneof=day week month year"`
-	TrialPeriodDays int     `json:"trial_period_days" binding:"gte=0"`
-	Description     string  `json:"description"`
-	IsActive        bool    `json:"is_active"`
+	Name                string  `json:"name" binding:"required"`
+	Amount              float64 `json:"amount" binding:"required,gt=0"`
+	Currency            string  `json:"currency" binding:"required,iso4217"`
+	Interval            string  `json:"interval" binding:"required,oneof=day week month year"`
+	TrialPeriodDays     int     `json:"trial_period_days" binding:"gte=0"`
+	Description         string  `json:"description"`
+	StatementDescriptor string  `json:"statement_descriptor" binding:"omitempty,max=22"`
+	IsActive            bool    `json:"is_active"`
+
+	// Merchant-defined key/value data, e.g. feature flags or tier codes
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// The updated_at of the plan being edited. When sent, the update is
+	// refused with 409 if the plan has changed since.
+	UpdatedAt *time.Time `json:"updated_at"`
 }
 
 // UpdatePlan updates a plan
@@ -119,33 +202,45 @@ func (h *PlanHandler) UpdatePlan(c *gin.Context) {
 
 	id, err := uuid.Parse(planID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid plan ID"})
+		respondError(c, http.StatusBadRequest, "invalid plan ID")
 		return
 	}
 
 	var req UpdatePlanRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondValidationError(c, err)
 		return
 	}
 
 	plan := &models.Plan{
-		ID:              id,
-		Name:            req.Name,
-		Amount:          req.Amount,
-		Currency:        req.Currency,
-		Interval:        req.Interval,
-		TrialPeriodDays: req.TrialPeriodDays,
-		Description:     req.Description,
-		IsActive:        req.IsActive,
+		ID:                  id,
+		Name:                req.Name,
+		Amount:              req.Amount,
+		Currency:            req.Currency,
+		Interval:            req.Interval,
+		TrialPeriodDays:     req.TrialPeriodDays,
+		Description:         req.Description,
+		StatementDescriptor: req.StatementDescriptor,
+		IsActive:            req.IsActive,
+		Metadata:            req.Metadata,
+	}
+	if req.UpdatedAt != nil {
+		plan.UpdatedAt = *req.UpdatedAt
 	}
 
 	if err := h.planService.UpdatePlan(c.Request.Context(), plan); err != nil {
-		if _, ok := err.(*services.NotFoundError); ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "plan not found"})
+		switch e := err.(type) {
+		case *services.ValidationError:
+			respondFieldErrors(c, http.StatusBadRequest, gin.H{e.Field: e.Error()})
+			return
+		case *services.NotFoundError:
+			respondError(c, http.StatusNotFound, "plan not found")
+			return
+		case *services.ConflictError:
+			respondError(c, http.StatusConflict, e.Error())
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -158,16 +253,16 @@ func (h *PlanHandler) DeletePlan(c *gin.Context) {
 
 	id, err := uuid.Parse(planID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid plan ID"})
+		respondError(c, http.StatusBadRequest, "invalid plan ID")
 		return
 	}
 
 	if err := h.planService.DeletePlan(c.Request.Context(), id); err != nil {
 		if _, ok := err.(*services.NotFoundError); ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "plan not found"})
+			respondError(c, http.StatusNotFound, "plan not found")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -177,17 +272,52 @@ func (h *PlanHandler) DeletePlan(c *gin.Context) {
 	})
 }
 
+// ActivatePlan restores an archived plan
+func (h *PlanHandler) ActivatePlan(c *gin.Context) {
+	h.setPlanActive(c, true)
+}
+
+// DeactivatePlan archives a plan so no new subscriptions can use it
+func (h *PlanHandler) DeactivatePlan(c *gin.Context) {
+	h.setPlanActive(c, false)
+}
+
+// setPlanActive changes only a plan's active flag
+func (h *PlanHandler) setPlanActive(c *gin.Context, active bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid plan ID")
+		return
+	}
+
+	plan, err := h.planService.SetPlanActive(c.Request.Context(), id, active)
+	if err != nil {
+		if _, ok := err.(*services.NotFoundError); ok {
+			respondError(c, http.StatusNotFound, "plan not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, plan)
+}
+
 // GetPlansByCurrency gets plans by currency
 func (h *PlanHandler) GetPlansByCurrency(c *gin.Context) {
-	currency := c.Param("currency")
+	currency := strings.ToUpper(strings.TrimSpace(c.Param("currency")))
 	if currency == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "currency parameter required"})
+		respondError(c, http.StatusBadRequest, "currency parameter required")
+		return
+	}
+	if !isValidCurrency(currency) {
+		respondFieldErrors(c, http.StatusBadRequest, gin.H{"currency": "must be a valid ISO 4217 currency code"})
 		return
 	}
 
 	plans, err := h.planService.GetPlansByCurrency(c.Request.Context(), currency)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
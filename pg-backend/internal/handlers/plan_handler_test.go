@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"pg-backend/internal/config"
+	"pg-backend/internal/models"
+	"pg-backend/internal/repositories"
+	"pg-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// fakePlanRepo serves a fixed list of active plans
+type fakePlanRepo struct {
+	repositories.PlanRepository
+	plans []models.Plan
+}
+
+func (r *fakePlanRepo) GetAllPlans(ctx context.Context, activeOnly bool) ([]models.Plan, error) {
+	return r.plans, nil
+}
+
+func TestGetPlansByCurrency(t *testing.T) {
+	cfg := &config.Config{}
+	repo := &fakePlanRepo{plans: []models.Plan{
+		{ID: uuid.New(), Name: "Basic", Amount: 10, Currency: "USD", Interval: "month", IsActive: true},
+		{ID: uuid.New(), Name: "Legacy", Amount: 90, Currency: "usd", Interval: "year", IsActive: true},
+		{ID: uuid.New(), Name: "Euro", Amount: 9, Currency: "EUR", Interval: "month", IsActive: true},
+	}}
+	handler := NewPlanHandler(services.NewPlanService(repo, services.NewAmountLimits(cfg), services.NewSupportedCurrencies(cfg)), cfg)
+
+	router := gin.New()
+	router.GET("/plans/currency/:currency", handler.GetPlansByCurrency)
+
+	tests := []struct {
+		currency  string
+		status    int
+		wantPlans []string
+	}{
+		{"USD", http.StatusOK, []string{"Basic", "Legacy"}},
+		{"usd", http.StatusOK, []string{"Basic", "Legacy"}},
+		{"Eur", http.StatusOK, []string{"Euro"}},
+		{"gbp", http.StatusOK, nil},
+		{"zzz", http.StatusBadRequest, nil},
+		{"us", http.StatusBadRequest, nil},
+		{"dollars", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.currency, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/plans/currency/"+tt.currency, nil))
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				apiErr := decodeEnvelope(t, w.Body.Bytes())
+				details, _ := apiErr["details"].(map[string]interface{})
+				fields, _ := details["fields"].(map[string]interface{})
+				if apiErr["code"] != models.ErrorCodeValidationFailed || fields["currency"] == nil {
+					t.Errorf("error = %v, want a validation error on currency", apiErr)
+				}
+				return
+			}
+
+			var plans []models.Plan
+			if err := json.Unmarshal(w.Body.Bytes(), &plans); err != nil {
+				t.Fatalf("decode plans: %v", err)
+			}
+			if len(plans) != len(tt.wantPlans) {
+				t.Fatalf("got %d plans, want %v", len(plans), tt.wantPlans)
+			}
+			for i, name := range tt.wantPlans {
+				if plans[i].Name != name {
+					t.Errorf("plan %d = %s, want %s", i, plans[i].Name, name)
+				}
+			}
+		})
+	}
+}
@@ -1,8 +1,13 @@
 package handlers
 
 import (
+	"database/sql"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"pg-backend/internal/models"
 	"pg-backend/internal/services"
 
 	"github.com/gin-gonic/gin"
@@ -23,39 +28,60 @@ func NewSubscriptionHandler(subscriptionService services.SubscriptionService) *S
 type CreateSubscriptionRequest struct {
 	UserID   string            `json:"user_id" binding:"required,uuid4"`
 	PlanID   string            `json:"plan_id" binding:"required,uuid4"`
-	CardID   string            `json:"card_id" binding:"required,uuid4"`
+	CardID   string            `json:"card_id" binding:"omitempty,uuid4"` // optional when the plan has a trial
 	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Optional date the first full billing period starts, e.g. the 1st of
+	// next month to bill every subscription on the same day
+	BillingCycleAnchor *time.Time `json:"billing_cycle_anchor,omitempty"`
 }
 
 // CreateSubscription creates a new subscription
 func (h *SubscriptionHandler) CreateSubscription(c *gin.Context) {
 	var req CreateSubscriptionRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondValidationError(c, err)
 		return
 	}
 
 	// Parse UUIDs
 	userID, err := uuid.Parse(req.UserID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		respondError(c, http.StatusBadRequest, "invalid user ID")
 		return
 	}
 
 	planID, err := uuid.Parse(req.PlanID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid plan ID"})
+		respondError(c, http.StatusBadRequest, "invalid plan ID")
 		return
 	}
 
-	cardID, err := uuid.Parse(req.CardID)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid card ID"})
-		return
+	var cardID uuid.NullUUID
+	if req.CardID != "" {
+		id, err := uuid.Parse(req.CardID)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "invalid card ID")
+			return
+		}
+		cardID = uuid.NullUUID{UUID: id, Valid: true}
+	}
+
+	var anchor sql.NullTime
+	if req.BillingCycleAnchor != nil {
+		anchor = sql.NullTime{Time: *req.BillingCycleAnchor, Valid: true}
 	}
 
-	subscription, err := h.subscriptionService.CreateSubscription(c.Request.Context(), userID, planID, cardID, req.Metadata)
+	subscription, err := h.subscriptionService.CreateSubscription(c.Request.Context(), userID, planID, cardID, req.Metadata, anchor)
 	if err != nil {
+		switch e := err.(type) {
+		case *services.ValidationError:
+			respondFieldErrors(c, http.StatusBadRequest, gin.H{e.Field: e.Error()})
+			return
+		case *services.ConflictError:
+			respondError(c, http.StatusConflict, e.Error())
+			return
+		}
 		status := http.StatusInternalServerError
 		switch {
 		case err.Error() == "user already has active subscription for this plan":
@@ -63,30 +89,123 @@ func (h *SubscriptionHandler) CreateSubscription(c *gin.Context) {
 		case err.Error() == "card does not belong to user":
 			status = http.StatusForbidden
 		}
-		c.JSON(status, gin.H{"error": err.Error()})
+		respondError(c, status, err.Error())
 		return
 	}
 
 	c.JSON(http.StatusCreated, subscription)
 }
 
-// GetSubscription gets a subscription by ID
+// ImportSubscriptionRow represents a single subscription being migrated from another processor
+type ImportSubscriptionRow struct {
+	UserID             string            `json:"user_id" binding:"required,uuid4"`
+	PlanID             string            `json:"plan_id" binding:"required,uuid4"`
+	CardID             string            `json:"card_id" binding:"required,uuid4"`
+	Status             string            `json:"status,omitempty"`
+	CurrentPeriodStart time.Time         `json:"current_period_start" binding:"required"`
+	CurrentPeriodEnd   time.Time         `json:"current_period_end" binding:"required"`
+	NextBillingAt      time.Time         `json:"next_billing_at" binding:"required"`
+	TrialEnd           *time.Time        `json:"trial_end,omitempty"`
+	Metadata           map[string]string `json:"metadata,omitempty"`
+	SkipInitialCharge  bool              `json:"skip_initial_charge"`
+}
+
+// ImportSubscriptionsRequest represents a bulk subscription import request
+type ImportSubscriptionsRequest struct {
+	Subscriptions []ImportSubscriptionRow `json:"subscriptions" binding:"required,min=1,max=500,dive"`
+}
+
+// ImportSubscriptions imports existing subscriptions in their current state.
+// Either every row is imported or none are; the response reports each row.
+func (h *SubscriptionHandler) ImportSubscriptions(c *gin.Context) {
+	var req ImportSubscriptionsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	rows := make([]services.SubscriptionImportRow, len(req.Subscriptions))
+	for i, item := range req.Subscriptions {
+		rows[i] = services.SubscriptionImportRow{
+			UserID:             uuid.MustParse(item.UserID),
+			PlanID:             uuid.MustParse(item.PlanID),
+			CardID:             uuid.MustParse(item.CardID),
+			Status:             models.SubscriptionStatus(item.Status),
+			CurrentPeriodStart: item.CurrentPeriodStart,
+			CurrentPeriodEnd:   item.CurrentPeriodEnd,
+			NextBillingAt:      item.NextBillingAt,
+			Metadata:           item.Metadata,
+			SkipInitialCharge:  item.SkipInitialCharge,
+		}
+		if item.TrialEnd != nil {
+			rows[i].TrialEnd = sql.NullTime{Time: *item.TrialEnd, Valid: true}
+		}
+	}
+
+	results, err := h.subscriptionService.ImportSubscriptions(c.Request.Context(), rows)
+	if err != nil {
+		if _, ok := err.(*services.ValidationError); ok {
+			respondErrorDetails(c, http.StatusUnprocessableEntity, models.ErrorCodeValidationFailed, err.Error(), gin.H{"results": results})
+			return
+		}
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"imported": len(results),
+		"results":  results,
+	})
+}
+
+// GetSubscription gets a subscription by ID. ?expand=plan,card embeds the
+// plan and a card summary so clients don't need separate lookups.
 func (h *SubscriptionHandler) GetSubscription(c *gin.Context) {
 	subscriptionID := c.Param("id")
 
 	id, err := uuid.Parse(subscriptionID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid subscription ID"})
+		respondError(c, http.StatusBadRequest, "invalid subscription ID")
 		return
 	}
 
-	subscription, err := h.subscriptionService.GetSubscription(c.Request.Context(), id)
+	var expandPlan, expandCard bool
+	if expand := c.Query("expand"); expand != "" {
+		for _, field := range strings.Split(expand, ",") {
+			switch strings.TrimSpace(field) {
+			case "plan":
+				expandPlan = true
+			case "card":
+				expandCard = true
+			default:
+				respondFieldErrors(c, http.StatusBadRequest, gin.H{"expand": "must be a comma separated list of plan, card"})
+				return
+			}
+		}
+	}
+
+	if !expandPlan && !expandCard {
+		subscription, err := h.subscriptionService.GetSubscription(c.Request.Context(), id)
+		if err != nil {
+			if _, ok := err.(*services.NotFoundError); ok {
+				respondError(c, http.StatusNotFound, "subscription not found")
+				return
+			}
+			respondError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, subscription)
+		return
+	}
+
+	subscription, err := h.subscriptionService.GetExpandedSubscription(c.Request.Context(), id, expandPlan, expandCard)
 	if err != nil {
 		if _, ok := err.(*services.NotFoundError); ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "subscription not found"})
+			respondError(c, http.StatusNotFound, "subscription not found")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -100,22 +219,70 @@ func (h *SubscriptionHandler) GetUserSubscriptions(c *gin.Context) {
 
 	uid, err := uuid.Parse(userID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		respondError(c, http.StatusBadRequest, "invalid user ID")
 		return
 	}
 
 	subscriptions, err := h.subscriptionService.GetUserSubscriptions(c.Request.Context(), uid, status)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	c.JSON(http.StatusOK, subscriptions)
 }
 
+// GetSubscriptionStats reports how many subscriptions are in each status
+func (h *SubscriptionHandler) GetSubscriptionStats(c *gin.Context) {
+	stats, err := h.subscriptionService.GetSubscriptionStats(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// Limits on the upcoming billing forecast
+const (
+	maxUpcomingHours   = 24 * 31
+	maxUpcomingCharges = 1000
+)
+
+// GetUpcomingBilling forecasts the subscription charges due in the next
+// hours (default 24), e.g. GET /admin/subscriptions/upcoming?hours=72
+func (h *SubscriptionHandler) GetUpcomingBilling(c *gin.Context) {
+	hours := 24
+	if raw := c.Query("hours"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxUpcomingHours {
+			respondFieldErrors(c, http.StatusBadRequest, gin.H{"hours": "must be a whole number of hours between 1 and " + strconv.Itoa(maxUpcomingHours)})
+			return
+		}
+		hours = n
+	}
+
+	preview, err := h.subscriptionService.PreviewUpcomingBilling(c.Request.Context(), time.Duration(hours)*time.Hour, maxUpcomingCharges)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"hours":              hours,
+		"until":              preview.Until,
+		"count":              len(preview.Charges),
+		"truncated":          len(preview.Charges) == maxUpcomingCharges,
+		"charges":            preview.Charges,
+		"totals_by_currency": preview.TotalsByCurrency,
+	})
+}
+
 // CancelSubscriptionRequest represents subscription cancellation request
 type CancelSubscriptionRequest struct {
-	CancelAtPeriodEnd bool `json:"cancel_at_period_end"`
+	CancelAtPeriodEnd bool   `json:"cancel_at_period_end"`
+	Reason            string `json:"reason,omitempty" binding:"omitempty,oneof=too_expensive not_using missing_features switched_service technical_issues customer_service temporary other"`
+	Comment           string `json:"comment,omitempty" binding:"max=1000"`
 }
 
 // CancelSubscription cancels a subscription
@@ -124,22 +291,33 @@ func (h *SubscriptionHandler) CancelSubscription(c *gin.Context) {
 
 	id, err := uuid.Parse(subscriptionID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid subscription ID"})
+		respondError(c, http.StatusBadRequest, "invalid subscription ID")
 		return
 	}
 
 	var req CancelSubscriptionRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondValidationError(c, err)
 		return
 	}
 
-	if err := h.subscriptionService.CancelSubscription(c.Request.Context(), id, req.CancelAtPeriodEnd); err != nil {
-		if _, ok := err.(*services.NotFoundError); ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "subscription not found"})
+	err = h.subscriptionService.CancelSubscription(
+		c.Request.Context(),
+		id,
+		req.CancelAtPeriodEnd,
+		models.CancellationReason(req.Reason),
+		req.Comment,
+	)
+	if err != nil {
+		switch e := err.(type) {
+		case *services.ValidationError:
+			respondFieldErrors(c, http.StatusBadRequest, gin.H{"comment": e.Error()})
+			return
+		case *services.NotFoundError:
+			respondError(c, http.StatusNotFound, "subscription not found")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -165,28 +343,32 @@ func (h *SubscriptionHandler) UpdateSubscriptionCard(c *gin.Context) {
 
 	subID, err := uuid.Parse(subscriptionID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid subscription ID"})
+		respondError(c, http.StatusBadRequest, "invalid subscription ID")
 		return
 	}
 
 	var req UpdateSubscriptionCardRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondValidationError(c, err)
 		return
 	}
 
 	cardID, err := uuid.Parse(req.CardID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid card ID"})
+		respondError(c, http.StatusBadRequest, "invalid card ID")
 		return
 	}
 
 	if err := h.subscriptionService.UpdateSubscriptionCard(c.Request.Context(), subID, cardID); err != nil {
 		if _, ok := err.(*services.NotFoundError); ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "subscription not found"})
+			respondError(c, http.StatusNotFound, "subscription not found")
+			return
+		}
+		if e, ok := err.(*services.ValidationError); ok {
+			respondFieldErrors(c, http.StatusBadRequest, gin.H{e.Field: e.Error()})
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -195,3 +377,152 @@ func (h *SubscriptionHandler) UpdateSubscriptionCard(c *gin.Context) {
 		"message": "Subscription card updated successfully",
 	})
 }
+
+// UpdateSubscriptionMetadataRequest represents a metadata update. Keys set
+// to null are removed; keys left out are kept as they are.
+type UpdateSubscriptionMetadataRequest struct {
+	Metadata map[string]*string `json:"metadata" binding:"required"`
+}
+
+// UpdateSubscriptionMetadata merges keys into a subscription's metadata, e.g.
+// {"metadata": {"external_id": "A-123", "old_ref": null}}
+func (h *SubscriptionHandler) UpdateSubscriptionMetadata(c *gin.Context) {
+	subID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid subscription ID")
+		return
+	}
+
+	var req UpdateSubscriptionMetadataRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	h.updateMetadata(c, subID, req.Metadata)
+}
+
+// DeleteSubscriptionMetadataKey removes a single key from a subscription's metadata
+func (h *SubscriptionHandler) DeleteSubscriptionMetadataKey(c *gin.Context) {
+	subID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid subscription ID")
+		return
+	}
+
+	h.updateMetadata(c, subID, map[string]*string{c.Param("key"): nil})
+}
+
+func (h *SubscriptionHandler) updateMetadata(c *gin.Context, subID uuid.UUID, changes map[string]*string) {
+	subscription, err := h.subscriptionService.UpdateMetadata(c.Request.Context(), subID, changes)
+	if err != nil {
+		switch e := err.(type) {
+		case *services.NotFoundError:
+			respondError(c, http.StatusNotFound, "subscription not found")
+		case *services.ValidationError:
+			respondFieldErrors(c, http.StatusBadRequest, gin.H{e.Field: e.Error()})
+		default:
+			respondError(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, subscription)
+}
+
+// UpdateCardForUserRequest represents a request to move all of a user's
+// subscriptions to a new card
+type UpdateCardForUserRequest struct {
+	CardID string `json:"card_id" binding:"required,uuid4"`
+}
+
+// UpdateCardForUser switches all of a user's active and past-due
+// subscriptions to a new card
+func (h *SubscriptionHandler) UpdateCardForUser(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid user ID")
+		return
+	}
+
+	var req UpdateCardForUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	cardID, err := uuid.Parse(req.CardID)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid card ID")
+		return
+	}
+
+	updated, err := h.subscriptionService.UpdateCardForUser(c.Request.Context(), userID, cardID)
+	if err != nil {
+		if _, ok := err.(*services.NotFoundError); ok {
+			respondError(c, http.StatusNotFound, err.Error())
+			return
+		}
+		if e, ok := err.(*services.ValidationError); ok {
+			respondFieldErrors(c, http.StatusBadRequest, gin.H{e.Field: e.Error()})
+			return
+		}
+		if err.Error() == "card does not belong to user" {
+			respondError(c, http.StatusForbidden, err.Error())
+			return
+		}
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Subscription cards updated successfully",
+		"updated": updated,
+	})
+}
+
+// ChangePlanRequest represents a request to move a subscription to another plan
+type ChangePlanRequest struct {
+	PlanID string `json:"plan_id" binding:"required,uuid4"`
+}
+
+// ChangePlan moves a subscription to another plan, prorating unused time
+func (h *SubscriptionHandler) ChangePlan(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid subscription ID")
+		return
+	}
+
+	var req ChangePlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	planID, err := uuid.Parse(req.PlanID)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid plan ID")
+		return
+	}
+
+	subscription, err := h.subscriptionService.ChangePlan(c.Request.Context(), id, planID)
+	if err != nil {
+		switch e := err.(type) {
+		case *services.ValidationError:
+			respondFieldErrors(c, http.StatusBadRequest, gin.H{e.Field: e.Error()})
+			return
+		case *services.NotFoundError:
+			respondError(c, http.StatusNotFound, e.Error())
+			return
+		case *services.ConflictError:
+			respondError(c, http.StatusConflict, e.Error())
+			return
+		}
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, subscription)
+}
@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"pg-backend/internal/models"
+	"pg-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type TransactionHandler struct {
+	transactionService services.TransactionService
+}
+
+func NewTransactionHandler(transactionService services.TransactionService) *TransactionHandler {
+	return &TransactionHandler{
+		transactionService: transactionService,
+	}
+}
+
+// UpdateTransactionStatusRequest represents a manual status override
+type UpdateTransactionStatusRequest struct {
+	Status    string `json:"status" binding:"required,oneof=succeeded failed"`
+	ChangedBy string `json:"changed_by" binding:"required"`
+	Reason    string `json:"reason" binding:"required"`
+}
+
+// UpdateTransactionStatus lets support correct a transaction's status after reconciliation
+func (h *TransactionHandler) UpdateTransactionStatus(c *gin.Context) {
+	transactionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid transaction ID")
+		return
+	}
+
+	var req UpdateTransactionStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	transaction, err := h.transactionService.UpdateTransactionStatus(
+		c.Request.Context(), transactionID, req.Status, req.ChangedBy, req.Reason,
+	)
+	if err != nil {
+		switch err.(type) {
+		case *services.NotFoundError:
+			respondError(c, http.StatusNotFound, err.Error())
+		case *services.ConflictError:
+			respondError(c, http.StatusConflict, err.Error())
+		default:
+			respondError(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"transaction": transaction,
+	})
+}
+
+// GetGatewayResponse returns the redacted gateway payload recorded for a transaction
+func (h *TransactionHandler) GetGatewayResponse(c *gin.Context) {
+	transactionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid transaction ID")
+		return
+	}
+
+	response, err := h.transactionService.GetGatewayResponse(c.Request.Context(), transactionID)
+	if err != nil {
+		if _, ok := err.(*services.NotFoundError); ok {
+			respondError(c, http.StatusNotFound, err.Error())
+			return
+		}
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"transaction_id":   transactionID,
+		"gateway_response": response,
+	})
+}
+
+// GetTimeline returns the chronological lifecycle of a payment: the
+// authorization or charge, captures, voids, refunds and disputes
+func (h *TransactionHandler) GetTimeline(c *gin.Context) {
+	transactionID, err := uuid.Parse(c.Param("transaction_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid transaction ID")
+		return
+	}
+
+	timeline, err := h.transactionService.GetTimeline(c.Request.Context(), transactionID)
+	if err != nil {
+		if _, ok := err.(*services.NotFoundError); ok {
+			respondError(c, http.StatusNotFound, err.Error())
+			return
+		}
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"transaction_id": transactionID,
+		"timeline":       timeline,
+	})
+}
+
+// GetRefundableTransactions lists a user's payments and captures that can
+// still be refunded, with the amount left to refund on each
+func (h *TransactionHandler) GetRefundableTransactions(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid user ID")
+		return
+	}
+
+	transactions, err := h.transactionService.GetRefundableTransactions(c.Request.Context(), userID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":      userID,
+		"transactions": transactions,
+	})
+}
+
+// GetPaymentMethodVolume breaks a user's successful charges down by payment
+// method and currency, e.g. for a dashboard comparing card and wallet volume
+func (h *TransactionHandler) GetPaymentMethodVolume(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid user ID")
+		return
+	}
+
+	volumes, err := h.transactionService.GetPaymentMethodVolume(c.Request.Context(), userID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":         userID,
+		"payment_methods": volumes,
+	})
+}
+
+// transactionExportHeader names the columns of a transaction CSV export
+var transactionExportHeader = []string{
+	"id", "created_at", "type", "status", "amount", "currency", "card",
+	"gateway_transaction_id", "gateway_order_id", "merchant_reference", "invoice_id",
+	"settlement_amount", "settlement_currency",
+}
+
+// ExportTransactions streams a user's transactions as CSV for accounting,
+// e.g. GET /users/:user_id/transactions/export?format=csv&from=2024-01-01&to=2024-01-31.
+// from and to take a date or RFC 3339 timestamp; a plain to date includes that
+// whole day. Card numbers are masked to the last four digits.
+func (h *TransactionHandler) ExportTransactions(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid user ID")
+		return
+	}
+
+	if format := c.DefaultQuery("format", "csv"); format != "csv" {
+		respondFieldErrors(c, http.StatusBadRequest, gin.H{"format": "must be csv"})
+		return
+	}
+
+	from, err := parseExportBound(c.Query("from"), false)
+	if err != nil {
+		respondFieldErrors(c, http.StatusBadRequest, gin.H{"from": "must be a date (YYYY-MM-DD) or RFC 3339 timestamp"})
+		return
+	}
+	to, err := parseExportBound(c.Query("to"), true)
+	if err != nil {
+		respondFieldErrors(c, http.StatusBadRequest, gin.H{"to": "must be a date (YYYY-MM-DD) or RFC 3339 timestamp"})
+		return
+	}
+
+	// Headers go out with the first batch, so errors found before anything
+	// is written can still be reported as JSON
+	writer := csv.NewWriter(c.Writer)
+	started := false
+	start := func() error {
+		started = true
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="transactions-%s.csv"`, userID))
+		c.Status(http.StatusOK)
+		return writer.Write(transactionExportHeader)
+	}
+
+	err = h.transactionService.ExportTransactions(c.Request.Context(), userID, from, to, func(batch []models.ExportedTransaction) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		for i := range batch {
+			if err := writer.Write(transactionExportRecord(&batch[i])); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		c.Writer.Flush()
+		return writer.Error()
+	})
+	if err != nil {
+		if started {
+			// Too late to change the response; the client gets a truncated file
+			fmt.Printf("Warning: Transaction export for user %s stopped early: %v\n", userID, err)
+			return
+		}
+		if e, ok := err.(*services.ValidationError); ok {
+			respondFieldErrors(c, http.StatusBadRequest, gin.H{e.Field: e.Error()})
+			return
+		}
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !started {
+		if err := start(); err != nil {
+			fmt.Printf("Warning: Transaction export for user %s failed: %v\n", userID, err)
+			return
+		}
+		writer.Flush()
+	}
+}
+
+// transactionExportRecord formats a transaction as a row under
+// transactionExportHeader
+func transactionExportRecord(t *models.ExportedTransaction) []string {
+	settlementAmount := ""
+	if t.SettlementCurrency != "" {
+		settlementAmount = strconv.FormatFloat(t.SettlementAmount, 'f', 2, 64)
+	}
+	return []string{
+		t.ID.String(),
+		t.CreatedAt.UTC().Format(time.RFC3339),
+		string(t.Type),
+		t.Status,
+		strconv.FormatFloat(t.Amount, 'f', 2, 64),
+		t.Currency,
+		t.MaskedCard(),
+		t.GatewayTransactionID,
+		t.GatewayOrderID,
+		t.MerchantReference,
+		t.InvoiceID.String,
+		settlementAmount,
+		t.SettlementCurrency,
+	}
+}
+
+// parseExportBound parses an export's from or to bound; empty means
+// unbounded. A plain date used as the upper bound covers the whole day.
+func parseExportBound(value string, upper bool) (sql.NullTime, error) {
+	if value == "" {
+		return sql.NullTime{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		if upper {
+			t = t.AddDate(0, 0, 1)
+		}
+		return sql.NullTime{Time: t, Valid: true}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return sql.NullTime{}, err
+	}
+	return sql.NullTime{Time: t, Valid: true}, nil
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"pg-backend/internal/config"
+	"pg-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// notificationSecretHeader carries the merchant's notification secret on
+// gateway webhooks
+const notificationSecretHeader = "X-Notification-Secret"
+
+// notificationTypeDispute identifies chargeback notifications
+const notificationTypeDispute = "DISPUTE"
+
+type WebhookHandler struct {
+	disputeService services.DisputeService
+	cfg            *config.Config
+}
+
+func NewWebhookHandler(disputeService services.DisputeService, cfg *config.Config) *WebhookHandler {
+	return &WebhookHandler{
+		disputeService: disputeService,
+		cfg:            cfg,
+	}
+}
+
+// GatewayNotification is the body of a gateway webhook
+type GatewayNotification struct {
+	NotificationType string `json:"notificationType" binding:"required"`
+	Order            struct {
+		ID string `json:"id"`
+	} `json:"order"`
+	Dispute *GatewayDispute `json:"dispute"`
+}
+
+// GatewayDispute describes a chargeback in a dispute notification
+type GatewayDispute struct {
+	ID              string  `json:"id" binding:"required"`
+	Reason          string  `json:"reason"`
+	Status          string  `json:"status" binding:"required"`
+	Amount          float64 `json:"amount"`
+	Currency        string  `json:"currency"`
+	EvidenceDueDate string  `json:"evidenceDueDate"`
+}
+
+// HandleGatewayNotification receives gateway webhooks. Dispute notifications
+// create or update the dispute; other notification types are acknowledged
+// and ignored.
+func (h *WebhookHandler) HandleGatewayNotification(c *gin.Context) {
+	secret := h.cfg.WebhookSecret()
+	provided := c.GetHeader(notificationSecretHeader)
+	if secret == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(secret)) != 1 {
+		respondError(c, http.StatusUnauthorized, "invalid notification secret")
+		return
+	}
+
+	var req GatewayNotification
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	if !strings.EqualFold(req.NotificationType, notificationTypeDispute) {
+		c.JSON(http.StatusOK, gin.H{"received": true, "ignored": true})
+		return
+	}
+	if req.Dispute == nil || req.Order.ID == "" {
+		respondError(c, http.StatusBadRequest, "dispute notification requires order.id and dispute")
+		return
+	}
+
+	evidenceDue, err := parseEvidenceDueDate(req.Dispute.EvidenceDueDate)
+	if err != nil {
+		respondFieldErrors(c, http.StatusBadRequest, gin.H{"dispute.evidenceDueDate": "must be a date (YYYY-MM-DD) or RFC 3339 timestamp"})
+		return
+	}
+
+	dispute, err := h.disputeService.HandleDisputeNotification(c.Request.Context(), &services.DisputeNotification{
+		GatewayDisputeID: req.Dispute.ID,
+		GatewayOrderID:   req.Order.ID,
+		Reason:           req.Dispute.Reason,
+		Status:           strings.ToLower(req.Dispute.Status),
+		Amount:           req.Dispute.Amount,
+		Currency:         strings.ToUpper(req.Dispute.Currency),
+		EvidenceDue:      evidenceDue,
+	})
+	if err != nil {
+		switch err.(type) {
+		case *services.ValidationError:
+			respondError(c, http.StatusBadRequest, err.Error())
+		case *services.NotFoundError:
+			respondError(c, http.StatusNotFound, err.Error())
+		default:
+			respondError(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"received": true,
+		"dispute":  dispute,
+	})
+}
+
+// parseEvidenceDueDate accepts a plain date or a full timestamp; empty means
+// no deadline was given
+func parseEvidenceDueDate(value string) (sql.NullTime, error) {
+	if value == "" {
+		return sql.NullTime{}, nil
+	}
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return sql.NullTime{Time: t, Valid: true}, nil
+		}
+	}
+	return sql.NullTime{}, fmt.Errorf("invalid evidence due date %q", value)
+}
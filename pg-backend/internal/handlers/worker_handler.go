@@ -1,9 +1,12 @@
 package handlers
 
 import (
+	"context"
 	"net/http"
 	"time"
 
+	"pg-backend/internal/config"
+	"pg-backend/internal/models"
 	"pg-backend/internal/worker"
 
 	"github.com/gin-gonic/gin"
@@ -11,11 +14,13 @@ import (
 
 type WorkerHandler struct {
 	workerManager *worker.WorkerManager
+	cfg           *config.Config
 }
 
-func NewWorkerHandler(workerManager *worker.WorkerManager) *WorkerHandler {
+func NewWorkerHandler(workerManager *worker.WorkerManager, cfg *config.Config) *WorkerHandler {
 	return &WorkerHandler{
 		workerManager: workerManager,
+		cfg:           cfg,
 	}
 }
 
@@ -31,16 +36,11 @@ func (h *WorkerHandler) GetWorkerStatus(c *gin.Context) {
 
 // RestartWorkers restarts all workers (admin only)
 func (h *WorkerHandler) RestartWorkers(c *gin.Context) {
-	// In production, add authentication here
-	h.workerManager.StopAll()
-
-	// Wait a moment
-	time.Sleep(1 * time.Second)
-
-	// Restart
-	if err := h.workerManager.StartAll(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to restart workers",
+	status, err := h.workerManager.RestartWorkers()
+	if err != nil {
+		respondErrorDetails(c, http.StatusInternalServerError, models.ErrorCodeInternal, "Failed to restart workers", gin.H{
+			"cause":   err.Error(),
+			"workers": status,
 		})
 		return
 	}
@@ -48,5 +48,71 @@ func (h *WorkerHandler) RestartWorkers(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "Workers restarted successfully",
+		"workers": status,
+	})
+}
+
+// PauseWorker makes the named worker skip billing cycles until resumed (admin only)
+func (h *WorkerHandler) PauseWorker(c *gin.Context) {
+	w, ok := h.workerManager.GetWorker(c.Param("name"))
+	if !ok {
+		respondError(c, http.StatusNotFound, "Worker not found")
+		return
+	}
+
+	w.Pause()
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Worker paused",
+		"worker":  w.HealthCheck(),
+	})
+}
+
+// ResumeWorker re-enables billing cycles for the named worker (admin only)
+func (h *WorkerHandler) ResumeWorker(c *gin.Context) {
+	w, ok := h.workerManager.GetWorker(c.Param("name"))
+	if !ok {
+		respondError(c, http.StatusNotFound, "Worker not found")
+		return
+	}
+
+	w.Resume()
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Worker resumed",
+		"worker":  w.HealthCheck(),
+	})
+}
+
+// RunBillingCycle runs one billing cycle immediately, for testing and incident
+// recovery (admin only)
+func (h *WorkerHandler) RunBillingCycle(c *gin.Context) {
+	w, ok := h.workerManager.GetWorker("billing")
+	if !ok {
+		respondError(c, http.StatusNotFound, "Worker not found")
+		return
+	}
+
+	// Detached from the request so a client disconnect doesn't abandon the
+	// cycle half way through
+	ctx, cancel := context.WithTimeout(context.Background(), h.cfg.BillingCycleTimeout())
+	defer cancel()
+
+	result, err := w.RunCycle(ctx)
+	if err != nil {
+		if err == worker.ErrCycleInProgress {
+			respondError(c, http.StatusConflict, err.Error())
+			return
+		}
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": len(result.Errors) == 0,
+		"message": "Billing cycle completed",
+		"result":  result,
 	})
 }
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"pg-backend/internal/config"
+	"pg-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminKeyHeader carries the admin API key on requests to admin endpoints
+const AdminKeyHeader = "X-Admin-Key"
+
+// RequireAdmin restricts a route group to callers presenting the configured
+// admin API key. With no key configured, all requests are refused.
+func RequireAdmin(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		expected := cfg.AdminAPIKey()
+		if expected == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, models.NewErrorResponse(models.ErrorCodeForbidden, "admin access is not configured", nil))
+			return
+		}
+
+		provided := c.GetHeader(AdminKeyHeader)
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(expected)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, models.NewErrorResponse(models.ErrorCodeUnauthorized, "admin credentials required", nil))
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"net/http"
+
+	"pg-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireFeature refuses every request to a route group while the named
+// feature is turned off in config, answering 404 with the feature_disabled
+// error code rather than Gin's plain-text 404
+func RequireFeature(feature string, enabled bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !enabled {
+			c.AbortWithStatusJSON(http.StatusNotFound, models.NewErrorResponse(models.ErrorCodeFeatureDisabled,
+				feature+" is not enabled", map[string]interface{}{"feature": feature}))
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"pg-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequireFeature(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for _, enabled := range []bool{true, false} {
+		router := gin.New()
+		applePay := router.Group("", RequireFeature("apple_pay", enabled))
+		applePay.GET("/users/:user_id/apple-pay-cards", func(c *gin.Context) {
+			c.JSON(http.StatusOK, gin.H{"cards": []string{}})
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/42/apple-pay-cards", nil))
+
+		if enabled {
+			if w.Code != http.StatusOK {
+				t.Errorf("enabled feature: status = %d, want 200", w.Code)
+			}
+			continue
+		}
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("disabled feature: status = %d, want 404", w.Code)
+		}
+		var response models.ErrorResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("disabled feature: response is not an error envelope: %v: %s", err, w.Body)
+		}
+		if response.Error.Code != models.ErrorCodeFeatureDisabled || response.Error.Details["feature"] != "apple_pay" {
+			t.Errorf("disabled feature: error = %+v, want feature_disabled for apple_pay", response.Error)
+		}
+	}
+}
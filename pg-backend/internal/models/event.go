@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event types published for downstream consumers (webhooks, reporting)
+const (
+	EventTransactionStatusChanged = "transaction.status_changed"
+	EventDisputeCreated           = "dispute.created"
+	EventDisputeUpdated           = "dispute.updated"
+
+	EventSubscriptionStatusChanged = "subscription.status_changed"
+	EventSubscriptionTrialEnded    = "subscription.trial_ended"
+
+	// A trial ended without a card on file; the customer needs to add one
+	EventSubscriptionPaymentMethodRequired = "subscription.payment_method_required"
+
+	// A long-interval subscription renews soon
+	EventSubscriptionRenewalReminder = "subscription.renewal_reminder"
+
+	// A recurring charge is waiting on the customer to complete 3-D Secure
+	EventBillingAttemptRequiresAction = "billing_attempt.requires_action"
+)
+
+// Event is a domain event recorded when important state changes
+type Event struct {
+	ID           uuid.UUID              `json:"id"`
+	Type         string                 `json:"type"`
+	ResourceType string                 `json:"resource_type"` // "transaction", "subscription", ...
+	ResourceID   uuid.UUID              `json:"resource_id"`
+	Data         map[string]interface{} `json:"data,omitempty"`
+	CreatedAt    time.Time              `json:"created_at"`
+}
@@ -2,6 +2,8 @@ package models
 
 import (
 	"database/sql"
+	"encoding/json"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -29,41 +31,352 @@ type Card struct {
 	DevicePaymentData map[string]interface{} `json:"device_payment_data,omitempty"`
 	GooglePayToken    string                 `json:"google_pay_token,omitempty"`
 
+	// Scheme reference of the cardholder-initiated transaction that stored the
+	// card, quoted on later credential-on-file charges
+	StoredCredentialReference string `json:"-"`
+
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// CardExpiresSoonWindow is how far ahead a card counts as expiring soon
+const CardExpiresSoonWindow = 30 * 24 * time.Hour
+
+// CardExpiryStatus is derived from a card's expiry month and year
+type CardExpiryStatus struct {
+	IsExpired   bool `json:"is_expired"`
+	ExpiresSoon bool `json:"expires_soon"`
+}
+
+// ExpiryStatus reports whether the card has expired or will expire within
+// CardExpiresSoonWindow. Cards are valid until the end of their expiry month.
+func (c *Card) ExpiryStatus() CardExpiryStatus {
+	return c.expiryStatusAt(time.Now())
+}
+
+func (c *Card) expiryStatusAt(now time.Time) CardExpiryStatus {
+	// First instant after the expiry month, in the caller's location
+	expiresAt := time.Date(c.ExpiryYear, time.Month(c.ExpiryMonth)+1, 1, 0, 0, 0, 0, now.Location())
+
+	return CardExpiryStatus{
+		IsExpired:   !now.Before(expiresAt),
+		ExpiresSoon: now.Before(expiresAt) && expiresAt.Sub(now) <= CardExpiresSoonWindow,
+	}
+}
+
 type Transaction struct {
-	ID                   uuid.UUID      `json:"id"`
-	UserID               uuid.UUID      `json:"user_id"`
-	CardID               uuid.UUID      `json:"card_id"`
-	SubscriptionID       uuid.NullUUID  `json:"subscription_id,omitempty"`
-	BillingAttemptID     uuid.NullUUID  `json:"billing_attempt_id,omitempty"`
-	InvoiceID            sql.NullString `json:"invoice_id,omitempty"`
-	Amount               float64        `json:"amount"`
-	Currency             string         `json:"currency"`
-	Status               string         `json:"status"`
-	GatewayTransactionID string         `json:"gateway_transaction_id"`
-	Type                 string         `json:"type"` // "manual", "recurring", "authorization", "capture", "void", "refund"
+	ID                   uuid.UUID       `json:"id"`
+	UserID               uuid.UUID       `json:"user_id"`
+	CardID               uuid.UUID       `json:"card_id"`
+	SubscriptionID       uuid.NullUUID   `json:"subscription_id,omitempty"`
+	BillingAttemptID     uuid.NullUUID   `json:"billing_attempt_id,omitempty"`
+	InvoiceID            sql.NullString  `json:"invoice_id,omitempty"`
+	Amount               float64         `json:"amount"`
+	Currency             string          `json:"currency"`
+	Status               string          `json:"status"`
+	GatewayTransactionID string          `json:"gateway_transaction_id"`
+	Type                 TransactionType `json:"type"`
 
 	// NEW FIELDS for Google Pay:
 	WalletProvider    string                 `json:"wallet_provider,omitempty"`     // "GOOGLE_PAY"
 	PaymentMethodType string                 `json:"payment_method_type,omitempty"` // "card", "google_pay"
 	DevicePaymentData map[string]interface{} `json:"device_payment_data,omitempty"`
 
+	// Merchant's own order number, sent to the gateway as order.reference
+	MerchantReference string `json:"merchant_reference,omitempty"`
+
+	// Authorization a capture or void was made against
+	ParentTransactionID uuid.NullUUID `json:"parent_transaction_id,omitempty"`
+
+	// Gateway order the transaction belongs to; captures, voids and refunds
+	// share the order of the original authorization or payment
+	GatewayOrderID string `json:"gateway_order_id,omitempty"`
+
+	// Redacted gateway payload for the operation that created the transaction.
+	// Only written on insert; read it with GetGatewayResponse.
+	GatewayResponse json.RawMessage `json:"-"`
+
+	// Set when a charge presented to the customer in Currency settles in
+	// another currency: the settled amount and the exchange rate applied
+	SettlementCurrency string  `json:"settlement_currency,omitempty"`
+	SettlementAmount   float64 `json:"settlement_amount,omitempty"`
+	FXRate             float64 `json:"fx_rate,omitempty"`
+
+	// Marketplace route chosen from the charge's metadata, and the statement
+	// descriptor it applied
+	RoutingKey          string `json:"routing_key,omitempty"`
+	StatementDescriptor string `json:"statement_descriptor,omitempty"`
+
+	// Correlation ID sent with the gateway request that created the
+	// transaction, and the gateway's own trace ID if it returned one. Quote
+	// both on gateway support cases.
+	CorrelationID        string `json:"correlation_id,omitempty"`
+	GatewayCorrelationID string `json:"gateway_correlation_id,omitempty"`
+
 	CreatedAt time.Time `json:"created_at"`
 }
 
-type Plan struct {
-	ID              uuid.UUID `json:"id"`
-	Name            string    `json:"name"`
-	Amount          float64   `json:"amount"`
+// Transaction statuses that support may set when reconciling a transaction
+const (
+	TransactionStatusPending   = "pending"
+	TransactionStatusSucceeded = "succeeded"
+	TransactionStatusFailed    = "failed"
+)
+
+// TransactionStatusDisputed marks a charge the cardholder's issuer has raised
+// a chargeback against. It is set from gateway dispute notifications only.
+const TransactionStatusDisputed = "disputed"
+
+// gatewayTransactionStatuses maps the statuses the gateway reports, which
+// are stored on transactions as received, to the statuses above
+var gatewayTransactionStatuses = map[string]string{
+	"APPROVED":                    TransactionStatusSucceeded,
+	"APPROVED_AUTO":               TransactionStatusSucceeded,
+	"APPROVED_PENDING_SETTLEMENT": TransactionStatusSucceeded,
+	"AUTHORIZED":                  TransactionStatusSucceeded,
+	"CAPTURED":                    TransactionStatusSucceeded,
+	"PARTIALLY_CAPTURED":          TransactionStatusSucceeded,
+	"VERIFIED":                    TransactionStatusSucceeded,
+	"DECLINED":                    TransactionStatusFailed,
+	"FAILED":                      TransactionStatusFailed,
+	"CANCELLED":                   TransactionStatusFailed,
+	"PENDING":                     TransactionStatusPending,
+	"INITIATED":                   TransactionStatusPending,
+	"SUBMITTED":                   TransactionStatusPending,
+	"UNKNOWN":                     TransactionStatusPending,
+	"DISPUTED":                    TransactionStatusDisputed,
+}
+
+// NormalizeTransactionStatus returns the status a stored transaction status
+// stands for, e.g. succeeded for the gateway's APPROVED or CAPTURED. Statuses
+// it doesn't know are returned lower-cased.
+func NormalizeTransactionStatus(status string) string {
+	status = strings.TrimSpace(status)
+	if normalized, ok := gatewayTransactionStatuses[strings.ToUpper(status)]; ok {
+		return normalized
+	}
+	if status == "" {
+		return TransactionStatusPending
+	}
+	return strings.ToLower(status)
+}
+
+// transactionStatusTransitions lists the manual status changes that are
+// allowed. Besides settling pending transactions, support may correct a
+// recorded outcome that reconciliation with the gateway shows to be wrong.
+var transactionStatusTransitions = map[string][]string{
+	TransactionStatusPending:   {TransactionStatusSucceeded, TransactionStatusFailed},
+	TransactionStatusSucceeded: {TransactionStatusFailed},
+	TransactionStatusFailed:    {TransactionStatusSucceeded},
+}
+
+// CanTransitionTransactionStatus reports whether a transaction may be moved
+// manually from one status to another. from may be a gateway status as
+// stored on the transaction.
+func CanTransitionTransactionStatus(from, to string) bool {
+	for _, allowed := range transactionStatusTransitions[NormalizeTransactionStatus(from)] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// TransactionType says what kind of operation created a transaction
+type TransactionType string
+
+const (
+	TransactionTypeManual    TransactionType = "manual"    // one-off charge
+	TransactionTypeRecurring TransactionType = "recurring" // subscription charge
+	TransactionTypeRefund    TransactionType = "refund"
+	TransactionTypeTest      TransactionType = "test" // simulated wallet payment
+	TransactionTypeSetupFee  TransactionType = "setup_fee"
+	TransactionTypeProration TransactionType = "proration"
+
+	// Authorization flow
+	TransactionTypeAuthorization TransactionType = "authorization"
+	TransactionTypeCapture       TransactionType = "capture"
+	TransactionTypeVoid          TransactionType = "void"
+
+	// TransactionTypeCredit marks account credit used towards a subscription
+	// charge. Its amount is negative: it reduces what the customer paid.
+	TransactionTypeCredit TransactionType = "credit"
+)
+
+var transactionTypes = []TransactionType{
+	TransactionTypeManual,
+	TransactionTypeRecurring,
+	TransactionTypeRefund,
+	TransactionTypeTest,
+	TransactionTypeSetupFee,
+	TransactionTypeProration,
+	TransactionTypeAuthorization,
+	TransactionTypeCapture,
+	TransactionTypeVoid,
+	TransactionTypeCredit,
+}
+
+// Valid reports whether t is one of the known transaction types
+func (t TransactionType) Valid() bool {
+	for _, known := range transactionTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Credit ledger entry types
+const (
+	CreditEntryTypeCredit  = "credit"  // credit granted to the user
+	CreditEntryTypeApplied = "applied" // credit used against a billing attempt
+)
+
+// CreditEntry is one line of a user's credit ledger. Granted credit is
+// positive and applied credit negative, so the balance is the sum of entries
+// that have not been released.
+type CreditEntry struct {
+	ID               uuid.UUID     `json:"id"`
+	UserID           uuid.UUID     `json:"user_id"`
+	Amount           float64       `json:"amount"`
+	Currency         string        `json:"currency"`
+	Type             string        `json:"type"`
+	Description      string        `json:"description,omitempty"`
+	SubscriptionID   uuid.NullUUID `json:"subscription_id,omitempty"`
+	BillingAttemptID uuid.NullUUID `json:"billing_attempt_id,omitempty"`
+	ReleasedAt       sql.NullTime  `json:"released_at,omitempty"`
+	CreatedAt        time.Time     `json:"created_at"`
+}
+
+// CreditBalance is a user's available credit in one currency
+type CreditBalance struct {
+	Currency string  `json:"currency"`
+	Balance  float64 `json:"balance"`
+}
+
+// Dispute statuses
+const (
+	DisputeStatusOpen        = "open"         // chargeback raised, evidence not yet submitted
+	DisputeStatusUnderReview = "under_review" // evidence submitted, awaiting the issuer
+	DisputeStatusWon         = "won"
+	DisputeStatusLost        = "lost"
+)
+
+// IsValidDisputeStatus reports whether status is a known dispute status
+func IsValidDisputeStatus(status string) bool {
+	switch status {
+	case DisputeStatusOpen, DisputeStatusUnderReview, DisputeStatusWon, DisputeStatusLost:
+		return true
+	}
+	return false
+}
+
+// Dispute is a chargeback raised by the cardholder's issuer against a transaction
+type Dispute struct {
+	ID               uuid.UUID    `json:"id"`
+	TransactionID    uuid.UUID    `json:"transaction_id"`
+	GatewayDisputeID string       `json:"gateway_dispute_id"`
+	Reason           string       `json:"reason"`
+	Status           string       `json:"status"`
+	Amount           float64      `json:"amount"`
+	Currency         string       `json:"currency"`
+	EvidenceDue      sql.NullTime `json:"evidence_due,omitempty"`
+	CreatedAt        time.Time    `json:"created_at"`
+	UpdatedAt        time.Time    `json:"updated_at"`
+}
+
+// AuthorizationBalance summarises how much of an authorization has been
+// captured and how much can still be captured
+type AuthorizationBalance struct {
+	AuthorizationID uuid.UUID `json:"authorization_id"`
+	OrderID         string    `json:"order_id"`
+	Authorized      float64   `json:"authorized_amount"`
+	Captured        float64   `json:"captured_amount"`
+	Remaining       float64   `json:"remaining_amount"`
+	Captures        int       `json:"captures"`
+	Voided          bool      `json:"voided"`
 	Currency        string    `json:"currency"`
-	Interval        string    `json:"interval"` // "day", "week", "month", "year"
-	TrialPeriodDays int       `json:"trial_period_days"`
-	Description     string    `json:"description"`
-	IsActive        bool      `json:"is_active"`
-	CreatedAt       time.Time `json:"created_at"`
-	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// RefundableTransaction is a payment or capture that can still be refunded,
+// with how much of it has been refunded already
+type RefundableTransaction struct {
+	Transaction
+	RefundedAmount   float64 `json:"refunded_amount"`
+	RefundableAmount float64 `json:"refundable_amount"`
+}
+
+// ExportedTransaction is a transaction as written to an accounting export,
+// with the scheme and last four digits of the card it was made on
+type ExportedTransaction struct {
+	Transaction
+	CardScheme   string
+	CardLastFour string
+}
+
+// MaskedCard renders the card as e.g. "VISA **** 4242", or "" when the
+// transaction has no saved card
+func (t *ExportedTransaction) MaskedCard() string {
+	if t.CardLastFour == "" {
+		return ""
+	}
+	masked := "**** " + t.CardLastFour
+	if t.CardScheme != "" {
+		masked = t.CardScheme + " " + masked
+	}
+	return masked
+}
+
+// PaymentMethodVolume is the number and total amount of a user's successful
+// charges made with one payment method in one currency
+type PaymentMethodVolume struct {
+	PaymentMethodType string  `json:"payment_method_type"`       // "card", "google_pay", "apple_pay"
+	WalletProvider    string  `json:"wallet_provider,omitempty"` // "GOOGLE_PAY", "APPLE_PAY"
+	Currency          string  `json:"currency"`
+	Count             int     `json:"count"`
+	Amount            float64 `json:"amount"`
+}
+
+// TimelineEvent is one step in the lifecycle of a payment: the authorization
+// or charge, its captures, voids and refunds, and any disputes raised on it
+type TimelineEvent struct {
+	Type                 string     `json:"type"`
+	OccurredAt           time.Time  `json:"occurred_at"`
+	TransactionID        uuid.UUID  `json:"transaction_id"`
+	ParentTransactionID  *uuid.UUID `json:"parent_transaction_id,omitempty"`
+	DisputeID            *uuid.UUID `json:"dispute_id,omitempty"`
+	GatewayTransactionID string     `json:"gateway_transaction_id,omitempty"`
+	Amount               float64    `json:"amount"`
+	Currency             string     `json:"currency"`
+	Status               string     `json:"status"`
+	Reason               string     `json:"reason,omitempty"`
+}
+
+// TransactionStatusAudit records a manual change to a transaction's status
+type TransactionStatusAudit struct {
+	ID            uuid.UUID `json:"id"`
+	TransactionID uuid.UUID `json:"transaction_id"`
+	FromStatus    string    `json:"from_status"`
+	ToStatus      string    `json:"to_status"`
+	ChangedBy     string    `json:"changed_by"`
+	Reason        string    `json:"reason"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type Plan struct {
+	ID                  uuid.UUID `json:"id"`
+	Name                string    `json:"name"`
+	Amount              float64   `json:"amount"`
+	Currency            string    `json:"currency"`
+	Interval            string    `json:"interval"` // "day", "week", "month", "year"
+	TrialPeriodDays     int       `json:"trial_period_days"`
+	Description         string    `json:"description"`
+	StatementDescriptor string    `json:"statement_descriptor,omitempty"` // empty uses the merchant default
+	IsActive            bool      `json:"is_active"`
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+
+	// Merchant-defined data such as feature flags or tier codes
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
 type SubscriptionStatus string
@@ -107,9 +420,60 @@ type Subscription struct {
 	Metadata           map[string]string    `json:"metadata,omitempty"`
 	BillingCycleAnchor sql.NullTime         `json:"billing_cycle_anchor,omitempty"`
 	NextBillingAt      time.Time            `json:"next_billing_at"`
-	CreatedAt          time.Time            `json:"created_at"`
+
+	// Scheme trace ID of the first successful charge. Sent with later
+	// merchant-initiated charges so the issuer can link them to the customer's
+	// original consent.
+	InitialTraceID string `json:"-"`
+
+	// Why the customer cancelled, for churn reporting
+	CancellationReason  CancellationReason `json:"cancellation_reason,omitempty"`
+	CancellationComment string             `json:"cancellation_comment,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UpcomingCharge is a subscription charge expected within a forecast window.
+// Credit is the account credit expected to be applied, and ProjectedAmount
+// what is left to charge to the card.
+type UpcomingCharge struct {
+	SubscriptionID  uuid.UUID `json:"subscription_id"`
+	UserID          uuid.UUID `json:"user_id"`
+	PlanName        string    `json:"plan_name"`
+	Amount          float64   `json:"amount"`
+	Credit          float64   `json:"credit"`
+	ProjectedAmount float64   `json:"projected_amount"`
+	Currency        string    `json:"currency"`
+	NextBillingAt   time.Time `json:"next_billing_at"`
+}
+
+// UpcomingBillingPreview forecasts the subscription charges due before Until
+type UpcomingBillingPreview struct {
+	Until            time.Time          `json:"until"`
+	Charges          []UpcomingCharge   `json:"charges"`
+	TotalsByCurrency map[string]float64 `json:"totals_by_currency"`
+}
+
+// SubscriptionStats is the number of subscriptions in each status
+type SubscriptionStats struct {
+	Counts map[SubscriptionStatus]int `json:"counts"`
+	Total  int                        `json:"total"`
 }
 
+// CancellationReason is the customer's chosen reason for cancelling
+type CancellationReason string
+
+const (
+	CancellationReasonTooExpensive    CancellationReason = "too_expensive"
+	CancellationReasonNotUsing        CancellationReason = "not_using"
+	CancellationReasonMissingFeatures CancellationReason = "missing_features"
+	CancellationReasonSwitchedService CancellationReason = "switched_service"
+	CancellationReasonTechnicalIssues CancellationReason = "technical_issues"
+	CancellationReasonCustomerService CancellationReason = "customer_service"
+	CancellationReasonTemporary       CancellationReason = "temporary"
+	CancellationReasonOther           CancellationReason = "other"
+)
+
 // BillingAttemptStatus type for type safety
 type BillingAttemptStatus string
 
@@ -121,6 +485,68 @@ const (
 	BillingAttemptStatusRequiresAction BillingAttemptStatus = "requires_action"
 )
 
+// IsValidBillingAttemptStatus reports whether status is a known billing attempt status
+func IsValidBillingAttemptStatus(status BillingAttemptStatus) bool {
+	switch status {
+	case BillingAttemptStatusPending, BillingAttemptStatusProcessing, BillingAttemptStatusSucceeded,
+		BillingAttemptStatusFailed, BillingAttemptStatusRequiresAction:
+		return true
+	}
+	return false
+}
+
+// CardSummary is the part of a saved card that is safe to show alongside
+// other resources: no gateway or wallet tokens
+type CardSummary struct {
+	ID                uuid.UUID `json:"id"`
+	LastFour          string    `json:"last_four"`
+	ExpiryMonth       int       `json:"expiry_month"`
+	ExpiryYear        int       `json:"expiry_year"`
+	Scheme            string    `json:"scheme"`
+	PaymentMethodType string    `json:"payment_method_type"`
+	WalletProvider    string    `json:"wallet_provider,omitempty"`
+	IsDefault         bool      `json:"is_default"`
+}
+
+// NewCardSummary returns the safe view of card
+func NewCardSummary(card *Card) *CardSummary {
+	return &CardSummary{
+		ID:                card.ID,
+		LastFour:          card.LastFour,
+		ExpiryMonth:       card.ExpiryMonth,
+		ExpiryYear:        card.ExpiryYear,
+		Scheme:            card.Scheme,
+		PaymentMethodType: card.PaymentMethodType,
+		WalletProvider:    card.WalletProvider,
+		IsDefault:         card.IsDefault,
+	}
+}
+
+// ExpandedSubscription is a subscription with its plan and card embedded
+// when they were requested and are still on file
+type ExpandedSubscription struct {
+	Subscription
+	Plan *Plan        `json:"plan,omitempty"`
+	Card *CardSummary `json:"card,omitempty"`
+}
+
+// BillingAttemptSummary is a billing attempt listed across subscriptions,
+// with the owning user and the gateway's error for triaging failures
+type BillingAttemptSummary struct {
+	ID             uuid.UUID            `json:"id"`
+	SubscriptionID uuid.UUID            `json:"subscription_id"`
+	UserID         uuid.UUID            `json:"user_id"`
+	Amount         float64              `json:"amount"`
+	Currency       string               `json:"currency"`
+	Status         BillingAttemptStatus `json:"status"`
+	AttemptNumber  int                  `json:"attempt_number"`
+	ErrorCode      string               `json:"error_code,omitempty"`
+	ErrorMessage   string               `json:"error_message,omitempty"`
+	ScheduledAt    time.Time            `json:"scheduled_at"`
+	ProcessedAt    *time.Time           `json:"processed_at,omitempty"`
+	CreatedAt      time.Time            `json:"created_at"`
+}
+
 // BillingAttempt model (NEW)
 type BillingAttempt struct {
 	ID                   uuid.UUID            `json:"id"`
@@ -134,6 +560,9 @@ type BillingAttempt struct {
 	AttemptNumber        int                  `json:"attempt_number"`
 	ScheduledAt          time.Time            `json:"scheduled_at"`
 	ProcessedAt          sql.NullTime         `json:"processed_at,omitempty"`
+	GatewayOrderID       sql.NullString       `json:"gateway_order_id,omitempty"`      // order submitted to the gateway, used to reconcile unknown outcomes
+	ChallengeReference   sql.NullString       `json:"challenge_reference,omitempty"`   // 3-D Secure authentication the customer must complete to resume the charge
+	ProcessingStartedAt  sql.NullTime         `json:"processing_started_at,omitempty"` // when the attempt last moved to processing
 	CreatedAt            time.Time            `json:"created_at"`
 }
 
@@ -158,6 +587,33 @@ const (
 	PaymentMethodTypeApplePay  = "apple_pay"
 )
 
+// recurringPaymentMethodTypes are the saved payment methods whose gateway
+// token can be charged without the cardholder present. Apple Pay cards keep
+// the wallet payment token (or the card number) rather than a gateway token,
+// so they can only be used for the payment they were saved with.
+var recurringPaymentMethodTypes = map[string]bool{
+	PaymentMethodTypeCard:      true,
+	PaymentMethodTypeGooglePay: true,
+}
+
+// SupportsRecurring reports whether the card can be billed for a
+// subscription: a card or Google Pay card tokenized at the gateway. Wallet
+// cards saved from a simulated payment have no real token and are excluded.
+func (c *Card) SupportsRecurring() bool {
+	if c.GatewayToken == "" {
+		return false
+	}
+	paymentMethodType := c.PaymentMethodType
+	if paymentMethodType == "" {
+		paymentMethodType = PaymentMethodTypeCard
+	}
+	if !recurringPaymentMethodTypes[paymentMethodType] {
+		return false
+	}
+	simulated, _ := c.DevicePaymentData["is_simulated"].(bool)
+	return !simulated
+}
+
 // Add to WalletProvider constants
 const (
 	WalletProviderGooglePay = "GOOGLE_PAY"
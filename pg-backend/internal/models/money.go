@@ -0,0 +1,126 @@
+package models
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount of a currency as returned by the API:
+//
+//	{"amount_minor": 1050, "amount": "10.50", "currency": "USD"}
+//
+// amount_minor is in the currency's smallest unit and amount is always
+// written with the currency's number of decimals, so clients never have to
+// guess whether "10" means 10.00.
+type Money struct {
+	AmountMinor int64
+	Currency    string
+}
+
+// NewMoney rounds amount to the currency's smallest unit. It returns nil when
+// there is no currency, so empty amounts drop out of responses.
+func NewMoney(amount float64, currency string) *Money {
+	if currency == "" {
+		return nil
+	}
+	currency = strings.ToUpper(currency)
+	scale := math.Pow10(CurrencyDecimalPlaces(currency))
+	return &Money{AmountMinor: int64(math.Round(amount * scale)), Currency: currency}
+}
+
+// Amount returns the amount as a decimal string with the currency's number of
+// decimals, e.g. "10.50" USD, "1000" JPY or "1.250" KWD
+func (m Money) Amount() string {
+	decimals := CurrencyDecimalPlaces(m.Currency)
+	return strconv.FormatFloat(float64(m.AmountMinor)/math.Pow10(decimals), 'f', decimals, 64)
+}
+
+// MarshalJSON writes the amount in minor units, as a decimal string and with
+// its currency
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		AmountMinor int64  `json:"amount_minor"`
+		Amount      string `json:"amount"`
+		Currency    string `json:"currency"`
+	}{m.AmountMinor, m.Amount(), m.Currency})
+}
+
+// transactionFields and subscriptionFields have the fields of Transaction and
+// Subscription without their MarshalJSON methods
+type (
+	transactionFields  Transaction
+	subscriptionFields Subscription
+)
+
+// transactionJSON is a Transaction as written to JSON, with its amounts as Money
+type transactionJSON struct {
+	transactionFields
+	Amount           *Money `json:"amount"`
+	SettlementAmount *Money `json:"settlement_amount,omitempty"`
+}
+
+func (t Transaction) toJSON() transactionJSON {
+	return transactionJSON{
+		transactionFields: transactionFields(t),
+		Amount:            NewMoney(t.Amount, t.Currency),
+		SettlementAmount:  NewMoney(t.SettlementAmount, t.SettlementCurrency),
+	}
+}
+
+// MarshalJSON writes the amount and settlement amount as Money
+func (t Transaction) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.toJSON())
+}
+
+// MarshalJSON writes the transaction's amounts, and how much of it has been
+// and can still be refunded, as Money
+func (t RefundableTransaction) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		transactionJSON
+		RefundedAmount   *Money `json:"refunded_amount"`
+		RefundableAmount *Money `json:"refundable_amount"`
+	}{t.Transaction.toJSON(), NewMoney(t.RefundedAmount, t.Currency), NewMoney(t.RefundableAmount, t.Currency)})
+}
+
+// subscriptionJSON is a Subscription as written to JSON, with its amount as Money
+type subscriptionJSON struct {
+	subscriptionFields
+	Amount *Money `json:"amount"`
+}
+
+func (s Subscription) toJSON() subscriptionJSON {
+	return subscriptionJSON{subscriptionFields: subscriptionFields(s), Amount: NewMoney(s.Amount, s.Currency)}
+}
+
+// MarshalJSON writes the amount as Money
+func (s Subscription) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.toJSON())
+}
+
+// MarshalJSON writes the subscription's amount as Money, with the plan and
+// card when they were expanded
+func (s ExpandedSubscription) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		subscriptionJSON
+		Plan *Plan        `json:"plan,omitempty"`
+		Card *CardSummary `json:"card,omitempty"`
+	}{s.Subscription.toJSON(), s.Plan, s.Card})
+}
+
+// currencyDecimals lists ISO 4217 currencies whose minor unit is not cents
+var currencyDecimals = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0,
+	"KRW": 0, "PYG": 0, "RWF": 0, "UGX": 0, "VND": 0, "VUV": 0, "XAF": 0,
+	"XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+// CurrencyDecimalPlaces is how many decimals the currency's minor unit has
+func CurrencyDecimalPlaces(currency string) int {
+	if decimals, ok := currencyDecimals[strings.ToUpper(currency)]; ok {
+		return decimals
+	}
+	return 2
+}
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestMoney(t *testing.T) {
+	tests := []struct {
+		amount      float64
+		currency    string
+		wantMinor   int64
+		wantAmount  string
+		wantEncoded string
+	}{
+		{1000, "jpy", 1000, "1000", `{"amount_minor":1000,"amount":"1000","currency":"JPY"}`},
+		{10.5, "USD", 1050, "10.50", `{"amount_minor":1050,"amount":"10.50","currency":"USD"}`},
+		{0.1 + 0.2, "USD", 30, "0.30", `{"amount_minor":30,"amount":"0.30","currency":"USD"}`},
+		{1.25, "KWD", 1250, "1.250", `{"amount_minor":1250,"amount":"1.250","currency":"KWD"}`},
+	}
+
+	for _, tt := range tests {
+		m := NewMoney(tt.amount, tt.currency)
+		if m.AmountMinor != tt.wantMinor {
+			t.Errorf("NewMoney(%v, %s).AmountMinor = %d, want %d", tt.amount, tt.currency, m.AmountMinor, tt.wantMinor)
+		}
+		if got := m.Amount(); got != tt.wantAmount {
+			t.Errorf("NewMoney(%v, %s).Amount() = %s, want %s", tt.amount, tt.currency, got, tt.wantAmount)
+		}
+		encoded, err := json.Marshal(m)
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		if string(encoded) != tt.wantEncoded {
+			t.Errorf("NewMoney(%v, %s) encoded as %s, want %s", tt.amount, tt.currency, encoded, tt.wantEncoded)
+		}
+	}
+
+	if m := NewMoney(10, ""); m != nil {
+		t.Errorf("NewMoney without a currency = %+v, want nil", m)
+	}
+}
+
+func TestTransactionAmountsAsMoney(t *testing.T) {
+	tx := RefundableTransaction{
+		Transaction: Transaction{
+			ID:                 uuid.New(),
+			Amount:             1.25,
+			Currency:           "KWD",
+			Status:             TransactionStatusSucceeded,
+			SettlementAmount:   4.06,
+			SettlementCurrency: "USD",
+		},
+		RefundedAmount:   0.5,
+		RefundableAmount: 0.75,
+	}
+
+	var got map[string]json.RawMessage
+	encoded, err := json.Marshal(tx)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if err := json.Unmarshal(encoded, &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	want := map[string]string{
+		"amount":            `{"amount_minor":1250,"amount":"1.250","currency":"KWD"}`,
+		"settlement_amount": `{"amount_minor":406,"amount":"4.06","currency":"USD"}`,
+		"refunded_amount":   `{"amount_minor":500,"amount":"0.500","currency":"KWD"}`,
+		"refundable_amount": `{"amount_minor":750,"amount":"0.750","currency":"KWD"}`,
+		"status":            `"succeeded"`,
+	}
+	for field, value := range want {
+		if string(got[field]) != value {
+			t.Errorf("%s = %s, want %s", field, got[field], value)
+		}
+	}
+}
+
+func TestSubscriptionAmountAsMoney(t *testing.T) {
+	sub := ExpandedSubscription{
+		Subscription: Subscription{ID: uuid.New(), PlanName: "Pro", Amount: 1500, Currency: "JPY"},
+		Plan:         &Plan{Name: "Pro"},
+	}
+
+	var got map[string]json.RawMessage
+	encoded, err := json.Marshal(sub)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if err := json.Unmarshal(encoded, &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	if want := `{"amount_minor":1500,"amount":"1500","currency":"JPY"}`; string(got["amount"]) != want {
+		t.Errorf("amount = %s, want %s", got["amount"], want)
+	}
+	if _, ok := got["plan"]; !ok {
+		t.Errorf("expanded subscription %s has no plan", encoded)
+	}
+	if _, ok := got["card"]; ok {
+		t.Errorf("expanded subscription %s has a card it was not given", encoded)
+	}
+}
@@ -0,0 +1,44 @@
+package models
+
+import "testing"
+
+func TestCanTransitionTransactionStatus(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{TransactionStatusPending, TransactionStatusSucceeded, true},
+		{TransactionStatusPending, TransactionStatusFailed, true},
+		{"PENDING", TransactionStatusFailed, true},
+		{"APPROVED", TransactionStatusFailed, true},
+		{"CAPTURED", TransactionStatusFailed, true},
+		{"DECLINED", TransactionStatusSucceeded, true},
+		{"APPROVED", TransactionStatusSucceeded, false},
+		{"DECLINED", TransactionStatusFailed, false},
+		{TransactionStatusDisputed, TransactionStatusSucceeded, false},
+		{"REFUNDED", TransactionStatusFailed, false},
+	}
+
+	for _, tt := range tests {
+		if got := CanTransitionTransactionStatus(tt.from, tt.to); got != tt.want {
+			t.Errorf("CanTransitionTransactionStatus(%q, %q) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeTransactionStatus(t *testing.T) {
+	tests := map[string]string{
+		"APPROVED":                TransactionStatusSucceeded,
+		"captured":                TransactionStatusSucceeded,
+		"DECLINED":                TransactionStatusFailed,
+		"":                        TransactionStatusPending,
+		TransactionStatusDisputed: TransactionStatusDisputed,
+		"REFUNDED":                "refunded",
+	}
+
+	for status, want := range tests {
+		if got := NormalizeTransactionStatus(status); got != want {
+			t.Errorf("NormalizeTransactionStatus(%q) = %q, want %q", status, got, want)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 type BillingRepository interface {
@@ -16,9 +17,16 @@ type BillingRepository interface {
 	GetBillingAttemptsBySubscriptionID(ctx context.Context, subscriptionID uuid.UUID) ([]models.BillingAttempt, error)
 	UpdateBillingAttempt(ctx context.Context, attempt *models.BillingAttempt) error
 	GetPendingBillingAttempts(ctx context.Context, limit int) ([]models.BillingAttempt, error)
-	GetFailedBillingAttemptsForRetry(ctx context.Context, maxAttempts int, olderThan time.Time) ([]models.BillingAttempt, error)
+	GetFailedBillingAttemptsForRetry(ctx context.Context, maxAttempts int, olderThan time.Time, nonRetryableCodes []string) ([]models.BillingAttempt, error)
+	ListBillingAttempts(ctx context.Context, status models.BillingAttemptStatus, limit, offset int) ([]models.BillingAttemptSummary, error)
+	ReleaseStuckBillingAttempts(ctx context.Context, startedBefore time.Time) ([]models.BillingAttempt, error)
 }
 
+const billingAttemptColumns = `
+			id, subscription_id, amount, currency, status, gateway_transaction_id,
+			error_code, error_message, attempt_number, scheduled_at, processed_at,
+			gateway_order_id, challenge_reference, processing_started_at, created_at`
+
 type billingRepository struct {
 	db *sql.DB
 }
@@ -30,16 +38,22 @@ func NewBillingRepository() BillingRepository {
 }
 
 func (r *billingRepository) CreateBillingAttempt(ctx context.Context, attempt *models.BillingAttempt) error {
+	return insertBillingAttempt(ctx, r.db, attempt)
+}
+
+// insertBillingAttempt inserts an attempt using either the pool or an open transaction
+func insertBillingAttempt(ctx context.Context, q queryRower, attempt *models.BillingAttempt) error {
 	query := `
 		INSERT INTO billing_attempts (
 			subscription_id, amount, currency, status, gateway_transaction_id,
-			error_code, error_message, attempt_number, scheduled_at, processed_at
+			error_code, error_message, attempt_number, scheduled_at, processed_at,
+			gateway_order_id, challenge_reference, processing_started_at
 		)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
 		RETURNING id, created_at
 	`
 
-	err := r.db.QueryRowContext(ctx, query,
+	err := q.QueryRowContext(ctx, query,
 		attempt.SubscriptionID,
 		attempt.Amount,
 		attempt.Currency,
@@ -50,6 +64,9 @@ func (r *billingRepository) CreateBillingAttempt(ctx context.Context, attempt *m
 		attempt.AttemptNumber,
 		attempt.ScheduledAt,
 		attempt.ProcessedAt,
+		attempt.GatewayOrderID,
+		attempt.ChallengeReference,
+		attempt.ProcessingStartedAt,
 	).Scan(&attempt.ID, &attempt.CreatedAt)
 
 	return err
@@ -57,29 +74,12 @@ func (r *billingRepository) CreateBillingAttempt(ctx context.Context, attempt *m
 
 func (r *billingRepository) GetBillingAttemptByID(ctx context.Context, id uuid.UUID) (*models.BillingAttempt, error) {
 	query := `
-		SELECT 
-			id, subscription_id, amount, currency, status, gateway_transaction_id,
-			error_code, error_message, attempt_number, scheduled_at, processed_at, created_at
+		SELECT ` + billingAttemptColumns + `
 		FROM billing_attempts
 		WHERE id = $1
 	`
 
-	attempt := &models.BillingAttempt{}
-	err := r.db.QueryRowContext(ctx, query, id).Scan(
-		&attempt.ID,
-		&attempt.SubscriptionID,
-		&attempt.Amount,
-		&attempt.Currency,
-		&attempt.Status,
-		&attempt.GatewayTransactionID,
-		&attempt.ErrorCode,
-		&attempt.ErrorMessage,
-		&attempt.AttemptNumber,
-		&attempt.ScheduledAt,
-		&attempt.ProcessedAt,
-		&attempt.CreatedAt,
-	)
-
+	attempt, err := scanBillingAttempt(r.db.QueryRowContext(ctx, query, id))
 	if err == sql.ErrNoRows {
 		return nil, &NotFoundError{Message: "billing attempt not found"}
 	}
@@ -92,9 +92,7 @@ func (r *billingRepository) GetBillingAttemptByID(ctx context.Context, id uuid.U
 
 func (r *billingRepository) GetBillingAttemptsBySubscriptionID(ctx context.Context, subscriptionID uuid.UUID) ([]models.BillingAttempt, error) {
 	query := `
-		SELECT 
-			id, subscription_id, amount, currency, status, gateway_transaction_id,
-			error_code, error_message, attempt_number, scheduled_at, processed_at, created_at
+		SELECT ` + billingAttemptColumns + `
 		FROM billing_attempts
 		WHERE subscription_id = $1
 		ORDER BY created_at DESC
@@ -109,25 +107,11 @@ func (r *billingRepository) GetBillingAttemptsBySubscriptionID(ctx context.Conte
 
 	var attempts []models.BillingAttempt
 	for rows.Next() {
-		var attempt models.BillingAttempt
-		err := rows.Scan(
-			&attempt.ID,
-			&attempt.SubscriptionID,
-			&attempt.Amount,
-			&attempt.Currency,
-			&attempt.Status,
-			&attempt.GatewayTransactionID,
-			&attempt.ErrorCode,
-			&attempt.ErrorMessage,
-			&attempt.AttemptNumber,
-			&attempt.ScheduledAt,
-			&attempt.ProcessedAt,
-			&attempt.CreatedAt,
-		)
+		attempt, err := scanBillingAttempt(rows)
 		if err != nil {
 			return nil, err
 		}
-		attempts = append(attempts, attempt)
+		attempts = append(attempts, *attempt)
 	}
 
 	return attempts, nil
@@ -142,8 +126,11 @@ func (r *billingRepository) UpdateBillingAttempt(ctx context.Context, attempt *m
 			error_code = $3,
 			error_message = $4,
 			attempt_number = $5,
-			processed_at = $6
-		WHERE id = $7
+			processed_at = $6,
+			gateway_order_id = $7,
+			challenge_reference = $8,
+			processing_started_at = $9
+		WHERE id = $10
 	`
 
 	result, err := r.db.ExecContext(ctx, query,
@@ -153,6 +140,9 @@ func (r *billingRepository) UpdateBillingAttempt(ctx context.Context, attempt *m
 		attempt.ErrorMessage,
 		attempt.AttemptNumber,
 		attempt.ProcessedAt,
+		attempt.GatewayOrderID,
+		attempt.ChallengeReference,
+		attempt.ProcessingStartedAt,
 		attempt.ID,
 	)
 
@@ -171,13 +161,16 @@ func (r *billingRepository) UpdateBillingAttempt(ctx context.Context, attempt *m
 	return nil
 }
 
+// GetPendingBillingAttempts returns attempts due to be charged, and attempts
+// whose outcome is awaiting reconciliation. Attempts waiting on the customer
+// to complete an authentication challenge, or held for review after the
+// gateway charged a different amount, are left alone.
 func (r *billingRepository) GetPendingBillingAttempts(ctx context.Context, limit int) ([]models.BillingAttempt, error) {
 	query := `
-		SELECT 
-			id, subscription_id, amount, currency, status, gateway_transaction_id,
-			error_code, error_message, attempt_number, scheduled_at, processed_at, created_at
+		SELECT ` + billingAttemptColumns + `
 		FROM billing_attempts
-		WHERE status IN ('pending', 'requires_action')
+		WHERE (status = 'pending' OR (status = 'requires_action' AND challenge_reference IS NULL
+			AND error_code IS DISTINCT FROM 'amount_mismatch'))
 		AND scheduled_at <= CURRENT_TIMESTAMP
 		ORDER BY scheduled_at ASC
 		LIMIT $1
@@ -191,45 +184,41 @@ func (r *billingRepository) GetPendingBillingAttempts(ctx context.Context, limit
 
 	var attempts []models.BillingAttempt
 	for rows.Next() {
-		var attempt models.BillingAttempt
-		err := rows.Scan(
-			&attempt.ID,
-			&attempt.SubscriptionID,
-			&attempt.Amount,
-			&attempt.Currency,
-			&attempt.Status,
-			&attempt.GatewayTransactionID,
-			&attempt.ErrorCode,
-			&attempt.ErrorMessage,
-			&attempt.AttemptNumber,
-			&attempt.ScheduledAt,
-			&attempt.ProcessedAt,
-			&attempt.CreatedAt,
-		)
+		attempt, err := scanBillingAttempt(rows)
 		if err != nil {
 			return nil, err
 		}
-		attempts = append(attempts, attempt)
+		attempts = append(attempts, *attempt)
 	}
 
 	return attempts, nil
 }
 
-func (r *billingRepository) GetFailedBillingAttemptsForRetry(ctx context.Context, maxAttempts int, olderThan time.Time) ([]models.BillingAttempt, error) {
+// GetFailedBillingAttemptsForRetry returns failed attempts that may be retried,
+// skipping declines whose canonical error code is in nonRetryableCodes.
+// Failures without a decline code, such as network errors, are retried; the
+// subscription is past due by then, so this is the only path that charges
+// the period again.
+// Attempts superseded by a newer attempt for their subscription are skipped,
+// so each failure is retried once.
+func (r *billingRepository) GetFailedBillingAttemptsForRetry(ctx context.Context, maxAttempts int, olderThan time.Time, nonRetryableCodes []string) ([]models.BillingAttempt, error) {
 	query := `
-		SELECT 
-			id, subscription_id, amount, currency, status, gateway_transaction_id,
-			error_code, error_message, attempt_number, scheduled_at, processed_at, created_at
-		FROM billing_attempts
+		SELECT ` + billingAttemptColumns + `
+		FROM billing_attempts ba
 		WHERE status = 'failed'
 		AND attempt_number < $1
 		AND processed_at < $2
-		AND error_code NOT IN ('card_declined', 'insufficient_funds', 'invalid_card')
+		AND (error_code IS NULL OR error_code <> ALL($3))
+		AND NOT EXISTS (
+			SELECT 1 FROM billing_attempts later
+			WHERE later.subscription_id = ba.subscription_id
+			AND later.created_at > ba.created_at
+		)
 		ORDER BY processed_at ASC
 		LIMIT 50
 	`
 
-	rows, err := r.db.QueryContext(ctx, query, maxAttempts, olderThan)
+	rows, err := r.db.QueryContext(ctx, query, maxAttempts, olderThan, pq.Array(nonRetryableCodes))
 	if err != nil {
 		return nil, err
 	}
@@ -237,26 +226,123 @@ func (r *billingRepository) GetFailedBillingAttemptsForRetry(ctx context.Context
 
 	var attempts []models.BillingAttempt
 	for rows.Next() {
-		var attempt models.BillingAttempt
-		err := rows.Scan(
+		attempt, err := scanBillingAttempt(rows)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, *attempt)
+	}
+
+	return attempts, nil
+}
+
+// ReleaseStuckBillingAttempts returns attempts that have been processing since
+// before startedBefore to the queue, and returns them. An attempt with no
+// gateway order never reached the gateway and goes back to pending; one with
+// an order may have been charged, so it goes to requires_action to be
+// reconciled with the gateway first.
+func (r *billingRepository) ReleaseStuckBillingAttempts(ctx context.Context, startedBefore time.Time) ([]models.BillingAttempt, error) {
+	query := `
+		UPDATE billing_attempts
+		SET
+			status = CASE WHEN gateway_order_id IS NULL THEN 'pending' ELSE 'requires_action' END,
+			error_message = 'processing was interrupted; released for another attempt',
+			processing_started_at = NULL
+		WHERE status = 'processing' AND processing_started_at < $1
+		RETURNING ` + billingAttemptColumns + `
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, startedBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []models.BillingAttempt
+	for rows.Next() {
+		attempt, err := scanBillingAttempt(rows)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, *attempt)
+	}
+
+	return attempts, rows.Err()
+}
+
+// ListBillingAttempts pages through billing attempts across all
+// subscriptions, optionally filtered by status, most recently scheduled first
+func (r *billingRepository) ListBillingAttempts(ctx context.Context, status models.BillingAttemptStatus, limit, offset int) ([]models.BillingAttemptSummary, error) {
+	query := `
+		SELECT ba.id, ba.subscription_id, s.user_id, ba.amount, ba.currency,
+		       ba.status, ba.attempt_number, ba.error_code, ba.error_message,
+		       ba.scheduled_at, ba.processed_at, ba.created_at
+		FROM billing_attempts ba
+		JOIN subscriptions s ON s.id = ba.subscription_id
+		WHERE ($1 = '' OR ba.status = $1)
+		ORDER BY ba.scheduled_at DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, string(status), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []models.BillingAttemptSummary{}
+	for rows.Next() {
+		var attempt models.BillingAttemptSummary
+		var errorCode, errorMessage sql.NullString
+		var processedAt sql.NullTime
+		if err := rows.Scan(
 			&attempt.ID,
 			&attempt.SubscriptionID,
+			&attempt.UserID,
 			&attempt.Amount,
 			&attempt.Currency,
 			&attempt.Status,
-			&attempt.GatewayTransactionID,
-			&attempt.ErrorCode,
-			&attempt.ErrorMessage,
 			&attempt.AttemptNumber,
+			&errorCode,
+			&errorMessage,
 			&attempt.ScheduledAt,
-			&attempt.ProcessedAt,
+			&processedAt,
 			&attempt.CreatedAt,
-		)
-		if err != nil {
+		); err != nil {
 			return nil, err
 		}
+		attempt.ErrorCode = errorCode.String
+		attempt.ErrorMessage = errorMessage.String
+		if processedAt.Valid {
+			attempt.ProcessedAt = &processedAt.Time
+		}
 		attempts = append(attempts, attempt)
 	}
 
-	return attempts, nil
+	return attempts, rows.Err()
+}
+
+func scanBillingAttempt(row rowScanner) (*models.BillingAttempt, error) {
+	var attempt models.BillingAttempt
+	err := row.Scan(
+		&attempt.ID,
+		&attempt.SubscriptionID,
+		&attempt.Amount,
+		&attempt.Currency,
+		&attempt.Status,
+		&attempt.GatewayTransactionID,
+		&attempt.ErrorCode,
+		&attempt.ErrorMessage,
+		&attempt.AttemptNumber,
+		&attempt.ScheduledAt,
+		&attempt.ProcessedAt,
+		&attempt.GatewayOrderID,
+		&attempt.ChallengeReference,
+		&attempt.ProcessingStartedAt,
+		&attempt.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &attempt, nil
 }
@@ -8,17 +8,26 @@ import (
 	"pg-backend/internal/models"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 type CardRepository interface {
 	CreateCard(ctx context.Context, card *models.Card) error
 	GetCardByID(ctx context.Context, id uuid.UUID) (*models.Card, error)
+	GetCardByGatewayToken(ctx context.Context, token string) (*models.Card, error)
 	GetCardsByUserID(ctx context.Context, userID uuid.UUID) ([]models.Card, error)
 	GetDefaultCardByUserID(ctx context.Context, userID uuid.UUID) (*models.Card, error)
 	UpdateCardAsDefault(ctx context.Context, userID, cardID uuid.UUID) error
 	DeleteCard(ctx context.Context, id uuid.UUID) error
+	SetStoredCredentialReference(ctx context.Context, id uuid.UUID, reference string) error
 }
 
+const cardColumns = `
+               id, user_id, gateway_token, last_four, expiry_month, expiry_year, 
+               scheme, is_default, payment_method_type, wallet_provider, 
+               device_payment_data, google_pay_token, stored_credential_reference,
+               created_at`
+
 type cardRepository struct {
 	db *sql.DB
 }
@@ -29,15 +38,21 @@ func NewCardRepository() CardRepository {
 	}
 }
 
+// CreateCard saves a card. A card saved with IsDefault becomes the default
+// only if it is the user's first card; the choice is made by the insert
+// itself, and idx_cards_one_default_per_user stops two concurrent first
+// cards from both becoming default.
 func (r *cardRepository) CreateCard(ctx context.Context, card *models.Card) error {
 	query := `
         INSERT INTO cards (
             user_id, gateway_token, last_four, expiry_month, expiry_year, 
             scheme, is_default, payment_method_type, wallet_provider, 
-            device_payment_data, google_pay_token
+            device_payment_data, google_pay_token, stored_credential_reference
         )
-        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
-        RETURNING id, created_at
+        VALUES ($1, $2, $3, $4, $5, $6,
+                $7 AND NOT EXISTS (SELECT 1 FROM cards WHERE user_id = $1),
+                $8, $9, $10, $11, $12)
+        RETURNING id, is_default, created_at
     `
 
 	// Convert device payment data to JSON
@@ -52,68 +67,44 @@ func (r *cardRepository) CreateCard(ctx context.Context, card *models.Card) erro
 		devicePaymentDataJSON = nil
 	}
 
-	// If this is the first card, set it as default
-	if card.IsDefault {
-		countQuery := `SELECT COUNT(*) FROM cards WHERE user_id = $1`
-		var count int
-		err := r.db.QueryRowContext(ctx, countQuery, card.UserID).Scan(&count)
-		if err != nil {
-			return err
-		}
-		card.IsDefault = count == 0
-	}
-
 	// Set default payment method type if not specified
 	if card.PaymentMethodType == "" {
 		card.PaymentMethodType = "card"
 	}
 
-	err := r.db.QueryRowContext(ctx, query,
-		card.UserID,
-		card.GatewayToken,
-		card.LastFour,
-		card.ExpiryMonth,
-		card.ExpiryYear,
-		card.Scheme,
-		card.IsDefault,
-		card.PaymentMethodType,
-		card.WalletProvider,
-		devicePaymentDataJSON,
-		card.GooglePayToken,
-	).Scan(&card.ID, &card.CreatedAt)
+	insert := func(isDefault bool) error {
+		return r.db.QueryRowContext(ctx, query,
+			card.UserID,
+			card.GatewayToken,
+			card.LastFour,
+			card.ExpiryMonth,
+			card.ExpiryYear,
+			card.Scheme,
+			isDefault,
+			card.PaymentMethodType,
+			card.WalletProvider,
+			devicePaymentDataJSON,
+			card.GooglePayToken,
+			nullIfEmpty(card.StoredCredentialReference),
+		).Scan(&card.ID, &card.IsDefault, &card.CreatedAt)
+	}
 
+	err := insert(card.IsDefault)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" && pqErr.Constraint == "idx_cards_one_default_per_user" {
+		// Another card for this user became the default first
+		err = insert(false)
+	}
 	return err
 }
 
 func (r *cardRepository) GetCardByID(ctx context.Context, id uuid.UUID) (*models.Card, error) {
 	query := `
-        SELECT id, user_id, gateway_token, last_four, expiry_month, expiry_year, 
-               scheme, is_default, payment_method_type, wallet_provider, 
-               device_payment_data, google_pay_token, created_at
+        SELECT ` + cardColumns + `
         FROM cards
         WHERE id = $1
     `
 
-	card := &models.Card{}
-	var devicePaymentDataJSON sql.NullString
-	var walletProvider, googlePayToken sql.NullString
-
-	err := r.db.QueryRowContext(ctx, query, id).Scan(
-		&card.ID,
-		&card.UserID,
-		&card.GatewayToken,
-		&card.LastFour,
-		&card.ExpiryMonth,
-		&card.ExpiryYear,
-		&card.Scheme,
-		&card.IsDefault,
-		&card.PaymentMethodType,
-		&walletProvider,
-		&devicePaymentDataJSON,
-		&googlePayToken,
-		&card.CreatedAt,
-	)
-
+	card, err := scanCard(r.db.QueryRowContext(ctx, query, id))
 	if err == sql.ErrNoRows {
 		return nil, &NotFoundError{Message: "card not found"}
 	}
@@ -121,25 +112,24 @@ func (r *cardRepository) GetCardByID(ctx context.Context, id uuid.UUID) (*models
 		return nil, err
 	}
 
-	// Parse device payment data
-	if devicePaymentDataJSON.Valid && devicePaymentDataJSON.String != "" {
-		var deviceData map[string]interface{}
-		if err := json.Unmarshal([]byte(devicePaymentDataJSON.String), &deviceData); err == nil {
-			card.DevicePaymentData = deviceData
-		}
-	}
+	return card, nil
+}
 
-	// Parse nullable strings
-	if walletProvider.Valid {
-		card.WalletProvider = walletProvider.String
-	}
-	if googlePayToken.Valid {
-		card.GooglePayToken = googlePayToken.String
-	}
+func (r *cardRepository) GetCardByGatewayToken(ctx context.Context, token string) (*models.Card, error) {
+	query := `
+        SELECT ` + cardColumns + `
+        FROM cards
+        WHERE gateway_token = $1
+        ORDER BY created_at DESC
+        LIMIT 1
+    `
 
-	// Set default payment method type if not set
-	if card.PaymentMethodType == "" {
-		card.PaymentMethodType = "card"
+	card, err := scanCard(r.db.QueryRowContext(ctx, query, token))
+	if err == sql.ErrNoRows {
+		return nil, &NotFoundError{Message: "card not found"}
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	return card, nil
@@ -147,9 +137,7 @@ func (r *cardRepository) GetCardByID(ctx context.Context, id uuid.UUID) (*models
 
 func (r *cardRepository) GetCardsByUserID(ctx context.Context, userID uuid.UUID) ([]models.Card, error) {
 	query := `
-        SELECT id, user_id, gateway_token, last_four, expiry_month, expiry_year, 
-               scheme, is_default, payment_method_type, wallet_provider, 
-               device_payment_data, google_pay_token, created_at
+        SELECT ` + cardColumns + `
         FROM cards
         WHERE user_id = $1
         ORDER BY is_default DESC, created_at DESC
@@ -163,85 +151,24 @@ func (r *cardRepository) GetCardsByUserID(ctx context.Context, userID uuid.UUID)
 
 	var cards []models.Card
 	for rows.Next() {
-		var card models.Card
-		var devicePaymentDataJSON sql.NullString
-		var walletProvider, googlePayToken sql.NullString
-
-		err := rows.Scan(
-			&card.ID,
-			&card.UserID,
-			&card.GatewayToken,
-			&card.LastFour,
-			&card.ExpiryMonth,
-			&card.ExpiryYear,
-			&card.Scheme,
-			&card.IsDefault,
-			&card.PaymentMethodType,
-			&walletProvider,
-			&devicePaymentDataJSON,
-			&googlePayToken,
-			&card.CreatedAt,
-		)
+		card, err := scanCard(rows)
 		if err != nil {
 			return nil, err
 		}
-
-		// Parse device payment data
-		if devicePaymentDataJSON.Valid && devicePaymentDataJSON.String != "" {
-			var deviceData map[string]interface{}
-			if err := json.Unmarshal([]byte(devicePaymentDataJSON.String), &deviceData); err == nil {
-				card.DevicePaymentData = deviceData
-			}
-		}
-
-		// Parse nullable strings
-		if walletProvider.Valid {
-			card.WalletProvider = walletProvider.String
-		}
-		if googlePayToken.Valid {
-			card.GooglePayToken = googlePayToken.String
-		}
-
-		// Set default payment method type if not set
-		if card.PaymentMethodType == "" {
-			card.PaymentMethodType = "card"
-		}
-
-		cards = append(cards, card)
+		cards = append(cards, *card)
 	}
 
-	return cards, nil
+	return cards, rows.Err()
 }
 
 func (r *cardRepository) GetDefaultCardByUserID(ctx context.Context, userID uuid.UUID) (*models.Card, error) {
 	query := `
-        SELECT id, user_id, gateway_token, last_four, expiry_month, expiry_year, 
-               scheme, is_default, payment_method_type, wallet_provider, 
-               device_payment_data, google_pay_token, created_at
+        SELECT ` + cardColumns + `
         FROM cards
         WHERE user_id = $1 AND is_default = true
     `
 
-	card := &models.Card{}
-	var devicePaymentDataJSON sql.NullString
-	var walletProvider, googlePayToken sql.NullString
-
-	err := r.db.QueryRowContext(ctx, query, userID).Scan(
-		&card.ID,
-		&card.UserID,
-		&card.GatewayToken,
-		&card.LastFour,
-		&card.ExpiryMonth,
-		&card.ExpiryYear,
-		&card.Scheme,
-		&card.IsDefault,
-		&card.PaymentMethodType,
-		&walletProvider,
-		&devicePaymentDataJSON,
-		&googlePayToken,
-		&card.CreatedAt,
-	)
-
+	card, err := scanCard(r.db.QueryRowContext(ctx, query, userID))
 	if err == sql.ErrNoRows {
 		return nil, &NotFoundError{Message: "no default card found"}
 	}
@@ -249,27 +176,6 @@ func (r *cardRepository) GetDefaultCardByUserID(ctx context.Context, userID uuid
 		return nil, err
 	}
 
-	// Parse device payment data
-	if devicePaymentDataJSON.Valid && devicePaymentDataJSON.String != "" {
-		var deviceData map[string]interface{}
-		if err := json.Unmarshal([]byte(devicePaymentDataJSON.String), &deviceData); err == nil {
-			card.DevicePaymentData = deviceData
-		}
-	}
-
-	// Parse nullable strings
-	if walletProvider.Valid {
-		card.WalletProvider = walletProvider.String
-	}
-	if googlePayToken.Valid {
-		card.GooglePayToken = googlePayToken.String
-	}
-
-	// Set default payment method type if not set
-	if card.PaymentMethodType == "" {
-		card.PaymentMethodType = "card"
-	}
-
 	return card, nil
 }
 
@@ -317,3 +223,62 @@ func (r *cardRepository) DeleteCard(ctx context.Context, id uuid.UUID) error {
 
 	return nil
 }
+
+// SetStoredCredentialReference records the scheme reference of the
+// transaction that first stored the card. An existing reference is kept.
+func (r *cardRepository) SetStoredCredentialReference(ctx context.Context, id uuid.UUID, reference string) error {
+	query := `
+        UPDATE cards
+        SET stored_credential_reference = $1
+        WHERE id = $2 AND stored_credential_reference IS NULL
+    `
+
+	_, err := r.db.ExecContext(ctx, query, reference, id)
+	return err
+}
+
+func scanCard(row rowScanner) (*models.Card, error) {
+	card := &models.Card{}
+	var devicePaymentDataJSON sql.NullString
+	var walletProvider, googlePayToken, storedCredentialReference sql.NullString
+
+	err := row.Scan(
+		&card.ID,
+		&card.UserID,
+		&card.GatewayToken,
+		&card.LastFour,
+		&card.ExpiryMonth,
+		&card.ExpiryYear,
+		&card.Scheme,
+		&card.IsDefault,
+		&card.PaymentMethodType,
+		&walletProvider,
+		&devicePaymentDataJSON,
+		&googlePayToken,
+		&storedCredentialReference,
+		&card.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse device payment data
+	if devicePaymentDataJSON.Valid && devicePaymentDataJSON.String != "" {
+		var deviceData map[string]interface{}
+		if err := json.Unmarshal([]byte(devicePaymentDataJSON.String), &deviceData); err == nil {
+			card.DevicePaymentData = deviceData
+		}
+	}
+
+	// Parse nullable strings
+	card.WalletProvider = walletProvider.String
+	card.GooglePayToken = googlePayToken.String
+	card.StoredCredentialReference = storedCredentialReference.String
+
+	// Set default payment method type if not set
+	if card.PaymentMethodType == "" {
+		card.PaymentMethodType = "card"
+	}
+
+	return card, nil
+}
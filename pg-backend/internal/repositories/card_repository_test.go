@@ -0,0 +1,78 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"pg-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+func TestUpdateCardAsDefaultConcurrently(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	user, err := NewUserRepository().CreateUser(ctx, fmt.Sprintf("default-card-%s@example.com", uuid.New()))
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Exec("DELETE FROM cards WHERE user_id = $1", user.ID)
+		db.Exec("DELETE FROM users WHERE id = $1", user.ID)
+	})
+
+	cards := NewCardRepository()
+	var cardIDs []uuid.UUID
+	for i := 0; i < 4; i++ {
+		card := &models.Card{
+			UserID:       user.ID,
+			GatewayToken: fmt.Sprintf("900000000000%04d", i),
+			LastFour:     fmt.Sprintf("%04d", i),
+			ExpiryMonth:  12,
+			ExpiryYear:   2030,
+			Scheme:       "MASTERCARD",
+			IsDefault:    true,
+		}
+		if err := cards.CreateCard(ctx, card); err != nil {
+			t.Fatalf("CreateCard: %v", err)
+		}
+		cardIDs = append(cardIDs, card.ID)
+	}
+
+	// Every card is made the default several times over, all at once
+	var wg sync.WaitGroup
+	errs := make(chan error, len(cardIDs)*5)
+	for round := 0; round < 5; round++ {
+		for _, id := range cardIDs {
+			wg.Add(1)
+			go func(id uuid.UUID) {
+				defer wg.Done()
+				if err := cards.UpdateCardAsDefault(ctx, user.ID, id); err != nil {
+					errs <- err
+				}
+			}(id)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("UpdateCardAsDefault: %v", err)
+	}
+
+	saved, err := cards.GetCardsByUserID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetCardsByUserID: %v", err)
+	}
+	defaults := 0
+	for _, card := range saved {
+		if card.IsDefault {
+			defaults++
+		}
+	}
+	if defaults != 1 {
+		t.Errorf("user has %d default cards, want exactly 1", defaults)
+	}
+}
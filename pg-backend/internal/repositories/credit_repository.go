@@ -0,0 +1,168 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"pg-backend/internal/database"
+	"pg-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+type CreditRepository interface {
+	CreateCreditEntry(ctx context.Context, entry *models.CreditEntry) error
+	GetBalances(ctx context.Context, userID uuid.UUID) ([]models.CreditBalance, error)
+	ApplyCredit(ctx context.Context, userID, billingAttemptID uuid.UUID, currency string, maxAmount float64) (float64, error)
+	GetAppliedCredit(ctx context.Context, billingAttemptID uuid.UUID) (float64, error)
+	ReleaseAppliedCredit(ctx context.Context, billingAttemptID uuid.UUID) error
+}
+
+type creditRepository struct {
+	db *sql.DB
+}
+
+func NewCreditRepository() CreditRepository {
+	return &creditRepository{
+		db: database.DB,
+	}
+}
+
+func (r *creditRepository) CreateCreditEntry(ctx context.Context, entry *models.CreditEntry) error {
+	return insertCreditEntry(ctx, r.db, entry)
+}
+
+func (r *creditRepository) GetBalances(ctx context.Context, userID uuid.UUID) ([]models.CreditBalance, error) {
+	query := `
+		SELECT currency, SUM(amount)
+		FROM credit_entries
+		WHERE user_id = $1 AND released_at IS NULL
+		GROUP BY currency
+		HAVING SUM(amount) > 0
+		ORDER BY currency
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances := []models.CreditBalance{}
+	for rows.Next() {
+		var balance models.CreditBalance
+		if err := rows.Scan(&balance.Currency, &balance.Balance); err != nil {
+			return nil, err
+		}
+		balance.Balance = roundAmount(balance.Balance)
+		balances = append(balances, balance)
+	}
+
+	return balances, rows.Err()
+}
+
+// ApplyCredit uses up to maxAmount of the user's credit against a billing
+// attempt and returns the amount applied. Calling it again for the same
+// attempt returns the credit already applied instead of applying more.
+func (r *creditRepository) ApplyCredit(ctx context.Context, userID, billingAttemptID uuid.UUID, currency string, maxAmount float64) (float64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Serialise credit use per user so two charges cannot spend the same balance
+	if _, err := tx.ExecContext(ctx, `SELECT id FROM users WHERE id = $1 FOR UPDATE`, userID); err != nil {
+		return 0, err
+	}
+
+	applied, err := appliedCredit(ctx, tx, billingAttemptID)
+	if err != nil {
+		return 0, err
+	}
+	if applied > 0 {
+		return applied, tx.Commit()
+	}
+
+	var balance float64
+	err = tx.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(amount), 0)
+		FROM credit_entries
+		WHERE user_id = $1 AND currency = $2 AND released_at IS NULL
+	`, userID, currency).Scan(&balance)
+	if err != nil {
+		return 0, err
+	}
+
+	applied = roundAmount(math.Min(balance, maxAmount))
+	if applied <= 0 {
+		return 0, nil
+	}
+
+	entry := &models.CreditEntry{
+		UserID:           userID,
+		Amount:           -applied,
+		Currency:         currency,
+		Type:             models.CreditEntryTypeApplied,
+		Description:      "Applied to subscription charge",
+		BillingAttemptID: uuid.NullUUID{UUID: billingAttemptID, Valid: true},
+	}
+	if err := insertCreditEntry(ctx, tx, entry); err != nil {
+		return 0, err
+	}
+
+	return applied, tx.Commit()
+}
+
+func (r *creditRepository) GetAppliedCredit(ctx context.Context, billingAttemptID uuid.UUID) (float64, error) {
+	return appliedCredit(ctx, r.db, billingAttemptID)
+}
+
+// ReleaseAppliedCredit returns credit applied to a billing attempt to the
+// user's balance, e.g. when the remaining charge was declined
+func (r *creditRepository) ReleaseAppliedCredit(ctx context.Context, billingAttemptID uuid.UUID) error {
+	query := `
+		UPDATE credit_entries
+		SET released_at = CURRENT_TIMESTAMP
+		WHERE billing_attempt_id = $1 AND type = $2 AND released_at IS NULL
+	`
+
+	_, err := r.db.ExecContext(ctx, query, billingAttemptID, models.CreditEntryTypeApplied)
+	return err
+}
+
+func appliedCredit(ctx context.Context, q queryRower, billingAttemptID uuid.UUID) (float64, error) {
+	query := `
+		SELECT COALESCE(-SUM(amount), 0)
+		FROM credit_entries
+		WHERE billing_attempt_id = $1 AND type = $2 AND released_at IS NULL
+	`
+
+	var applied float64
+	err := q.QueryRowContext(ctx, query, billingAttemptID, models.CreditEntryTypeApplied).Scan(&applied)
+	return roundAmount(applied), err
+}
+
+func insertCreditEntry(ctx context.Context, q queryRower, entry *models.CreditEntry) error {
+	query := `
+		INSERT INTO credit_entries
+		(user_id, amount, currency, type, description, subscription_id, billing_attempt_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+	`
+
+	return q.QueryRowContext(ctx, query,
+		entry.UserID,
+		entry.Amount,
+		entry.Currency,
+		entry.Type,
+		nullIfEmpty(entry.Description),
+		entry.SubscriptionID,
+		entry.BillingAttemptID,
+	).Scan(&entry.ID, &entry.CreatedAt)
+}
+
+// roundAmount rounds to minor units so float sums do not drift
+func roundAmount(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
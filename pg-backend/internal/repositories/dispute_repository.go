@@ -0,0 +1,128 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"pg-backend/internal/database"
+	"pg-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+type DisputeRepository interface {
+	UpsertDispute(ctx context.Context, dispute *models.Dispute) (created bool, err error)
+	GetDisputes(ctx context.Context, status string, limit int) ([]models.Dispute, error)
+	GetDisputesByTransactionID(ctx context.Context, transactionID uuid.UUID) ([]models.Dispute, error)
+}
+
+const disputeColumns = `
+			id, transaction_id, gateway_dispute_id, reason, status, amount, currency,
+			evidence_due, created_at, updated_at`
+
+type disputeRepository struct {
+	db *sql.DB
+}
+
+func NewDisputeRepository() DisputeRepository {
+	return &disputeRepository{
+		db: database.DB,
+	}
+}
+
+// UpsertDispute records a dispute, or updates the existing record with the
+// same gateway dispute ID. created reports whether a new row was inserted.
+func (r *disputeRepository) UpsertDispute(ctx context.Context, dispute *models.Dispute) (bool, error) {
+	query := `
+		INSERT INTO disputes
+		(transaction_id, gateway_dispute_id, reason, status, amount, currency, evidence_due)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (gateway_dispute_id) DO UPDATE SET
+			reason = EXCLUDED.reason,
+			status = EXCLUDED.status,
+			amount = EXCLUDED.amount,
+			currency = EXCLUDED.currency,
+			evidence_due = EXCLUDED.evidence_due,
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING id, transaction_id, created_at, updated_at, (xmax = 0) AS created
+	`
+
+	var created bool
+	err := r.db.QueryRowContext(ctx, query,
+		dispute.TransactionID,
+		dispute.GatewayDisputeID,
+		dispute.Reason,
+		dispute.Status,
+		dispute.Amount,
+		dispute.Currency,
+		dispute.EvidenceDue,
+	).Scan(&dispute.ID, &dispute.TransactionID, &dispute.CreatedAt, &dispute.UpdatedAt, &created)
+
+	return created, err
+}
+
+// GetDisputes lists the most recently updated disputes, optionally filtered by status
+func (r *disputeRepository) GetDisputes(ctx context.Context, status string, limit int) ([]models.Dispute, error) {
+	query := `
+		SELECT ` + disputeColumns + `
+		FROM disputes
+		WHERE ($1 = '' OR status = $1)
+		ORDER BY updated_at DESC
+		LIMIT $2
+	`
+
+	return r.queryDisputes(ctx, query, status, limit)
+}
+
+func (r *disputeRepository) GetDisputesByTransactionID(ctx context.Context, transactionID uuid.UUID) ([]models.Dispute, error) {
+	query := `
+		SELECT ` + disputeColumns + `
+		FROM disputes
+		WHERE transaction_id = $1
+		ORDER BY created_at DESC
+	`
+
+	return r.queryDisputes(ctx, query, transactionID)
+}
+
+func (r *disputeRepository) queryDisputes(ctx context.Context, query string, args ...interface{}) ([]models.Dispute, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	disputes := []models.Dispute{}
+	for rows.Next() {
+		dispute, err := scanDispute(rows)
+		if err != nil {
+			return nil, err
+		}
+		disputes = append(disputes, *dispute)
+	}
+
+	return disputes, rows.Err()
+}
+
+func scanDispute(row rowScanner) (*models.Dispute, error) {
+	var dispute models.Dispute
+	var reason sql.NullString
+
+	err := row.Scan(
+		&dispute.ID,
+		&dispute.TransactionID,
+		&dispute.GatewayDisputeID,
+		&reason,
+		&dispute.Status,
+		&dispute.Amount,
+		&dispute.Currency,
+		&dispute.EvidenceDue,
+		&dispute.CreatedAt,
+		&dispute.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	dispute.Reason = reason.String
+	return &dispute, nil
+}
@@ -0,0 +1,47 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"pg-backend/internal/database"
+	"pg-backend/internal/models"
+)
+
+type EventRepository interface {
+	CreateEvent(ctx context.Context, event *models.Event) error
+}
+
+type eventRepository struct {
+	db *sql.DB
+}
+
+func NewEventRepository() EventRepository {
+	return &eventRepository{
+		db: database.DB,
+	}
+}
+
+func (r *eventRepository) CreateEvent(ctx context.Context, event *models.Event) error {
+	dataJSON := "{}"
+	if len(event.Data) > 0 {
+		dataBytes, err := json.Marshal(event.Data)
+		if err != nil {
+			return err
+		}
+		dataJSON = string(dataBytes)
+	}
+
+	query := `
+		INSERT INTO events (type, resource_type, resource_id, data)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+
+	return r.db.QueryRowContext(ctx, query,
+		event.Type,
+		event.ResourceType,
+		event.ResourceID,
+		dataJSON,
+	).Scan(&event.ID, &event.CreatedAt)
+}
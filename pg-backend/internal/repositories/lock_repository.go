@@ -0,0 +1,52 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"pg-backend/internal/database"
+)
+
+// LockRepository takes locks shared by every instance on the database, so
+// work that must only run once at a time, such as a billing cycle, isn't
+// repeated by each replica
+type LockRepository interface {
+	// TryLock takes the lock named by key without waiting. When acquired is
+	// true the caller holds the lock until it calls unlock.
+	TryLock(ctx context.Context, key int64) (unlock func(), acquired bool, err error)
+}
+
+type lockRepository struct {
+	db *sql.DB
+}
+
+func NewLockRepository() LockRepository {
+	return &lockRepository{
+		db: database.DB,
+	}
+}
+
+// TryLock takes a session-level Postgres advisory lock. The lock belongs to
+// the connection it was taken on, so that connection is held out of the pool
+// until unlock; if the process dies the lock is released with its session.
+func (r *lockRepository) TryLock(ctx context.Context, key int64) (func(), bool, error) {
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&acquired); err != nil {
+		conn.Close()
+		return nil, false, err
+	}
+	if !acquired {
+		conn.Close()
+		return nil, false, nil
+	}
+
+	unlock := func() {
+		conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key)
+		conn.Close()
+	}
+	return unlock, true, nil
+}
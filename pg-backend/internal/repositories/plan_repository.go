@@ -3,6 +3,7 @@ package repositories
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"pg-backend/internal/database"
 	"pg-backend/internal/models"
 
@@ -12,13 +13,19 @@ import (
 
 type PlanRepository interface {
 	CreatePlan(ctx context.Context, plan *models.Plan) error
+	CreatePlans(ctx context.Context, plans []*models.Plan) error
 	GetPlanByID(ctx context.Context, id uuid.UUID) (*models.Plan, error)
 	GetPlanByName(ctx context.Context, name string) (*models.Plan, error)
 	GetAllPlans(ctx context.Context, activeOnly bool) ([]models.Plan, error)
 	UpdatePlan(ctx context.Context, plan *models.Plan) error
+	SetActive(ctx context.Context, id uuid.UUID, active bool) (*models.Plan, error)
 	DeletePlan(ctx context.Context, id uuid.UUID) error
 }
 
+const planColumns = `
+		       id, name, amount, currency, interval, trial_period_days, 
+		       description, statement_descriptor, is_active, metadata, created_at, updated_at`
+
 type planRepository struct {
 	db *sql.DB
 }
@@ -30,20 +37,51 @@ func NewPlanRepository() PlanRepository {
 }
 
 func (r *planRepository) CreatePlan(ctx context.Context, plan *models.Plan) error {
+	return insertPlan(ctx, r.db, plan)
+}
+
+// CreatePlans inserts every plan in one transaction. If any insert fails
+// nothing is created and the error is a *BatchError naming the plan.
+func (r *planRepository) CreatePlans(ctx context.Context, plans []*models.Plan) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i, plan := range plans {
+		if err := insertPlan(ctx, tx, plan); err != nil {
+			return &BatchError{Index: i, Err: err}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// insertPlan inserts a plan using either the pool or an open transaction
+func insertPlan(ctx context.Context, q queryRower, plan *models.Plan) error {
 	query := `
-		INSERT INTO plans (name, amount, currency, interval, trial_period_days, description, is_active)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO plans (name, amount, currency, interval, trial_period_days, description,
+		                   statement_descriptor, is_active, metadata)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 		RETURNING id, created_at, updated_at
 	`
 
-	err := r.db.QueryRowContext(ctx, query,
+	metadataJSON, err := marshalMetadata(plan.Metadata)
+	if err != nil {
+		return err
+	}
+
+	err = q.QueryRowContext(ctx, query,
 		plan.Name,
 		plan.Amount,
 		plan.Currency,
 		plan.Interval,
 		plan.TrialPeriodDays,
 		plan.Description,
+		nullIfEmpty(plan.StatementDescriptor),
 		plan.IsActive,
+		metadataJSON,
 	).Scan(&plan.ID, &plan.CreatedAt, &plan.UpdatedAt)
 
 	if err != nil {
@@ -58,26 +96,12 @@ func (r *planRepository) CreatePlan(ctx context.Context, plan *models.Plan) erro
 
 func (r *planRepository) GetPlanByID(ctx context.Context, id uuid.UUID) (*models.Plan, error) {
 	query := `
-		SELECT id, name, amount, currency, interval, trial_period_days, 
-		       description, is_active, created_at, updated_at
+		SELECT ` + planColumns + `
 		FROM plans
 		WHERE id = $1
 	`
 
-	plan := &models.Plan{}
-	err := r.db.QueryRowContext(ctx, query, id).Scan(
-		&plan.ID,
-		&plan.Name,
-		&plan.Amount,
-		&plan.Currency,
-		&plan.Interval,
-		&plan.TrialPeriodDays,
-		&plan.Description,
-		&plan.IsActive,
-		&plan.CreatedAt,
-		&plan.UpdatedAt,
-	)
-
+	plan, err := scanPlan(r.db.QueryRowContext(ctx, query, id))
 	if err == sql.ErrNoRows {
 		return nil, &NotFoundError{Message: "plan not found"}
 	}
@@ -90,26 +114,12 @@ func (r *planRepository) GetPlanByID(ctx context.Context, id uuid.UUID) (*models
 
 func (r *planRepository) GetPlanByName(ctx context.Context, name string) (*models.Plan, error) {
 	query := `
-		SELECT id, name, amount, currency, interval, trial_period_days, 
-		       description, is_active, created_at, updated_at
+		SELECT ` + planColumns + `
 		FROM plans
 		WHERE name = $1
 	`
 
-	plan := &models.Plan{}
-	err := r.db.QueryRowContext(ctx, query, name).Scan(
-		&plan.ID,
-		&plan.Name,
-		&plan.Amount,
-		&plan.Currency,
-		&plan.Interval,
-		&plan.TrialPeriodDays,
-		&plan.Description,
-		&plan.IsActive,
-		&plan.CreatedAt,
-		&plan.UpdatedAt,
-	)
-
+	plan, err := scanPlan(r.db.QueryRowContext(ctx, query, name))
 	if err == sql.ErrNoRows {
 		return nil, &NotFoundError{Message: "plan not found"}
 	}
@@ -126,16 +136,14 @@ func (r *planRepository) GetAllPlans(ctx context.Context, activeOnly bool) ([]mo
 
 	if activeOnly {
 		query = `
-			SELECT id, name, amount, currency, interval, trial_period_days, 
-			       description, is_active, created_at, updated_at
+			SELECT ` + planColumns + `
 			FROM plans
 			WHERE is_active = true
 			ORDER BY amount ASC, name ASC
 		`
 	} else {
 		query = `
-			SELECT id, name, amount, currency, interval, trial_period_days, 
-			       description, is_active, created_at, updated_at
+			SELECT ` + planColumns + `
 			FROM plans
 			ORDER BY is_active DESC, amount ASC, name ASC
 		`
@@ -149,38 +157,35 @@ func (r *planRepository) GetAllPlans(ctx context.Context, activeOnly bool) ([]mo
 
 	var plans []models.Plan
 	for rows.Next() {
-		var plan models.Plan
-		err := rows.Scan(
-			&plan.ID,
-			&plan.Name,
-			&plan.Amount,
-			&plan.Currency,
-			&plan.Interval,
-			&plan.TrialPeriodDays,
-			&plan.Description,
-			&plan.IsActive,
-			&plan.CreatedAt,
-			&plan.UpdatedAt,
-		)
+		plan, err := scanPlan(rows)
 		if err != nil {
 			return nil, err
 		}
-		plans = append(plans, plan)
+		plans = append(plans, *plan)
 	}
 
-	return plans, nil
+	return plans, rows.Err()
 }
 
+// UpdatePlan overwrites a plan. A non-zero plan.UpdatedAt is the version the
+// caller read: the update only applies while the row still has it, and is
+// reported as not found otherwise.
 func (r *planRepository) UpdatePlan(ctx context.Context, plan *models.Plan) error {
 	query := `
 		UPDATE plans
 		SET name = $1, amount = $2, currency = $3, interval = $4, 
-		    trial_period_days = $5, description = $6, is_active = $7
-		WHERE id = $8
+		    trial_period_days = $5, description = $6, is_active = $7,
+		    statement_descriptor = $9, metadata = $10, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $8 AND ($11 IS NULL OR updated_at = $11)
 		RETURNING updated_at
 	`
 
-	err := r.db.QueryRowContext(ctx, query,
+	metadataJSON, err := marshalMetadata(plan.Metadata)
+	if err != nil {
+		return err
+	}
+
+	err = r.db.QueryRowContext(ctx, query,
 		plan.Name,
 		plan.Amount,
 		plan.Currency,
@@ -189,6 +194,9 @@ func (r *planRepository) UpdatePlan(ctx context.Context, plan *models.Plan) erro
 		plan.Description,
 		plan.IsActive,
 		plan.ID,
+		nullIfEmpty(plan.StatementDescriptor),
+		metadataJSON,
+		sql.NullTime{Time: plan.UpdatedAt, Valid: !plan.UpdatedAt.IsZero()},
 	).Scan(&plan.UpdatedAt)
 
 	if err == sql.ErrNoRows {
@@ -201,6 +209,25 @@ func (r *planRepository) UpdatePlan(ctx context.Context, plan *models.Plan) erro
 	return nil
 }
 
+// SetActive archives or restores a plan, leaving its other fields as they are
+func (r *planRepository) SetActive(ctx context.Context, id uuid.UUID, active bool) (*models.Plan, error) {
+	query := `
+		UPDATE plans
+		SET is_active = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING ` + planColumns
+
+	plan, err := scanPlan(r.db.QueryRowContext(ctx, query, id, active))
+	if err == sql.ErrNoRows {
+		return nil, &NotFoundError{Message: "plan not found"}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
 func (r *planRepository) DeletePlan(ctx context.Context, id uuid.UUID) error {
 	query := `DELETE FROM plans WHERE id = $1`
 	result, err := r.db.ExecContext(ctx, query, id)
@@ -218,3 +245,35 @@ func (r *planRepository) DeletePlan(ctx context.Context, id uuid.UUID) error {
 
 	return nil
 }
+
+func scanPlan(row rowScanner) (*models.Plan, error) {
+	plan := &models.Plan{}
+	var statementDescriptor, metadataJSON sql.NullString
+
+	err := row.Scan(
+		&plan.ID,
+		&plan.Name,
+		&plan.Amount,
+		&plan.Currency,
+		&plan.Interval,
+		&plan.TrialPeriodDays,
+		&plan.Description,
+		&statementDescriptor,
+		&plan.IsActive,
+		&metadataJSON,
+		&plan.CreatedAt,
+		&plan.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	plan.StatementDescriptor = statementDescriptor.String
+	if metadataJSON.Valid && metadataJSON.String != "" {
+		metadata := make(map[string]string)
+		if err := json.Unmarshal([]byte(metadataJSON.String), &metadata); err == nil && len(metadata) > 0 {
+			plan.Metadata = metadata
+		}
+	}
+	return plan, nil
+}
@@ -0,0 +1,59 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"pg-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+func TestUpdatePlanRejectsStaleVersion(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	plans := NewPlanRepository()
+	plan := &models.Plan{
+		Name:     fmt.Sprintf("concurrent-edit-%s", uuid.New()),
+		Amount:   10,
+		Currency: "USD",
+		Interval: "month",
+		IsActive: true,
+	}
+	if err := plans.CreatePlan(ctx, plan); err != nil {
+		t.Fatalf("CreatePlan: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Exec("DELETE FROM plans WHERE id = $1", plan.ID)
+	})
+
+	// Two admins load the same version of the plan
+	first, err := plans.GetPlanByID(ctx, plan.ID)
+	if err != nil {
+		t.Fatalf("GetPlanByID: %v", err)
+	}
+	second := *first
+
+	first.Amount = 12
+	if err := plans.UpdatePlan(ctx, first); err != nil {
+		t.Fatalf("first UpdatePlan: %v", err)
+	}
+
+	second.Description = "edited from a stale copy"
+	if _, ok := plans.UpdatePlan(ctx, &second).(*NotFoundError); !ok {
+		t.Fatalf("second UpdatePlan did not match the changed row")
+	}
+
+	saved, err := plans.GetPlanByID(ctx, plan.ID)
+	if err != nil {
+		t.Fatalf("GetPlanByID: %v", err)
+	}
+	if saved.Amount != 12 || saved.Description != plan.Description {
+		t.Errorf("saved plan = %v %q, want the first edit only", saved.Amount, saved.Description)
+	}
+	if !saved.UpdatedAt.Equal(first.UpdatedAt) {
+		t.Errorf("updated_at = %v, want %v from the first update", saved.UpdatedAt, first.UpdatedAt)
+	}
+}
@@ -0,0 +1,47 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+)
+
+// queryRower is implemented by both *sql.DB and *sql.Tx so insert helpers
+// can run standalone or as part of a larger transaction
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// extraColumnsScanner scans a row that has extra columns after the ones a
+// scan helper such as scanTransaction knows about into extra
+type extraColumnsScanner struct {
+	row   rowScanner
+	extra []interface{}
+}
+
+func (s extraColumnsScanner) Scan(dest ...interface{}) error {
+	return s.row.Scan(append(dest, s.extra...)...)
+}
+
+// nullIfEmpty stores optional string columns as NULL rather than empty strings
+func nullIfEmpty(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// marshalMetadata encodes a metadata map for a JSONB column, storing an empty
+// object when there is none
+func marshalMetadata(metadata map[string]string) (string, error) {
+	if len(metadata) == 0 {
+		return "{}", nil
+	}
+	metadataBytes, err := json.Marshal(metadata)
+	if err != nil {
+		return "", err
+	}
+	return string(metadataBytes), nil
+}
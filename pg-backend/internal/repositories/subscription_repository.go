@@ -4,21 +4,45 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"pg-backend/internal/database"
 	"pg-backend/internal/models"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 type SubscriptionRepository interface {
 	CreateSubscription(ctx context.Context, subscription *models.Subscription) error
+	ImportSubscriptions(ctx context.Context, imports []SubscriptionImport) error
 	GetSubscriptionByID(ctx context.Context, id uuid.UUID) (*models.Subscription, error)
 	GetSubscriptionsByUserID(ctx context.Context, userID uuid.UUID, status string) ([]models.Subscription, error)
 	UpdateSubscription(ctx context.Context, subscription *models.Subscription) error
-	CancelSubscription(ctx context.Context, id uuid.UUID, cancelAtPeriodEnd bool) error
-	GetSubscriptionsDueForBilling(ctx context.Context, cutoffTime time.Time) ([]models.Subscription, error)
+	CancelSubscription(ctx context.Context, id uuid.UUID, cancelAtPeriodEnd bool, reason models.CancellationReason, comment string) error
+	GetSubscriptionsDueForBilling(ctx context.Context, cutoffTime time.Time, limit int) ([]models.Subscription, error)
 	GetActiveSubscriptionCount(ctx context.Context) (int, error)
+	CountActiveSubscriptionsByUserID(ctx context.Context, userID uuid.UUID) (int, error)
+	CountByStatus(ctx context.Context) (map[models.SubscriptionStatus]int, error)
+	SetInitialTraceID(ctx context.Context, id uuid.UUID, traceID string) error
+	HasHadTrialOrPaidPeriod(ctx context.Context, userID uuid.UUID, planID uuid.NullUUID) (bool, error)
+	UpdateCardForUser(ctx context.Context, userID, cardID uuid.UUID) (int, error)
+	ExpireIncompleteSubscriptions(ctx context.Context, createdBefore time.Time) ([]uuid.UUID, error)
+	ClaimRenewalReminders(ctx context.Context, intervals []string, window time.Duration, limit int) ([]models.Subscription, error)
+}
+
+const subscriptionColumns = `
+			id, user_id, plan_id, card_id, plan_name, amount, currency, status,
+			interval, current_period_start, current_period_end, trial_start,
+			trial_end, cancel_at_period_end, canceled_at, metadata,
+			billing_cycle_anchor, next_billing_at, initial_trace_id,
+			cancellation_reason, cancellation_comment, created_at`
+
+// SubscriptionImport pairs an imported subscription with the billing attempt
+// that should be queued for it, if any
+type SubscriptionImport struct {
+	Subscription   *models.Subscription
+	InitialAttempt *models.BillingAttempt
 }
 
 type subscriptionRepository struct {
@@ -32,14 +56,39 @@ func NewSubscriptionRepository() SubscriptionRepository {
 }
 
 func (r *subscriptionRepository) CreateSubscription(ctx context.Context, subscription *models.Subscription) error {
-	// Convert metadata map to JSON
-	metadataJSON := "{}"
-	if subscription.Metadata != nil && len(subscription.Metadata) > 0 {
-		metadataBytes, err := json.Marshal(subscription.Metadata)
-		if err != nil {
-			return err
+	return insertSubscription(ctx, r.db, subscription)
+}
+
+// ImportSubscriptions inserts migrated subscriptions, and any initial billing
+// attempts, in a single transaction so a failed row leaves nothing behind
+func (r *subscriptionRepository) ImportSubscriptions(ctx context.Context, imports []SubscriptionImport) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i := range imports {
+		if err := insertSubscription(ctx, tx, imports[i].Subscription); err != nil {
+			return fmt.Errorf("row %d: %w", i, err)
+		}
+
+		if imports[i].InitialAttempt != nil {
+			imports[i].InitialAttempt.SubscriptionID = imports[i].Subscription.ID
+			if err := insertBillingAttempt(ctx, tx, imports[i].InitialAttempt); err != nil {
+				return fmt.Errorf("row %d: %w", i, err)
+			}
 		}
-		metadataJSON = string(metadataBytes)
+	}
+
+	return tx.Commit()
+}
+
+// insertSubscription inserts a subscription using either the pool or an open transaction
+func insertSubscription(ctx context.Context, q queryRower, subscription *models.Subscription) error {
+	metadataJSON, err := marshalMetadata(subscription.Metadata)
+	if err != nil {
+		return err
 	}
 
 	query := `
@@ -53,7 +102,7 @@ func (r *subscriptionRepository) CreateSubscription(ctx context.Context, subscri
 		RETURNING id, created_at
 	`
 
-	err := r.db.QueryRowContext(ctx, query,
+	err = q.QueryRowContext(ctx, query,
 		subscription.UserID,
 		subscription.PlanID,
 		subscription.CardID,
@@ -77,44 +126,12 @@ func (r *subscriptionRepository) CreateSubscription(ctx context.Context, subscri
 
 func (r *subscriptionRepository) GetSubscriptionByID(ctx context.Context, id uuid.UUID) (*models.Subscription, error) {
 	query := `
-		SELECT 
-			id, user_id, plan_id, card_id, plan_name, amount, currency, status,
-			interval, current_period_start, current_period_end, trial_start,
-			trial_end, cancel_at_period_end, canceled_at, metadata, 
-			billing_cycle_anchor, next_billing_at, created_at
+		SELECT ` + subscriptionColumns + `
 		FROM subscriptions
 		WHERE id = $1
 	`
 
-	var (
-		subscription models.Subscription
-		metadataJSON sql.NullString
-		planID       sql.NullString
-		cardID       sql.NullString
-	)
-
-	err := r.db.QueryRowContext(ctx, query, id).Scan(
-		&subscription.ID,
-		&subscription.UserID,
-		&planID,
-		&cardID,
-		&subscription.PlanName,
-		&subscription.Amount,
-		&subscription.Currency,
-		&subscription.Status,
-		&subscription.Interval,
-		&subscription.CurrentPeriodStart,
-		&subscription.CurrentPeriodEnd,
-		&subscription.TrialStart,
-		&subscription.TrialEnd,
-		&subscription.CancelAtPeriodEnd,
-		&subscription.CanceledAt,
-		&metadataJSON,
-		&subscription.BillingCycleAnchor,
-		&subscription.NextBillingAt,
-		&subscription.CreatedAt,
-	)
-
+	subscription, err := scanSubscription(r.db.QueryRowContext(ctx, query, id))
 	if err == sql.ErrNoRows {
 		return nil, &NotFoundError{Message: "subscription not found"}
 	}
@@ -122,27 +139,7 @@ func (r *subscriptionRepository) GetSubscriptionByID(ctx context.Context, id uui
 		return nil, err
 	}
 
-	// Parse UUIDs
-	if planID.Valid {
-		if parsedID, err := uuid.Parse(planID.String); err == nil {
-			subscription.PlanID = uuid.NullUUID{UUID: parsedID, Valid: true}
-		}
-	}
-	if cardID.Valid {
-		if parsedID, err := uuid.Parse(cardID.String); err == nil {
-			subscription.CardID = uuid.NullUUID{UUID: parsedID, Valid: true}
-		}
-	}
-
-	// Parse metadata
-	if metadataJSON.Valid && metadataJSON.String != "" {
-		metadata := make(map[string]string)
-		if err := json.Unmarshal([]byte(metadataJSON.String), &metadata); err == nil {
-			subscription.Metadata = metadata
-		}
-	}
-
-	return &subscription, nil
+	return subscription, nil
 }
 
 func (r *subscriptionRepository) GetSubscriptionsByUserID(ctx context.Context, userID uuid.UUID, status string) ([]models.Subscription, error) {
@@ -151,11 +148,7 @@ func (r *subscriptionRepository) GetSubscriptionsByUserID(ctx context.Context, u
 
 	if status != "" {
 		query = `
-			SELECT 
-				id, user_id, plan_id, card_id, plan_name, amount, currency, status,
-				interval, current_period_start, current_period_end, trial_start,
-				trial_end, cancel_at_period_end, canceled_at, metadata, 
-				billing_cycle_anchor, next_billing_at, created_at
+			SELECT ` + subscriptionColumns + `
 			FROM subscriptions
 			WHERE user_id = $1 AND status = $2
 			ORDER BY created_at DESC
@@ -163,11 +156,7 @@ func (r *subscriptionRepository) GetSubscriptionsByUserID(ctx context.Context, u
 		args = []interface{}{userID, status}
 	} else {
 		query = `
-			SELECT 
-				id, user_id, plan_id, card_id, plan_name, amount, currency, status,
-				interval, current_period_start, current_period_end, trial_start,
-				trial_end, cancel_at_period_end, canceled_at, metadata, 
-				billing_cycle_anchor, next_billing_at, created_at
+			SELECT ` + subscriptionColumns + `
 			FROM subscriptions
 			WHERE user_id = $1
 			ORDER BY 
@@ -182,81 +171,13 @@ func (r *subscriptionRepository) GetSubscriptionsByUserID(ctx context.Context, u
 		args = []interface{}{userID}
 	}
 
-	rows, err := r.db.QueryContext(ctx, query, args...)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var subscriptions []models.Subscription
-	for rows.Next() {
-		var (
-			subscription models.Subscription
-			metadataJSON sql.NullString
-			planID       sql.NullString
-			cardID       sql.NullString
-		)
-
-		err := rows.Scan(
-			&subscription.ID,
-			&subscription.UserID,
-			&planID,
-			&cardID,
-			&subscription.PlanName,
-			&subscription.Amount,
-			&subscription.Currency,
-			&subscription.Status,
-			&subscription.Interval,
-			&subscription.CurrentPeriodStart,
-			&subscription.CurrentPeriodEnd,
-			&subscription.TrialStart,
-			&subscription.TrialEnd,
-			&subscription.CancelAtPeriodEnd,
-			&subscription.CanceledAt,
-			&metadataJSON,
-			&subscription.BillingCycleAnchor,
-			&subscription.NextBillingAt,
-			&subscription.CreatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
-
-		// Parse UUIDs
-		if planID.Valid {
-			if parsedID, err := uuid.Parse(planID.String); err == nil {
-				subscription.PlanID = uuid.NullUUID{UUID: parsedID, Valid: true}
-			}
-		}
-		if cardID.Valid {
-			if parsedID, err := uuid.Parse(cardID.String); err == nil {
-				subscription.CardID = uuid.NullUUID{UUID: parsedID, Valid: true}
-			}
-		}
-
-		// Parse metadata
-		if metadataJSON.Valid && metadataJSON.String != "" {
-			metadata := make(map[string]string)
-			if err := json.Unmarshal([]byte(metadataJSON.String), &metadata); err == nil {
-				subscription.Metadata = metadata
-			}
-		}
-
-		subscriptions = append(subscriptions, subscription)
-	}
-
-	return subscriptions, nil
+	return r.querySubscriptions(ctx, query, args...)
 }
 
 func (r *subscriptionRepository) UpdateSubscription(ctx context.Context, subscription *models.Subscription) error {
-	// Convert metadata map to JSON
-	metadataJSON := "{}"
-	if subscription.Metadata != nil && len(subscription.Metadata) > 0 {
-		metadataBytes, err := json.Marshal(subscription.Metadata)
-		if err != nil {
-			return err
-		}
-		metadataJSON = string(metadataBytes)
+	metadataJSON, err := marshalMetadata(subscription.Metadata)
+	if err != nil {
+		return err
 	}
 
 	query := `
@@ -282,7 +203,7 @@ func (r *subscriptionRepository) UpdateSubscription(ctx context.Context, subscri
 		RETURNING created_at
 	`
 
-	err := r.db.QueryRowContext(ctx, query,
+	err = r.db.QueryRowContext(ctx, query,
 		subscription.PlanID,
 		subscription.CardID,
 		subscription.PlanName,
@@ -312,7 +233,7 @@ func (r *subscriptionRepository) UpdateSubscription(ctx context.Context, subscri
 	return nil
 }
 
-func (r *subscriptionRepository) CancelSubscription(ctx context.Context, id uuid.UUID, cancelAtPeriodEnd bool) error {
+func (r *subscriptionRepository) CancelSubscription(ctx context.Context, id uuid.UUID, cancelAtPeriodEnd bool, reason models.CancellationReason, comment string) error {
 	query := `
 		UPDATE subscriptions
 		SET 
@@ -324,11 +245,14 @@ func (r *subscriptionRepository) CancelSubscription(ctx context.Context, id uuid
 			canceled_at = CASE 
 				WHEN $1 = true THEN canceled_at
 				ELSE CURRENT_TIMESTAMP
-			END
+			END,
+			cancellation_reason = $3,
+			cancellation_comment = $4
 		WHERE id = $2
 	`
 
-	result, err := r.db.ExecContext(ctx, query, cancelAtPeriodEnd, id)
+	result, err := r.db.ExecContext(ctx, query, cancelAtPeriodEnd, id,
+		nullIfEmpty(string(reason)), nullIfEmpty(comment))
 	if err != nil {
 		return err
 	}
@@ -344,13 +268,11 @@ func (r *subscriptionRepository) CancelSubscription(ctx context.Context, id uuid
 	return nil
 }
 
-func (r *subscriptionRepository) GetSubscriptionsDueForBilling(ctx context.Context, cutoffTime time.Time) ([]models.Subscription, error) {
+// GetSubscriptionsDueForBilling returns up to limit subscriptions whose next
+// charge falls on or before cutoffTime, earliest first
+func (r *subscriptionRepository) GetSubscriptionsDueForBilling(ctx context.Context, cutoffTime time.Time, limit int) ([]models.Subscription, error) {
 	query := `
-		SELECT 
-			id, user_id, plan_id, card_id, plan_name, amount, currency, status,
-			interval, current_period_start, current_period_end, trial_start,
-			trial_end, cancel_at_period_end, canceled_at, metadata, 
-			billing_cycle_anchor, next_billing_at, created_at
+		SELECT ` + subscriptionColumns + `
 		FROM subscriptions
 		WHERE 
 			status IN ('active', 'trialing')
@@ -358,84 +280,260 @@ func (r *subscriptionRepository) GetSubscriptionsDueForBilling(ctx context.Conte
 			AND next_billing_at <= $1
 			AND (trial_end IS NULL OR trial_end <= CURRENT_TIMESTAMP)
 		ORDER BY next_billing_at ASC
-		LIMIT 100
+		LIMIT $2
+	`
+
+	return r.querySubscriptions(ctx, query, cutoffTime, limit)
+}
+
+// SetInitialTraceID records the scheme trace ID of the first successful charge
+// on a subscription. Later calls are ignored so the original reference is kept.
+func (r *subscriptionRepository) SetInitialTraceID(ctx context.Context, id uuid.UUID, traceID string) error {
+	query := `
+		UPDATE subscriptions
+		SET initial_trace_id = $1
+		WHERE id = $2 AND initial_trace_id IS NULL
+	`
+
+	_, err := r.db.ExecContext(ctx, query, traceID, id)
+	return err
+}
+
+func (r *subscriptionRepository) GetActiveSubscriptionCount(ctx context.Context) (int, error) {
+	query := `
+		SELECT COUNT(*) 
+		FROM subscriptions 
+		WHERE status IN ('active', 'trialing') 
+		AND cancel_at_period_end = false
+	`
+
+	var count int
+	err := r.db.QueryRowContext(ctx, query).Scan(&count)
+	return count, err
+}
+
+// CountActiveSubscriptionsByUserID counts the user's active and trialing
+// subscriptions, plus new ones awaiting their first charge, without loading them
+func (r *subscriptionRepository) CountActiveSubscriptionsByUserID(ctx context.Context, userID uuid.UUID) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM subscriptions
+		WHERE user_id = $1 AND status IN ('active', 'trialing', 'incomplete')
+	`
+
+	var count int
+	err := r.db.QueryRowContext(ctx, query, userID).Scan(&count)
+	return count, err
+}
+
+// CountByStatus counts subscriptions in each status with one grouped query.
+// Statuses with no subscriptions are absent from the map.
+func (r *subscriptionRepository) CountByStatus(ctx context.Context) (map[models.SubscriptionStatus]int, error) {
+	query := `
+		SELECT status, COUNT(*)
+		FROM subscriptions
+		GROUP BY status
 	`
 
-	rows, err := r.db.QueryContext(ctx, query, cutoffTime)
+	rows, err := r.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var subscriptions []models.Subscription
+	counts := make(map[models.SubscriptionStatus]int)
 	for rows.Next() {
-		var (
-			subscription models.Subscription
-			metadataJSON sql.NullString
-			planID       sql.NullString
-			cardID       sql.NullString
-		)
+		var status models.SubscriptionStatus
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		counts[status] = count
+	}
+	return counts, rows.Err()
+}
 
-		err := rows.Scan(
-			&subscription.ID,
-			&subscription.UserID,
-			&planID,
-			&cardID,
-			&subscription.PlanName,
-			&subscription.Amount,
-			&subscription.Currency,
-			&subscription.Status,
-			&subscription.Interval,
-			&subscription.CurrentPeriodStart,
-			&subscription.CurrentPeriodEnd,
-			&subscription.TrialStart,
-			&subscription.TrialEnd,
-			&subscription.CancelAtPeriodEnd,
-			&subscription.CanceledAt,
-			&metadataJSON,
-			&subscription.BillingCycleAnchor,
-			&subscription.NextBillingAt,
-			&subscription.CreatedAt,
-		)
+func (r *subscriptionRepository) querySubscriptions(ctx context.Context, query string, args ...interface{}) ([]models.Subscription, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscriptions []models.Subscription
+	for rows.Next() {
+		subscription, err := scanSubscription(rows)
 		if err != nil {
 			return nil, err
 		}
+		subscriptions = append(subscriptions, *subscription)
+	}
 
-		// Parse UUIDs
-		if planID.Valid {
-			if parsedID, err := uuid.Parse(planID.String); err == nil {
-				subscription.PlanID = uuid.NullUUID{UUID: parsedID, Valid: true}
-			}
+	return subscriptions, rows.Err()
+}
+
+func scanSubscription(row rowScanner) (*models.Subscription, error) {
+	var (
+		subscription   models.Subscription
+		metadataJSON   sql.NullString
+		planID         sql.NullString
+		cardID         sql.NullString
+		initialTraceID sql.NullString
+		cancelReason   sql.NullString
+		cancelComment  sql.NullString
+	)
+
+	err := row.Scan(
+		&subscription.ID,
+		&subscription.UserID,
+		&planID,
+		&cardID,
+		&subscription.PlanName,
+		&subscription.Amount,
+		&subscription.Currency,
+		&subscription.Status,
+		&subscription.Interval,
+		&subscription.CurrentPeriodStart,
+		&subscription.CurrentPeriodEnd,
+		&subscription.TrialStart,
+		&subscription.TrialEnd,
+		&subscription.CancelAtPeriodEnd,
+		&subscription.CanceledAt,
+		&metadataJSON,
+		&subscription.BillingCycleAnchor,
+		&subscription.NextBillingAt,
+		&initialTraceID,
+		&cancelReason,
+		&cancelComment,
+		&subscription.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse UUIDs
+	if planID.Valid {
+		if parsedID, err := uuid.Parse(planID.String); err == nil {
+			subscription.PlanID = uuid.NullUUID{UUID: parsedID, Valid: true}
 		}
-		if cardID.Valid {
-			if parsedID, err := uuid.Parse(cardID.String); err == nil {
-				subscription.CardID = uuid.NullUUID{UUID: parsedID, Valid: true}
-			}
+	}
+	if cardID.Valid {
+		if parsedID, err := uuid.Parse(cardID.String); err == nil {
+			subscription.CardID = uuid.NullUUID{UUID: parsedID, Valid: true}
 		}
+	}
 
-		// Parse metadata
-		if metadataJSON.Valid && metadataJSON.String != "" {
-			metadata := make(map[string]string)
-			if err := json.Unmarshal([]byte(metadataJSON.String), &metadata); err == nil {
-				subscription.Metadata = metadata
-			}
+	subscription.InitialTraceID = initialTraceID.String
+	subscription.CancellationReason = models.CancellationReason(cancelReason.String)
+	subscription.CancellationComment = cancelComment.String
+
+	// Parse metadata
+	if metadataJSON.Valid && metadataJSON.String != "" {
+		metadata := make(map[string]string)
+		if err := json.Unmarshal([]byte(metadataJSON.String), &metadata); err == nil {
+			subscription.Metadata = metadata
 		}
+	}
 
-		subscriptions = append(subscriptions, subscription)
+	return &subscription, nil
+}
+
+// HasHadTrialOrPaidPeriod reports whether the user has ever started a trial
+// or a paid billing period, on the given plan or, when planID is not valid,
+// on any plan
+func (r *subscriptionRepository) HasHadTrialOrPaidPeriod(ctx context.Context, userID uuid.UUID, planID uuid.NullUUID) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM subscriptions
+			WHERE user_id = $1
+			  AND ($2::uuid IS NULL OR plan_id = $2)
+			  AND (trial_start IS NOT NULL
+			       OR current_period_start IS NOT NULL
+			       OR status IN ('trialing', 'active'))
+		)
+	`
+
+	var exists bool
+	err := r.db.QueryRowContext(ctx, query, userID, planID).Scan(&exists)
+	return exists, err
+}
+
+// ExpireIncompleteSubscriptions marks subscriptions still waiting for their
+// first successful charge since before createdBefore as incomplete_expired and
+// returns their IDs
+func (r *subscriptionRepository) ExpireIncompleteSubscriptions(ctx context.Context, createdBefore time.Time) ([]uuid.UUID, error) {
+	query := `
+		UPDATE subscriptions
+		SET status = 'incomplete_expired'
+		WHERE status = 'incomplete' AND created_at < $1
+		RETURNING id
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, createdBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
 	}
 
-	return subscriptions, nil
+	return ids, rows.Err()
 }
 
-func (r *subscriptionRepository) GetActiveSubscriptionCount(ctx context.Context) (int, error) {
+// ClaimRenewalReminders marks up to limit subscriptions on one of intervals
+// that renew within window as reminded, and returns them. A subscription is
+// claimed once per renewal: a reminder sent before the current window opened
+// was for an earlier renewal.
+func (r *subscriptionRepository) ClaimRenewalReminders(ctx context.Context, intervals []string, window time.Duration, limit int) ([]models.Subscription, error) {
 	query := `
-		SELECT COUNT(*) 
-		FROM subscriptions 
-		WHERE status IN ('active', 'trialing') 
-		AND cancel_at_period_end = false
+		UPDATE subscriptions
+		SET renewal_reminded_at = CURRENT_TIMESTAMP
+		WHERE id IN (
+			SELECT id
+			FROM subscriptions
+			WHERE
+				status IN ('active', 'trialing')
+				AND cancel_at_period_end = false
+				AND interval = ANY($1)
+				AND next_billing_at > CURRENT_TIMESTAMP
+				AND next_billing_at <= $2
+				AND (renewal_reminded_at IS NULL OR renewal_reminded_at < next_billing_at - $3 * INTERVAL '1 second')
+			ORDER BY next_billing_at ASC
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + subscriptionColumns + `
 	`
 
-	var count int
-	err := r.db.QueryRowContext(ctx, query).Scan(&count)
-	return count, err
+	return r.querySubscriptions(ctx, query, pq.Array(intervals), time.Now().Add(window), window.Seconds(), limit)
+}
+
+// UpdateCardForUser moves all of a user's active and past-due subscriptions
+// to cardID in a single statement and returns how many were updated
+func (r *subscriptionRepository) UpdateCardForUser(ctx context.Context, userID, cardID uuid.UUID) (int, error) {
+	query := `
+		UPDATE subscriptions
+		SET card_id = $2
+		WHERE user_id = $1 AND status IN ('active', 'past_due')
+	`
+
+	result, err := r.db.ExecContext(ctx, query, userID, cardID)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(rows), nil
 }
@@ -0,0 +1,41 @@
+package repositories
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	"pg-backend/internal/database"
+)
+
+// testDatabaseURLEnv names a Postgres database, with the schema and
+// migrations applied, for repository tests. They are skipped without it.
+const testDatabaseURLEnv = "TEST_DATABASE_URL"
+
+// openTestDB connects database.DB, which the repository constructors use, to
+// the test database for the duration of t
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	url := os.Getenv(testDatabaseURLEnv)
+	if url == "" {
+		t.Skipf("%s is not set", testDatabaseURLEnv)
+	}
+
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatalf("open test database: %v", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		t.Fatalf("connect to test database: %v", err)
+	}
+
+	previous := database.DB
+	database.DB = db
+	t.Cleanup(func() {
+		database.DB = previous
+		db.Close()
+	})
+	return db
+}
@@ -5,24 +5,57 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
+	"math"
 	"pg-backend/internal/database"
 	"pg-backend/internal/models"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 type TransactionRepository interface {
 	CreateTransaction(ctx context.Context, transaction *models.Transaction) error
 	GetTransactionByID(ctx context.Context, id uuid.UUID) (*models.Transaction, error)
-	GetTransactionsByUserID(ctx context.Context, userID uuid.UUID) ([]models.Transaction, error)
+	GetTransactionsByUserID(ctx context.Context, userID uuid.UUID, filter TransactionFilter) ([]models.Transaction, error)
 	GetTransactionsByCardID(ctx context.Context, cardID uuid.UUID) ([]models.Transaction, error)
 
 	//NEW
-	GetTransactionsBySubscriptionID(ctx context.Context, subscriptionID uuid.UUID) ([]models.Transaction, error)
+	GetTransactionsBySubscriptionID(ctx context.Context, subscriptionID uuid.UUID, transactionType models.TransactionType, limit, offset int) ([]models.Transaction, error)
 	GetTransactionsByBillingAttemptID(ctx context.Context, billingAttemptID uuid.UUID) ([]models.Transaction, error)
 	CreateSubscriptionTransaction(ctx context.Context, transaction *models.Transaction, subscriptionID, billingAttemptID uuid.UUID) error
+	UpdateStatus(ctx context.Context, audit *models.TransactionStatusAudit) error
+	GetTransactionsByMerchantReference(ctx context.Context, reference string) ([]models.Transaction, error)
+	GetGatewayResponse(ctx context.Context, id uuid.UUID) (json.RawMessage, error)
+	GetTransactionsByGatewayOrderID(ctx context.Context, orderID string) ([]models.Transaction, error)
+	GetChargeByGatewayOrderID(ctx context.Context, orderID string) (*models.Transaction, error)
+	GetAuthorizationByGatewayOrderID(ctx context.Context, orderID string) (*models.Transaction, error)
+	GetAuthorizationBalance(ctx context.Context, authorization *models.Transaction) (*models.AuthorizationBalance, error)
+	GetRelatedTransactions(ctx context.Context, transaction *models.Transaction) ([]models.Transaction, error)
+	GetRefundableCharges(ctx context.Context, userID uuid.UUID) ([]models.Transaction, error)
+	GetRefundedAmountsByOrder(ctx context.Context, orderIDs []string) (map[string]float64, error)
+	GetTransactionsForExport(ctx context.Context, userID uuid.UUID, from, to sql.NullTime, after *models.ExportedTransaction, limit int) ([]models.ExportedTransaction, error)
+	CountByPaymentMethod(ctx context.Context, userID uuid.UUID) ([]models.PaymentMethodVolume, error)
 }
 
+// TransactionFilter narrows a transaction listing; empty fields match everything
+type TransactionFilter struct {
+	PaymentMethodType string // "card" also matches transactions saved without a type
+	WalletProvider    string
+}
+
+// transactionPaymentMethod is the payment method type of a transaction row.
+// Card payments have historically been stored without one.
+const transactionPaymentMethod = `COALESCE(NULLIF(payment_method_type, ''), 'card')`
+
+const transactionColumns = `
+			id, user_id, card_id, subscription_id, billing_attempt_id, invoice_id,
+			amount, currency, status, gateway_transaction_id, type, wallet_provider,
+			payment_method_type, device_payment_data, merchant_reference, gateway_order_id,
+			parent_transaction_id, settlement_currency, settlement_amount, fx_rate,
+			routing_key, statement_descriptor, correlation_id, gateway_correlation_id,
+			created_at`
+
 type transactionRepository struct {
 	db *sql.DB
 }
@@ -34,27 +67,27 @@ func NewTransactionRepository() TransactionRepository {
 }
 
 func (r *transactionRepository) CreateTransaction(ctx context.Context, transaction *models.Transaction) error {
+	if !transaction.Type.Valid() {
+		return fmt.Errorf("invalid transaction type %q", transaction.Type)
+	}
+
 	query := `
 		INSERT INTO transactions 
 		(user_id, card_id, amount, currency, status, gateway_transaction_id, type,
-		 wallet_provider, payment_method_type, device_payment_data)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		 wallet_provider, payment_method_type, device_payment_data, merchant_reference,
+		 gateway_order_id, gateway_response, parent_transaction_id,
+		 settlement_currency, settlement_amount, fx_rate, routing_key, statement_descriptor,
+		 correlation_id, gateway_correlation_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21)
 		RETURNING id, created_at
 	`
 
-	// Convert device payment data to JSON
-	var devicePaymentDataJSON interface{}
-	if transaction.DevicePaymentData != nil {
-		jsonData, err := json.Marshal(transaction.DevicePaymentData)
-		if err != nil {
-			return err
-		}
-		devicePaymentDataJSON = string(jsonData)
-	} else {
-		devicePaymentDataJSON = nil
+	devicePaymentDataJSON, err := marshalDevicePaymentData(transaction.DevicePaymentData)
+	if err != nil {
+		return err
 	}
 
-	err := r.db.QueryRowContext(ctx, query,
+	err = r.db.QueryRowContext(ctx, query,
 		transaction.UserID,
 		transaction.CardID,
 		transaction.Amount,
@@ -65,6 +98,17 @@ func (r *transactionRepository) CreateTransaction(ctx context.Context, transacti
 		transaction.WalletProvider,
 		transaction.PaymentMethodType,
 		devicePaymentDataJSON,
+		nullIfEmpty(transaction.MerchantReference),
+		nullIfEmpty(transaction.GatewayOrderID),
+		nullIfEmpty(string(transaction.GatewayResponse)),
+		transaction.ParentTransactionID,
+		nullIfEmpty(transaction.SettlementCurrency),
+		sql.NullFloat64{Float64: transaction.SettlementAmount, Valid: transaction.SettlementCurrency != ""},
+		sql.NullFloat64{Float64: transaction.FXRate, Valid: transaction.SettlementCurrency != ""},
+		nullIfEmpty(transaction.RoutingKey),
+		nullIfEmpty(transaction.StatementDescriptor),
+		nullIfEmpty(transaction.CorrelationID),
+		nullIfEmpty(transaction.GatewayCorrelationID),
 	).Scan(&transaction.ID, &transaction.CreatedAt)
 
 	return err
@@ -72,32 +116,12 @@ func (r *transactionRepository) CreateTransaction(ctx context.Context, transacti
 
 func (r *transactionRepository) GetTransactionByID(ctx context.Context, id uuid.UUID) (*models.Transaction, error) {
 	query := `
-		SELECT id, user_id, card_id, amount, currency, status, 
-		       gateway_transaction_id, type, wallet_provider, payment_method_type,
-		       device_payment_data, created_at
+		SELECT ` + transactionColumns + `
 		FROM transactions
 		WHERE id = $1
 	`
 
-	transaction := &models.Transaction{}
-	var devicePaymentDataJSON sql.NullString
-	var walletProvider, paymentMethodType sql.NullString
-
-	err := r.db.QueryRowContext(ctx, query, id).Scan(
-		&transaction.ID,
-		&transaction.UserID,
-		&transaction.CardID,
-		&transaction.Amount,
-		&transaction.Currency,
-		&transaction.Status,
-		&transaction.GatewayTransactionID,
-		&transaction.Type,
-		&walletProvider,
-		&paymentMethodType,
-		&devicePaymentDataJSON,
-		&transaction.CreatedAt,
-	)
-
+	transaction, err := scanTransaction(r.db.QueryRowContext(ctx, query, id))
 	if err == sql.ErrNoRows {
 		return nil, &NotFoundError{Message: "transaction not found"}
 	}
@@ -105,304 +129,284 @@ func (r *transactionRepository) GetTransactionByID(ctx context.Context, id uuid.
 		return nil, err
 	}
 
-	// Parse nullable strings
-	if walletProvider.Valid {
-		transaction.WalletProvider = walletProvider.String
-	}
-	if paymentMethodType.Valid {
-		transaction.PaymentMethodType = paymentMethodType.String
-	}
-
-	// Parse device payment data
-	if devicePaymentDataJSON.Valid && devicePaymentDataJSON.String != "" {
-		var deviceData map[string]interface{}
-		if err := json.Unmarshal([]byte(devicePaymentDataJSON.String), &deviceData); err == nil {
-			transaction.DevicePaymentData = deviceData
-		}
-	}
-
 	return transaction, nil
 }
 
-func (r *transactionRepository) GetTransactionsByUserID(ctx context.Context, userID uuid.UUID) ([]models.Transaction, error) {
+func (r *transactionRepository) GetTransactionsByUserID(ctx context.Context, userID uuid.UUID, filter TransactionFilter) ([]models.Transaction, error) {
 	query := `
-		SELECT id, user_id, card_id, amount, currency, status, 
-		       gateway_transaction_id, type, wallet_provider, payment_method_type,
-		       device_payment_data, created_at
+		SELECT ` + transactionColumns + `
 		FROM transactions
 		WHERE user_id = $1
+		  AND ($2 = '' OR ` + transactionPaymentMethod + ` = $2)
+		  AND ($3 = '' OR wallet_provider = $3)
 		ORDER BY created_at DESC
 	`
 
+	return r.queryTransactions(ctx, query, userID, filter.PaymentMethodType, filter.WalletProvider)
+}
+
+// CountByPaymentMethod totals the user's successful charges by payment method
+// and currency, largest volume first. Refunds, voids and uncaptured
+// authorizations are not counted.
+func (r *transactionRepository) CountByPaymentMethod(ctx context.Context, userID uuid.UUID) ([]models.PaymentMethodVolume, error) {
+	query := `
+		SELECT ` + transactionPaymentMethod + `, COALESCE(wallet_provider, ''),
+		       currency, COUNT(*), COALESCE(SUM(amount), 0)
+		FROM transactions
+		WHERE user_id = $1
+		  AND type IN ('manual', 'recurring', 'capture', 'test', 'setup_fee', 'proration')
+		  AND status NOT IN ('pending', 'failed')
+		GROUP BY 1, 2, 3
+		ORDER BY 5 DESC, 1, 2, 3
+	`
+
 	rows, err := r.db.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var transactions []models.Transaction
+	volumes := []models.PaymentMethodVolume{}
 	for rows.Next() {
-		var transaction models.Transaction
-		var devicePaymentDataJSON sql.NullString
-		var walletProvider, paymentMethodType sql.NullString
-
-		err := rows.Scan(
-			&transaction.ID,
-			&transaction.UserID,
-			&transaction.CardID,
-			&transaction.Amount,
-			&transaction.Currency,
-			&transaction.Status,
-			&transaction.GatewayTransactionID,
-			&transaction.Type,
-			&walletProvider,
-			&paymentMethodType,
-			&devicePaymentDataJSON,
-			&transaction.CreatedAt,
-		)
-		if err != nil {
+		var volume models.PaymentMethodVolume
+		if err := rows.Scan(
+			&volume.PaymentMethodType,
+			&volume.WalletProvider,
+			&volume.Currency,
+			&volume.Count,
+			&volume.Amount,
+		); err != nil {
 			return nil, err
 		}
-
-		// Parse nullable strings
-		if walletProvider.Valid {
-			transaction.WalletProvider = walletProvider.String
-		}
-		if paymentMethodType.Valid {
-			transaction.PaymentMethodType = paymentMethodType.String
-		}
-
-		// Parse device payment data
-		if devicePaymentDataJSON.Valid && devicePaymentDataJSON.String != "" {
-			var deviceData map[string]interface{}
-			if err := json.Unmarshal([]byte(devicePaymentDataJSON.String), &deviceData); err == nil {
-				transaction.DevicePaymentData = deviceData
-			}
-		}
-
-		transactions = append(transactions, transaction)
+		volumes = append(volumes, volume)
 	}
 
-	return transactions, nil
+	return volumes, rows.Err()
 }
 
 func (r *transactionRepository) GetTransactionsByCardID(ctx context.Context, cardID uuid.UUID) ([]models.Transaction, error) {
 	query := `
-		SELECT id, user_id, card_id, amount, currency, status, 
-		       gateway_transaction_id, type, wallet_provider, payment_method_type,
-		       device_payment_data, created_at
+		SELECT ` + transactionColumns + `
 		FROM transactions
 		WHERE card_id = $1
 		ORDER BY created_at DESC
 	`
 
-	rows, err := r.db.QueryContext(ctx, query, cardID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+	return r.queryTransactions(ctx, query, cardID)
+}
 
-	var transactions []models.Transaction
-	for rows.Next() {
-		var transaction models.Transaction
-		var devicePaymentDataJSON sql.NullString
-		var walletProvider, paymentMethodType sql.NullString
-
-		err := rows.Scan(
-			&transaction.ID,
-			&transaction.UserID,
-			&transaction.CardID,
-			&transaction.Amount,
-			&transaction.Currency,
-			&transaction.Status,
-			&transaction.GatewayTransactionID,
-			&transaction.Type,
-			&walletProvider,
-			&paymentMethodType,
-			&devicePaymentDataJSON,
-			&transaction.CreatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
+// GetTransactionsBySubscriptionID returns a page of a subscription's
+// transactions, newest first, optionally only those of one type
+func (r *transactionRepository) GetTransactionsBySubscriptionID(ctx context.Context, subscriptionID uuid.UUID, transactionType models.TransactionType, limit, offset int) ([]models.Transaction, error) {
+	query := `
+		SELECT ` + transactionColumns + `
+		FROM transactions
+		WHERE subscription_id = $1 AND ($2 = '' OR type = $2)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3 OFFSET $4
+	`
 
-		// Parse nullable strings
-		if walletProvider.Valid {
-			transaction.WalletProvider = walletProvider.String
-		}
-		if paymentMethodType.Valid {
-			transaction.PaymentMethodType = paymentMethodType.String
-		}
+	return r.queryTransactions(ctx, query, subscriptionID, transactionType, limit, offset)
+}
 
-		// Parse device payment data
-		if devicePaymentDataJSON.Valid && devicePaymentDataJSON.String != "" {
-			var deviceData map[string]interface{}
-			if err := json.Unmarshal([]byte(devicePaymentDataJSON.String), &deviceData); err == nil {
-				transaction.DevicePaymentData = deviceData
-			}
-		}
+func (r *transactionRepository) GetTransactionsByBillingAttemptID(ctx context.Context, billingAttemptID uuid.UUID) ([]models.Transaction, error) {
+	query := `
+		SELECT ` + transactionColumns + `
+		FROM transactions
+		WHERE billing_attempt_id = $1
+		ORDER BY created_at DESC
+	`
+
+	return r.queryTransactions(ctx, query, billingAttemptID)
+}
 
-		transactions = append(transactions, transaction)
+// GetTransactionsByMerchantReference returns every transaction recorded
+// against the merchant's own order reference
+func (r *transactionRepository) GetTransactionsByMerchantReference(ctx context.Context, reference string) ([]models.Transaction, error) {
+	query := `
+		SELECT ` + transactionColumns + `
+		FROM transactions
+		WHERE merchant_reference = $1
+		ORDER BY created_at DESC
+	`
+
+	return r.queryTransactions(ctx, query, reference)
+}
+
+// GetTransactionsByGatewayOrderID returns every transaction recorded against a
+// gateway order, such as its authorization, captures and refunds, oldest first
+func (r *transactionRepository) GetTransactionsByGatewayOrderID(ctx context.Context, orderID string) ([]models.Transaction, error) {
+	query := `
+		SELECT ` + transactionColumns + `
+		FROM transactions
+		WHERE gateway_order_id = $1
+		ORDER BY created_at ASC
+	`
+
+	return r.queryTransactions(ctx, query, orderID)
+}
+
+// GetRelatedTransactions returns the transaction together with everything
+// linked to it through its gateway order or parent authorization, oldest first
+func (r *transactionRepository) GetRelatedTransactions(ctx context.Context, transaction *models.Transaction) ([]models.Transaction, error) {
+	rootID := transaction.ID
+	if transaction.ParentTransactionID.Valid {
+		rootID = transaction.ParentTransactionID.UUID
 	}
 
-	return transactions, nil
+	query := `
+		SELECT ` + transactionColumns + `
+		FROM transactions
+		WHERE id = $1
+		   OR id = $2
+		   OR parent_transaction_id = $2
+		   OR ($3 <> '' AND gateway_order_id = $3)
+		ORDER BY created_at ASC, id ASC
+	`
+
+	return r.queryTransactions(ctx, query, transaction.ID, rootID, transaction.GatewayOrderID)
 }
 
-func (r *transactionRepository) GetTransactionsBySubscriptionID(ctx context.Context, subscriptionID uuid.UUID) ([]models.Transaction, error) {
+// GetRefundableCharges returns the user's successful payments and captures
+// on a gateway order, oldest first. Refunds already made against them are
+// not taken into account; see GetRefundedAmountsByOrder.
+func (r *transactionRepository) GetRefundableCharges(ctx context.Context, userID uuid.UUID) ([]models.Transaction, error) {
 	query := `
-		SELECT 
-			id, user_id, card_id, subscription_id, billing_attempt_id, invoice_id,
-			amount, currency, status, gateway_transaction_id, type, wallet_provider,
-			payment_method_type, device_payment_data, created_at
+		SELECT ` + transactionColumns + `
 		FROM transactions
-		WHERE subscription_id = $1
-		ORDER BY created_at DESC
+		WHERE user_id = $1
+		  AND type IN ('manual', 'recurring', 'capture', 'test', 'setup_fee', 'proration')
+		  AND status NOT IN ('pending', 'failed', 'disputed')
+		  AND gateway_order_id IS NOT NULL
+		  AND amount > 0
+		ORDER BY created_at ASC, id ASC
+	`
+
+	return r.queryTransactions(ctx, query, userID)
+}
+
+// GetRefundedAmountsByOrder totals the refunds recorded against each gateway
+// order. Orders without refunds are absent from the map.
+func (r *transactionRepository) GetRefundedAmountsByOrder(ctx context.Context, orderIDs []string) (map[string]float64, error) {
+	query := `
+		SELECT gateway_order_id, SUM(amount)
+		FROM transactions
+		WHERE type = 'refund'
+		  AND status NOT IN ('pending', 'failed')
+		  AND gateway_order_id = ANY($1)
+		GROUP BY gateway_order_id
 	`
 
-	rows, err := r.db.QueryContext(ctx, query, subscriptionID)
+	rows, err := r.db.QueryContext(ctx, query, pq.Array(orderIDs))
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var transactions []models.Transaction
+	refunded := make(map[string]float64)
 	for rows.Next() {
-		var transaction models.Transaction
-		var devicePaymentDataJSON sql.NullString
-		var walletProvider, paymentMethodType sql.NullString
-
-		err := rows.Scan(
-			&transaction.ID,
-			&transaction.UserID,
-			&transaction.CardID,
-			&transaction.SubscriptionID,
-			&transaction.BillingAttemptID,
-			&transaction.InvoiceID,
-			&transaction.Amount,
-			&transaction.Currency,
-			&transaction.Status,
-			&transaction.GatewayTransactionID,
-			&transaction.Type,
-			&walletProvider,
-			&paymentMethodType,
-			&devicePaymentDataJSON,
-			&transaction.CreatedAt,
-		)
-		if err != nil {
+		var orderID string
+		var amount float64
+		if err := rows.Scan(&orderID, &amount); err != nil {
 			return nil, err
 		}
+		refunded[orderID] = roundAmount(amount)
+	}
+	return refunded, rows.Err()
+}
 
-		// Parse nullable strings
-		if walletProvider.Valid {
-			transaction.WalletProvider = walletProvider.String
-		}
-		if paymentMethodType.Valid {
-			transaction.PaymentMethodType = paymentMethodType.String
-		}
-
-		// Parse device payment data
-		if devicePaymentDataJSON.Valid && devicePaymentDataJSON.String != "" {
-			var deviceData map[string]interface{}
-			if err := json.Unmarshal([]byte(devicePaymentDataJSON.String), &deviceData); err == nil {
-				transaction.DevicePaymentData = deviceData
-			}
-		}
+// GetChargeByGatewayOrderID returns the latest transaction that moved money
+// to the merchant on a gateway order, ignoring refunds and voids
+func (r *transactionRepository) GetChargeByGatewayOrderID(ctx context.Context, orderID string) (*models.Transaction, error) {
+	query := `
+		SELECT ` + transactionColumns + `
+		FROM transactions
+		WHERE gateway_order_id = $1 AND type NOT IN ('refund', 'void')
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
 
-		transactions = append(transactions, transaction)
+	transaction, err := scanTransaction(r.db.QueryRowContext(ctx, query, orderID))
+	if err == sql.ErrNoRows {
+		return nil, &NotFoundError{Message: "transaction not found"}
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	return transactions, nil
+	return transaction, nil
 }
 
-func (r *transactionRepository) GetTransactionsByBillingAttemptID(ctx context.Context, billingAttemptID uuid.UUID) ([]models.Transaction, error) {
+// GetAuthorizationByGatewayOrderID returns the authorization recorded for a gateway order
+func (r *transactionRepository) GetAuthorizationByGatewayOrderID(ctx context.Context, orderID string) (*models.Transaction, error) {
 	query := `
-		SELECT 
-			id, user_id, card_id, subscription_id, billing_attempt_id, invoice_id,
-			amount, currency, status, gateway_transaction_id, type, wallet_provider,
-			payment_method_type, device_payment_data, created_at
+		SELECT ` + transactionColumns + `
 		FROM transactions
-		WHERE billing_attempt_id = $1
+		WHERE gateway_order_id = $1 AND type = 'authorization'
 		ORDER BY created_at DESC
+		LIMIT 1
 	`
 
-	rows, err := r.db.QueryContext(ctx, query, billingAttemptID)
+	transaction, err := scanTransaction(r.db.QueryRowContext(ctx, query, orderID))
+	if err == sql.ErrNoRows {
+		return nil, &NotFoundError{Message: "authorization not found"}
+	}
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	var transactions []models.Transaction
-	for rows.Next() {
-		var transaction models.Transaction
-		var devicePaymentDataJSON sql.NullString
-		var walletProvider, paymentMethodType sql.NullString
-
-		err := rows.Scan(
-			&transaction.ID,
-			&transaction.UserID,
-			&transaction.CardID,
-			&transaction.SubscriptionID,
-			&transaction.BillingAttemptID,
-			&transaction.InvoiceID,
-			&transaction.Amount,
-			&transaction.Currency,
-			&transaction.Status,
-			&transaction.GatewayTransactionID,
-			&transaction.Type,
-			&walletProvider,
-			&paymentMethodType,
-			&devicePaymentDataJSON,
-			&transaction.CreatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
-
-		// Parse nullable strings
-		if walletProvider.Valid {
-			transaction.WalletProvider = walletProvider.String
-		}
-		if paymentMethodType.Valid {
-			transaction.PaymentMethodType = paymentMethodType.String
-		}
+	return transaction, nil
+}
 
-		// Parse device payment data
-		if devicePaymentDataJSON.Valid && devicePaymentDataJSON.String != "" {
-			var deviceData map[string]interface{}
-			if err := json.Unmarshal([]byte(devicePaymentDataJSON.String), &deviceData); err == nil {
-				transaction.DevicePaymentData = deviceData
-			}
-		}
+// GetAuthorizationBalance totals the captures and voids linked to an authorization
+func (r *transactionRepository) GetAuthorizationBalance(ctx context.Context, authorization *models.Transaction) (*models.AuthorizationBalance, error) {
+	query := `
+		SELECT COALESCE(SUM(amount) FILTER (WHERE type = 'capture'), 0),
+		       COUNT(*) FILTER (WHERE type = 'capture'),
+		       COUNT(*) FILTER (WHERE type = 'void') > 0
+		FROM transactions
+		WHERE parent_transaction_id = $1
+	`
 
-		transactions = append(transactions, transaction)
+	balance := &models.AuthorizationBalance{
+		AuthorizationID: authorization.ID,
+		OrderID:         authorization.GatewayOrderID,
+		Authorized:      authorization.Amount,
+		Currency:        authorization.Currency,
+	}
+	err := r.db.QueryRowContext(ctx, query, authorization.ID).Scan(&balance.Captured, &balance.Captures, &balance.Voided)
+	if err != nil {
+		return nil, err
 	}
 
-	return transactions, nil
+	balance.Captured = roundAmount(balance.Captured)
+	if !balance.Voided {
+		balance.Remaining = math.Max(roundAmount(balance.Authorized-balance.Captured), 0)
+	}
+	return balance, nil
 }
 
 func (r *transactionRepository) CreateSubscriptionTransaction(ctx context.Context, transaction *models.Transaction, subscriptionID, billingAttemptID uuid.UUID) error {
+	if !transaction.Type.Valid() {
+		return fmt.Errorf("invalid transaction type %q", transaction.Type)
+	}
+
 	query := `
 		INSERT INTO transactions 
 		(user_id, card_id, subscription_id, billing_attempt_id, invoice_id,
 		 amount, currency, status, gateway_transaction_id, type, wallet_provider,
-		 payment_method_type, device_payment_data)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		 payment_method_type, device_payment_data, merchant_reference, gateway_order_id,
+		 gateway_response, correlation_id, gateway_correlation_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
 		RETURNING id, created_at
 	`
 
-	// Convert device payment data to JSON
-	var devicePaymentDataJSON interface{}
-	if transaction.DevicePaymentData != nil {
-		jsonData, err := json.Marshal(transaction.DevicePaymentData)
-		if err != nil {
-			return err
-		}
-		devicePaymentDataJSON = string(jsonData)
-	} else {
-		devicePaymentDataJSON = nil
+	devicePaymentDataJSON, err := marshalDevicePaymentData(transaction.DevicePaymentData)
+	if err != nil {
+		return err
 	}
 
-	err := r.db.QueryRowContext(ctx, query,
+	err = r.db.QueryRowContext(ctx, query,
 		transaction.UserID,
 		transaction.CardID,
 		subscriptionID,
@@ -416,7 +420,228 @@ func (r *transactionRepository) CreateSubscriptionTransaction(ctx context.Contex
 		transaction.WalletProvider,
 		transaction.PaymentMethodType,
 		devicePaymentDataJSON,
+		nullIfEmpty(transaction.MerchantReference),
+		nullIfEmpty(transaction.GatewayOrderID),
+		nullIfEmpty(string(transaction.GatewayResponse)),
+		nullIfEmpty(transaction.CorrelationID),
+		nullIfEmpty(transaction.GatewayCorrelationID),
 	).Scan(&transaction.ID, &transaction.CreatedAt)
 
 	return err
 }
+
+// GetGatewayResponse returns the stored gateway payload for a transaction, or
+// nil if none was recorded
+func (r *transactionRepository) GetGatewayResponse(ctx context.Context, id uuid.UUID) (json.RawMessage, error) {
+	query := `
+		SELECT gateway_response
+		FROM transactions
+		WHERE id = $1
+	`
+
+	var response sql.NullString
+	err := r.db.QueryRowContext(ctx, query, id).Scan(&response)
+	if err == sql.ErrNoRows {
+		return nil, &NotFoundError{Message: "transaction not found"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !response.Valid {
+		return nil, nil
+	}
+
+	return json.RawMessage(response.String), nil
+}
+
+// UpdateStatus moves a transaction from audit.FromStatus to audit.ToStatus and
+// records the audit entry in the same database transaction. It returns a
+// ConflictError if the status changed since it was read.
+func (r *transactionRepository) UpdateStatus(ctx context.Context, audit *models.TransactionStatusAudit) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		`UPDATE transactions SET status = $1 WHERE id = $2 AND status = $3`,
+		audit.ToStatus, audit.TransactionID, audit.FromStatus,
+	)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return &ConflictError{Message: "transaction status has changed"}
+	}
+
+	query := `
+		INSERT INTO transaction_status_audits
+		(transaction_id, from_status, to_status, changed_by, reason)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`
+
+	err = tx.QueryRowContext(ctx, query,
+		audit.TransactionID,
+		audit.FromStatus,
+		audit.ToStatus,
+		audit.ChangedBy,
+		audit.Reason,
+	).Scan(&audit.ID, &audit.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetTransactionsForExport returns up to limit of a user's transactions
+// created in [from, to), oldest first, with the card each was made on. Pass
+// the last transaction of the previous page as after to read the next one;
+// nil starts from the beginning.
+func (r *transactionRepository) GetTransactionsForExport(ctx context.Context, userID uuid.UUID, from, to sql.NullTime, after *models.ExportedTransaction, limit int) ([]models.ExportedTransaction, error) {
+	query := `
+		SELECT ` + transactionColumns + `, card_scheme, card_last_four
+		FROM transactions
+		LEFT JOIN LATERAL (
+			SELECT scheme AS card_scheme, last_four AS card_last_four
+			FROM cards
+			WHERE cards.id = transactions.card_id
+		) card ON true
+		WHERE user_id = $1
+		AND ($2::timestamptz IS NULL OR created_at >= $2)
+		AND ($3::timestamptz IS NULL OR created_at < $3)
+		AND ($4::timestamptz IS NULL OR (created_at, id) > ($4, $5::uuid))
+		ORDER BY created_at, id
+		LIMIT $6
+	`
+
+	var afterCreatedAt sql.NullTime
+	afterID := uuid.Nil
+	if after != nil {
+		afterCreatedAt = sql.NullTime{Time: after.CreatedAt, Valid: true}
+		afterID = after.ID
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, userID, from, to, afterCreatedAt, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exported := []models.ExportedTransaction{}
+	for rows.Next() {
+		var scheme, lastFour sql.NullString
+		transaction, err := scanTransaction(extraColumnsScanner{row: rows, extra: []interface{}{&scheme, &lastFour}})
+		if err != nil {
+			return nil, err
+		}
+		exported = append(exported, models.ExportedTransaction{
+			Transaction:  *transaction,
+			CardScheme:   scheme.String,
+			CardLastFour: lastFour.String,
+		})
+	}
+
+	return exported, rows.Err()
+}
+
+func (r *transactionRepository) queryTransactions(ctx context.Context, query string, args ...interface{}) ([]models.Transaction, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var transactions []models.Transaction
+	for rows.Next() {
+		transaction, err := scanTransaction(rows)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, *transaction)
+	}
+
+	return transactions, rows.Err()
+}
+
+func scanTransaction(row rowScanner) (*models.Transaction, error) {
+	var transaction models.Transaction
+	var devicePaymentDataJSON sql.NullString
+	var walletProvider, paymentMethodType, merchantReference, gatewayOrderID sql.NullString
+	var settlementCurrency, routingKey, statementDescriptor sql.NullString
+	var correlationID, gatewayCorrelationID sql.NullString
+	var settlementAmount, fxRate sql.NullFloat64
+
+	err := row.Scan(
+		&transaction.ID,
+		&transaction.UserID,
+		&transaction.CardID,
+		&transaction.SubscriptionID,
+		&transaction.BillingAttemptID,
+		&transaction.InvoiceID,
+		&transaction.Amount,
+		&transaction.Currency,
+		&transaction.Status,
+		&transaction.GatewayTransactionID,
+		&transaction.Type,
+		&walletProvider,
+		&paymentMethodType,
+		&devicePaymentDataJSON,
+		&merchantReference,
+		&gatewayOrderID,
+		&transaction.ParentTransactionID,
+		&settlementCurrency,
+		&settlementAmount,
+		&fxRate,
+		&routingKey,
+		&statementDescriptor,
+		&correlationID,
+		&gatewayCorrelationID,
+		&transaction.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse nullable strings
+	transaction.WalletProvider = walletProvider.String
+	transaction.PaymentMethodType = paymentMethodType.String
+	transaction.MerchantReference = merchantReference.String
+	transaction.GatewayOrderID = gatewayOrderID.String
+	transaction.SettlementCurrency = settlementCurrency.String
+	transaction.SettlementAmount = settlementAmount.Float64
+	transaction.FXRate = fxRate.Float64
+	transaction.RoutingKey = routingKey.String
+	transaction.StatementDescriptor = statementDescriptor.String
+	transaction.CorrelationID = correlationID.String
+	transaction.GatewayCorrelationID = gatewayCorrelationID.String
+
+	// Parse device payment data
+	if devicePaymentDataJSON.Valid && devicePaymentDataJSON.String != "" {
+		var deviceData map[string]interface{}
+		if err := json.Unmarshal([]byte(devicePaymentDataJSON.String), &deviceData); err == nil {
+			transaction.DevicePaymentData = deviceData
+		}
+	}
+
+	return &transaction, nil
+}
+
+// marshalDevicePaymentData converts device payment data to JSON, or nil when absent
+func marshalDevicePaymentData(data map[string]interface{}) (interface{}, error) {
+	if data == nil {
+		return nil, nil
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return string(jsonData), nil
+}
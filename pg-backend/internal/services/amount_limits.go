@@ -0,0 +1,32 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"pg-backend/internal/config"
+)
+
+// AmountLimits caps single transaction amounts per currency, as set by the
+// merchant's risk team (see config.AmountLimits)
+type AmountLimits map[string]config.AmountLimit
+
+func NewAmountLimits(cfg *config.Config) AmountLimits {
+	return cfg.AmountLimits()
+}
+
+// Check returns a ValidationError on field when amount is outside the limits
+// set for currency. Currencies without limits are not checked.
+func (l AmountLimits) Check(field string, amount float64, currency string) error {
+	limit, ok := l[strings.ToUpper(currency)]
+	if !ok {
+		return nil
+	}
+	if limit.Max > 0 && amount > limit.Max {
+		return &ValidationError{Field: field, Message: fmt.Sprintf("amount exceeds the %.2f %s limit", limit.Max, strings.ToUpper(currency))}
+	}
+	if limit.Min > 0 && amount < limit.Min {
+		return &ValidationError{Field: field, Message: fmt.Sprintf("amount is below the %.2f %s minimum", limit.Min, strings.ToUpper(currency))}
+	}
+	return nil
+}
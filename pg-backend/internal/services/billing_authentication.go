@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"pg-backend/internal/models"
+	"pg-backend/internal/repositories"
+
+	"github.com/google/uuid"
+)
+
+// markRequiresAuthentication parks an attempt the issuer won't approve until
+// the cardholder completes 3-D Secure (SCA). The challenge reference is the
+// authentication transaction the customer completes on the attempt's gateway
+// order; the attempt then waits for CompleteAuthentication instead of being
+// retried.
+func markRequiresAuthentication(ctx context.Context, billingRepo repositories.BillingRepository, eventService EventService, subscription *models.Subscription, attempt *models.BillingAttempt, paymentResp *PaymentResponse) {
+	if paymentResp.Order.ID != "" {
+		attempt.GatewayOrderID = sql.NullString{String: paymentResp.Order.ID, Valid: true}
+	}
+	attempt.Status = models.BillingAttemptStatusRequiresAction
+	attempt.ErrorCode = sql.NullString{String: DeclineCodeAuthenticationRequired, Valid: true}
+	attempt.ErrorMessage = sql.NullString{String: declineErrorMessage(paymentResp.Result, paymentResp.GatewayCode), Valid: true}
+	attempt.ChallengeReference = sql.NullString{String: newChallengeReference(), Valid: true}
+	if err := billingRepo.UpdateBillingAttempt(ctx, attempt); err != nil {
+		fmt.Printf("Warning: Failed to record authentication challenge for attempt %s: %v\n", attempt.ID, err)
+		return
+	}
+
+	eventService.Publish(ctx, models.EventBillingAttemptRequiresAction, "billing_attempt", attempt.ID, map[string]interface{}{
+		"subscription_id":     subscription.ID,
+		"user_id":             subscription.UserID,
+		"gateway_order_id":    attempt.GatewayOrderID.String,
+		"challenge_reference": attempt.ChallengeReference.String,
+	})
+}
+
+// newChallengeReference returns a fresh gateway transaction ID for a 3-D
+// Secure authentication, e.g. "3ds-1f0c9a2b7d4e"
+func newChallengeReference() string {
+	return "3ds-" + strings.ReplaceAll(uuid.NewString(), "-", "")[:12]
+}
+
+// CompleteAuthentication resumes a billing attempt that was waiting on the
+// customer to complete a 3-D Secure challenge, once they have authenticated
+// under its challenge reference. The card is charged on the attempt's gateway
+// order, referring to that authentication. A charge the issuer still won't
+// approve without authentication gets a new challenge; any other decline
+// fails the attempt.
+func (s *billingService) CompleteAuthentication(ctx context.Context, attemptID, userID uuid.UUID) (*models.BillingAttempt, error) {
+	attempt, err := s.billingRepo.GetBillingAttemptByID(ctx, attemptID)
+	if err != nil {
+		if _, ok := err.(*repositories.NotFoundError); ok {
+			return nil, &NotFoundError{Message: "billing attempt not found"}
+		}
+		return nil, err
+	}
+
+	subscription, err := s.subscriptionRepo.GetSubscriptionByID(ctx, attempt.SubscriptionID)
+	if err != nil {
+		if _, ok := err.(*repositories.NotFoundError); ok {
+			return nil, &NotFoundError{Message: "subscription not found"}
+		}
+		return nil, err
+	}
+	if subscription.UserID != userID {
+		return nil, &ForbiddenError{Message: "billing attempt does not belong to this user"}
+	}
+
+	if attempt.Status != models.BillingAttemptStatusRequiresAction || !attempt.ChallengeReference.Valid {
+		return nil, &ConflictError{Message: fmt.Sprintf("billing attempt is %s and not awaiting authentication", attempt.Status)}
+	}
+	if !attempt.GatewayOrderID.Valid {
+		return nil, &ConflictError{Message: "billing attempt has no gateway order to resume"}
+	}
+
+	card, err := s.cardRepo.GetCardByID(ctx, subscription.CardID.UUID)
+	if err != nil {
+		return nil, fmt.Errorf("card not found: %w", err)
+	}
+
+	// Credit was released when the challenge was issued; apply whatever is
+	// available now
+	_, chargeAmount, err := applyAccountCredit(ctx, s.creditRepo, subscription.UserID, attempt)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge := attempt.ChallengeReference.String
+	attempt.Status = models.BillingAttemptStatusProcessing
+	attempt.ChallengeReference = sql.NullString{}
+	attempt.ProcessedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	attempt.ProcessingStartedAt = attempt.ProcessedAt
+	if err := s.billingRepo.UpdateBillingAttempt(ctx, attempt); err != nil {
+		releaseAccountCredit(ctx, s.creditRepo, attempt)
+		return nil, fmt.Errorf("failed to update attempt status: %w", err)
+	}
+
+	if chargeAmount <= 0 {
+		// Credit now covers the whole amount; nothing to authenticate
+		if err := s.completeBillingAttempt(ctx, attempt, subscription, "", models.TransactionStatusSucceeded, nil, GatewayTrace{}); err != nil {
+			return nil, err
+		}
+		return attempt, nil
+	}
+
+	paymentResp, err := s.mastercardService.PayWithTokenAuthenticated(
+		ctx,
+		card.GatewayToken,
+		attempt.GatewayOrderID.String,
+		"pay-"+challenge,
+		fmt.Sprintf("%.2f", chargeAmount),
+		attempt.Currency,
+		challenge,
+	)
+	if err != nil {
+		var mismatch *AmountMismatchError
+		if errors.As(err, &mismatch) {
+			markAmountMismatch(context.WithoutCancel(ctx), s.billingRepo, attempt, mismatch)
+			return nil, fmt.Errorf("payment held for review: %w", err)
+		}
+		var ambiguous *AmbiguousPaymentError
+		if errors.As(err, &ambiguous) {
+			// Without a challenge reference the billing worker reconciles it
+			attempt.Status = models.BillingAttemptStatusRequiresAction
+			attempt.ErrorMessage = sql.NullString{String: "payment outcome unknown; awaiting reconciliation", Valid: true}
+			s.billingRepo.UpdateBillingAttempt(context.WithoutCancel(ctx), attempt)
+			return nil, fmt.Errorf("payment outcome unknown: %w", err)
+		}
+		// Nothing was charged; the customer can try again
+		releaseAccountCredit(ctx, s.creditRepo, attempt)
+		attempt.Status = models.BillingAttemptStatusRequiresAction
+		attempt.ChallengeReference = sql.NullString{String: challenge, Valid: true}
+		s.billingRepo.UpdateBillingAttempt(context.WithoutCancel(ctx), attempt)
+		return nil, fmt.Errorf("payment failed: %w", err)
+	}
+
+	if paymentResp.Result != "SUCCESS" || paymentResp.GatewayCode != "APPROVED" {
+		releaseAccountCredit(ctx, s.creditRepo, attempt)
+		if requiresAuthentication(paymentResp.GatewayCode) {
+			markRequiresAuthentication(ctx, s.billingRepo, s.eventService, subscription, attempt, paymentResp)
+			return attempt, nil
+		}
+		attempt.Status = models.BillingAttemptStatusFailed
+		attempt.ErrorCode = sql.NullString{String: normalizeDeclineCode(paymentResp.GatewayCode), Valid: true}
+		attempt.ErrorMessage = sql.NullString{String: declineErrorMessage(paymentResp.Result, paymentResp.GatewayCode), Valid: true}
+		s.billingRepo.UpdateBillingAttempt(ctx, attempt)
+		return attempt, &PaymentDeclinedError{Result: paymentResp.Result, GatewayCode: paymentResp.GatewayCode}
+	}
+
+	if err := s.completeBillingAttempt(ctx, attempt, subscription, paymentResp.Transaction.ID, paymentResp.Transaction.Status, paymentResp.Raw, paymentResp.GatewayTrace); err != nil {
+		return nil, err
+	}
+	return attempt, nil
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 9, 30, 0, 0, time.UTC)
+}
+
+func TestAddAnchoredMonths(t *testing.T) {
+	anchor31 := sql.NullTime{Time: date(2025, time.January, 31), Valid: true}
+	anchor29 := sql.NullTime{Time: date(2024, time.February, 29), Valid: true}
+
+	tests := []struct {
+		name   string
+		from   time.Time
+		months int
+		anchor sql.NullTime
+		want   time.Time
+	}{
+		{"31st into February", date(2025, time.January, 31), 1, anchor31, date(2025, time.February, 28)},
+		{"back to the 31st after February", date(2025, time.February, 28), 1, anchor31, date(2025, time.March, 31)},
+		{"31st into a 30-day month", date(2025, time.March, 31), 1, anchor31, date(2025, time.April, 30)},
+		{"31st into a leap February", date(2024, time.January, 31), 1, anchor31, date(2024, time.February, 29)},
+		{"leap day a year on", date(2024, time.February, 29), 12, anchor29, date(2025, time.February, 28)},
+		{"leap day anchor back to a leap year", date(2027, time.February, 28), 12, anchor29, date(2028, time.February, 29)},
+		{"December into January", date(2025, time.December, 15), 1, sql.NullTime{}, date(2026, time.January, 15)},
+		{"December 31st into January", date(2025, time.December, 31), 1, anchor31, date(2026, time.January, 31)},
+		{"November 30th over the year end", date(2025, time.November, 30), 3, sql.NullTime{}, date(2026, time.February, 28)},
+		{"no anchor keeps from's day", date(2025, time.February, 28), 1, sql.NullTime{}, date(2025, time.March, 28)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := addAnchoredMonths(tt.from, tt.months, tt.anchor); !got.Equal(tt.want) {
+				t.Errorf("addAnchoredMonths(%s, %d) = %s, want %s", tt.from.Format("2006-01-02"), tt.months, got.Format(time.RFC3339), tt.want.Format(time.RFC3339))
+			}
+		})
+	}
+}
+
+func TestMonthlyBillingStaysOnAnchorDay(t *testing.T) {
+	anchor := sql.NullTime{Time: date(2025, time.January, 31), Valid: true}
+
+	want := []time.Time{
+		date(2025, time.February, 28),
+		date(2025, time.March, 31),
+		date(2025, time.April, 30),
+		date(2025, time.May, 31),
+	}
+
+	next := anchor.Time
+	for _, w := range want {
+		next = calculateNextBillingDate(next, "month", anchor)
+		if !next.Equal(w) {
+			t.Fatalf("next billing date = %s, want %s", next.Format("2006-01-02"), w.Format("2006-01-02"))
+		}
+	}
+}
@@ -3,7 +3,10 @@ package services
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"pg-backend/internal/config"
 	"pg-backend/internal/models"
 	"pg-backend/internal/repositories"
 	"time"
@@ -14,17 +17,25 @@ import (
 type BillingService interface {
 	CreateManualPayment(ctx context.Context, userID, cardID uuid.UUID, amount float64, currency, description string) (*models.Transaction, error)
 	GetBillingHistory(ctx context.Context, userID uuid.UUID, limit, offset int) ([]models.Transaction, error)
+	GetSubscriptionTransactions(ctx context.Context, subscriptionID uuid.UUID, transactionType models.TransactionType, limit, offset int) ([]models.Transaction, error)
 	GetSubscriptionBillingHistory(ctx context.Context, subscriptionID uuid.UUID) ([]models.BillingAttempt, error)
 	ProcessPendingBillingAttempts(ctx context.Context, limit int) (int, error)
+	ReleaseStuckBillingAttempts(ctx context.Context) (int, error)
+	ListBillingAttempts(ctx context.Context, status models.BillingAttemptStatus, limit, offset int) ([]models.BillingAttemptSummary, error)
+	CompleteAuthentication(ctx context.Context, attemptID, userID uuid.UUID) (*models.BillingAttempt, error)
 }
 
 type billingService struct {
 	transactionRepo   repositories.TransactionRepository
 	billingRepo       repositories.BillingRepository
 	subscriptionRepo  repositories.SubscriptionRepository
+	planRepo          repositories.PlanRepository
 	cardRepo          repositories.CardRepository
 	userRepo          repositories.UserRepository
+	creditRepo        repositories.CreditRepository
 	mastercardService MastercardService
+	eventService      EventService
+	cfg               *config.Config
 }
 
 func NewBillingService(
@@ -32,16 +43,24 @@ func NewBillingService(
 	billingRepo repositories.BillingRepository,
 	cardRepo repositories.CardRepository,
 	subscriptionRepo repositories.SubscriptionRepository,
+	planRepo repositories.PlanRepository,
 	userRepo repositories.UserRepository,
+	creditRepo repositories.CreditRepository,
 	mastercardService MastercardService,
+	eventService EventService,
+	cfg *config.Config,
 ) BillingService {
 	return &billingService{
 		transactionRepo:   transactionRepo,
 		billingRepo:       billingRepo,
 		subscriptionRepo:  subscriptionRepo,
+		planRepo:          planRepo,
 		cardRepo:          cardRepo,
 		userRepo:          userRepo,
+		creditRepo:        creditRepo,
 		mastercardService: mastercardService,
+		eventService:      eventService,
+		cfg:               cfg,
 	}
 }
 
@@ -70,20 +89,35 @@ func (s *billingService) CreateManualPayment(ctx context.Context, userID, cardID
 	if currency == "" {
 		currency = "LKR"
 	}
+	if err := NewSupportedCurrencies(s.cfg).Check("currency", currency); err != nil {
+		return nil, err
+	}
+	if err := NewAmountLimits(s.cfg).Check("amount", amount, currency); err != nil {
+		return nil, err
+	}
 
 	// 5. Process payment via Mastercard
 	amountStr := fmt.Sprintf("%.2f", amount)
 	paymentResp, err := s.mastercardService.PayWithToken(
+		ctx,
 		card.GatewayToken,
 		amountStr,
 		currency,
+		"",
+		"",
 	)
-	if err != nil {
+	var mismatch *AmountMismatchError
+	heldForReview := errors.As(err, &mismatch) && mismatch.Response != nil
+	if heldForReview {
+		// The gateway charged a different amount, so money may have moved:
+		// record the order as pending for manual review before failing
+		paymentResp = mismatch.Response
+	} else if err != nil {
 		return nil, fmt.Errorf("payment failed: %w", err)
 	}
 
 	// 6. Check payment result
-	if paymentResp.Result != "SUCCESS" || paymentResp.GatewayCode != "APPROVED" {
+	if !heldForReview && (paymentResp.Result != "SUCCESS" || paymentResp.GatewayCode != "APPROVED") {
 		return nil, fmt.Errorf("payment declined: %s", paymentResp.GatewayCode)
 	}
 
@@ -95,7 +129,14 @@ func (s *billingService) CreateManualPayment(ctx context.Context, userID, cardID
 		Currency:             currency,
 		Status:               paymentResp.Transaction.Status,
 		GatewayTransactionID: paymentResp.Transaction.ID,
-		Type:                 "manual",
+		GatewayOrderID:       paymentResp.Order.ID,
+		GatewayResponse:      paymentResp.Raw,
+		CorrelationID:        paymentResp.CorrelationID,
+		GatewayCorrelationID: paymentResp.GatewayCorrelationID,
+		Type:                 models.TransactionTypeManual,
+	}
+	if heldForReview {
+		transaction.Status = models.TransactionStatusPending
 	}
 
 	if err := s.transactionRepo.CreateTransaction(ctx, transaction); err != nil {
@@ -103,6 +144,9 @@ func (s *billingService) CreateManualPayment(ctx context.Context, userID, cardID
 		fmt.Printf("Warning: Failed to save transaction to database: %v\n", err)
 	}
 
+	if heldForReview {
+		return nil, fmt.Errorf("payment held for review: %w", mismatch)
+	}
 	return transaction, nil
 }
 
@@ -114,7 +158,7 @@ func (s *billingService) GetBillingHistory(ctx context.Context, userID uuid.UUID
 	}
 
 	// Get all transactions for user
-	allTransactions, err := s.transactionRepo.GetTransactionsByUserID(ctx, userID)
+	allTransactions, err := s.transactionRepo.GetTransactionsByUserID(ctx, userID, repositories.TransactionFilter{})
 	if err != nil {
 		return nil, err
 	}
@@ -133,10 +177,44 @@ func (s *billingService) GetBillingHistory(ctx context.Context, userID uuid.UUID
 	return allTransactions[start:end], nil
 }
 
+// GetSubscriptionTransactions pages through the charges, credits and refunds
+// recorded against a subscription
+func (s *billingService) GetSubscriptionTransactions(ctx context.Context, subscriptionID uuid.UUID, transactionType models.TransactionType, limit, offset int) ([]models.Transaction, error) {
+	if transactionType != "" && !transactionType.Valid() {
+		return nil, &ValidationError{Field: "type", Message: fmt.Sprintf("unknown transaction type %q", transactionType)}
+	}
+
+	if _, err := s.subscriptionRepo.GetSubscriptionByID(ctx, subscriptionID); err != nil {
+		if _, ok := err.(*repositories.NotFoundError); ok {
+			return nil, &NotFoundError{Message: "subscription not found"}
+		}
+		return nil, err
+	}
+
+	transactions, err := s.transactionRepo.GetTransactionsBySubscriptionID(ctx, subscriptionID, transactionType, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	if transactions == nil {
+		transactions = []models.Transaction{}
+	}
+
+	return transactions, nil
+}
+
 func (s *billingService) GetSubscriptionBillingHistory(ctx context.Context, subscriptionID uuid.UUID) ([]models.BillingAttempt, error) {
 	return s.billingRepo.GetBillingAttemptsBySubscriptionID(ctx, subscriptionID)
 }
 
+// ListBillingAttempts pages through billing attempts across all
+// subscriptions, e.g. every failed charge awaiting dunning
+func (s *billingService) ListBillingAttempts(ctx context.Context, status models.BillingAttemptStatus, limit, offset int) ([]models.BillingAttemptSummary, error) {
+	if status != "" && !models.IsValidBillingAttemptStatus(status) {
+		return nil, &ValidationError{Field: "status", Message: fmt.Sprintf("unknown billing attempt status %q", status)}
+	}
+	return s.billingRepo.ListBillingAttempts(ctx, status, limit, offset)
+}
+
 func (s *billingService) ProcessPendingBillingAttempts(ctx context.Context, limit int) (int, error) {
 	// Get pending billing attempts
 	attempts, err := s.billingRepo.GetPendingBillingAttempts(ctx, limit)
@@ -146,7 +224,20 @@ func (s *billingService) ProcessPendingBillingAttempts(ctx context.Context, limi
 
 	processedCount := 0
 	for _, attempt := range attempts {
-		if err := s.processBillingAttempt(ctx, &attempt); err != nil {
+		itemCtx, cancel := context.WithTimeout(ctx, s.cfg.BillingItemTimeout())
+		if attempt.Status == models.BillingAttemptStatusRequiresAction {
+			// The attempt may already have been charged; confirm with the
+			// gateway before submitting anything new
+			err = s.reconcileBillingAttempt(itemCtx, &attempt)
+		} else {
+			err = s.processBillingAttempt(itemCtx, &attempt)
+		}
+		cancel()
+		if errors.Is(err, ErrGatewayUnavailable) {
+			// The rest would fail the same way; pick them up next cycle
+			return processedCount, err
+		}
+		if err != nil {
 			fmt.Printf("Failed to process billing attempt %s: %v\n", attempt.ID, err)
 			continue
 		}
@@ -156,10 +247,28 @@ func (s *billingService) ProcessPendingBillingAttempts(ctx context.Context, limi
 	return processedCount, nil
 }
 
+// ReleaseStuckBillingAttempts puts back attempts left in processing for longer
+// than the configured timeout, e.g. by a worker that crashed mid-charge, so
+// they are picked up again instead of being stranded
+func (s *billingService) ReleaseStuckBillingAttempts(ctx context.Context) (int, error) {
+	startedBefore := time.Now().Add(-s.cfg.BillingProcessingTimeout())
+	attempts, err := s.billingRepo.ReleaseStuckBillingAttempts(ctx, startedBefore)
+	if err != nil {
+		return 0, fmt.Errorf("failed to release stuck billing attempts: %w", err)
+	}
+
+	for _, attempt := range attempts {
+		fmt.Printf("Warning: Billing attempt %s was stuck in processing; moved to %s\n", attempt.ID, attempt.Status)
+	}
+
+	return len(attempts), nil
+}
+
 func (s *billingService) processBillingAttempt(ctx context.Context, attempt *models.BillingAttempt) error {
 	// 1. Update attempt status to processing
 	attempt.Status = models.BillingAttemptStatusProcessing
 	attempt.ProcessedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	attempt.ProcessingStartedAt = attempt.ProcessedAt
 	if err := s.billingRepo.UpdateBillingAttempt(ctx, attempt); err != nil {
 		return fmt.Errorf("failed to update attempt status: %w", err)
 	}
@@ -172,8 +281,20 @@ func (s *billingService) processBillingAttempt(ctx context.Context, attempt *mod
 		s.billingRepo.UpdateBillingAttempt(ctx, attempt)
 		return fmt.Errorf("subscription not found: %w", err)
 	}
+	if subscription.Status == models.SubscriptionStatusIncompleteExpired {
+		attempt.Status = models.BillingAttemptStatusFailed
+		attempt.ErrorMessage = sql.NullString{String: "Subscription expired before its first payment", Valid: true}
+		s.billingRepo.UpdateBillingAttempt(ctx, attempt)
+		return fmt.Errorf("subscription %s has expired", subscription.ID)
+	}
 
 	// 3. Get card
+	if !subscription.CardID.Valid {
+		attempt.Status = models.BillingAttemptStatusFailed
+		attempt.ErrorMessage = sql.NullString{String: "No card on file", Valid: true}
+		s.billingRepo.UpdateBillingAttempt(ctx, attempt)
+		return fmt.Errorf("subscription %s has no card", subscription.ID)
+	}
 	card, err := s.cardRepo.GetCardByID(ctx, subscription.CardID.UUID)
 	if err != nil {
 		attempt.Status = models.BillingAttemptStatusFailed
@@ -182,45 +303,206 @@ func (s *billingService) processBillingAttempt(ctx context.Context, attempt *mod
 		return fmt.Errorf("card not found: %w", err)
 	}
 
-	// 4. Process payment
-	amountStr := fmt.Sprintf("%.2f", attempt.Amount)
-	paymentResp, err := s.mastercardService.PayWithToken(
+	// 4. Fix the gateway order before anything is sent, so reprocessing this
+	// attempt resubmits the same order instead of charging again
+	if !attempt.GatewayOrderID.Valid {
+		attempt.GatewayOrderID = sql.NullString{String: subscriptionOrderID(subscription, attempt.AttemptNumber), Valid: true}
+		if err := s.billingRepo.UpdateBillingAttempt(ctx, attempt); err != nil {
+			return fmt.Errorf("failed to record gateway order: %w", err)
+		}
+	}
+
+	// 5. Apply account credit before charging the card
+	_, chargeAmount, err := applyAccountCredit(ctx, s.creditRepo, subscription.UserID, attempt)
+	if err != nil {
+		attempt.Status = models.BillingAttemptStatusFailed
+		attempt.ErrorMessage = sql.NullString{String: err.Error(), Valid: true}
+		s.billingRepo.UpdateBillingAttempt(ctx, attempt)
+		return err
+	}
+	if chargeAmount <= 0 {
+		// Fully covered by credit; nothing to send to the gateway
+		return s.completeBillingAttempt(ctx, attempt, subscription, "", models.TransactionStatusSucceeded, nil, GatewayTrace{})
+	}
+
+	// 6. Process payment
+	amountStr := fmt.Sprintf("%.2f", chargeAmount)
+	paymentResp, err := s.mastercardService.PayWithTokenRecurring(
+		ctx,
 		card.GatewayToken,
+		attempt.GatewayOrderID.String,
 		amountStr,
 		attempt.Currency,
+		subscription.ID.String(),
+		initialTraceID(subscription, card),
+		statementDescriptor(ctx, s.planRepo, s.cfg, subscription),
 	)
 	if err != nil {
+		var mismatch *AmountMismatchError
+		if errors.As(err, &mismatch) {
+			markAmountMismatch(context.WithoutCancel(ctx), s.billingRepo, attempt, mismatch)
+			return fmt.Errorf("payment held for review: %w", err)
+		}
+		var ambiguous *AmbiguousPaymentError
+		if errors.As(err, &ambiguous) {
+			// The charge may still complete at the gateway; flag it for
+			// reconciliation rather than fail it
+			attempt.Status = models.BillingAttemptStatusRequiresAction
+			attempt.GatewayOrderID = sql.NullString{String: ambiguous.OrderID, Valid: true}
+			attempt.ErrorMessage = sql.NullString{String: "payment outcome unknown; awaiting reconciliation", Valid: true}
+			s.billingRepo.UpdateBillingAttempt(context.WithoutCancel(ctx), attempt)
+			return fmt.Errorf("payment outcome unknown: %w", err)
+		}
+		if errors.Is(err, ErrGatewayUnavailable) {
+			// Nothing reached the gateway; leave the attempt for a later cycle
+			releaseAccountCredit(ctx, s.creditRepo, attempt)
+			attempt.Status = models.BillingAttemptStatusPending
+			s.billingRepo.UpdateBillingAttempt(ctx, attempt)
+			return err
+		}
 		attempt.Status = models.BillingAttemptStatusFailed
 		attempt.ErrorMessage = sql.NullString{String: err.Error(), Valid: true}
 		s.billingRepo.UpdateBillingAttempt(ctx, attempt)
+		releaseAccountCredit(ctx, s.creditRepo, attempt)
 		return fmt.Errorf("payment failed: %w", err)
 	}
 
-	// 5. Check payment result
+	// 7. Check payment result
 	if paymentResp.Result != "SUCCESS" || paymentResp.GatewayCode != "APPROVED" {
+		releaseAccountCredit(ctx, s.creditRepo, attempt)
+		if requiresAuthentication(paymentResp.GatewayCode) {
+			// Retrying won't help; the customer has to authenticate first
+			markRequiresAuthentication(ctx, s.billingRepo, s.eventService, subscription, attempt, paymentResp)
+			return fmt.Errorf("payment requires customer authentication")
+		}
 		attempt.Status = models.BillingAttemptStatusFailed
-		attempt.ErrorCode = sql.NullString{String: paymentResp.GatewayCode, Valid: true}
-		attempt.ErrorMessage = sql.NullString{String: paymentResp.Result, Valid: true}
+		attempt.ErrorCode = sql.NullString{String: normalizeDeclineCode(paymentResp.GatewayCode), Valid: true}
+		attempt.ErrorMessage = sql.NullString{String: declineErrorMessage(paymentResp.Result, paymentResp.GatewayCode), Valid: true}
 		s.billingRepo.UpdateBillingAttempt(ctx, attempt)
 		return fmt.Errorf("payment declined: %s", paymentResp.GatewayCode)
 	}
 
-	// 6. Payment succeeded
+	// 8. Payment succeeded
+	recordInitialTraceID(ctx, s.subscriptionRepo, subscription, paymentResp)
+	attempt.GatewayOrderID = sql.NullString{String: paymentResp.Order.ID, Valid: paymentResp.Order.ID != ""}
+	return s.completeBillingAttempt(ctx, attempt, subscription, paymentResp.Transaction.ID, paymentResp.Transaction.Status, paymentResp.Raw, paymentResp.GatewayTrace)
+}
+
+// markAmountMismatch holds an attempt the gateway charged for a different
+// amount. The credit it applied stays applied and it is never retried: the
+// order has to be checked and settled by hand.
+func markAmountMismatch(ctx context.Context, billingRepo repositories.BillingRepository, attempt *models.BillingAttempt, mismatch *AmountMismatchError) {
+	if mismatch.OrderID != "" {
+		attempt.GatewayOrderID = sql.NullString{String: mismatch.OrderID, Valid: true}
+	}
+	attempt.Status = models.BillingAttemptStatusRequiresAction
+	attempt.ErrorCode = sql.NullString{String: DeclineCodeAmountMismatch, Valid: true}
+	attempt.ErrorMessage = sql.NullString{String: mismatch.Error() + "; requires manual review", Valid: true}
+	if err := billingRepo.UpdateBillingAttempt(ctx, attempt); err != nil {
+		fmt.Printf("Warning: Failed to hold attempt %s for review: %v\n", attempt.ID, err)
+	}
+}
+
+// reconcileBillingAttempt resolves an attempt whose gateway outcome was unknown.
+// A confirmed charge is recorded as succeeded, a declined one as failed, and an
+// order the gateway never saw is charged normally.
+func (s *billingService) reconcileBillingAttempt(ctx context.Context, attempt *models.BillingAttempt) error {
+	if !attempt.GatewayOrderID.Valid || attempt.ErrorCode.String == DeclineCodeAmountMismatch {
+		return fmt.Errorf("attempt requires manual action")
+	}
+
+	order, err := s.mastercardService.RetrieveOrder(ctx, attempt.GatewayOrderID.String)
+	if err != nil {
+		if _, ok := err.(*NotFoundError); ok {
+			// Nothing reached the gateway, so it is safe to charge, under
+			// the same order in case this submission is lost too
+			attempt.Status = models.BillingAttemptStatusPending
+			attempt.ErrorMessage = sql.NullString{}
+			return s.processBillingAttempt(ctx, attempt)
+		}
+		return fmt.Errorf("failed to retrieve order: %w", err)
+	}
+
+	if !order.IsPaid() {
+		gatewayCode := order.Status
+		if n := len(order.Transaction); n > 0 {
+			gatewayCode = order.Transaction[n-1].Response.GatewayCode
+		}
+		attempt.Status = models.BillingAttemptStatusFailed
+		attempt.ErrorCode = sql.NullString{String: normalizeDeclineCode(gatewayCode), Valid: true}
+		attempt.ErrorMessage = sql.NullString{String: declineErrorMessage(fmt.Sprintf("gateway order %s", order.Status), gatewayCode), Valid: true}
+		s.billingRepo.UpdateBillingAttempt(ctx, attempt)
+		releaseAccountCredit(ctx, s.creditRepo, attempt)
+		return fmt.Errorf("reconciled payment was not successful: %s", gatewayCode)
+	}
+
+	subscription, err := s.subscriptionRepo.GetSubscriptionByID(ctx, attempt.SubscriptionID)
+	if err != nil {
+		return fmt.Errorf("subscription not found: %w", err)
+	}
+
+	gatewayTransactionID := ""
+	for _, txn := range order.Transaction {
+		if txn.Result == "SUCCESS" && (txn.Transaction.Type == "PAYMENT" || txn.Transaction.Type == "CAPTURE") {
+			gatewayTransactionID = txn.Transaction.ID
+		}
+	}
+
+	return s.completeBillingAttempt(ctx, attempt, subscription, gatewayTransactionID, order.Status, order.Raw, order.GatewayTrace)
+}
+
+// completeBillingAttempt marks an attempt succeeded and records its
+// transactions. gatewayTransactionID is empty when account credit covered the
+// whole amount; gatewayResponse is the redacted payload to keep with the
+// transaction and trace the request that produced it.
+func (s *billingService) completeBillingAttempt(ctx context.Context, attempt *models.BillingAttempt, subscription *models.Subscription, gatewayTransactionID, status string, gatewayResponse json.RawMessage, trace GatewayTrace) error {
 	attempt.Status = models.BillingAttemptStatusSucceeded
-	attempt.GatewayTransactionID = sql.NullString{String: paymentResp.Transaction.ID, Valid: true}
+	attempt.GatewayTransactionID = sql.NullString{String: gatewayTransactionID, Valid: gatewayTransactionID != ""}
+	attempt.ErrorMessage = sql.NullString{}
 	if err := s.billingRepo.UpdateBillingAttempt(ctx, attempt); err != nil {
 		return fmt.Errorf("failed to update attempt: %w", err)
 	}
 
-	// 7. Record transaction
+	credit, err := s.creditRepo.GetAppliedCredit(ctx, attempt.ID)
+	if err != nil {
+		fmt.Printf("Warning: Failed to load credit applied to attempt %s: %v\n", attempt.ID, err)
+	}
+	recordCreditTransaction(ctx, s.transactionRepo, subscription, attempt, credit)
+
+	// The first successful charge activates a new subscription. A past-due
+	// subscription is active again once a retry or completed challenge pays
+	// the period; its period was left in place when the charge failed, so
+	// it moves on to the next one now.
+	if previousStatus := subscription.Status; previousStatus == models.SubscriptionStatusIncomplete ||
+		previousStatus == models.SubscriptionStatusPastDue {
+		if previousStatus == models.SubscriptionStatusPastDue {
+			advanceBillingPeriod(subscription)
+		}
+		subscription.Status = models.SubscriptionStatusActive
+		if err := s.subscriptionRepo.UpdateSubscription(ctx, subscription); err != nil {
+			fmt.Printf("Warning: Failed to activate subscription %s: %v\n", subscription.ID, err)
+		} else {
+			publishSubscriptionStatusChange(ctx, s.eventService, subscription, previousStatus)
+		}
+	}
+
+	if gatewayTransactionID == "" {
+		return nil
+	}
+
+	// Record transaction
 	transaction := &models.Transaction{
 		UserID:               subscription.UserID,
 		CardID:               subscription.CardID.UUID,
-		Amount:               attempt.Amount,
+		Amount:               roundAmount(attempt.Amount - credit),
 		Currency:             attempt.Currency,
-		Status:               paymentResp.Transaction.Status,
-		GatewayTransactionID: paymentResp.Transaction.ID,
-		Type:                 "recurring",
+		Status:               status,
+		GatewayTransactionID: gatewayTransactionID,
+		GatewayOrderID:       attempt.GatewayOrderID.String,
+		GatewayResponse:      gatewayResponse,
+		CorrelationID:        trace.CorrelationID,
+		GatewayCorrelationID: trace.GatewayCorrelationID,
+		Type:                 models.TransactionTypeRecurring,
 		InvoiceID:            sql.NullString{String: fmt.Sprintf("INV-%d", time.Now().Unix()), Valid: true},
 	}
 
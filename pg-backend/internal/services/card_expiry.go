@@ -0,0 +1,55 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseCardExpiry reads a card expiry as the gateway reports it and returns
+// the month and four-digit year. The gateway sends sourceOfFunds.provided.card
+// .expiry as MMYY; MM/YY, MMYYYY, MM/YYYY and YYYY-MM are accepted as well.
+// Any other shape is an error rather than a guess.
+func ParseCardExpiry(expiry string) (month, year int, err error) {
+	expiry = strings.TrimSpace(expiry)
+
+	var monthPart, yearPart string
+	switch {
+	case len(expiry) == 7 && expiry[4] == '-':
+		yearPart, monthPart = expiry[:4], expiry[5:]
+	case strings.Contains(expiry, "/"):
+		monthPart, yearPart, _ = strings.Cut(expiry, "/")
+	case len(expiry) == 4 || len(expiry) == 6:
+		monthPart, yearPart = expiry[:2], expiry[2:]
+	default:
+		return 0, 0, fmt.Errorf("unrecognised card expiry %q", expiry)
+	}
+
+	if len(monthPart) != 2 || (len(yearPart) != 2 && len(yearPart) != 4) {
+		return 0, 0, fmt.Errorf("unrecognised card expiry %q", expiry)
+	}
+	month, err = strconv.Atoi(monthPart)
+	if err != nil || !isDigits(monthPart) || month < 1 || month > 12 {
+		return 0, 0, fmt.Errorf("invalid card expiry month in %q", expiry)
+	}
+	year, err = strconv.Atoi(yearPart)
+	if err != nil || !isDigits(yearPart) {
+		return 0, 0, fmt.Errorf("invalid card expiry year in %q", expiry)
+	}
+	if len(yearPart) == 2 {
+		year += 2000
+	}
+
+	return month, year, nil
+}
+
+// isDigits reports whether s is made of ASCII digits only; strconv.Atoi
+// would also accept a sign
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
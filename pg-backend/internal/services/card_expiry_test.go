@@ -0,0 +1,59 @@
+package services
+
+import "testing"
+
+func TestParseCardExpiry(t *testing.T) {
+	tests := []struct {
+		expiry    string
+		wantMonth int
+		wantYear  int
+	}{
+		{"1230", 12, 2030},
+		{"0527", 5, 2027},
+		{"122030", 12, 2030},
+		{"2030-12", 12, 2030},
+		{"2027-05", 5, 2027},
+		{"12/30", 12, 2030},
+		{"05/2027", 5, 2027},
+		{" 1230 ", 12, 2030},
+	}
+
+	for _, tt := range tests {
+		month, year, err := ParseCardExpiry(tt.expiry)
+		if err != nil {
+			t.Errorf("ParseCardExpiry(%q): %v", tt.expiry, err)
+			continue
+		}
+		if month != tt.wantMonth || year != tt.wantYear {
+			t.Errorf("ParseCardExpiry(%q) = %02d/%d, want %02d/%d", tt.expiry, month, year, tt.wantMonth, tt.wantYear)
+		}
+	}
+}
+
+func TestParseCardExpiryInvalid(t *testing.T) {
+	for _, expiry := range []string{
+		"",
+		"1",
+		"123",
+		"12345",
+		"1330",
+		"0030",
+		"12/",
+		"/30",
+		"1/30",
+		"12/030",
+		"2030/12",
+		"30-12",
+		"2030-1",
+		"2030-13",
+		"ab30",
+		"12ab",
+		"-130",
+		"+1/30",
+		"12/+3",
+	} {
+		if month, year, err := ParseCardExpiry(expiry); err == nil {
+			t.Errorf("ParseCardExpiry(%q) = %02d/%d, want an error", expiry, month, year)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testAmexPAN is a standard AMEX test card number
+const testAmexPAN = "378282246310005"
+
+func TestDetectCardScheme(t *testing.T) {
+	tests := []struct {
+		number string
+		want   string
+	}{
+		{testAmexPAN, CardSchemeAmex},
+		{TestDPANAmex, CardSchemeAmex},
+		{"341111111111111", CardSchemeAmex},
+		{TestFPANVisa, CardSchemeVisa},
+		{"5123450000000008", CardSchemeMastercard},
+		{"2223000048400011", CardSchemeMastercard},
+		{"6011111111111117", CardSchemeDiscover},
+		{"3530111333300000", CardSchemeUnknown},
+		{"", CardSchemeUnknown},
+	}
+
+	for _, tt := range tests {
+		if got := DetectCardScheme(tt.number); got != tt.want {
+			t.Errorf("DetectCardScheme(%q) = %s, want %s", tt.number, got, tt.want)
+		}
+	}
+}
+
+func TestValidateSourceAmex(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  PaymentSourceType
+		card    CardDetails
+		wantErr string // field of the expected ValidationError, "" for none
+	}{
+		{"card with a 4-digit CID", PaymentSourceCard, CardDetails{Number: testAmexPAN, CVV: "1234"}, ""},
+		{"card with a 3-digit CVV", PaymentSourceCard, CardDetails{Number: testAmexPAN, CVV: "123"}, "cvv"},
+		{"16-digit AMEX number", PaymentSourceCard, CardDetails{Number: "3782822463100051", CVV: "1234"}, "card_number"},
+		{"device payment", PaymentSourceDevicePayment, CardDetails{Number: TestDPANAmex, Cryptogram: TestCryptogram}, ""},
+		{"device payment failing Luhn", PaymentSourceDevicePayment, CardDetails{Number: "370295136149944", Cryptogram: TestCryptogram}, "card_number"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			card := tt.card
+			card.ExpiryMonth, card.ExpiryYear = TestDPANExpiryMonth, TestDPANExpiryYear
+
+			err := validateSource(ChargeRequest{Source: tt.source, Card: &card})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateSource: %v", err)
+				}
+				return
+			}
+			if verr, ok := err.(*ValidationError); !ok || verr.Field != tt.wantErr {
+				t.Errorf("error = %v, want a ValidationError on %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAmexCardPayment(t *testing.T) {
+	gateway := newTestGateway(t, func(t *testing.T, r *http.Request, body map[string]interface{}) (int, string) {
+		card := cardInRequest(body)
+		if card["number"] != testAmexPAN || card["securityCode"] != "1234" {
+			t.Errorf("card = %v, want the AMEX number with its 4-digit CID", card)
+		}
+		return http.StatusOK, `{"result":"SUCCESS","gatewayCode":"APPROVED","order":{"id":"1","amount":"25.00","currency":"USD"}}`
+	})
+
+	resp, err := gateway.PayWithCard(testAmexPAN, "12", "30", "1234", "25.00", "USD", "", "")
+	if err != nil {
+		t.Fatalf("PayWithCard: %v", err)
+	}
+	if resp.GatewayCode != "APPROVED" {
+		t.Errorf("gateway code = %s, want APPROVED", resp.GatewayCode)
+	}
+}
+
+func TestAmexDevicePayment(t *testing.T) {
+	// Two 20-byte AEVVs, as AMEX wallets may return
+	first := strings.Repeat("A", 20)
+	cryptogram := base64.StdEncoding.EncodeToString([]byte(first + strings.Repeat("B", 20)))
+
+	gateway := newTestGateway(t, func(t *testing.T, r *http.Request, body map[string]interface{}) (int, string) {
+		card := cardInRequest(body)
+		if card["number"] != TestDPANAmex {
+			t.Errorf("card number = %v, want %s", card["number"], TestDPANAmex)
+		}
+		devicePayment, _ := card["devicePayment"].(map[string]interface{})
+		if got := devicePayment["onlinePaymentCryptogram"]; got != base64.StdEncoding.EncodeToString([]byte(first)) {
+			t.Errorf("cryptogram = %v, want only the first AEVV", got)
+		}
+		return http.StatusOK, `{"result":"SUCCESS","gatewayCode":"APPROVED","order":{"id":"1","amount":"25.00","currency":"USD"}}`
+	})
+
+	if _, err := gateway.PayWithGooglePay(TestDPANAmex, TestDPANExpiryMonth, TestDPANExpiryYear, cryptogram, "05", "25.00", "USD"); err != nil {
+		t.Fatalf("PayWithGooglePay: %v", err)
+	}
+}
+
+func TestAmexDevicePaymentWithoutPrivilege(t *testing.T) {
+	var mu sync.Mutex
+	var requests []map[string]interface{}
+
+	gateway := newTestGateway(t, func(t *testing.T, r *http.Request, body map[string]interface{}) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, body)
+		if len(requests) == 1 {
+			return http.StatusBadRequest, `{"result":"ERROR","error":{"cause":"INVALID_REQUEST","explanation":"Missing merchant privilege 'DEVICE_PAYMENTS'"}}`
+		}
+		return http.StatusOK, `{"result":"SUCCESS","gatewayCode":"APPROVED","order":{"id":"1","amount":"25.00","currency":"USD"}}`
+	})
+
+	if _, err := gateway.PayWithGooglePay(TestDPANAmex, TestDPANExpiryMonth, TestDPANExpiryYear, TestCryptogram, TestEciIndicator, "25.00", "USD"); err != nil {
+		t.Fatalf("PayWithGooglePay: %v", err)
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("sent %d requests, want the device payment and a card fallback", len(requests))
+	}
+	if code := cardInRequest(requests[1])["securityCode"]; code != "1234" {
+		t.Errorf("fallback security code = %v, want a 4-digit AMEX CID", code)
+	}
+}
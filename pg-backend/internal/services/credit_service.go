@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"pg-backend/internal/models"
+	"pg-backend/internal/repositories"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CreditService manages account credit, e.g. proration from a plan change or
+// cancellation. Credit is spent automatically on the next subscription charge.
+type CreditService interface {
+	GrantCredit(ctx context.Context, userID uuid.UUID, amount float64, currency, description string, subscriptionID uuid.NullUUID) (*models.CreditEntry, error)
+	GetBalances(ctx context.Context, userID uuid.UUID) ([]models.CreditBalance, error)
+}
+
+type creditService struct {
+	creditRepo repositories.CreditRepository
+	userRepo   repositories.UserRepository
+}
+
+func NewCreditService(creditRepo repositories.CreditRepository, userRepo repositories.UserRepository) CreditService {
+	return &creditService{
+		creditRepo: creditRepo,
+		userRepo:   userRepo,
+	}
+}
+
+func (s *creditService) GrantCredit(ctx context.Context, userID uuid.UUID, amount float64, currency, description string, subscriptionID uuid.NullUUID) (*models.CreditEntry, error) {
+	amount = roundAmount(amount)
+	if amount <= 0 {
+		return nil, &ValidationError{Message: "amount must be greater than 0"}
+	}
+
+	if _, err := s.userRepo.GetUserByID(ctx, userID); err != nil {
+		if _, ok := err.(*repositories.NotFoundError); ok {
+			return nil, &NotFoundError{Message: "user not found"}
+		}
+		return nil, err
+	}
+
+	entry := &models.CreditEntry{
+		UserID:         userID,
+		Amount:         amount,
+		Currency:       currency,
+		Type:           models.CreditEntryTypeCredit,
+		Description:    description,
+		SubscriptionID: subscriptionID,
+	}
+	if err := s.creditRepo.CreateCreditEntry(ctx, entry); err != nil {
+		return nil, fmt.Errorf("failed to grant credit: %w", err)
+	}
+
+	return entry, nil
+}
+
+func (s *creditService) GetBalances(ctx context.Context, userID uuid.UUID) ([]models.CreditBalance, error) {
+	if _, err := s.userRepo.GetUserByID(ctx, userID); err != nil {
+		if _, ok := err.(*repositories.NotFoundError); ok {
+			return nil, &NotFoundError{Message: "user not found"}
+		}
+		return nil, err
+	}
+
+	return s.creditRepo.GetBalances(ctx, userID)
+}
+
+// applyAccountCredit spends the user's credit on a billing attempt and returns
+// the amount still to be charged to the card
+func applyAccountCredit(ctx context.Context, creditRepo repositories.CreditRepository, userID uuid.UUID, attempt *models.BillingAttempt) (credit, remaining float64, err error) {
+	credit, err = creditRepo.ApplyCredit(ctx, userID, attempt.ID, attempt.Currency, attempt.Amount)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to apply account credit: %w", err)
+	}
+
+	remaining = roundAmount(attempt.Amount - credit)
+	return credit, remaining, nil
+}
+
+// releaseAccountCredit returns credit held by a failed billing attempt to the
+// user's balance so the next attempt can use it
+func releaseAccountCredit(ctx context.Context, creditRepo repositories.CreditRepository, attempt *models.BillingAttempt) {
+	if err := creditRepo.ReleaseAppliedCredit(context.WithoutCancel(ctx), attempt.ID); err != nil {
+		fmt.Printf("Warning: Failed to release credit for billing attempt %s: %v\n", attempt.ID, err)
+	}
+}
+
+// recordCreditTransaction records credit used towards a subscription charge as
+// a negative "credit" transaction alongside the card charge
+func recordCreditTransaction(ctx context.Context, transactionRepo repositories.TransactionRepository, subscription *models.Subscription, attempt *models.BillingAttempt, credit float64) {
+	if credit <= 0 {
+		return
+	}
+
+	transaction := &models.Transaction{
+		UserID:    subscription.UserID,
+		CardID:    subscription.CardID.UUID,
+		Amount:    -credit,
+		Currency:  attempt.Currency,
+		Status:    models.TransactionStatusSucceeded,
+		Type:      models.TransactionTypeCredit,
+		InvoiceID: sql.NullString{String: fmt.Sprintf("INV-%d", time.Now().Unix()), Valid: true},
+	}
+
+	if err := transactionRepo.CreateSubscriptionTransaction(
+		ctx, transaction, subscription.ID, attempt.ID,
+	); err != nil {
+		fmt.Printf("Warning: Failed to record credit transaction: %v\n", err)
+	}
+}
+
+// roundAmount rounds to minor units so credit arithmetic does not drift
+func roundAmount(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Canonical decline codes stored on failed billing attempts
+const (
+	DeclineCodeCardDeclined      = "card_declined"
+	DeclineCodeInsufficientFunds = "insufficient_funds"
+	DeclineCodeExpiredCard       = "expired_card"
+	DeclineCodeInvalidCard       = "invalid_card"
+	DeclineCodeDoNotContact      = "do_not_contact"
+	DeclineCodeBlocked           = "blocked"
+	DeclineCodeReferred          = "referred"
+	DeclineCodeTimedOut          = "timed_out"
+	DeclineCodeSystemError       = "system_error"
+	DeclineCodeUnspecified       = "unspecified_failure"
+
+	// The issuer wants the cardholder to complete 3-D Secure (SCA) before it
+	// approves the charge. Not a failure: the attempt waits for the customer.
+	DeclineCodeAuthenticationRequired = "authentication_required"
+
+	// The gateway processed the order for a different amount or currency.
+	// Not retried: money may have moved, so the attempt is held for review.
+	DeclineCodeAmountMismatch = "amount_mismatch"
+)
+
+// gatewayDeclineCodes maps gateway response codes to canonical decline codes
+var gatewayDeclineCodes = map[string]string{
+	"DECLINED":                DeclineCodeCardDeclined,
+	"DECLINED_AVS":            DeclineCodeCardDeclined,
+	"DECLINED_CSC":            DeclineCodeCardDeclined,
+	"DECLINED_AVS_CSC":        DeclineCodeCardDeclined,
+	"DECLINED_PAYMENT_PLAN":   DeclineCodeCardDeclined,
+	"DECLINED_DO_NOT_CONTACT": DeclineCodeDoNotContact,
+	"INSUFFICIENT_FUNDS":      DeclineCodeInsufficientFunds,
+	"EXPIRED_CARD":            DeclineCodeExpiredCard,
+	"INVALID_CSC":             DeclineCodeInvalidCard,
+	"BLOCKED":                 DeclineCodeBlocked,
+	"DO_NOT_PROCEED":          DeclineCodeBlocked,
+	"REFERRED":                DeclineCodeReferred,
+	"TIMED_OUT":               DeclineCodeTimedOut,
+	"ACQUIRER_SYSTEM_ERROR":   DeclineCodeSystemError,
+	"SYSTEM_ERROR":            DeclineCodeSystemError,
+	"UNSPECIFIED_FAILURE":     DeclineCodeUnspecified,
+	"UNKNOWN":                 DeclineCodeUnspecified,
+
+	"AUTHENTICATION_REQUIRED":    DeclineCodeAuthenticationRequired,
+	"AUTHENTICATION_IN_PROGRESS": DeclineCodeAuthenticationRequired,
+}
+
+// normalizeDeclineCode converts a gateway response code, or a code that is
+// already canonical, to the canonical value stored on billing attempts.
+// Unmapped codes are kept, lower-cased.
+func normalizeDeclineCode(code string) string {
+	code = strings.TrimSpace(code)
+	if canonical, ok := gatewayDeclineCodes[strings.ToUpper(code)]; ok {
+		return canonical
+	}
+	return strings.ToLower(code)
+}
+
+// requiresAuthentication reports whether a gateway response code asks for the
+// cardholder to authenticate the charge rather than declining it
+func requiresAuthentication(gatewayCode string) bool {
+	return normalizeDeclineCode(gatewayCode) == DeclineCodeAuthenticationRequired
+}
+
+// normalizeDeclineCodes normalizes a configured list of decline codes,
+// dropping blanks and duplicates
+func normalizeDeclineCodes(codes []string) []string {
+	seen := make(map[string]bool, len(codes))
+	normalized := make([]string, 0, len(codes))
+	for _, code := range codes {
+		code = normalizeDeclineCode(code)
+		if code == "" || seen[code] {
+			continue
+		}
+		seen[code] = true
+		normalized = append(normalized, code)
+	}
+	return normalized
+}
+
+// declineErrorMessage records the raw gateway outcome next to the canonical
+// error code, e.g. "FAILURE (gateway code INSUFFICIENT_FUNDS)"
+func declineErrorMessage(result, gatewayCode string) string {
+	if gatewayCode == "" {
+		return result
+	}
+	return fmt.Sprintf("%s (gateway code %s)", result, gatewayCode)
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNormalizeDeclineCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"DECLINED", DeclineCodeCardDeclined},
+		{"DECLINED_CSC", DeclineCodeCardDeclined},
+		{"DECLINED_DO_NOT_CONTACT", DeclineCodeDoNotContact},
+		{"INSUFFICIENT_FUNDS", DeclineCodeInsufficientFunds},
+		{"expired_card", DeclineCodeExpiredCard},
+		{" Expired_Card ", DeclineCodeExpiredCard},
+		{"UNKNOWN", DeclineCodeUnspecified},
+		{"SOMETHING_NEW", "something_new"},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeDeclineCode(tt.code); got != tt.want {
+			t.Errorf("normalizeDeclineCode(%q) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeDeclineCodes(t *testing.T) {
+	got := normalizeDeclineCodes([]string{"EXPIRED_CARD", "expired_card", "", "DECLINED", "do_not_contact"})
+	want := []string{DeclineCodeExpiredCard, DeclineCodeCardDeclined, DeclineCodeDoNotContact}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("normalizeDeclineCodes = %v, want %v", got, want)
+	}
+}
+
+func TestNonRetryableDeclineCodesAreNotRetried(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         string // NON_RETRYABLE_DECLINE_CODES, "" for the default list
+		amount      float64
+		wantRetried int
+	}{
+		{"expired card by default", "", 10.00 + MockAmountExpiredCard/100.0, 0},
+		{"card declined by default", "", 10.00 + MockAmountDeclined/100.0, 0},
+		{"gateway error without a code", "", 10.00 + MockAmountGatewayError/100.0, 1},
+		{"raw gateway code in the config", "EXPIRED_CARD", 10.00 + MockAmountExpiredCard/100.0, 0},
+		{"code left out of the config", "EXPIRED_CARD", 10.00 + MockAmountInsufficientFunds/100.0, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				t.Setenv("NON_RETRYABLE_DECLINE_CODES", tt.env)
+			}
+
+			f := newBillingFixture(t, tt.amount)
+			f.processDue(t)
+
+			retried, err := f.service.RetryFailedBilling(context.Background(), []time.Duration{0, 0, 0})
+			if err != nil {
+				t.Fatalf("RetryFailedBilling: %v", err)
+			}
+			if retried != tt.wantRetried {
+				t.Errorf("RetryFailedBilling scheduled %d retries, want %d", retried, tt.wantRetried)
+			}
+		})
+	}
+}
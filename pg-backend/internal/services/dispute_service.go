@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"pg-backend/internal/models"
+	"pg-backend/internal/repositories"
+
+	"github.com/google/uuid"
+)
+
+// disputeListLimit caps how many disputes ListDisputes returns
+const disputeListLimit = 100
+
+// DisputeNotification is a chargeback update received from the gateway
+type DisputeNotification struct {
+	GatewayDisputeID string
+	GatewayOrderID   string
+	Reason           string
+	Status           string
+	Amount           float64 // zero means the full amount of the disputed charge
+	Currency         string
+	EvidenceDue      sql.NullTime
+}
+
+// DisputeService tracks chargebacks raised against transactions
+type DisputeService interface {
+	HandleDisputeNotification(ctx context.Context, notification *DisputeNotification) (*models.Dispute, error)
+	ListDisputes(ctx context.Context, status string) ([]models.Dispute, error)
+	GetTransactionDisputes(ctx context.Context, transactionID uuid.UUID) ([]models.Dispute, error)
+}
+
+type disputeService struct {
+	disputeRepo     repositories.DisputeRepository
+	transactionRepo repositories.TransactionRepository
+	eventService    EventService
+}
+
+func NewDisputeService(
+	disputeRepo repositories.DisputeRepository,
+	transactionRepo repositories.TransactionRepository,
+	eventService EventService,
+) DisputeService {
+	return &disputeService{
+		disputeRepo:     disputeRepo,
+		transactionRepo: transactionRepo,
+		eventService:    eventService,
+	}
+}
+
+// HandleDisputeNotification creates or updates the dispute described by a
+// gateway notification and flags the disputed charge. Notifications may be
+// delivered more than once; repeats update the same dispute.
+func (s *disputeService) HandleDisputeNotification(ctx context.Context, notification *DisputeNotification) (*models.Dispute, error) {
+	if notification.GatewayDisputeID == "" {
+		return nil, &ValidationError{Message: "dispute ID is required"}
+	}
+	if !models.IsValidDisputeStatus(notification.Status) {
+		return nil, &ValidationError{Message: fmt.Sprintf("unknown dispute status %q", notification.Status)}
+	}
+
+	transaction, err := s.transactionRepo.GetChargeByGatewayOrderID(ctx, notification.GatewayOrderID)
+	if err != nil {
+		if _, ok := err.(*repositories.NotFoundError); ok {
+			return nil, &NotFoundError{Message: fmt.Sprintf("no transaction found for gateway order %s", notification.GatewayOrderID)}
+		}
+		return nil, err
+	}
+
+	dispute := &models.Dispute{
+		TransactionID:    transaction.ID,
+		GatewayDisputeID: notification.GatewayDisputeID,
+		Reason:           notification.Reason,
+		Status:           notification.Status,
+		Amount:           notification.Amount,
+		Currency:         notification.Currency,
+		EvidenceDue:      notification.EvidenceDue,
+	}
+	if dispute.Amount <= 0 {
+		dispute.Amount = transaction.Amount
+	}
+	if dispute.Currency == "" {
+		dispute.Currency = transaction.Currency
+	}
+
+	created, err := s.disputeRepo.UpsertDispute(ctx, dispute)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record dispute: %w", err)
+	}
+
+	eventType := models.EventDisputeUpdated
+	if created {
+		eventType = models.EventDisputeCreated
+	}
+	s.eventService.Publish(ctx, eventType, "dispute", dispute.ID, map[string]interface{}{
+		"transaction_id":     dispute.TransactionID,
+		"gateway_dispute_id": dispute.GatewayDisputeID,
+		"status":             dispute.Status,
+		"amount":             dispute.Amount,
+		"currency":           dispute.Currency,
+	})
+
+	s.flagDisputedTransaction(ctx, transaction, dispute)
+
+	return dispute, nil
+}
+
+// flagDisputedTransaction marks a succeeded charge, stored with the gateway's
+// status such as CAPTURED, as disputed. The dispute is already recorded, so a
+// failure here is logged rather than returned.
+func (s *disputeService) flagDisputedTransaction(ctx context.Context, transaction *models.Transaction, dispute *models.Dispute) {
+	if models.NormalizeTransactionStatus(transaction.Status) != models.TransactionStatusSucceeded {
+		return
+	}
+
+	audit := &models.TransactionStatusAudit{
+		TransactionID: transaction.ID,
+		FromStatus:    transaction.Status,
+		ToStatus:      models.TransactionStatusDisputed,
+		ChangedBy:     "gateway",
+		Reason:        fmt.Sprintf("chargeback %s: %s", dispute.GatewayDisputeID, dispute.Reason),
+	}
+	if err := s.transactionRepo.UpdateStatus(ctx, audit); err != nil {
+		if _, ok := err.(*repositories.ConflictError); !ok {
+			fmt.Printf("Warning: Failed to flag transaction %s as disputed: %v\n", transaction.ID, err)
+		}
+		return
+	}
+
+	s.eventService.Publish(ctx, models.EventTransactionStatusChanged, "transaction", transaction.ID, map[string]interface{}{
+		"from_status": audit.FromStatus,
+		"to_status":   audit.ToStatus,
+		"changed_by":  audit.ChangedBy,
+		"reason":      audit.Reason,
+		"audit_id":    audit.ID,
+		"dispute_id":  dispute.ID,
+	})
+}
+
+func (s *disputeService) ListDisputes(ctx context.Context, status string) ([]models.Dispute, error) {
+	if status != "" && !models.IsValidDisputeStatus(status) {
+		return nil, &ValidationError{Message: fmt.Sprintf("unknown dispute status %q", status)}
+	}
+
+	return s.disputeRepo.GetDisputes(ctx, status, disputeListLimit)
+}
+
+func (s *disputeService) GetTransactionDisputes(ctx context.Context, transactionID uuid.UUID) ([]models.Dispute, error) {
+	if _, err := s.transactionRepo.GetTransactionByID(ctx, transactionID); err != nil {
+		if _, ok := err.(*repositories.NotFoundError); ok {
+			return nil, &NotFoundError{Message: "transaction not found"}
+		}
+		return nil, err
+	}
+
+	return s.disputeRepo.GetDisputesByTransactionID(ctx, transactionID)
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"pg-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+func TestDisputeFlagsCapturedCharge(t *testing.T) {
+	tests := []struct {
+		status string
+		want   string
+	}{
+		{"CAPTURED", models.TransactionStatusDisputed},
+		{"APPROVED", models.TransactionStatusDisputed},
+		{models.TransactionStatusSucceeded, models.TransactionStatusDisputed},
+		{"DECLINED", "DECLINED"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			transactions := &fakeTransactionRepo{}
+			charge := &models.Transaction{UserID: uuid.New(), Amount: 25, Currency: "USD", Status: tt.status, GatewayOrderID: "order-1"}
+			transactions.CreateTransaction(context.Background(), charge)
+
+			service := NewDisputeService(fakeDisputeRepo{}, transactions, &fakeEventService{})
+			_, err := service.HandleDisputeNotification(context.Background(), &DisputeNotification{
+				GatewayDisputeID: "dispute-1",
+				GatewayOrderID:   "order-1",
+				Reason:           "fraud",
+				Status:           models.DisputeStatusOpen,
+			})
+			if err != nil {
+				t.Fatalf("HandleDisputeNotification: %v", err)
+			}
+
+			if got := transactions.transactions[0].Status; got != tt.want {
+				t.Errorf("transaction status = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
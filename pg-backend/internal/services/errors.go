@@ -18,9 +18,27 @@ func (e *DuplicateError) Error() string {
 }
 
 type ValidationError struct {
+	// Field names the request field at fault, when there is one
+	Field   string
 	Message string
 }
 
 func (e *ValidationError) Error() string {
 	return e.Message
 }
+
+type ConflictError struct {
+	Message string
+}
+
+func (e *ConflictError) Error() string {
+	return e.Message
+}
+
+type ForbiddenError struct {
+	Message string
+}
+
+func (e *ForbiddenError) Error() string {
+	return e.Message
+}
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"pg-backend/internal/models"
+	"pg-backend/internal/repositories"
+
+	"github.com/google/uuid"
+)
+
+// EventService records domain events for downstream consumers. Publishing is
+// best effort: a failure is logged and never fails the operation that emitted it.
+type EventService interface {
+	Publish(ctx context.Context, eventType, resourceType string, resourceID uuid.UUID, data map[string]interface{})
+}
+
+type eventService struct {
+	eventRepo repositories.EventRepository
+}
+
+func NewEventService(eventRepo repositories.EventRepository) EventService {
+	return &eventService{
+		eventRepo: eventRepo,
+	}
+}
+
+func (s *eventService) Publish(ctx context.Context, eventType, resourceType string, resourceID uuid.UUID, data map[string]interface{}) {
+	event := &models.Event{
+		Type:         eventType,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		Data:         data,
+	}
+
+	if err := s.eventRepo.CreateEvent(context.WithoutCancel(ctx), event); err != nil {
+		fmt.Printf("Warning: Failed to publish %s event for %s %s: %v\n", eventType, resourceType, resourceID, err)
+	}
+}
@@ -0,0 +1,415 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"pg-backend/internal/models"
+	"pg-backend/internal/repositories"
+
+	"github.com/google/uuid"
+)
+
+// In-memory repositories for service tests. Each embeds its interface, so
+// calling a method a fake doesn't implement panics and points at the gap.
+
+type fakeSubscriptionRepo struct {
+	repositories.SubscriptionRepository
+
+	mu            sync.Mutex
+	subscriptions map[uuid.UUID]*models.Subscription
+	imported      []repositories.SubscriptionImport
+}
+
+func newFakeSubscriptionRepo(subscriptions ...*models.Subscription) *fakeSubscriptionRepo {
+	r := &fakeSubscriptionRepo{subscriptions: make(map[uuid.UUID]*models.Subscription)}
+	for _, subscription := range subscriptions {
+		r.subscriptions[subscription.ID] = subscription
+	}
+	return r
+}
+
+func (r *fakeSubscriptionRepo) get(id uuid.UUID) models.Subscription {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return *r.subscriptions[id]
+}
+
+func (r *fakeSubscriptionRepo) CreateSubscription(ctx context.Context, subscription *models.Subscription) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	subscription.ID = uuid.New()
+	subscription.CreatedAt = time.Now()
+	copied := *subscription
+	r.subscriptions[subscription.ID] = &copied
+	return nil
+}
+
+func (r *fakeSubscriptionRepo) ImportSubscriptions(ctx context.Context, imports []repositories.SubscriptionImport) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, item := range imports {
+		item.Subscription.ID = uuid.New()
+		r.imported = append(r.imported, item)
+	}
+	return nil
+}
+
+func (r *fakeSubscriptionRepo) GetSubscriptionByID(ctx context.Context, id uuid.UUID) (*models.Subscription, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	subscription, ok := r.subscriptions[id]
+	if !ok {
+		return nil, &repositories.NotFoundError{Message: "subscription not found"}
+	}
+	copied := *subscription
+	return &copied, nil
+}
+
+func (r *fakeSubscriptionRepo) GetSubscriptionsByUserID(ctx context.Context, userID uuid.UUID, status string) ([]models.Subscription, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var subscriptions []models.Subscription
+	for _, subscription := range r.subscriptions {
+		if subscription.UserID == userID && (status == "" || string(subscription.Status) == status) {
+			subscriptions = append(subscriptions, *subscription)
+		}
+	}
+	return subscriptions, nil
+}
+
+func (r *fakeSubscriptionRepo) UpdateSubscription(ctx context.Context, subscription *models.Subscription) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copied := *subscription
+	r.subscriptions[subscription.ID] = &copied
+	return nil
+}
+
+// GetSubscriptionsDueForBilling applies the same filter as the SQL query
+func (r *fakeSubscriptionRepo) GetSubscriptionsDueForBilling(ctx context.Context, cutoffTime time.Time, limit int) ([]models.Subscription, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var due []models.Subscription
+	for _, subscription := range r.subscriptions {
+		if subscription.Status != models.SubscriptionStatusActive && subscription.Status != models.SubscriptionStatusTrialing {
+			continue
+		}
+		if subscription.CancelAtPeriodEnd || subscription.NextBillingAt.After(cutoffTime) {
+			continue
+		}
+		if subscription.TrialEnd.Valid && subscription.TrialEnd.Time.After(time.Now()) {
+			continue
+		}
+		due = append(due, *subscription)
+		if len(due) == limit {
+			break
+		}
+	}
+	return due, nil
+}
+
+func (r *fakeSubscriptionRepo) CountActiveSubscriptionsByUserID(ctx context.Context, userID uuid.UUID) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	count := 0
+	for _, subscription := range r.subscriptions {
+		if subscription.UserID != userID {
+			continue
+		}
+		switch subscription.Status {
+		case models.SubscriptionStatusActive, models.SubscriptionStatusTrialing, models.SubscriptionStatusIncomplete:
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *fakeSubscriptionRepo) SetInitialTraceID(ctx context.Context, id uuid.UUID, traceID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if subscription, ok := r.subscriptions[id]; ok && subscription.InitialTraceID == "" {
+		subscription.InitialTraceID = traceID
+	}
+	return nil
+}
+
+func (r *fakeSubscriptionRepo) HasHadTrialOrPaidPeriod(ctx context.Context, userID uuid.UUID, planID uuid.NullUUID) (bool, error) {
+	return false, nil
+}
+
+type fakeBillingRepo struct {
+	repositories.BillingRepository
+
+	mu       sync.Mutex
+	attempts []*models.BillingAttempt
+	created  time.Time
+}
+
+func newFakeBillingRepo() *fakeBillingRepo {
+	return &fakeBillingRepo{created: time.Now().Add(-time.Hour)}
+}
+
+func (r *fakeBillingRepo) forSubscription(subscriptionID uuid.UUID) []models.BillingAttempt {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var attempts []models.BillingAttempt
+	for _, attempt := range r.attempts {
+		if attempt.SubscriptionID == subscriptionID {
+			attempts = append(attempts, *attempt)
+		}
+	}
+	return attempts
+}
+
+func (r *fakeBillingRepo) CreateBillingAttempt(ctx context.Context, attempt *models.BillingAttempt) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// Strictly increasing, like created_at on consecutive inserts
+	r.created = r.created.Add(time.Millisecond)
+	attempt.ID = uuid.New()
+	attempt.CreatedAt = r.created
+	copied := *attempt
+	r.attempts = append(r.attempts, &copied)
+	return nil
+}
+
+func (r *fakeBillingRepo) UpdateBillingAttempt(ctx context.Context, attempt *models.BillingAttempt) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, existing := range r.attempts {
+		if existing.ID == attempt.ID {
+			copied := *attempt
+			r.attempts[i] = &copied
+			return nil
+		}
+	}
+	return &repositories.NotFoundError{Message: "billing attempt not found"}
+}
+
+func (r *fakeBillingRepo) GetPendingBillingAttempts(ctx context.Context, limit int) ([]models.BillingAttempt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var pending []models.BillingAttempt
+	for _, attempt := range r.attempts {
+		if attempt.Status == models.BillingAttemptStatusPending && !attempt.ScheduledAt.After(time.Now()) {
+			pending = append(pending, *attempt)
+		}
+	}
+	return pending, nil
+}
+
+// GetFailedBillingAttemptsForRetry applies the same filter as the SQL query
+func (r *fakeBillingRepo) GetFailedBillingAttemptsForRetry(ctx context.Context, maxAttempts int, olderThan time.Time, nonRetryableCodes []string) ([]models.BillingAttempt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var failed []models.BillingAttempt
+	for _, attempt := range r.attempts {
+		if attempt.Status != models.BillingAttemptStatusFailed || attempt.AttemptNumber >= maxAttempts {
+			continue
+		}
+		if !attempt.ProcessedAt.Valid || !attempt.ProcessedAt.Time.Before(olderThan) {
+			continue
+		}
+		if attempt.ErrorCode.Valid && containsString(nonRetryableCodes, attempt.ErrorCode.String) {
+			continue
+		}
+		superseded := false
+		for _, later := range r.attempts {
+			if later.SubscriptionID == attempt.SubscriptionID && later.CreatedAt.After(attempt.CreatedAt) {
+				superseded = true
+			}
+		}
+		if !superseded {
+			failed = append(failed, *attempt)
+		}
+	}
+	return failed, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// fakeUserRepo finds every user
+type fakeUserRepo struct {
+	repositories.UserRepository
+}
+
+func (fakeUserRepo) GetUserByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	return &models.User{ID: id}, nil
+}
+
+type fakeCardRepo struct {
+	repositories.CardRepository
+
+	cards map[uuid.UUID]*models.Card
+}
+
+func newFakeCardRepo(cards ...*models.Card) *fakeCardRepo {
+	r := &fakeCardRepo{cards: make(map[uuid.UUID]*models.Card)}
+	for _, card := range cards {
+		r.cards[card.ID] = card
+	}
+	return r
+}
+
+func (r *fakeCardRepo) GetCardByID(ctx context.Context, id uuid.UUID) (*models.Card, error) {
+	card, ok := r.cards[id]
+	if !ok {
+		return nil, &repositories.NotFoundError{Message: "card not found"}
+	}
+	copied := *card
+	return &copied, nil
+}
+
+type fakePlanRepo struct {
+	repositories.PlanRepository
+
+	plans map[uuid.UUID]*models.Plan
+}
+
+func newFakePlanRepo(plans ...*models.Plan) *fakePlanRepo {
+	r := &fakePlanRepo{plans: make(map[uuid.UUID]*models.Plan)}
+	for _, plan := range plans {
+		r.plans[plan.ID] = plan
+	}
+	return r
+}
+
+func (r *fakePlanRepo) GetPlanByID(ctx context.Context, id uuid.UUID) (*models.Plan, error) {
+	plan, ok := r.plans[id]
+	if !ok {
+		return nil, &repositories.NotFoundError{Message: "plan not found"}
+	}
+	copied := *plan
+	return &copied, nil
+}
+
+// UpdatePlan applies the same version check as the SQL WHERE clause: a plan
+// whose UpdatedAt is set only overwrites that version of the row
+func (r *fakePlanRepo) UpdatePlan(ctx context.Context, plan *models.Plan) error {
+	existing, ok := r.plans[plan.ID]
+	if !ok || (!plan.UpdatedAt.IsZero() && !plan.UpdatedAt.Equal(existing.UpdatedAt)) {
+		return &repositories.NotFoundError{Message: "plan not found"}
+	}
+	plan.UpdatedAt = existing.UpdatedAt.Add(time.Second)
+	copied := *plan
+	r.plans[plan.ID] = &copied
+	return nil
+}
+
+func (r *fakePlanRepo) GetAllPlans(ctx context.Context, activeOnly bool) ([]models.Plan, error) {
+	var plans []models.Plan
+	for _, plan := range r.plans {
+		if !activeOnly || plan.IsActive {
+			plans = append(plans, *plan)
+		}
+	}
+	return plans, nil
+}
+
+// fakeCreditRepo holds no credit, so every charge goes to the card. Credit
+// granted, e.g. for unused time on a plan change, is recorded in entries.
+type fakeCreditRepo struct {
+	repositories.CreditRepository
+
+	mu      sync.Mutex
+	entries []models.CreditEntry
+}
+
+func (r *fakeCreditRepo) CreateCreditEntry(ctx context.Context, entry *models.CreditEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry.ID = uuid.New()
+	r.entries = append(r.entries, *entry)
+	return nil
+}
+
+func (r *fakeCreditRepo) ApplyCredit(ctx context.Context, userID, billingAttemptID uuid.UUID, currency string, maxAmount float64) (float64, error) {
+	return 0, nil
+}
+
+func (r *fakeCreditRepo) GetAppliedCredit(ctx context.Context, billingAttemptID uuid.UUID) (float64, error) {
+	return 0, nil
+}
+
+func (r *fakeCreditRepo) ReleaseAppliedCredit(ctx context.Context, billingAttemptID uuid.UUID) error {
+	return nil
+}
+
+type fakeTransactionRepo struct {
+	repositories.TransactionRepository
+
+	mu           sync.Mutex
+	transactions []models.Transaction
+}
+
+func (r *fakeTransactionRepo) CreateTransaction(ctx context.Context, transaction *models.Transaction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	transaction.ID = uuid.New()
+	r.transactions = append(r.transactions, *transaction)
+	return nil
+}
+
+func (r *fakeTransactionRepo) CreateSubscriptionTransaction(ctx context.Context, transaction *models.Transaction, subscriptionID, billingAttemptID uuid.UUID) error {
+	return r.CreateTransaction(ctx, transaction)
+}
+
+func (r *fakeTransactionRepo) GetChargeByGatewayOrderID(ctx context.Context, orderID string) (*models.Transaction, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.transactions) - 1; i >= 0; i-- {
+		if r.transactions[i].GatewayOrderID == orderID {
+			copied := r.transactions[i]
+			return &copied, nil
+		}
+	}
+	return nil, &repositories.NotFoundError{Message: "transaction not found"}
+}
+
+// UpdateStatus applies the change only from the audit's FromStatus, as the
+// SQL update does
+func (r *fakeTransactionRepo) UpdateStatus(ctx context.Context, audit *models.TransactionStatusAudit) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.transactions {
+		if r.transactions[i].ID != audit.TransactionID {
+			continue
+		}
+		if r.transactions[i].Status != audit.FromStatus {
+			return &repositories.ConflictError{Message: "transaction status has changed"}
+		}
+		r.transactions[i].Status = audit.ToStatus
+		audit.ID = uuid.New()
+		return nil
+	}
+	return &repositories.NotFoundError{Message: "transaction not found"}
+}
+
+type fakeDisputeRepo struct {
+	repositories.DisputeRepository
+}
+
+func (fakeDisputeRepo) UpsertDispute(ctx context.Context, dispute *models.Dispute) (bool, error) {
+	dispute.ID = uuid.New()
+	return true, nil
+}
+
+type fakeEventService struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (s *fakeEventService) Publish(ctx context.Context, eventType, resourceType string, resourceID uuid.UUID, data map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, eventType)
+}
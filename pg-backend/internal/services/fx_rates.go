@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"pg-backend/internal/config"
+)
+
+// FXRateSource supplies the exchange rate for charges presented to the
+// customer in one currency and settled in another
+type FXRateSource interface {
+	Rate(ctx context.Context, from, to string) (float64, error)
+}
+
+// NewFXRateSource returns a rate source backed by the configured FX_RATES
+// table, or one that converts 1:1 when no rates are configured
+func NewFXRateSource(cfg *config.Config) FXRateSource {
+	rates := cfg.FXRates()
+	if len(rates) == 0 {
+		return parRateSource{}
+	}
+	return &staticRateSource{rates: rates}
+}
+
+// parRateSource treats every currency pair as 1:1
+type parRateSource struct{}
+
+func (parRateSource) Rate(ctx context.Context, from, to string) (float64, error) {
+	return 1, nil
+}
+
+// staticRateSource looks rates up in a fixed FROM/TO table, using the inverse
+// of TO/FROM when only that direction is listed
+type staticRateSource struct {
+	rates map[string]float64
+}
+
+func (s *staticRateSource) Rate(ctx context.Context, from, to string) (float64, error) {
+	from, to = strings.ToUpper(from), strings.ToUpper(to)
+	if from == to {
+		return 1, nil
+	}
+	if rate, ok := s.rates[from+"/"+to]; ok {
+		return rate, nil
+	}
+	if rate, ok := s.rates[to+"/"+from]; ok {
+		return 1 / rate, nil
+	}
+	return 0, &ValidationError{
+		Field:   "settlement_currency",
+		Message: fmt.Sprintf("no exchange rate configured from %s to %s", from, to),
+	}
+}
+
+// ConvertAmount converts amount from one currency to another at the source's
+// rate, rounded to the target currency's precision, and returns the rate used
+func ConvertAmount(ctx context.Context, source FXRateSource, amount float64, from, to string) (converted, rate float64, err error) {
+	rate, err = source.Rate(ctx, from, to)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	scale := math.Pow10(currencyDecimalPlaces(to))
+	return math.Round(amount*rate*scale) / scale, rate, nil
+}
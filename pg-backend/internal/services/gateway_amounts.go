@@ -0,0 +1,125 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"pg-backend/internal/models"
+)
+
+// GatewayAmount is an amount from a gateway response. The gateway sends
+// amounts as JSON numbers or strings in several shapes (10, 10.0, "10.00",
+// 1e1); all decode to the same shortest exact decimal string, "10".
+type GatewayAmount string
+
+// UnmarshalJSON accepts a JSON number, a numeric string or null
+func (a *GatewayAmount) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		*a = ""
+		return nil
+	}
+
+	raw := string(data)
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+		if raw = strings.TrimSpace(raw); raw == "" {
+			*a = ""
+			return nil
+		}
+	}
+
+	// Parsing as a rational keeps decimals like 0.1 exact
+	value, ok := new(big.Rat).SetString(raw)
+	if !ok {
+		return fmt.Errorf("invalid gateway amount %q", raw)
+	}
+
+	*a = GatewayAmount(formatRat(value))
+	return nil
+}
+
+// String returns the normalized decimal amount, or "" when the gateway sent none
+func (a GatewayAmount) String() string {
+	return string(a)
+}
+
+// Float64 returns the amount as a float, 0 when it is empty
+func (a GatewayAmount) Float64() float64 {
+	f, _ := strconv.ParseFloat(string(a), 64)
+	return f
+}
+
+// MinorUnits returns the amount in the currency's smallest unit, e.g. 1050
+// for 10.5 USD or 1000 for 1000 JPY. Amounts with more precision than the
+// currency allows are an error rather than being rounded.
+func (a GatewayAmount) MinorUnits(currency string) (int64, error) {
+	value, ok := new(big.Rat).SetString(string(a))
+	if !ok {
+		return 0, fmt.Errorf("invalid gateway amount %q", string(a))
+	}
+
+	scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(currencyDecimalPlaces(currency))), nil))
+	value.Mul(value, scale)
+	if !value.IsInt() || !value.Num().IsInt64() {
+		return 0, fmt.Errorf("amount %s has more precision than %s allows", string(a), currency)
+	}
+	return value.Num().Int64(), nil
+}
+
+// formatRat writes value as a decimal without trailing zeros
+func formatRat(value *big.Rat) string {
+	text := value.FloatString(10)
+	text = strings.TrimRight(text, "0")
+	return strings.TrimSuffix(text, ".")
+}
+
+// amountTolerance is half of the currency's smallest unit: amounts closer
+// than this are the same once rounded to the currency's precision
+func amountTolerance(currency string) float64 {
+	return math.Pow10(-currencyDecimalPlaces(currency)) / 2
+}
+
+// currencyDecimalPlaces is how many decimals the currency's minor unit has
+func currencyDecimalPlaces(currency string) int {
+	return models.CurrencyDecimalPlaces(currency)
+}
+
+// verifyOrderAmount checks that a PAY or AUTHORIZE response echoes the amount
+// and currency that were requested. Responses without an order, such as
+// request errors, are not checked.
+func verifyOrderAmount(response *PaymentResponse, amount, currency string) error {
+	if response.Order.ID == "" && response.Order.Currency == "" {
+		return nil
+	}
+
+	returnedAmount := response.Order.Amount.String()
+
+	mismatch := &AmountMismatchError{
+		OrderID:           response.Order.ID,
+		RequestedAmount:   amount,
+		RequestedCurrency: currency,
+		ReturnedAmount:    returnedAmount,
+		ReturnedCurrency:  response.Order.Currency,
+		Response:          response,
+	}
+
+	requested, reqErr := strconv.ParseFloat(amount, 64)
+	returned, retErr := strconv.ParseFloat(returnedAmount, 64)
+	if reqErr != nil || retErr != nil ||
+		!strings.EqualFold(response.Order.Currency, currency) ||
+		math.Abs(requested-returned) >= amountTolerance(currency) {
+		log.Printf("ERROR: %v", mismatch)
+		return mismatch
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestGatewayAmountShapes(t *testing.T) {
+	tests := []struct {
+		json      string
+		want      string
+		currency  string
+		wantMinor int64
+	}{
+		{`10`, "10", "USD", 1000},
+		{`10.0`, "10", "USD", 1000},
+		{`10.00`, "10", "USD", 1000},
+		{`"10"`, "10", "USD", 1000},
+		{`"10.00"`, "10", "USD", 1000},
+		{`" 10.00 "`, "10", "USD", 1000},
+		{`1e1`, "10", "USD", 1000},
+		{`"1.0E1"`, "10", "USD", 1000},
+		{`1.05e1`, "10.5", "USD", 1050},
+		{`10.5`, "10.5", "USD", 1050},
+		{`"10.50"`, "10.5", "USD", 1050},
+		{`0.1`, "0.1", "USD", 10},
+		{`1234567.89`, "1234567.89", "USD", 123456789},
+		{`1000`, "1000", "JPY", 1000},
+		{`"12.345"`, "12.345", "KWD", 12345},
+	}
+
+	for _, tt := range tests {
+		var response PaymentResponse
+		if err := json.Unmarshal([]byte(`{"order":{"id":"1","amount":`+tt.json+`,"currency":"`+tt.currency+`"}}`), &response); err != nil {
+			t.Errorf("amount %s: %v", tt.json, err)
+			continue
+		}
+		if got := response.Order.Amount.String(); got != tt.want {
+			t.Errorf("amount %s decoded as %q, want %q", tt.json, got, tt.want)
+		}
+		minor, err := response.Order.Amount.MinorUnits(tt.currency)
+		if err != nil || minor != tt.wantMinor {
+			t.Errorf("amount %s is %d %s minor units (%v), want %d", tt.json, minor, tt.currency, err, tt.wantMinor)
+		}
+	}
+}
+
+func TestGatewayAmountMissing(t *testing.T) {
+	for _, raw := range []string{`null`, `""`, `"  "`} {
+		var amount GatewayAmount
+		if err := json.Unmarshal([]byte(raw), &amount); err != nil {
+			t.Errorf("amount %s: %v", raw, err)
+			continue
+		}
+		if amount != "" {
+			t.Errorf("amount %s decoded as %q, want empty", raw, amount)
+		}
+	}
+
+	// An order without an amount field decodes to the empty amount too
+	var response PaymentResponse
+	if err := json.Unmarshal([]byte(`{"order":{"id":"1"}}`), &response); err != nil || response.Order.Amount != "" {
+		t.Errorf("order without an amount decoded as %q (%v)", response.Order.Amount, err)
+	}
+}
+
+func TestGatewayAmountInvalid(t *testing.T) {
+	for _, raw := range []string{`"ten"`, `"10,00"`, `true`, `{}`} {
+		var amount GatewayAmount
+		if err := json.Unmarshal([]byte(raw), &amount); err == nil {
+			t.Errorf("amount %s decoded as %q, want an error", raw, amount)
+		}
+	}
+
+	// More precision than the currency has is not rounded away
+	if _, err := GatewayAmount("10.005").MinorUnits("USD"); err == nil {
+		t.Error("10.005 USD converted to minor units, want an error")
+	}
+	if _, err := GatewayAmount("10.5").MinorUnits("JPY"); err == nil {
+		t.Error("10.5 JPY converted to minor units, want an error")
+	}
+}
+
+func TestVerifyOrderAmountShapes(t *testing.T) {
+	tests := []struct {
+		json         string
+		amount       string
+		wantMismatch bool
+	}{
+		{`10`, "10.00", false},
+		{`"10.00"`, "10", false},
+		{`1e1`, "10.00", false},
+		{`10.004`, "10.00", false},
+		{`10.01`, "10.00", true},
+		{`"9.99"`, "10.00", true},
+	}
+
+	for _, tt := range tests {
+		var response PaymentResponse
+		if err := json.Unmarshal([]byte(`{"order":{"id":"1","amount":`+tt.json+`,"currency":"USD"}}`), &response); err != nil {
+			t.Fatalf("amount %s: %v", tt.json, err)
+		}
+
+		err := verifyOrderAmount(&response, tt.amount, "USD")
+		var mismatch *AmountMismatchError
+		if got := errors.As(err, &mismatch); got != tt.wantMismatch {
+			t.Errorf("returned %s for %s: error = %v, want mismatch %v", tt.json, tt.amount, err, tt.wantMismatch)
+		}
+	}
+}
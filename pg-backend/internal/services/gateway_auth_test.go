@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"pg-backend/internal/config"
+)
+
+// writeClientCertificate writes a self-signed client certificate and its key
+// to t's temp dir and returns their paths
+func writeClientCertificate(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "merchant." + testMerchantID},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	certFile, keyFile := writeClientCertificate(t)
+
+	tests := []struct {
+		name      string
+		env       map[string]string
+		wantBasic bool
+		wantErr   bool
+	}{
+		{"basic by default", nil, true, false},
+		{"certificate when a client certificate is configured",
+			map[string]string{"GATEWAY_CLIENT_CERT_FILE": certFile, "GATEWAY_CLIENT_KEY_FILE": keyFile}, false, false},
+		{"basic chosen despite a client certificate",
+			map[string]string{"GATEWAY_AUTH_METHOD": "basic", "GATEWAY_CLIENT_CERT_FILE": certFile, "GATEWAY_CLIENT_KEY_FILE": keyFile}, true, false},
+		{"certificate without a key",
+			map[string]string{"GATEWAY_CLIENT_CERT_FILE": certFile}, false, true},
+		{"certificate without any files",
+			map[string]string{"GATEWAY_AUTH_METHOD": "certificate"}, false, true},
+		{"unknown method",
+			map[string]string{"GATEWAY_AUTH_METHOD": "oauth"}, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			authenticator, err := NewAuthenticator(&config.Config{MastercardMerchantID: testMerchantID, MastercardAPIPassword: "secret"})
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("NewAuthenticator = %T, want an error", authenticator)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewAuthenticator: %v", err)
+			}
+			if _, basic := authenticator.(*BasicAuthenticator); basic != tt.wantBasic {
+				t.Errorf("NewAuthenticator = %T, want basic %v", authenticator, tt.wantBasic)
+			}
+		})
+	}
+}
+
+func TestCertificateAuthentication(t *testing.T) {
+	certFile, keyFile := writeClientCertificate(t)
+	authenticator, err := NewCertificateAuthenticator(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewCertificateAuthenticator: %v", err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			t.Error("request made without a client certificate")
+		}
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			t.Errorf("Authorization = %q, want no password sent", auth)
+		}
+		io.WriteString(w, `{"session":{"id":"SESSION0001"}}`)
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	// srv.Client trusts the test server; the authenticator adds the client certificate
+	client := srv.Client()
+	authenticator.ConfigureClient(client)
+
+	cfg := &config.Config{MastercardMerchantID: testMerchantID, MastercardHost: strings.TrimPrefix(srv.URL, "https://")}
+	gateway := NewMastercardServiceWithClient(cfg, authenticator, nil, client)
+	if _, err := gateway.TestConnection(context.Background()); err != nil {
+		t.Fatalf("TestConnection: %v", err)
+	}
+}
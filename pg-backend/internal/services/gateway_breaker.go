@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"pg-backend/internal/config"
+)
+
+// ErrGatewayUnavailable is returned without contacting the gateway while the
+// circuit breaker is open. Nothing was sent, so the request can safely be
+// tried again later.
+var ErrGatewayUnavailable = errors.New("payment gateway unavailable: circuit breaker is open")
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// GatewayBreakerState is a snapshot of the gateway circuit breaker for health
+// checks and monitoring
+type GatewayBreakerState struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+	RetryAt             *time.Time `json:"retry_at,omitempty"`
+	Trips               int64      `json:"trips"`             // times the breaker has opened
+	RejectedRequests    int64      `json:"rejected_requests"` // requests failed fast while open
+}
+
+// GatewayBreaker stops calling the gateway after too many consecutive
+// failures. While open every request fails with ErrGatewayUnavailable; once
+// the cooldown has passed one trial request is let through, which closes the
+// breaker if it succeeds and reopens it if it fails.
+type GatewayBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu          sync.Mutex
+	state       string
+	failures    int
+	openedAt    time.Time
+	trialActive bool
+	trips       int64
+	rejected    int64
+}
+
+// NewGatewayBreaker returns a closed breaker configured from cfg. A threshold
+// of 0 disables it.
+func NewGatewayBreaker(cfg *config.Config) *GatewayBreaker {
+	return &GatewayBreaker{
+		threshold: cfg.GatewayBreakerThreshold(),
+		cooldown:  cfg.GatewayBreakerCooldown(),
+		state:     BreakerClosed,
+	}
+}
+
+// allow reports whether a request may be sent now
+func (b *GatewayBreaker) allow() error {
+	if b == nil || b.threshold <= 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			b.rejected++
+			return ErrGatewayUnavailable
+		}
+		b.state = BreakerHalfOpen
+		b.trialActive = true
+		log.Printf("Gateway circuit breaker half-open; sending a trial request")
+		return nil
+	case BreakerHalfOpen:
+		// Only the trial request goes through until it has an answer
+		if b.trialActive {
+			b.rejected++
+			return ErrGatewayUnavailable
+		}
+		b.trialActive = true
+	}
+	return nil
+}
+
+// record updates the breaker with the outcome of a request allow let through
+func (b *GatewayBreaker) record(err error) {
+	if b == nil || b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trialActive = false
+	if errors.Is(err, context.Canceled) {
+		// The caller gave up; that says nothing about the gateway
+		return
+	}
+	if !isGatewayFailure(err) {
+		if b.state != BreakerClosed {
+			log.Printf("Gateway circuit breaker closed; the gateway is responding again")
+		}
+		b.state = BreakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= b.threshold) {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+		b.trips++
+		log.Printf("Gateway circuit breaker opened after %d consecutive failures; retrying in %v: %v", b.failures, b.cooldown, err)
+	}
+}
+
+// IsOpen reports whether requests are currently being failed fast
+func (b *GatewayBreaker) IsOpen() bool {
+	if b == nil || b.threshold <= 0 {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == BreakerOpen && time.Since(b.openedAt) < b.cooldown
+}
+
+// State returns a snapshot of the breaker
+func (b *GatewayBreaker) State() GatewayBreakerState {
+	if b == nil {
+		return GatewayBreakerState{State: BreakerClosed}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := GatewayBreakerState{
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		Trips:               b.trips,
+		RejectedRequests:    b.rejected,
+	}
+	if b.state != BreakerClosed {
+		openedAt := b.openedAt
+		retryAt := openedAt.Add(b.cooldown)
+		state.OpenedAt = &openedAt
+		state.RetryAt = &retryAt
+	}
+	return state
+}
+
+// isGatewayFailure reports whether err suggests the gateway itself is
+// unhealthy: it could not be reached, timed out or answered with a server
+// error. Declines and rejected requests show the gateway is working.
+func isGatewayFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var apiErr *GatewayAPIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= http.StatusInternalServerError || apiErr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
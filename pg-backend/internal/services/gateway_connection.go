@@ -0,0 +1,57 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ConnectionTestResult describes a successful gateway credentials check
+type ConnectionTestResult struct {
+	MerchantID           string `json:"merchant_id"`
+	Host                 string `json:"host"`
+	SessionID            string `json:"session_id"`
+	CorrelationID        string `json:"correlation_id"`
+	GatewayCorrelationID string `json:"gateway_correlation_id,omitempty"`
+	DurationMS           int64  `json:"duration_ms"`
+}
+
+// TestConnection checks the configured merchant credentials by creating a
+// throwaway checkout session and reading it back. Nothing is charged or
+// stored; the session simply expires at the gateway.
+func (s *mastercardService) TestConnection(ctx context.Context) (*ConnectionTestResult, error) {
+	started := time.Now()
+	sessionsEndpoint := fmt.Sprintf("/api/rest/version/100/merchant/%s/session", s.cfg.MastercardMerchantID)
+
+	created, err := s.sendRequest(ctx, "POST", sessionsEndpoint, map[string]interface{}{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	var session struct {
+		Session struct {
+			ID string `json:"id"`
+		} `json:"session"`
+	}
+	if err := json.Unmarshal(created.Body, &session); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal session: %v", err)
+	}
+	if session.Session.ID == "" {
+		return nil, fmt.Errorf("gateway returned no session ID (correlation ID %s)", created.Trace.CorrelationID)
+	}
+
+	retrieved, err := s.sendRequest(ctx, "GET", sessionsEndpoint+"/"+session.Session.ID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve session %s: %w", session.Session.ID, err)
+	}
+
+	return &ConnectionTestResult{
+		MerchantID:           s.cfg.MastercardMerchantID,
+		Host:                 s.cfg.MastercardHost,
+		SessionID:            session.Session.ID,
+		CorrelationID:        retrieved.Trace.CorrelationID,
+		GatewayCorrelationID: retrieved.Trace.GatewayCorrelationID,
+		DurationMS:           time.Since(started).Milliseconds(),
+	}, nil
+}
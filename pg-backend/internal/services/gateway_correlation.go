@@ -0,0 +1,42 @@
+package services
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// correlationIDHeader carries the ID we generate for each gateway request.
+// Quote it, with the gateway's own ID when there is one, on support cases.
+const correlationIDHeader = "Correlation-Id"
+
+// gatewayCorrelationHeaders are the response headers the gateway may return
+// its own trace ID in, in order of preference
+var gatewayCorrelationHeaders = []string{"Correlation-Id", "X-Correlation-Id", "Trace-Id", "X-Trace-Id"}
+
+// GatewayTrace identifies one gateway request: the correlation ID we sent,
+// and the gateway's own trace ID if it returned one
+type GatewayTrace struct {
+	CorrelationID        string
+	GatewayCorrelationID string
+}
+
+// gatewayReply is a successful gateway response and the request's trace
+type gatewayReply struct {
+	Body  []byte
+	Trace GatewayTrace
+}
+
+func newCorrelationID() string {
+	return uuid.NewString()
+}
+
+// gatewayCorrelationID returns the trace ID the gateway sent back, if any
+func gatewayCorrelationID(header http.Header) string {
+	for _, name := range gatewayCorrelationHeaders {
+		if value := header.Get(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
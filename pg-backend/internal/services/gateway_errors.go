@@ -0,0 +1,121 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+// GatewayAPIError is returned when the gateway answers with a non-success HTTP status
+type GatewayAPIError struct {
+	StatusCode int
+	Body       string
+	Trace      GatewayTrace
+}
+
+func (e *GatewayAPIError) Error() string {
+	if e.Trace.GatewayCorrelationID != "" {
+		return fmt.Sprintf("API error %d (correlation ID %s, gateway trace ID %s): %s", e.StatusCode, e.Trace.CorrelationID, e.Trace.GatewayCorrelationID, e.Body)
+	}
+	if e.Trace.CorrelationID != "" {
+		return fmt.Sprintf("API error %d (correlation ID %s): %s", e.StatusCode, e.Trace.CorrelationID, e.Body)
+	}
+	return fmt.Sprintf("API error %d: %s", e.StatusCode, e.Body)
+}
+
+// Detail returns the gateway's own explanation of the error, e.g.
+// "INVALID_REQUEST: Invalid credentials.", or the raw body if it gave none
+func (e *GatewayAPIError) Detail() string {
+	var body struct {
+		Error struct {
+			Cause       string `json:"cause"`
+			Explanation string `json:"explanation"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(e.Body), &body); err != nil || body.Error.Cause == "" {
+		return e.Body
+	}
+	if body.Error.Explanation == "" {
+		return body.Error.Cause
+	}
+	return body.Error.Cause + ": " + body.Error.Explanation
+}
+
+// AmbiguousPaymentError is returned when a payment request may have reached
+// the gateway but no definitive answer came back (timeout, dropped
+// connection, upstream gateway error). The charge may still have succeeded
+// under OrderID, so callers must reconcile with RetrieveOrder before retrying.
+type AmbiguousPaymentError struct {
+	OrderID string
+	Err     error
+}
+
+func (e *AmbiguousPaymentError) Error() string {
+	return fmt.Sprintf("payment outcome unknown for order %s: %v", e.OrderID, e.Err)
+}
+
+func (e *AmbiguousPaymentError) Unwrap() error {
+	return e.Err
+}
+
+// isOutcomeUnknown reports whether err leaves it unclear if the gateway
+// processed the request. Failures to connect at all are not ambiguous.
+func isOutcomeUnknown(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return false
+	}
+
+	var apiErr *GatewayAPIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusBadGateway || apiErr.StatusCode == http.StatusGatewayTimeout
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
+
+// ambiguousIfUnknown wraps err as an AmbiguousPaymentError for orderID when
+// the outcome of the request cannot be determined
+func ambiguousIfUnknown(orderID string, err error) error {
+	if isOutcomeUnknown(err) {
+		return &AmbiguousPaymentError{OrderID: orderID, Err: err}
+	}
+	return err
+}
+
+// AmountMismatchError is returned when the gateway's response to a PAY or
+// AUTHORIZE reports a different order amount or currency from the request.
+// The gateway may still have processed the order, so it must be reconciled
+// by hand and never retried as an ordinary failure. Response is the gateway's
+// reply, kept so the order can be recorded for review.
+type AmountMismatchError struct {
+	OrderID           string
+	RequestedAmount   string
+	RequestedCurrency string
+	ReturnedAmount    string
+	ReturnedCurrency  string
+	Response          *PaymentResponse
+}
+
+func (e *AmountMismatchError) Error() string {
+	return fmt.Sprintf("gateway returned %s %s for order %s, requested %s %s",
+		e.ReturnedAmount, e.ReturnedCurrency, e.OrderID, e.RequestedAmount, e.RequestedCurrency)
+}
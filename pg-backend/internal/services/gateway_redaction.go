@@ -0,0 +1,59 @@
+package services
+
+import "encoding/json"
+
+// redactedValue replaces sensitive values in stored gateway payloads
+const redactedValue = "[REDACTED]"
+
+// sensitiveGatewayFields are payload keys whose values must never be stored
+// or shown back to staff: card and wallet credentials, stored-card tokens and
+// cardholder names
+var sensitiveGatewayFields = map[string]bool{
+	"number":                  true,
+	"securityCode":            true,
+	"expiry":                  true,
+	"nameOnCard":              true,
+	"token":                   true,
+	"paymentToken":            true,
+	"onlinePaymentCryptogram": true,
+	"cryptogram":              true,
+	"deviceSpecificNumber":    true,
+	"password":                true,
+}
+
+// redactGatewayResponse returns a copy of a gateway payload with sensitive
+// fields replaced, at any depth. A payload that is not valid JSON is dropped
+// rather than stored unredacted.
+func redactGatewayResponse(body []byte) json.RawMessage {
+	var payload interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil
+	}
+
+	redacted, err := json.Marshal(redactGatewayValue(payload))
+	if err != nil {
+		return nil
+	}
+	return redacted
+}
+
+func redactGatewayValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if sensitiveGatewayFields[key] {
+				v[key] = redactedValue
+				continue
+			}
+			v[key] = redactGatewayValue(child)
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = redactGatewayValue(child)
+		}
+		return v
+	default:
+		return value
+	}
+}
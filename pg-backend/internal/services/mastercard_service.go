@@ -2,8 +2,9 @@ package services
 
 import (
 	"bytes"
-	"encoding/base64"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -14,7 +15,6 @@ import (
 	"time"
 
 	"pg-backend/internal/config"
-	"pg-backend/pkg/utils"
 )
 
 // Test data for Google Pay (from MTF documentation)
@@ -38,20 +38,27 @@ const (
 type MastercardService interface {
 	VerifyCard(cardNumber, expiryMonth, expiryYear, cvv, currency string) (*VerifyResponse, error)
 	CreatePaymentToken(cardNumber, expiryMonth, expiryYear, cvv string) (*TokenResponse, error)
+	RetrieveToken(ctx context.Context, token string) (*TokenResponse, error)
 
-	// Direct payment operations
-	PayWithToken(token, amount, currency string) (*PaymentResponse, error)
-	PayWithCard(cardNumber, expiryMonth, expiryYear, cvv, amount, currency string) (*PaymentResponse, error)
+	// Direct payment operations. A non-empty descriptor overrides the name on
+	// the cardholder's statement for that order.
+	PayWithToken(ctx context.Context, token, amount, currency, reference, descriptor string) (*PaymentResponse, error)
+	PayWithTokenOrder(ctx context.Context, token, orderID, amount, currency string) (*PaymentResponse, error)
+	PayWithCard(cardNumber, expiryMonth, expiryYear, cvv, amount, currency, reference, descriptor string) (*PaymentResponse, error)
+	PayWithTokenRecurring(ctx context.Context, token, orderID, amount, currency, agreementID, initialTraceID, descriptor string) (*PaymentResponse, error)
+	PayWithTokenAuthenticated(ctx context.Context, token, orderID, transactionID, amount, currency, authenticationTransactionID string) (*PaymentResponse, error)
 
 	// Authorization flow operations (NEW)
-	AuthorizeWithToken(token, amount, currency string) (*PaymentResponse, error)
-	AuthorizeWithCard(cardNumber, expiryMonth, expiryYear, cvv, amount, currency string) (*PaymentResponse, error)
-	CaptureAuthorization(orderID, amount, currency string) (*PaymentResponse, error)
+	AuthorizeWithToken(token, amount, currency, reference, descriptor string) (*PaymentResponse, error)
+	AuthorizeWithCard(cardNumber, expiryMonth, expiryYear, cvv, amount, currency, reference, descriptor string) (*PaymentResponse, error)
+	CaptureAuthorization(orderID, transactionID, amount, currency string) (*PaymentResponse, error)
 	VoidAuthorization(orderID string) (*PaymentResponse, error)
 	UpdateAuthorization(orderID, amount, currency string) (*PaymentResponse, error)
 
 	// Other operations
 	RefundPayment(orderID, amount, currency string) (*PaymentResponse, error)
+	RetrieveOrder(ctx context.Context, orderID string) (*OrderResponse, error)
+	TestConnection(ctx context.Context) (*ConnectionTestResult, error)
 
 	// NEW: Google Pay methods for merchant-decrypted flow
 	PayWithGooglePay(cardNumber, expiryMonth, expiryYear, cryptogram, eci, amount, currency string) (*PaymentResponse, error)
@@ -100,19 +107,37 @@ type GooglePayPaymentRequest struct {
 }
 
 type mastercardService struct {
-	cfg        *config.Config
-	httpClient *http.Client
+	cfg           *config.Config
+	authenticator Authenticator
+	httpClient    HTTPDoer
+	breaker       *GatewayBreaker
 }
 
-func NewMastercardService(cfg *config.Config) MastercardService {
+// NewMastercardService returns the gateway client, sending requests over the
+// pooled gateway transport with authenticator's credentials. Requests go
+// through breaker, which fails them fast while the gateway is down.
+func NewMastercardService(cfg *config.Config, authenticator Authenticator, breaker *GatewayBreaker) MastercardService {
+	httpClient := &http.Client{Transport: newGatewayTransport(cfg)}
+	authenticator.ConfigureClient(httpClient)
+
+	return NewMastercardServiceWithClient(cfg, authenticator, breaker, httpClient)
+}
+
+// NewMastercardServiceWithClient returns a gateway client that sends its
+// requests through httpClient instead of the pooled gateway transport, e.g.
+// the client of an httptest.Server standing in for MastercardHost in tests.
+// httpClient is used as is; authenticator only authenticates requests.
+func NewMastercardServiceWithClient(cfg *config.Config, authenticator Authenticator, breaker *GatewayBreaker, httpClient HTTPDoer) MastercardService {
 	return &mastercardService{
-		cfg:        cfg,
-		httpClient: &http.Client{},
+		cfg:           cfg,
+		authenticator: authenticator,
+		httpClient:    httpClient,
+		breaker:       breaker,
 	}
 }
 
 // AuthorizeWithToken authorizes payment with token (hold funds)
-func (s *mastercardService) AuthorizeWithToken(token, amount, currency string) (*PaymentResponse, error) {
+func (s *mastercardService) AuthorizeWithToken(token, amount, currency, reference, descriptor string) (*PaymentResponse, error) {
 	orderID := generateOrderID()
 	endpoint := fmt.Sprintf("/api/rest/version/100/merchant/%s/order/%s/transaction/1",
 		s.cfg.MastercardMerchantID, orderID)
@@ -122,29 +147,37 @@ func (s *mastercardService) AuthorizeWithToken(token, amount, currency string) (
 	}
 	request.Order.Amount = amount
 	request.Order.Currency = currency
+	request.Order.Reference = reference
+	if descriptor != "" {
+		request.Order.StatementDescriptor = &StatementDescriptor{Name: descriptor}
+	}
 	request.SourceOfFunds.Type = "CARD"
 	request.SourceOfFunds.Token = token
+	request.SourceOfFunds.Provided.Card.StoredOnFile = "STORED"
 
-	body, err := s.makeRequest("PUT", endpoint, request)
+	reply, err := s.sendRequest(context.Background(), "PUT", endpoint, request)
 	if err != nil {
 		return nil, err
 	}
 
 	var response PaymentResponse
-	err = json.Unmarshal(body, &response)
+	err = json.Unmarshal(reply.Body, &response)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal response: %v", err)
 	}
 
-	// Convert amount to string if it's a number
-	response.Order.Amount = utils.ConvertToString(response.Order.Amount)
-	response.Transaction.Amount = utils.ConvertToString(response.Transaction.Amount)
+	response.Raw = redactGatewayResponse(reply.Body)
+	response.GatewayTrace = reply.Trace
+
+	if err := verifyOrderAmount(&response, amount, currency); err != nil {
+		return nil, err
+	}
 
 	return &response, nil
 }
 
 // AuthorizeWithCard authorizes payment with card details (hold funds)
-func (s *mastercardService) AuthorizeWithCard(cardNumber, expiryMonth, expiryYear, cvv, amount, currency string) (*PaymentResponse, error) {
+func (s *mastercardService) AuthorizeWithCard(cardNumber, expiryMonth, expiryYear, cvv, amount, currency, reference, descriptor string) (*PaymentResponse, error) {
 	orderID := generateOrderID()
 	endpoint := fmt.Sprintf("/api/rest/version/100/merchant/%s/order/%s/transaction/1",
 		s.cfg.MastercardMerchantID, orderID)
@@ -154,34 +187,42 @@ func (s *mastercardService) AuthorizeWithCard(cardNumber, expiryMonth, expiryYea
 	}
 	request.Order.Amount = amount
 	request.Order.Currency = currency
+	request.Order.Reference = reference
+	if descriptor != "" {
+		request.Order.StatementDescriptor = &StatementDescriptor{Name: descriptor}
+	}
 	request.SourceOfFunds.Type = "CARD"
 	request.SourceOfFunds.Provided.Card.Number = cardNumber
 	request.SourceOfFunds.Provided.Card.Expiry.Month = expiryMonth
 	request.SourceOfFunds.Provided.Card.Expiry.Year = expiryYear
 	request.SourceOfFunds.Provided.Card.SecurityCode = cvv
 
-	body, err := s.makeRequest("PUT", endpoint, request)
+	reply, err := s.sendRequest(context.Background(), "PUT", endpoint, request)
 	if err != nil {
 		return nil, err
 	}
 
 	var response PaymentResponse
-	err = json.Unmarshal(body, &response)
+	err = json.Unmarshal(reply.Body, &response)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal response: %v", err)
 	}
 
-	// Convert amount to string if it's a number
-	response.Order.Amount = utils.ConvertToString(response.Order.Amount)
-	response.Transaction.Amount = utils.ConvertToString(response.Transaction.Amount)
+	response.Raw = redactGatewayResponse(reply.Body)
+	response.GatewayTrace = reply.Trace
+
+	if err := verifyOrderAmount(&response, amount, currency); err != nil {
+		return nil, err
+	}
 
 	return &response, nil
 }
 
-// CaptureAuthorization captures previously authorized funds
-func (s *mastercardService) CaptureAuthorization(orderID, amount, currency string) (*PaymentResponse, error) {
-	endpoint := fmt.Sprintf("/api/rest/version/100/merchant/%s/order/%s/transaction/2",
-		s.cfg.MastercardMerchantID, orderID)
+// CaptureAuthorization captures previously authorized funds. An order can be
+// captured in parts; each capture needs its own transactionID.
+func (s *mastercardService) CaptureAuthorization(orderID, transactionID, amount, currency string) (*PaymentResponse, error) {
+	endpoint := fmt.Sprintf("/api/rest/version/100/merchant/%s/order/%s/transaction/%s",
+		s.cfg.MastercardMerchantID, orderID, transactionID)
 
 	request := map[string]interface{}{
 		"apiOperation": "CAPTURE",
@@ -191,17 +232,20 @@ func (s *mastercardService) CaptureAuthorization(orderID, amount, currency strin
 		},
 	}
 
-	body, err := s.makeRequest("PUT", endpoint, request)
+	reply, err := s.sendRequest(context.Background(), "PUT", endpoint, request)
 	if err != nil {
 		return nil, err
 	}
 
 	var response PaymentResponse
-	err = json.Unmarshal(body, &response)
+	err = json.Unmarshal(reply.Body, &response)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal response: %v", err)
 	}
 
+	response.Raw = redactGatewayResponse(reply.Body)
+	response.GatewayTrace = reply.Trace
+
 	return &response, nil
 }
 
@@ -217,17 +261,20 @@ func (s *mastercardService) VoidAuthorization(orderID string) (*PaymentResponse,
 		},
 	}
 
-	body, err := s.makeRequest("PUT", endpoint, request)
+	reply, err := s.sendRequest(context.Background(), "PUT", endpoint, request)
 	if err != nil {
 		return nil, err
 	}
 
 	var response PaymentResponse
-	err = json.Unmarshal(body, &response)
+	err = json.Unmarshal(reply.Body, &response)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal response: %v", err)
 	}
 
+	response.Raw = redactGatewayResponse(reply.Body)
+	response.GatewayTrace = reply.Trace
+
 	return &response, nil
 }
 
@@ -244,22 +291,42 @@ func (s *mastercardService) UpdateAuthorization(orderID, amount, currency string
 		},
 	}
 
-	body, err := s.makeRequest("PUT", endpoint, request)
+	reply, err := s.sendRequest(context.Background(), "PUT", endpoint, request)
 	if err != nil {
 		return nil, err
 	}
 
 	var response PaymentResponse
-	err = json.Unmarshal(body, &response)
+	err = json.Unmarshal(reply.Body, &response)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal response: %v", err)
 	}
 
+	response.Raw = redactGatewayResponse(reply.Body)
+	response.GatewayTrace = reply.Trace
+
 	return &response, nil
 }
 
 // Helper method to make API requests
 func (s *mastercardService) makeRequest(method, endpoint string, requestBody interface{}) ([]byte, error) {
+	return s.makeRequestContext(context.Background(), method, endpoint, requestBody)
+}
+
+// makeRequestContext is makeRequest bound to ctx, so callers can abandon slow gateway calls
+func (s *mastercardService) makeRequestContext(ctx context.Context, method, endpoint string, requestBody interface{}) ([]byte, error) {
+	reply, err := s.sendRequest(ctx, method, endpoint, requestBody)
+	if err != nil {
+		return nil, err
+	}
+	return reply.Body, nil
+}
+
+// sendRequest sends a gateway request under a new correlation ID, returning
+// the response body with the request's trace. Failures carry the
+// correlation ID in their message and are logged with it. While the circuit
+// breaker is open it fails with ErrGatewayUnavailable without sending anything.
+func (s *mastercardService) sendRequest(ctx context.Context, method, endpoint string, requestBody interface{}) (*gatewayReply, error) {
 	url := fmt.Sprintf("https://%s%s", s.cfg.MastercardHost, endpoint)
 
 	var body []byte
@@ -272,33 +339,48 @@ func (s *mastercardService) makeRequest(method, endpoint string, requestBody int
 		}
 	}
 
-	req, err := http.NewRequest(method, url, bytes.NewBuffer(body))
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewBuffer(body))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 
-	// Set Basic Auth header
-	auth := fmt.Sprintf("merchant.%s:%s", s.cfg.MastercardMerchantID, s.cfg.MastercardAPIPassword)
-	encodedAuth := base64.StdEncoding.EncodeToString([]byte(auth))
-	req.Header.Set("Authorization", "Basic "+encodedAuth)
+	s.authenticator.Authenticate(req)
 	req.Header.Set("Content-Type", "application/json")
 
+	trace := GatewayTrace{CorrelationID: newCorrelationID()}
+	req.Header.Set(correlationIDHeader, trace.CorrelationID)
+
+	if err := s.breaker.allow(); err != nil {
+		return nil, err
+	}
+
 	resp, err := s.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %v", err)
+		s.breaker.record(err)
+		log.Printf("Gateway %s %s failed (correlation ID %s): %v", method, endpoint, trace.CorrelationID, err)
+		return nil, fmt.Errorf("failed to send request (correlation ID %s): %w", trace.CorrelationID, err)
 	}
 	defer resp.Body.Close()
+	trace.GatewayCorrelationID = gatewayCorrelationID(resp.Header)
 
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %v", err)
+		s.breaker.record(err)
+		log.Printf("Gateway %s %s failed (correlation ID %s): %v", method, endpoint, trace.CorrelationID, err)
+		return nil, fmt.Errorf("failed to read response (correlation ID %s): %w", trace.CorrelationID, err)
 	}
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return nil, fmt.Errorf("API error %d: %s", resp.StatusCode, string(respBody))
+		apiErr := &GatewayAPIError{StatusCode: resp.StatusCode, Body: string(respBody), Trace: trace}
+		s.breaker.record(apiErr)
+		// The body may echo card data, so only the status and IDs are logged
+		log.Printf("Gateway %s %s failed with status %d (correlation ID %s, gateway trace ID %s)",
+			method, endpoint, resp.StatusCode, trace.CorrelationID, trace.GatewayCorrelationID)
+		return nil, apiErr
 	}
 
-	return respBody, nil
+	s.breaker.record(nil)
+	return &gatewayReply{Body: respBody, Trace: trace}, nil
 }
 
 // Request/Response structures
@@ -317,6 +399,7 @@ type VerifyRequest struct {
 					Year  string `json:"year"`
 				} `json:"expiry"`
 				SecurityCode string `json:"securityCode"`
+				StoredOnFile string `json:"storedOnFile,omitempty"`
 			} `json:"card"`
 		} `json:"provided"`
 	} `json:"sourceOfFunds"`
@@ -337,6 +420,11 @@ type VerifyResponse struct {
 		ID     string `json:"id"`
 		Status string `json:"status"`
 	} `json:"transaction"`
+	AuthorizationResponse struct {
+		// Scheme identifier for the verification, kept as the card's
+		// stored-credential reference
+		TransactionIdentifier string `json:"transactionIdentifier"`
+	} `json:"authorizationResponse"`
 }
 
 type TokenRequest struct {
@@ -349,7 +437,7 @@ type TokenRequest struct {
 					Month string `json:"month"`
 					Year  string `json:"year"`
 				} `json:"expiry"`
-				SecurityCode string `json:"securityCode"`
+				SecurityCode string `json:"securityCode,omitempty"` // absent for wallet device PANs
 			} `json:"card"`
 		} `json:"provided"`
 	} `json:"sourceOfFunds"`
@@ -377,8 +465,11 @@ type TokenResponse struct {
 type PaymentRequest struct {
 	ApiOperation string `json:"apiOperation"`
 	Order        struct {
-		Amount   string `json:"amount"`
-		Currency string `json:"currency"`
+		Amount    string `json:"amount"`
+		Currency  string `json:"currency"`
+		Reference string `json:"reference,omitempty"`
+
+		StatementDescriptor *StatementDescriptor `json:"statementDescriptor,omitempty"`
 	} `json:"order"`
 	SourceOfFunds struct {
 		Type     string `json:"type"`
@@ -391,29 +482,149 @@ type PaymentRequest struct {
 					Year  string `json:"year,omitempty"`
 				} `json:"expiry,omitempty"`
 				SecurityCode string `json:"securityCode,omitempty"`
+				// STORED when charging a saved card (credential on file)
+				StoredOnFile string `json:"storedOnFile,omitempty"`
 			} `json:"card,omitempty"`
 		} `json:"provided,omitempty"`
 	} `json:"sourceOfFunds"`
+
+	// Set when the cardholder has completed 3-D Secure for the order
+	Authentication *PaymentAuthentication `json:"authentication,omitempty"`
+}
+
+// PaymentAuthentication refers a PAY to the 3-D Secure authentication
+// transaction the cardholder completed on the same order
+type PaymentAuthentication struct {
+	TransactionID string `json:"transactionId"`
+}
+
+// RecurringPaymentRequest is a PAY against a stored card that the merchant
+// initiates without the cardholder present. Issuers increasingly decline such
+// charges unless they carry stored-credential indicators:
+//
+//   - agreement.id / agreement.type: the recurring agreement (our subscription)
+//     the charge belongs to, so the gateway can link every charge in the series
+//   - sourceOfFunds.provided.card.storedOnFile: STORED, i.e. the card was saved
+//     earlier with the cardholder's consent
+//   - transaction.source: MERCHANT, i.e. merchant-initiated rather than
+//     entered by the cardholder
+//   - transaction.acquirer.traceId: the scheme's identifier for the first
+//     charge in the series (authorizationResponse.transactionIdentifier in its
+//     response). Omitted until the first charge has succeeded.
+type RecurringPaymentRequest struct {
+	ApiOperation string `json:"apiOperation"`
+	Agreement    struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+	} `json:"agreement"`
+	Order struct {
+		Amount              string               `json:"amount"`
+		Currency            string               `json:"currency"`
+		StatementDescriptor *StatementDescriptor `json:"statementDescriptor,omitempty"`
+	} `json:"order"`
+	SourceOfFunds struct {
+		Type     string `json:"type"`
+		Token    string `json:"token"`
+		Provided struct {
+			Card struct {
+				StoredOnFile string `json:"storedOnFile"`
+			} `json:"card"`
+		} `json:"provided"`
+	} `json:"sourceOfFunds"`
+	Transaction struct {
+		Source   string             `json:"source"`
+		Acquirer *AcquirerReference `json:"acquirer,omitempty"`
+	} `json:"transaction"`
+}
+
+// StatementDescriptor overrides the merchant name shown on the cardholder's
+// statement for one order
+type StatementDescriptor struct {
+	Name string `json:"name"`
+}
+
+// AcquirerReference links a charge to an earlier one in the same series
+type AcquirerReference struct {
+	TraceID string `json:"traceId"`
 }
 
 type PaymentResponse struct {
 	Result      string `json:"result"`
 	GatewayCode string `json:"gatewayCode"`
 	Order       struct {
-		ID             string      `json:"id"`
-		Amount         interface{} `json:"amount"`
-		Currency       string      `json:"currency"`
-		Status         string      `json:"status"`
-		WalletProvider string      `json:"walletProvider,omitempty"`
+		ID             string        `json:"id"`
+		Amount         GatewayAmount `json:"amount"`
+		Currency       string        `json:"currency"`
+		Status         string        `json:"status"`
+		WalletProvider string        `json:"walletProvider,omitempty"`
 	} `json:"order"`
 	Transaction struct {
-		ID          string      `json:"id"`
-		Amount      interface{} `json:"amount"`
-		Currency    string      `json:"currency"`
-		Type        string      `json:"type"`
-		Status      string      `json:"status"`
-		Description string      `json:"description"`
+		ID          string        `json:"id"`
+		Amount      GatewayAmount `json:"amount"`
+		Currency    string        `json:"currency"`
+		Type        string        `json:"type"`
+		Status      string        `json:"status"`
+		Description string        `json:"description"`
 	} `json:"transaction"`
+	AuthorizationResponse struct {
+		// Scheme identifier for the charge, used as the initial trace ID of a
+		// recurring series
+		TransactionIdentifier string `json:"transactionIdentifier"`
+	} `json:"authorizationResponse"`
+	Response struct {
+		GatewayCode string `json:"gatewayCode"`
+
+		// The gateway's risk assessment of the payment, e.g. PROCEED or
+		// DO_NOT_PROCEED
+		GatewayRecommendation string `json:"gatewayRecommendation"`
+	} `json:"response"`
+
+	// Raw is the full gateway payload with sensitive fields redacted, kept
+	// on the transaction record for dispute investigations
+	Raw json.RawMessage `json:"-"`
+
+	GatewayTrace `json:"-"`
+}
+
+// GatewayRecommendationDoNotProceed is the gateway advising against a payment,
+// even one the issuer approved
+const GatewayRecommendationDoNotProceed = "DO_NOT_PROCEED"
+
+// NotRecommended reports whether the gateway recommends not proceeding with
+// the payment
+func (r *PaymentResponse) NotRecommended() bool {
+	return r.Response.GatewayRecommendation == GatewayRecommendationDoNotProceed
+}
+
+// OrderResponse is the gateway's view of an order and its transactions
+type OrderResponse struct {
+	Result              string        `json:"result"`
+	ID                  string        `json:"id"`
+	Amount              GatewayAmount `json:"amount"`
+	Currency            string        `json:"currency"`
+	Status              string        `json:"status"`
+	TotalCapturedAmount GatewayAmount `json:"totalCapturedAmount"`
+	Transaction         []struct {
+		Result   string `json:"result"`
+		Response struct {
+			GatewayCode string `json:"gatewayCode"`
+		} `json:"response"`
+		Transaction struct {
+			ID     string        `json:"id"`
+			Type   string        `json:"type"`
+			Amount GatewayAmount `json:"amount"`
+		} `json:"transaction"`
+	} `json:"transaction"`
+
+	// Raw is the full gateway payload with sensitive fields redacted
+	Raw json.RawMessage `json:"-"`
+
+	GatewayTrace `json:"-"`
+}
+
+// IsPaid reports whether the order was charged successfully
+func (r *OrderResponse) IsPaid() bool {
+	return r.Result == "SUCCESS" && (r.Status == "CAPTURED" || r.Status == "PARTIALLY_REFUNDED" || r.Status == "REFUNDED")
 }
 
 func generateOrderID() string {
@@ -436,6 +647,9 @@ func (s *mastercardService) VerifyCard(cardNumber, expiryMonth, expiryYear, cvv,
 	request.SourceOfFunds.Provided.Card.Expiry.Month = expiryMonth
 	request.SourceOfFunds.Provided.Card.Expiry.Year = expiryYear
 	request.SourceOfFunds.Provided.Card.SecurityCode = cvv
+	// The card is saved after verification, so this is the initial
+	// cardholder-initiated transaction of the stored credential
+	request.SourceOfFunds.Provided.Card.StoredOnFile = "TO_BE_STORED"
 
 	body, err := s.makeRequest("PUT", endpoint, request)
 	if err != nil {
@@ -475,9 +689,42 @@ func (s *mastercardService) CreatePaymentToken(cardNumber, expiryMonth, expiryYe
 	return &response, nil
 }
 
-func (s *mastercardService) PayWithToken(token, amount, currency string) (*PaymentResponse, error) {
-	// Generate truly unique order ID with timestamp
-	orderID := generateOrderID() // FIXED: Use random number
+// RetrieveToken fetches a stored token and the card it stands for. Tokens are
+// per merchant, so this also finds tokens created by the mobile backend's
+// session flow. Returns a NotFoundError for unknown tokens.
+func (s *mastercardService) RetrieveToken(ctx context.Context, token string) (*TokenResponse, error) {
+	endpoint := fmt.Sprintf("/api/rest/version/100/merchant/%s/token/%s", s.cfg.MastercardMerchantID, token)
+
+	body, err := s.makeRequestContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		var apiErr *GatewayAPIError
+		if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound ||
+			(apiErr.StatusCode == http.StatusBadRequest && strings.Contains(apiErr.Body, "not found"))) {
+			return nil, &NotFoundError{Message: "token not found at gateway"}
+		}
+		return nil, err
+	}
+
+	var response TokenResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %v", err)
+	}
+
+	return &response, nil
+}
+
+func (s *mastercardService) PayWithToken(ctx context.Context, token, amount, currency, reference, descriptor string) (*PaymentResponse, error) {
+	return s.payWithToken(ctx, token, generateOrderID(), amount, currency, reference, descriptor)
+}
+
+// PayWithTokenOrder charges a stored card under the given gateway order ID.
+// Submitting the same order ID again can't charge the card twice: the
+// gateway rejects the repeated transaction instead.
+func (s *mastercardService) PayWithTokenOrder(ctx context.Context, token, orderID, amount, currency string) (*PaymentResponse, error) {
+	return s.payWithToken(ctx, token, orderID, amount, currency, "", "")
+}
+
+func (s *mastercardService) payWithToken(ctx context.Context, token, orderID, amount, currency, reference, descriptor string) (*PaymentResponse, error) {
 	endpoint := fmt.Sprintf("/api/rest/version/100/merchant/%s/order/%s/transaction/1",
 		s.cfg.MastercardMerchantID, orderID)
 
@@ -486,28 +733,122 @@ func (s *mastercardService) PayWithToken(token, amount, currency string) (*Payme
 	}
 	request.Order.Amount = amount
 	request.Order.Currency = currency
+	request.Order.Reference = reference
+	if descriptor != "" {
+		request.Order.StatementDescriptor = &StatementDescriptor{Name: descriptor}
+	}
 	request.SourceOfFunds.Type = "CARD"
 	request.SourceOfFunds.Token = token
+	request.SourceOfFunds.Provided.Card.StoredOnFile = "STORED"
 
-	body, err := s.makeRequest("PUT", endpoint, request)
+	reply, err := s.sendRequest(ctx, "PUT", endpoint, request)
+	if err != nil {
+		return nil, ambiguousIfUnknown(orderID, err)
+	}
+
+	var response PaymentResponse
+	err = json.Unmarshal(reply.Body, &response)
 	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %v", err)
+	}
+
+	response.Raw = redactGatewayResponse(reply.Body)
+	response.GatewayTrace = reply.Trace
+
+	if err := verifyOrderAmount(&response, amount, currency); err != nil {
 		return nil, err
 	}
 
+	return &response, nil
+}
+
+// PayWithTokenRecurring charges a stored card as a merchant-initiated recurring
+// payment. agreementID groups the charges of one subscription at the gateway;
+// initialTraceID is the scheme trace ID of the first charge, if known;
+// descriptor overrides the statement name, and is omitted when empty. Like
+// PayWithTokenOrder, reusing orderID for a retry can't charge twice.
+func (s *mastercardService) PayWithTokenRecurring(ctx context.Context, token, orderID, amount, currency, agreementID, initialTraceID, descriptor string) (*PaymentResponse, error) {
+	endpoint := fmt.Sprintf("/api/rest/version/100/merchant/%s/order/%s/transaction/1",
+		s.cfg.MastercardMerchantID, orderID)
+
+	request := RecurringPaymentRequest{
+		ApiOperation: "PAY",
+	}
+	request.Agreement.ID = agreementID
+	request.Agreement.Type = "RECURRING"
+	request.Order.Amount = amount
+	request.Order.Currency = currency
+	request.SourceOfFunds.Type = "CARD"
+	request.SourceOfFunds.Token = token
+	request.SourceOfFunds.Provided.Card.StoredOnFile = "STORED"
+	request.Transaction.Source = "MERCHANT"
+	if initialTraceID != "" {
+		request.Transaction.Acquirer = &AcquirerReference{TraceID: initialTraceID}
+	}
+	if descriptor != "" {
+		request.Order.StatementDescriptor = &StatementDescriptor{Name: descriptor}
+	}
+
+	reply, err := s.sendRequest(ctx, "PUT", endpoint, request)
+	if err != nil {
+		return nil, ambiguousIfUnknown(orderID, err)
+	}
+
 	var response PaymentResponse
-	err = json.Unmarshal(body, &response)
+	err = json.Unmarshal(reply.Body, &response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %v", err)
+	}
+
+	response.Raw = redactGatewayResponse(reply.Body)
+	response.GatewayTrace = reply.Trace
+
+	if err := verifyOrderAmount(&response, amount, currency); err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// PayWithTokenAuthenticated charges a stored card after the cardholder has
+// completed the 3-D Secure challenge the issuer asked for. The charge is
+// submitted as transactionID on the original order, and refers to the
+// authentication by authenticationTransactionID.
+func (s *mastercardService) PayWithTokenAuthenticated(ctx context.Context, token, orderID, transactionID, amount, currency, authenticationTransactionID string) (*PaymentResponse, error) {
+	endpoint := fmt.Sprintf("/api/rest/version/100/merchant/%s/order/%s/transaction/%s",
+		s.cfg.MastercardMerchantID, orderID, transactionID)
+
+	request := PaymentRequest{
+		ApiOperation:   "PAY",
+		Authentication: &PaymentAuthentication{TransactionID: authenticationTransactionID},
+	}
+	request.Order.Amount = amount
+	request.Order.Currency = currency
+	request.SourceOfFunds.Type = "CARD"
+	request.SourceOfFunds.Token = token
+	request.SourceOfFunds.Provided.Card.StoredOnFile = "STORED"
+
+	reply, err := s.sendRequest(ctx, "PUT", endpoint, request)
 	if err != nil {
+		return nil, ambiguousIfUnknown(orderID, err)
+	}
+
+	var response PaymentResponse
+	if err := json.Unmarshal(reply.Body, &response); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal response: %v", err)
 	}
 
-	// Convert amount to string if it's a number
-	response.Order.Amount = utils.ConvertToString(response.Order.Amount)
-	response.Transaction.Amount = utils.ConvertToString(response.Transaction.Amount)
+	response.Raw = redactGatewayResponse(reply.Body)
+	response.GatewayTrace = reply.Trace
+
+	if err := verifyOrderAmount(&response, amount, currency); err != nil {
+		return nil, err
+	}
 
 	return &response, nil
 }
 
-func (s *mastercardService) PayWithCard(cardNumber, expiryMonth, expiryYear, cvv, amount, currency string) (*PaymentResponse, error) {
+func (s *mastercardService) PayWithCard(cardNumber, expiryMonth, expiryYear, cvv, amount, currency, reference, descriptor string) (*PaymentResponse, error) {
 
 	orderID := generateOrderID()
 	endpoint := fmt.Sprintf("/api/rest/version/100/merchant/%s/order/%s/transaction/1",
@@ -518,26 +859,60 @@ func (s *mastercardService) PayWithCard(cardNumber, expiryMonth, expiryYear, cvv
 	}
 	request.Order.Amount = amount
 	request.Order.Currency = currency
+	request.Order.Reference = reference
+	if descriptor != "" {
+		request.Order.StatementDescriptor = &StatementDescriptor{Name: descriptor}
+	}
 	request.SourceOfFunds.Type = "CARD"
 	request.SourceOfFunds.Provided.Card.Number = cardNumber
 	request.SourceOfFunds.Provided.Card.Expiry.Month = expiryMonth
 	request.SourceOfFunds.Provided.Card.Expiry.Year = expiryYear
 	request.SourceOfFunds.Provided.Card.SecurityCode = cvv
 
-	body, err := s.makeRequest("PUT", endpoint, request)
+	reply, err := s.sendRequest(context.Background(), "PUT", endpoint, request)
 	if err != nil {
 		return nil, err
 	}
 
 	var response PaymentResponse
-	err = json.Unmarshal(body, &response)
+	err = json.Unmarshal(reply.Body, &response)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal response: %v", err)
 	}
 
-	// Convert amount to string if it's a number
-	response.Order.Amount = utils.ConvertToString(response.Order.Amount)
-	response.Transaction.Amount = utils.ConvertToString(response.Transaction.Amount)
+	response.Raw = redactGatewayResponse(reply.Body)
+	response.GatewayTrace = reply.Trace
+
+	if err := verifyOrderAmount(&response, amount, currency); err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// RetrieveOrder fetches the current state of an order from the gateway.
+// Returns a NotFoundError when the gateway has no record of the order.
+func (s *mastercardService) RetrieveOrder(ctx context.Context, orderID string) (*OrderResponse, error) {
+	endpoint := fmt.Sprintf("/api/rest/version/100/merchant/%s/order/%s",
+		s.cfg.MastercardMerchantID, orderID)
+
+	reply, err := s.sendRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		var apiErr *GatewayAPIError
+		if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound ||
+			(apiErr.StatusCode == http.StatusBadRequest && strings.Contains(apiErr.Body, "not found"))) {
+			return nil, &NotFoundError{Message: fmt.Sprintf("order %s not found at gateway", orderID)}
+		}
+		return nil, err
+	}
+
+	var response OrderResponse
+	if err := json.Unmarshal(reply.Body, &response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %v", err)
+	}
+
+	response.Raw = redactGatewayResponse(reply.Body)
+	response.GatewayTrace = reply.Trace
 
 	return &response, nil
 }
@@ -559,17 +934,20 @@ func (s *mastercardService) RefundPayment(orderID, amount, currency string) (*Pa
 		},
 	}
 
-	body, err := s.makeRequest("PUT", endpoint, request)
+	reply, err := s.sendRequest(context.Background(), "PUT", endpoint, request)
 	if err != nil {
 		return nil, err
 	}
 
 	var response PaymentResponse
-	err = json.Unmarshal(body, &response)
+	err = json.Unmarshal(reply.Body, &response)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal response: %v", err)
 	}
 
+	response.Raw = redactGatewayResponse(reply.Body)
+	response.GatewayTrace = reply.Trace
+
 	return &response, nil
 }
 
@@ -597,19 +975,19 @@ func (s *mastercardService) PayWithGooglePay(cardNumber, expiryMonth, expiryYear
 	request.SourceOfFunds.Provided.Card.Expiry.Month = expiryMonth
 	request.SourceOfFunds.Provided.Card.Expiry.Year = expiryYear
 	request.SourceOfFunds.Provided.Card.DevicePayment.CryptogramFormat = "3DSECURE"
-	request.SourceOfFunds.Provided.Card.DevicePayment.OnlinePaymentCryptogram = cryptogram
+	request.SourceOfFunds.Provided.Card.DevicePayment.OnlinePaymentCryptogram = onlinePaymentCryptogram(cardNumber, cryptogram)
 	request.SourceOfFunds.Provided.Card.DevicePayment.EciIndicator = eci
 	request.Device.Ani = "12341234"
 	request.Transaction.Source = "INTERNET"
 
-	body, err := s.makeRequest("PUT", endpoint, request)
+	reply, err := s.sendRequest(context.Background(), "PUT", endpoint, request)
 
 	// If Google Pay fails due to missing privilege, fallback to regular card payment
 	if err != nil && strings.Contains(err.Error(), "Missing merchant privilege") {
 		log.Println("Device Payments privilege not available, simulating Google Pay with regular card payment")
 
 		// Fallback to regular PAY operation (simulating Google Pay)
-		return s.PayWithCard(cardNumber, expiryMonth, expiryYear, "123", amount, currency)
+		return s.PayWithCard(cardNumber, expiryMonth, expiryYear, testSecurityCode(cardNumber), amount, currency, "", "")
 	}
 
 	if err != nil {
@@ -617,14 +995,17 @@ func (s *mastercardService) PayWithGooglePay(cardNumber, expiryMonth, expiryYear
 	}
 
 	var response PaymentResponse
-	err = json.Unmarshal(body, &response)
+	err = json.Unmarshal(reply.Body, &response)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal Google Pay response: %v", err)
 	}
 
-	// Convert amount to string if it's a number
-	response.Order.Amount = utils.ConvertToString(response.Order.Amount)
-	response.Transaction.Amount = utils.ConvertToString(response.Transaction.Amount)
+	response.Raw = redactGatewayResponse(reply.Body)
+	response.GatewayTrace = reply.Trace
+
+	if err := verifyOrderAmount(&response, amount, currency); err != nil {
+		return nil, err
+	}
 
 	return &response, nil
 }
@@ -646,25 +1027,28 @@ func (s *mastercardService) AuthorizeWithGooglePay(cardNumber, expiryMonth, expi
 	request.SourceOfFunds.Provided.Card.Expiry.Month = expiryMonth
 	request.SourceOfFunds.Provided.Card.Expiry.Year = expiryYear
 	request.SourceOfFunds.Provided.Card.DevicePayment.CryptogramFormat = "3DSECURE"
-	request.SourceOfFunds.Provided.Card.DevicePayment.OnlinePaymentCryptogram = cryptogram
+	request.SourceOfFunds.Provided.Card.DevicePayment.OnlinePaymentCryptogram = onlinePaymentCryptogram(cardNumber, cryptogram)
 	request.SourceOfFunds.Provided.Card.DevicePayment.EciIndicator = eci
 	request.Device.Ani = "12341234"
 	request.Transaction.Source = "INTERNET"
 
-	body, err := s.makeRequest("PUT", endpoint, request)
+	reply, err := s.sendRequest(context.Background(), "PUT", endpoint, request)
 	if err != nil {
 		return nil, err
 	}
 
 	var response PaymentResponse
-	err = json.Unmarshal(body, &response)
+	err = json.Unmarshal(reply.Body, &response)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal Google Pay authorization response: %v", err)
 	}
 
-	// Convert amount to string if it's a number
-	response.Order.Amount = utils.ConvertToString(response.Order.Amount)
-	response.Transaction.Amount = utils.ConvertToString(response.Transaction.Amount)
+	response.Raw = redactGatewayResponse(reply.Body)
+	response.GatewayTrace = reply.Trace
+
+	if err := verifyOrderAmount(&response, amount, currency); err != nil {
+		return nil, err
+	}
 
 	return &response, nil
 }
@@ -701,19 +1085,23 @@ func (s *mastercardService) PayWithGooglePayToken(paymentToken, amount, currency
 		},
 	}
 
-	body, err := s.makeRequest("PUT", endpoint, request)
+	reply, err := s.sendRequest(context.Background(), "PUT", endpoint, request)
 	if err != nil {
 		return nil, err
 	}
 
 	var response PaymentResponse
-	err = json.Unmarshal(body, &response)
+	err = json.Unmarshal(reply.Body, &response)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal Google Pay token response: %v", err)
 	}
 
-	response.Order.Amount = utils.ConvertToString(response.Order.Amount)
-	response.Transaction.Amount = utils.ConvertToString(response.Transaction.Amount)
+	response.Raw = redactGatewayResponse(reply.Body)
+	response.GatewayTrace = reply.Trace
+
+	if err := verifyOrderAmount(&response, amount, currency); err != nil {
+		return nil, err
+	}
 
 	return &response, nil
 }
@@ -749,19 +1137,23 @@ func (s *mastercardService) AuthorizeWithGooglePayToken(paymentToken, amount, cu
 		},
 	}
 
-	body, err := s.makeRequest("PUT", endpoint, request)
+	reply, err := s.sendRequest(context.Background(), "PUT", endpoint, request)
 	if err != nil {
 		return nil, err
 	}
 
 	var response PaymentResponse
-	err = json.Unmarshal(body, &response)
+	err = json.Unmarshal(reply.Body, &response)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal Google Pay token authorization response: %v", err)
 	}
 
-	response.Order.Amount = utils.ConvertToString(response.Order.Amount)
-	response.Transaction.Amount = utils.ConvertToString(response.Transaction.Amount)
+	response.Raw = redactGatewayResponse(reply.Body)
+	response.GatewayTrace = reply.Trace
+
+	if err := verifyOrderAmount(&response, amount, currency); err != nil {
+		return nil, err
+	}
 
 	return &response, nil
 }
@@ -796,7 +1188,7 @@ func (s *mastercardService) PayWithApplePayToken(paymentToken, amount, currency
 		},
 	}
 
-	body, err := s.makeRequest("PUT", endpoint, request)
+	reply, err := s.sendRequest(context.Background(), "PUT", endpoint, request)
 	if err != nil {
 		// Check if it's a privilege error
 		if strings.Contains(err.Error(), "Missing merchant privilege") {
@@ -806,13 +1198,17 @@ func (s *mastercardService) PayWithApplePayToken(paymentToken, amount, currency
 	}
 
 	var response PaymentResponse
-	err = json.Unmarshal(body, &response)
+	err = json.Unmarshal(reply.Body, &response)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal Apple Pay response: %v", err)
 	}
 
-	response.Order.Amount = utils.ConvertToString(response.Order.Amount)
-	response.Transaction.Amount = utils.ConvertToString(response.Transaction.Amount)
+	response.Raw = redactGatewayResponse(reply.Body)
+	response.GatewayTrace = reply.Trace
+
+	if err := verifyOrderAmount(&response, amount, currency); err != nil {
+		return nil, err
+	}
 
 	return &response, nil
 }
@@ -847,7 +1243,7 @@ func (s *mastercardService) AuthorizeWithApplePayToken(paymentToken, amount, cur
 		},
 	}
 
-	body, err := s.makeRequest("PUT", endpoint, request)
+	reply, err := s.sendRequest(context.Background(), "PUT", endpoint, request)
 	if err != nil {
 		if strings.Contains(err.Error(), "Missing merchant privilege") {
 			return nil, fmt.Errorf("Missing merchant privilege 'Device Payments'")
@@ -856,13 +1252,17 @@ func (s *mastercardService) AuthorizeWithApplePayToken(paymentToken, amount, cur
 	}
 
 	var response PaymentResponse
-	err = json.Unmarshal(body, &response)
+	err = json.Unmarshal(reply.Body, &response)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal Apple Pay authorization response: %v", err)
 	}
 
-	response.Order.Amount = utils.ConvertToString(response.Order.Amount)
-	response.Transaction.Amount = utils.ConvertToString(response.Transaction.Amount)
+	response.Raw = redactGatewayResponse(reply.Body)
+	response.GatewayTrace = reply.Trace
+
+	if err := verifyOrderAmount(&response, amount, currency); err != nil {
+		return nil, err
+	}
 
 	return &response, nil
 }
@@ -0,0 +1,266 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"pg-backend/internal/config"
+)
+
+const testMerchantID = "TESTMERCHANT"
+
+// gatewayReplyFunc answers one gateway request in a test
+type gatewayReplyFunc func(t *testing.T, r *http.Request, body map[string]interface{}) (int, string)
+
+// newTestGateway returns a client whose requests are answered by reply
+// through an httptest server standing in for MastercardHost
+func newTestGateway(t *testing.T, reply gatewayReplyFunc) MastercardService {
+	t.Helper()
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wantAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte("merchant."+testMerchantID+":secret"))
+		if got := r.Header.Get("Authorization"); got != wantAuth {
+			t.Errorf("Authorization = %q, want %q", got, wantAuth)
+		}
+		if r.Header.Get(correlationIDHeader) == "" {
+			t.Errorf("request has no %s header", correlationIDHeader)
+		}
+
+		var body map[string]interface{}
+		if data, _ := io.ReadAll(r.Body); len(data) > 0 {
+			if err := json.Unmarshal(data, &body); err != nil {
+				t.Errorf("request body is not JSON: %v", err)
+			}
+		}
+
+		status, response := reply(t, r, body)
+		w.WriteHeader(status)
+		io.WriteString(w, response)
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := &config.Config{
+		MastercardMerchantID:  testMerchantID,
+		MastercardAPIPassword: "secret",
+		MastercardHost:        strings.TrimPrefix(srv.URL, "https://"),
+	}
+	authenticator, err := NewAuthenticator(cfg)
+	if err != nil {
+		t.Fatalf("NewAuthenticator: %v", err)
+	}
+	return NewMastercardServiceWithClient(cfg, authenticator, nil, srv.Client())
+}
+
+// cardInRequest returns sourceOfFunds.provided.card from a request body
+func cardInRequest(body map[string]interface{}) map[string]interface{} {
+	sourceOfFunds, _ := body["sourceOfFunds"].(map[string]interface{})
+	provided, _ := sourceOfFunds["provided"].(map[string]interface{})
+	card, _ := provided["card"].(map[string]interface{})
+	return card
+}
+
+func TestPayWithCard(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		response     string
+		wantCode     string
+		wantErrCode  int    // GatewayAPIError status, 0 for none
+		wantDetail   string // GatewayAPIError detail
+		wantMismatch bool
+	}{
+		{
+			name:     "approved",
+			status:   http.StatusCreated,
+			response: `{"result":"SUCCESS","gatewayCode":"APPROVED","order":{"id":"1","amount":10.5,"currency":"USD"},"transaction":{"id":"1","type":"PAYMENT"},"response":{"gatewayCode":"APPROVED"}}`,
+			wantCode: "APPROVED",
+		},
+		{
+			name:     "declined",
+			status:   http.StatusOK,
+			response: `{"result":"FAILURE","gatewayCode":"DECLINED","order":{"id":"1","amount":"10.50","currency":"USD"},"response":{"gatewayCode":"DECLINED"}}`,
+			wantCode: "DECLINED",
+		},
+		{
+			name:     "insufficient funds",
+			status:   http.StatusOK,
+			response: `{"result":"FAILURE","gatewayCode":"INSUFFICIENT_FUNDS","order":{"id":"1","amount":"10.50","currency":"USD"}}`,
+			wantCode: "INSUFFICIENT_FUNDS",
+		},
+		{
+			name:        "invalid request",
+			status:      http.StatusBadRequest,
+			response:    `{"result":"ERROR","error":{"cause":"INVALID_REQUEST","explanation":"Value 'X' is invalid for field 'currency'"}}`,
+			wantErrCode: http.StatusBadRequest,
+			wantDetail:  "INVALID_REQUEST: Value 'X' is invalid for field 'currency'",
+		},
+		{
+			name:        "server error without a JSON body",
+			status:      http.StatusInternalServerError,
+			response:    `upstream unavailable`,
+			wantErrCode: http.StatusInternalServerError,
+			wantDetail:  "upstream unavailable",
+		},
+		{
+			name:         "amount mismatch",
+			status:       http.StatusOK,
+			response:     `{"result":"SUCCESS","gatewayCode":"APPROVED","order":{"id":"1","amount":1050,"currency":"USD"}}`,
+			wantMismatch: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t, func(t *testing.T, r *http.Request, body map[string]interface{}) (int, string) {
+				if r.Method != http.MethodPut {
+					t.Errorf("method = %s, want PUT", r.Method)
+				}
+				if !strings.HasPrefix(r.URL.Path, "/api/rest/version/100/merchant/"+testMerchantID+"/order/") {
+					t.Errorf("unexpected path %s", r.URL.Path)
+				}
+				if body["apiOperation"] != "PAY" {
+					t.Errorf("apiOperation = %v, want PAY", body["apiOperation"])
+				}
+				if number := cardInRequest(body)["number"]; number != "5123450000000008" {
+					t.Errorf("card number = %v", number)
+				}
+				return tt.status, tt.response
+			})
+
+			resp, err := gateway.PayWithCard("5123450000000008", "01", "39", "100", "10.50", "USD", "ref-1", "")
+
+			var apiErr *GatewayAPIError
+			var mismatch *AmountMismatchError
+			switch {
+			case tt.wantErrCode != 0:
+				if !errors.As(err, &apiErr) {
+					t.Fatalf("error = %v, want a GatewayAPIError", err)
+				}
+				if apiErr.StatusCode != tt.wantErrCode {
+					t.Errorf("status = %d, want %d", apiErr.StatusCode, tt.wantErrCode)
+				}
+				if apiErr.Detail() != tt.wantDetail {
+					t.Errorf("detail = %q, want %q", apiErr.Detail(), tt.wantDetail)
+				}
+				if apiErr.Trace.CorrelationID == "" {
+					t.Errorf("error has no correlation ID")
+				}
+			case tt.wantMismatch:
+				if !errors.As(err, &mismatch) {
+					t.Fatalf("error = %v, want an AmountMismatchError", err)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("PayWithCard: %v", err)
+				}
+				if resp.GatewayCode != tt.wantCode {
+					t.Errorf("gateway code = %q, want %q", resp.GatewayCode, tt.wantCode)
+				}
+				if resp.CorrelationID == "" {
+					t.Errorf("response has no correlation ID")
+				}
+				if len(resp.Raw) == 0 {
+					t.Errorf("response has no raw payload")
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyCard(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		response    string
+		wantResult  string
+		wantCode    string
+		wantErrCode int
+	}{
+		{
+			name:       "verified",
+			status:     http.StatusOK,
+			response:   `{"result":"SUCCESS","response":{"gatewayCode":"APPROVED"},"authorizationResponse":{"transactionIdentifier":"TRACE1"}}`,
+			wantResult: "SUCCESS",
+			wantCode:   "APPROVED",
+		},
+		{
+			name:       "declined",
+			status:     http.StatusOK,
+			response:   `{"result":"FAILURE","response":{"gatewayCode":"DECLINED"}}`,
+			wantResult: "FAILURE",
+			wantCode:   "DECLINED",
+		},
+		{
+			name:        "invalid credentials",
+			status:      http.StatusUnauthorized,
+			response:    `{"result":"ERROR","error":{"cause":"INVALID_REQUEST","explanation":"Invalid credentials."}}`,
+			wantErrCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(t, func(t *testing.T, r *http.Request, body map[string]interface{}) (int, string) {
+				if want := "/api/rest/version/100/merchant/" + testMerchantID + "/order/VERIFY_0008/transaction/1"; r.URL.Path != want {
+					t.Errorf("path = %s, want %s", r.URL.Path, want)
+				}
+				if body["apiOperation"] != "VERIFY" {
+					t.Errorf("apiOperation = %v, want VERIFY", body["apiOperation"])
+				}
+				card := cardInRequest(body)
+				if card["storedOnFile"] != "TO_BE_STORED" {
+					t.Errorf("storedOnFile = %v, want TO_BE_STORED", card["storedOnFile"])
+				}
+				if card["securityCode"] != "100" {
+					t.Errorf("securityCode = %v, want 100", card["securityCode"])
+				}
+				return tt.status, tt.response
+			})
+
+			resp, err := gateway.VerifyCard("5123450000000008", "01", "39", "100", "USD")
+			if tt.wantErrCode != 0 {
+				var apiErr *GatewayAPIError
+				if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.wantErrCode {
+					t.Fatalf("error = %v, want a %d GatewayAPIError", err, tt.wantErrCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("VerifyCard: %v", err)
+			}
+			if resp.Result != tt.wantResult || resp.Response.GatewayCode != tt.wantCode {
+				t.Errorf("got %s/%s, want %s/%s", resp.Result, resp.Response.GatewayCode, tt.wantResult, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestGatewayErrorLogOmitsBody(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	gateway := newTestGateway(t, func(t *testing.T, r *http.Request, body map[string]interface{}) (int, string) {
+		return http.StatusBadRequest, `{"result":"ERROR","error":{"cause":"INVALID_REQUEST","explanation":"Invalid card 5123450000000008"}}`
+	})
+
+	if _, err := gateway.PayWithCard("5123450000000008", "12", "30", "123", "10.00", "USD", "", ""); err == nil {
+		t.Fatal("PayWithCard succeeded on a gateway error")
+	}
+
+	if strings.Contains(logged.String(), "5123450000000008") {
+		t.Errorf("log contains the response body: %s", logged.String())
+	}
+	if !strings.Contains(logged.String(), "status 400") {
+		t.Errorf("log = %q, want the response status", logged.String())
+	}
+}
@@ -0,0 +1,37 @@
+package services
+
+import "fmt"
+
+// Limits on merchant-supplied metadata maps
+const (
+	maxMetadataKeys        = 50
+	maxMetadataKeyLength   = 40
+	maxMetadataValueLength = 500
+)
+
+// validateMetadata checks a metadata map stays within the size limits
+func validateMetadata(metadata map[string]string) error {
+	if len(metadata) > maxMetadataKeys {
+		return &ValidationError{
+			Field:   "metadata",
+			Message: fmt.Sprintf("metadata may have at most %d keys", maxMetadataKeys),
+		}
+	}
+
+	for key, value := range metadata {
+		if key == "" || len(key) > maxMetadataKeyLength {
+			return &ValidationError{
+				Field:   "metadata",
+				Message: fmt.Sprintf("metadata keys must be between 1 and %d characters", maxMetadataKeyLength),
+			}
+		}
+		if len(value) > maxMetadataValueLength {
+			return &ValidationError{
+				Field:   "metadata",
+				Message: fmt.Sprintf("metadata value for %q exceeds %d characters", key, maxMetadataValueLength),
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,598 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pg-backend/internal/config"
+)
+
+// Sentinel amounts understood by the mock gateway. The outcome of a payment,
+// authorization, capture or refund is picked by the last two digits of its
+// amount in minor units, e.g. 10.51 USD is declined. Any other amount is
+// approved.
+const (
+	MockAmountDeclined               = 51 // DECLINED
+	MockAmountInsufficientFunds      = 52 // INSUFFICIENT_FUNDS
+	MockAmountExpiredCard            = 53 // EXPIRED_CARD
+	MockAmountAuthenticationRequired = 54 // AUTHENTICATION_REQUIRED (token payments)
+	MockAmountGatewayError           = 55 // the gateway answers HTTP 500
+	MockAmountTimeout                = 56 // charged, but the answer is lost (HTTP 504)
+)
+
+// mockGatewayCodes are the gateway codes returned for declining sentinels
+var mockGatewayCodes = map[int64]string{
+	MockAmountDeclined:               "DECLINED",
+	MockAmountInsufficientFunds:      "INSUFFICIENT_FUNDS",
+	MockAmountExpiredCard:            "EXPIRED_CARD",
+	MockAmountAuthenticationRequired: "AUTHENTICATION_REQUIRED",
+}
+
+type mockOrder struct {
+	currency       string
+	amount         int64 // authorized or charged, in minor units
+	captured       int64
+	refunded       int64
+	status         string
+	walletProvider string
+	transactions   []mockTransaction
+}
+
+type mockTransaction struct {
+	id          string
+	txnType     string
+	result      string
+	gatewayCode string
+	amount      int64
+}
+
+type mockCard struct {
+	number string
+	expiry string // MMYY, as the gateway reports it
+}
+
+// mockMastercardService is an in-memory stand-in for the gateway, so payments
+// and the subscription billing flow can be exercised without network access
+// or merchant credentials. Orders and tokens live only as long as the process.
+type mockMastercardService struct {
+	cfg *config.Config
+
+	mu     sync.Mutex
+	orders map[string]*mockOrder
+	tokens map[string]mockCard
+}
+
+// NewMockMastercardService returns a MastercardService that never calls the
+// gateway. Outcomes follow the MockAmount sentinels; card verification
+// approves every unexpired card, and any token is accepted for payment so
+// cards saved before a restart keep working.
+func NewMockMastercardService(cfg *config.Config) MastercardService {
+	return &mockMastercardService{
+		cfg:    cfg,
+		orders: make(map[string]*mockOrder),
+		tokens: make(map[string]mockCard),
+	}
+}
+
+func (s *mockMastercardService) VerifyCard(cardNumber, expiryMonth, expiryYear, cvv, currency string) (*VerifyResponse, error) {
+	if len(cardNumber) < 4 {
+		return nil, mockRequestError("invalid card number")
+	}
+
+	gatewayCode := "APPROVED"
+	if mockCardExpired(expiryMonth, expiryYear) {
+		gatewayCode = "EXPIRED_CARD"
+	}
+
+	var response VerifyResponse
+	response.Result = "SUCCESS"
+	if gatewayCode != "APPROVED" {
+		response.Result = "FAILURE"
+	}
+	response.GatewayCode = gatewayCode
+	response.Response.GatewayCode = gatewayCode
+	response.Order.ID = "VERIFY_" + cardNumber[len(cardNumber)-4:]
+	response.Order.Currency = currency
+	response.Order.Status = "VERIFIED"
+	response.Transaction.ID = "1"
+	response.Transaction.Status = "VERIFIED"
+	response.AuthorizationResponse.TransactionIdentifier = mockDigits(15)
+
+	return &response, nil
+}
+
+func (s *mockMastercardService) CreatePaymentToken(cardNumber, expiryMonth, expiryYear, cvv string) (*TokenResponse, error) {
+	if len(cardNumber) < 12 {
+		return nil, mockRequestError("invalid card number")
+	}
+	month, err := strconv.Atoi(expiryMonth)
+	if err != nil {
+		return nil, mockRequestError("invalid expiry month")
+	}
+	year := expiryYear
+	if len(year) == 4 {
+		year = year[2:]
+	}
+
+	token := "9" + mockDigits(15)
+	card := mockCard{number: cardNumber, expiry: fmt.Sprintf("%02d%s", month, year)}
+
+	s.mu.Lock()
+	s.tokens[token] = card
+	s.mu.Unlock()
+
+	return mockTokenResponse(token, card), nil
+}
+
+func (s *mockMastercardService) RetrieveToken(ctx context.Context, token string) (*TokenResponse, error) {
+	s.mu.Lock()
+	card, ok := s.tokens[token]
+	s.mu.Unlock()
+	if !ok {
+		return nil, &NotFoundError{Message: "token not found at gateway"}
+	}
+
+	return mockTokenResponse(token, card), nil
+}
+
+func (s *mockMastercardService) PayWithToken(ctx context.Context, token, amount, currency, reference, descriptor string) (*PaymentResponse, error) {
+	return s.transact(generateOrderID(), "PAYMENT", amount, currency, "", true)
+}
+
+func (s *mockMastercardService) PayWithTokenOrder(ctx context.Context, token, orderID, amount, currency string) (*PaymentResponse, error) {
+	return s.transact(orderID, "PAYMENT", amount, currency, "", true)
+}
+
+func (s *mockMastercardService) PayWithCard(cardNumber, expiryMonth, expiryYear, cvv, amount, currency, reference, descriptor string) (*PaymentResponse, error) {
+	return s.transact(generateOrderID(), "PAYMENT", amount, currency, "", false)
+}
+
+func (s *mockMastercardService) PayWithTokenRecurring(ctx context.Context, token, orderID, amount, currency, agreementID, initialTraceID, descriptor string) (*PaymentResponse, error) {
+	return s.transact(orderID, "PAYMENT", amount, currency, "", true)
+}
+
+// PayWithTokenAuthenticated approves the charge whatever its amount, since
+// the sentinel that asked for authentication would otherwise ask again
+func (s *mockMastercardService) PayWithTokenAuthenticated(ctx context.Context, token, orderID, transactionID, amount, currency, authenticationTransactionID string) (*PaymentResponse, error) {
+	minor, err := GatewayAmount(amount).MinorUnits(currency)
+	if err != nil {
+		return nil, mockRequestError("invalid amount")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, err := s.openOrder(orderID, currency)
+	if err != nil {
+		return nil, err
+	}
+	order.amount = minor
+	order.captured = minor
+	order.status = "CAPTURED"
+	txn := order.record(transactionID, "PAYMENT", "SUCCESS", "APPROVED", minor)
+
+	return mockPaymentResponse(orderID, order, txn)
+}
+
+func (s *mockMastercardService) AuthorizeWithToken(token, amount, currency, reference, descriptor string) (*PaymentResponse, error) {
+	return s.transact(generateOrderID(), "AUTHORIZATION", amount, currency, "", false)
+}
+
+func (s *mockMastercardService) AuthorizeWithCard(cardNumber, expiryMonth, expiryYear, cvv, amount, currency, reference, descriptor string) (*PaymentResponse, error) {
+	return s.transact(generateOrderID(), "AUTHORIZATION", amount, currency, "", false)
+}
+
+func (s *mockMastercardService) CaptureAuthorization(orderID, transactionID, amount, currency string) (*PaymentResponse, error) {
+	minor, err := GatewayAmount(amount).MinorUnits(currency)
+	if err != nil || minor <= 0 {
+		return nil, mockRequestError("invalid amount")
+	}
+	if minor%100 == MockAmountGatewayError {
+		return nil, mockSentinelError(orderID, minor)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[orderID]
+	if !ok {
+		return nil, mockRequestError("order %s not found", orderID)
+	}
+	if order.status != "AUTHORIZED" && order.status != "PARTIALLY_CAPTURED" {
+		return nil, mockRequestError("order %s is %s and cannot be captured", orderID, order.status)
+	}
+	if order.captured+minor > order.amount {
+		return nil, mockRequestError("capture exceeds the authorized amount")
+	}
+
+	if code, declined := mockDecline(minor, false); declined {
+		txn := order.record(transactionID, "CAPTURE", "FAILURE", code, minor)
+		return mockPaymentResponse(orderID, order, txn)
+	}
+
+	order.captured += minor
+	order.status = "PARTIALLY_CAPTURED"
+	if order.captured == order.amount {
+		order.status = "CAPTURED"
+	}
+	txn := order.record(transactionID, "CAPTURE", "SUCCESS", "APPROVED", minor)
+	if minor%100 == MockAmountTimeout {
+		return nil, mockSentinelError(orderID, minor)
+	}
+
+	return mockPaymentResponse(orderID, order, txn)
+}
+
+func (s *mockMastercardService) VoidAuthorization(orderID string) (*PaymentResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[orderID]
+	if !ok {
+		return nil, mockRequestError("order %s not found", orderID)
+	}
+	if order.status != "AUTHORIZED" {
+		return nil, mockRequestError("order %s is %s and cannot be voided", orderID, order.status)
+	}
+
+	order.status = "CANCELLED"
+	txn := order.record("", "VOID_AUTHORIZATION", "SUCCESS", "APPROVED", order.amount)
+
+	return mockPaymentResponse(orderID, order, txn)
+}
+
+func (s *mockMastercardService) UpdateAuthorization(orderID, amount, currency string) (*PaymentResponse, error) {
+	minor, err := GatewayAmount(amount).MinorUnits(currency)
+	if err != nil {
+		return nil, mockRequestError("invalid amount")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[orderID]
+	if !ok {
+		return nil, mockRequestError("order %s not found", orderID)
+	}
+	if order.status != "AUTHORIZED" {
+		return nil, mockRequestError("order %s is %s and cannot be updated", orderID, order.status)
+	}
+
+	order.amount = minor
+	txn := order.record("", "UPDATE_AUTHORIZATION", "SUCCESS", "APPROVED", minor)
+
+	return mockPaymentResponse(orderID, order, txn)
+}
+
+func (s *mockMastercardService) RefundPayment(orderID, amount, currency string) (*PaymentResponse, error) {
+	minor, err := GatewayAmount(amount).MinorUnits(currency)
+	if err != nil || minor <= 0 {
+		return nil, mockRequestError("invalid amount")
+	}
+	if minor%100 == MockAmountGatewayError {
+		return nil, mockSentinelError(orderID, minor)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[orderID]
+	if !ok {
+		return nil, mockRequestError("order %s not found", orderID)
+	}
+	if order.status != "CAPTURED" && order.status != "PARTIALLY_REFUNDED" {
+		return nil, mockRequestError("order %s is %s and cannot be refunded", orderID, order.status)
+	}
+	if order.refunded+minor > order.captured {
+		return nil, mockRequestError("refund exceeds the captured amount")
+	}
+
+	if code, declined := mockDecline(minor, false); declined {
+		txn := order.record("", "REFUND", "FAILURE", code, minor)
+		return mockPaymentResponse(orderID, order, txn)
+	}
+
+	order.refunded += minor
+	order.status = "PARTIALLY_REFUNDED"
+	if order.refunded == order.captured {
+		order.status = "REFUNDED"
+	}
+	txn := order.record("", "REFUND", "SUCCESS", "APPROVED", minor)
+	if minor%100 == MockAmountTimeout {
+		return nil, mockSentinelError(orderID, minor)
+	}
+
+	return mockPaymentResponse(orderID, order, txn)
+}
+
+func (s *mockMastercardService) RetrieveOrder(ctx context.Context, orderID string) (*OrderResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[orderID]
+	if !ok {
+		return nil, &NotFoundError{Message: fmt.Sprintf("order %s not found at gateway", orderID)}
+	}
+
+	transactions := make([]map[string]interface{}, 0, len(order.transactions))
+	for _, txn := range order.transactions {
+		transactions = append(transactions, map[string]interface{}{
+			"result":   txn.result,
+			"response": map[string]interface{}{"gatewayCode": txn.gatewayCode},
+			"transaction": map[string]interface{}{
+				"id":     txn.id,
+				"type":   txn.txnType,
+				"amount": formatMinorUnits(txn.amount, order.currency),
+			},
+		})
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"result":              "SUCCESS",
+		"id":                  orderID,
+		"amount":              formatMinorUnits(order.amount, order.currency),
+		"currency":            order.currency,
+		"status":              order.status,
+		"totalCapturedAmount": formatMinorUnits(order.captured, order.currency),
+		"transaction":         transactions,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var response OrderResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %v", err)
+	}
+	response.Raw = body
+	response.GatewayTrace = GatewayTrace{CorrelationID: newCorrelationID()}
+
+	return &response, nil
+}
+
+func (s *mockMastercardService) TestConnection(ctx context.Context) (*ConnectionTestResult, error) {
+	return &ConnectionTestResult{
+		MerchantID:    s.cfg.MastercardMerchantID,
+		Host:          "mock",
+		SessionID:     "SESSION" + mockDigits(16),
+		CorrelationID: newCorrelationID(),
+	}, nil
+}
+
+func (s *mockMastercardService) PayWithGooglePay(cardNumber, expiryMonth, expiryYear, cryptogram, eci, amount, currency string) (*PaymentResponse, error) {
+	return s.transact(generateOrderID(), "PAYMENT", amount, currency, "GOOGLE_PAY", false)
+}
+
+func (s *mockMastercardService) AuthorizeWithGooglePay(cardNumber, expiryMonth, expiryYear, cryptogram, eci, amount, currency string) (*PaymentResponse, error) {
+	return s.transact(generateOrderID(), "AUTHORIZATION", amount, currency, "GOOGLE_PAY", false)
+}
+
+func (s *mockMastercardService) PayWithGooglePayToken(paymentToken, amount, currency string) (*PaymentResponse, error) {
+	return s.transact(generateOrderID(), "PAYMENT", amount, currency, "GOOGLE_PAY", false)
+}
+
+func (s *mockMastercardService) AuthorizeWithGooglePayToken(paymentToken, amount, currency string) (*PaymentResponse, error) {
+	return s.transact(generateOrderID(), "AUTHORIZATION", amount, currency, "GOOGLE_PAY", false)
+}
+
+func (s *mockMastercardService) PayWithApplePayToken(paymentToken, amount, currency string) (*PaymentResponse, error) {
+	return s.transact(generateOrderID(), "PAYMENT", amount, currency, "APPLE_PAY", false)
+}
+
+func (s *mockMastercardService) AuthorizeWithApplePayToken(paymentToken, amount, currency string) (*PaymentResponse, error) {
+	return s.transact(generateOrderID(), "AUTHORIZATION", amount, currency, "APPLE_PAY", false)
+}
+
+// transact runs a PAYMENT or AUTHORIZATION on orderID. challengeable is set
+// for stored-card charges; see mockDecline.
+func (s *mockMastercardService) transact(orderID, txnType, amount, currency, walletProvider string, challengeable bool) (*PaymentResponse, error) {
+	minor, err := GatewayAmount(amount).MinorUnits(currency)
+	if err != nil || minor <= 0 {
+		return nil, mockRequestError("invalid amount")
+	}
+	sentinel := minor % 100
+	if sentinel == MockAmountGatewayError {
+		return nil, mockSentinelError(orderID, minor)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, err := s.openOrder(orderID, currency)
+	if err != nil {
+		return nil, err
+	}
+	order.walletProvider = walletProvider
+
+	if code, declined := mockDecline(minor, challengeable); declined {
+		order.amount = minor
+		order.status = "FAILED"
+		txn := order.record("", txnType, "FAILURE", code, minor)
+		return mockPaymentResponse(orderID, order, txn)
+	}
+
+	order.amount = minor
+	order.status = "AUTHORIZED"
+	if txnType == "PAYMENT" {
+		order.captured = minor
+		order.status = "CAPTURED"
+	}
+	txn := order.record("", txnType, "SUCCESS", "APPROVED", minor)
+
+	if sentinel == MockAmountTimeout {
+		// The charge stands; only the answer is lost, as after a real timeout
+		return nil, mockSentinelError(orderID, minor)
+	}
+
+	return mockPaymentResponse(orderID, order, txn)
+}
+
+// openOrder returns orderID for a new transaction, creating it if needed.
+// Like the gateway, it refuses to charge an order that already succeeded.
+// The caller must hold s.mu.
+func (s *mockMastercardService) openOrder(orderID, currency string) (*mockOrder, error) {
+	order, ok := s.orders[orderID]
+	if !ok {
+		order = &mockOrder{currency: currency}
+		s.orders[orderID] = order
+		return order, nil
+	}
+	if order.status != "FAILED" {
+		return nil, mockRequestError("order %s is already %s", orderID, order.status)
+	}
+	return order, nil
+}
+
+// record appends a transaction to the order, numbering it after the last
+// one when id is empty
+func (o *mockOrder) record(id, txnType, result, gatewayCode string, amount int64) mockTransaction {
+	if id == "" {
+		id = strconv.Itoa(len(o.transactions) + 1)
+	}
+	txn := mockTransaction{id: id, txnType: txnType, result: result, gatewayCode: gatewayCode, amount: amount}
+	o.transactions = append(o.transactions, txn)
+	return txn
+}
+
+// mockPaymentResponse builds the gateway's answer to txn from the JSON the
+// gateway would send, so Raw looks like a real response
+func mockPaymentResponse(orderID string, order *mockOrder, txn mockTransaction) (*PaymentResponse, error) {
+	status := "APPROVED"
+	if txn.result != "SUCCESS" {
+		status = "DECLINED"
+	}
+
+	orderBody := map[string]interface{}{
+		"id":       orderID,
+		"amount":   formatMinorUnits(order.amount, order.currency),
+		"currency": order.currency,
+		"status":   order.status,
+	}
+	if order.walletProvider != "" {
+		orderBody["walletProvider"] = order.walletProvider
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"result":      txn.result,
+		"gatewayCode": txn.gatewayCode,
+		"response":    map[string]interface{}{"gatewayCode": txn.gatewayCode},
+		"order":       orderBody,
+		"transaction": map[string]interface{}{
+			"id":       txn.id,
+			"amount":   formatMinorUnits(txn.amount, order.currency),
+			"currency": order.currency,
+			"type":     txn.txnType,
+			"status":   status,
+		},
+		"authorizationResponse": map[string]interface{}{"transactionIdentifier": mockDigits(15)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var response PaymentResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %v", err)
+	}
+	response.Raw = body
+	response.GatewayTrace = GatewayTrace{CorrelationID: newCorrelationID()}
+
+	return &response, nil
+}
+
+// mockDecline returns the gateway code for an amount the mock declines.
+// challengeable is set for stored-card charges, the only ones the
+// authentication sentinel applies to; elsewhere it is approved.
+func mockDecline(minor int64, challengeable bool) (string, bool) {
+	sentinel := minor % 100
+	if sentinel == MockAmountAuthenticationRequired && !challengeable {
+		return "", false
+	}
+	code, declined := mockGatewayCodes[sentinel]
+	return code, declined
+}
+
+// mockSentinelError returns the transport failure the error sentinels stand
+// for, or nil for any other amount
+func mockSentinelError(orderID string, minor int64) error {
+	trace := GatewayTrace{CorrelationID: newCorrelationID()}
+	switch minor % 100 {
+	case MockAmountGatewayError:
+		return &GatewayAPIError{StatusCode: http.StatusInternalServerError, Body: `{"error":{"cause":"SERVER_FAILED","explanation":"Simulated gateway error"}}`, Trace: trace}
+	case MockAmountTimeout:
+		return ambiguousIfUnknown(orderID, &GatewayAPIError{StatusCode: http.StatusGatewayTimeout, Body: "simulated gateway timeout", Trace: trace})
+	}
+	return nil
+}
+
+// mockRequestError is the gateway rejecting a request it can't process
+func mockRequestError(format string, args ...interface{}) error {
+	explanation, _ := json.Marshal(fmt.Sprintf(format, args...))
+	return &GatewayAPIError{
+		StatusCode: http.StatusBadRequest,
+		Body:       fmt.Sprintf(`{"error":{"cause":"INVALID_REQUEST","explanation":%s}}`, explanation),
+		Trace:      GatewayTrace{CorrelationID: newCorrelationID()},
+	}
+}
+
+func mockTokenResponse(token string, card mockCard) *TokenResponse {
+	var response TokenResponse
+	scheme := DetectCardScheme(card.number)
+
+	response.Token = token
+	response.SourceOfFunds.Provided.Card.Brand = scheme
+	response.SourceOfFunds.Provided.Card.Scheme = scheme
+	response.SourceOfFunds.Provided.Card.Funding = "CREDIT"
+	response.SourceOfFunds.Provided.Card.Expiry = card.expiry
+	response.SourceOfFunds.Provided.Card.Bin = card.number[:6]
+	response.SourceOfFunds.Provided.Card.Last4 = card.number[len(card.number)-4:]
+	response.SourceOfFunds.Provided.Card.Number = card.number[:6] + strings.Repeat("x", len(card.number)-10) + card.number[len(card.number)-4:]
+
+	return &response
+}
+
+// mockCardExpired reports whether a card expiring at the end of month/year
+// has expired. An expiry that doesn't parse is treated as valid.
+func mockCardExpired(month, year string) bool {
+	m, err := strconv.Atoi(month)
+	if err != nil {
+		return false
+	}
+	y, err := strconv.Atoi(year)
+	if err != nil {
+		return false
+	}
+	if y < 100 {
+		y += 2000
+	}
+	return time.Now().After(time.Date(y, time.Month(m)+1, 1, 0, 0, 0, 0, time.UTC))
+}
+
+// formatMinorUnits formats a minor unit amount as the gateway's decimal string
+func formatMinorUnits(minor int64, currency string) string {
+	decimals := currencyDecimalPlaces(currency)
+	scale := int64(1)
+	for i := 0; i < decimals; i++ {
+		scale *= 10
+	}
+	if decimals == 0 {
+		return strconv.FormatInt(minor, 10)
+	}
+	return fmt.Sprintf("%d.%0*d", minor/scale, decimals, minor%scale)
+}
+
+// mockDigits returns n random digits, for tokens and trace identifiers
+func mockDigits(n int) string {
+	digits := make([]byte, n)
+	for i := range digits {
+		digits[i] = byte('0' + rand.Intn(10))
+	}
+	return string(digits)
+}
@@ -0,0 +1,228 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"pg-backend/internal/models"
+	"pg-backend/internal/repositories"
+
+	"github.com/google/uuid"
+)
+
+// captureRetryDelay is the pause between capture attempts
+const captureRetryDelay = 2 * time.Second
+
+// CapturePolicy controls how hard a capture is tried and what happens to the
+// authorization when it can't be completed
+type CapturePolicy struct {
+	MaxAttempts   int
+	VoidOnFailure bool
+}
+
+// CaptureRequest captures part or all of an authorization
+type CaptureRequest struct {
+	OrderID  string
+	Amount   string
+	Currency string
+
+	// VoidRemainder releases what is left of the authorization once this
+	// capture succeeds
+	VoidRemainder bool
+
+	// VoidOnFailure overrides CapturePolicy.VoidOnFailure when set
+	VoidOnFailure *bool
+}
+
+// CaptureResult is a successful capture. VoidErr is set when the remainder
+// was to be voided but couldn't be.
+type CaptureResult struct {
+	Response      *PaymentResponse
+	Authorization *models.Transaction
+	Capture       *models.Transaction
+	Void          *models.Transaction
+	VoidErr       error
+	Captured      float64
+	Remaining     float64
+}
+
+// CaptureFailedError is returned when every capture attempt failed. Err holds
+// the last transport error; otherwise Result and GatewayCode hold the last
+// decline. Void is set when the authorization was released as a result.
+type CaptureFailedError struct {
+	Attempts    int
+	Result      string
+	GatewayCode string
+	Err         error
+	Remaining   float64
+	Void        *models.Transaction
+	VoidErr     error
+}
+
+func (e *CaptureFailedError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("capture failed after %d attempts: %v", e.Attempts, e.Err)
+	}
+	return fmt.Sprintf("capture declined after %d attempts: %s", e.Attempts, e.GatewayCode)
+}
+
+// Capture captures from a recorded authorization, retrying failed attempts up
+// to the policy's limit. If every attempt fails and void-on-failure applies,
+// the authorization is voided so the funds aren't held indefinitely.
+func (s *paymentService) Capture(ctx context.Context, req CaptureRequest) (*CaptureResult, error) {
+	authorization, err := s.transactionRepo.GetAuthorizationByGatewayOrderID(ctx, req.OrderID)
+	if err != nil {
+		if _, ok := err.(*repositories.NotFoundError); ok {
+			return nil, &NotFoundError{Message: "authorization not found"}
+		}
+		return nil, err
+	}
+
+	balance, err := s.transactionRepo.GetAuthorizationBalance(ctx, authorization)
+	if err != nil {
+		return nil, err
+	}
+
+	amount, err := strconv.ParseFloat(req.Amount, 64)
+	if err != nil || amount <= 0 {
+		return nil, &ValidationError{Field: "amount", Message: "must be a positive number"}
+	}
+	if !strings.EqualFold(req.Currency, authorization.Currency) {
+		return nil, &ValidationError{Field: "currency", Message: "must match the authorization currency " + authorization.Currency}
+	}
+	if balance.Voided {
+		return nil, &ConflictError{Message: "authorization has been voided"}
+	}
+	if amount > balance.Remaining+0.005 {
+		return nil, &ConflictError{Message: fmt.Sprintf("capture amount exceeds the remaining authorized balance of %.2f", balance.Remaining)}
+	}
+
+	resp, failure := s.attemptCapture(ctx, req, balance)
+	if failure != nil {
+		failure.Remaining = balance.Remaining
+		voidOnFailure := s.capturePolicy.VoidOnFailure
+		if req.VoidOnFailure != nil {
+			voidOnFailure = *req.VoidOnFailure
+		}
+		if voidOnFailure {
+			failure.Void, failure.VoidErr = s.VoidAuthorization(ctx, authorization)
+			if failure.VoidErr != nil {
+				log.Printf("ERROR: failed to void authorization %s after capture failure: %v", authorization.ID, failure.VoidErr)
+			}
+		}
+		return nil, failure
+	}
+
+	// Save capture transaction against its authorization
+	capture := &models.Transaction{
+		UserID:               authorization.UserID,
+		CardID:               authorization.CardID,
+		Amount:               amount,
+		Currency:             authorization.Currency,
+		Status:               resp.Transaction.Status,
+		GatewayTransactionID: resp.Transaction.ID,
+		GatewayOrderID:       req.OrderID,
+		GatewayResponse:      resp.Raw,
+		CorrelationID:        resp.CorrelationID,
+		GatewayCorrelationID: resp.GatewayCorrelationID,
+		ParentTransactionID:  uuid.NullUUID{UUID: authorization.ID, Valid: true},
+		Type:                 models.TransactionTypeCapture,
+		RoutingKey:           authorization.RoutingKey,
+		StatementDescriptor:  authorization.StatementDescriptor,
+	}
+	if err := s.transactionRepo.CreateTransaction(ctx, capture); err != nil {
+		return nil, fmt.Errorf("capture %s succeeded but could not be recorded: %w", resp.Transaction.ID, err)
+	}
+
+	result := &CaptureResult{
+		Response:      resp,
+		Authorization: authorization,
+		Capture:       capture,
+		Captured:      roundAmount(balance.Captured + amount),
+		Remaining:     math.Max(roundAmount(balance.Remaining-amount), 0),
+	}
+
+	if req.VoidRemainder && result.Remaining > 0.005 {
+		if result.Void, result.VoidErr = s.VoidAuthorization(ctx, authorization); result.VoidErr == nil {
+			result.Remaining = 0
+		}
+	}
+
+	return result, nil
+}
+
+// attemptCapture tries the capture up to the policy's attempt limit. Every
+// attempt reuses the same gateway transaction ID, so a retry after a lost
+// response can't capture twice.
+func (s *paymentService) attemptCapture(ctx context.Context, req CaptureRequest, balance *models.AuthorizationBalance) (*PaymentResponse, *CaptureFailedError) {
+	// Every partial capture needs its own gateway transaction ID
+	transactionID := fmt.Sprintf("capture-%d", balance.Captures+1)
+
+	maxAttempts := s.capturePolicy.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	failure := &CaptureFailedError{}
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				failure.Err = ctx.Err()
+				return nil, failure
+			case <-time.After(captureRetryDelay):
+			}
+		}
+
+		failure.Attempts = attempt
+		resp, err := s.mastercardService.CaptureAuthorization(req.OrderID, transactionID, req.Amount, req.Currency)
+		if err != nil {
+			failure.Err, failure.Result, failure.GatewayCode = err, "", ""
+			continue
+		}
+		if resp.Result != "SUCCESS" {
+			failure.Err, failure.Result, failure.GatewayCode = nil, resp.Result, resp.GatewayCode
+			continue
+		}
+		return resp, nil
+	}
+
+	return nil, failure
+}
+
+// VoidAuthorization voids what is left of an authorization and records the
+// void against it so no further captures are accepted
+func (s *paymentService) VoidAuthorization(ctx context.Context, authorization *models.Transaction) (*models.Transaction, error) {
+	voidResp, err := s.mastercardService.VoidAuthorization(authorization.GatewayOrderID)
+	if err != nil {
+		return nil, err
+	}
+	if voidResp.Result != "SUCCESS" {
+		return nil, fmt.Errorf("void declined: %s", voidResp.GatewayCode)
+	}
+
+	voidTransaction := &models.Transaction{
+		UserID:               authorization.UserID,
+		CardID:               authorization.CardID,
+		Currency:             authorization.Currency,
+		Status:               voidResp.Transaction.Status,
+		GatewayTransactionID: voidResp.Transaction.ID,
+		GatewayOrderID:       authorization.GatewayOrderID,
+		GatewayResponse:      voidResp.Raw,
+		CorrelationID:        voidResp.CorrelationID,
+		GatewayCorrelationID: voidResp.GatewayCorrelationID,
+		ParentTransactionID:  uuid.NullUUID{UUID: authorization.ID, Valid: true},
+		Type:                 models.TransactionTypeVoid,
+	}
+	if err := s.transactionRepo.CreateTransaction(ctx, voidTransaction); err != nil {
+		// The void went through at the gateway; don't report it as failed
+		log.Printf("Warning: failed to record void for authorization %s: %v", authorization.ID, err)
+	}
+
+	return voidTransaction, nil
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"pg-backend/internal/config"
+)
+
+// Metadata keys a charge can use to pick its route. routing_key wins when
+// both are set.
+const (
+	MetadataRoutingKey  = "routing_key"
+	MetadataSubMerchant = "sub_merchant"
+)
+
+// PaymentRoute is how charges for one storefront are presented to the gateway
+type PaymentRoute struct {
+	Key                 string
+	StatementDescriptor string
+}
+
+// PaymentRouter resolves a routing key to its route
+type PaymentRouter interface {
+	Route(key string) (*PaymentRoute, bool)
+}
+
+// NewPaymentRouter returns a router backed by the configured PAYMENT_ROUTES
+func NewPaymentRouter(cfg *config.Config) PaymentRouter {
+	return staticPaymentRouter(cfg.PaymentRoutes())
+}
+
+// staticPaymentRouter maps lower-cased routing keys to statement descriptors
+type staticPaymentRouter map[string]string
+
+func (r staticPaymentRouter) Route(key string) (*PaymentRoute, bool) {
+	key = strings.ToLower(strings.TrimSpace(key))
+	descriptor, ok := r[key]
+	if !ok {
+		return nil, false
+	}
+	return &PaymentRoute{Key: key, StatementDescriptor: descriptor}, true
+}
+
+// resolveRoute picks the route named by a charge's metadata. It returns nil
+// when the metadata names none, and an error when it names an unknown route.
+func resolveRoute(router PaymentRouter, metadata map[string]string) (*PaymentRoute, error) {
+	key := metadata[MetadataRoutingKey]
+	if key == "" {
+		key = metadata[MetadataSubMerchant]
+	}
+	if key == "" {
+		return nil, nil
+	}
+
+	route, ok := router.Route(key)
+	if !ok {
+		return nil, &ValidationError{Field: "metadata", Message: fmt.Sprintf("unknown routing key %q", key)}
+	}
+	return route, nil
+}
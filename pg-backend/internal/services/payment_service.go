@@ -0,0 +1,394 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"pg-backend/internal/models"
+	"pg-backend/internal/repositories"
+	"pg-backend/pkg/utils"
+
+	"github.com/google/uuid"
+)
+
+// PaymentService runs customer-initiated charges and authorizations end to
+// end: user and card lookup, the gateway call, decline mapping and recording
+// the transaction
+type PaymentService interface {
+	Charge(ctx context.Context, req ChargeRequest) (*ChargeResult, error)
+	Authorize(ctx context.Context, req ChargeRequest) (*ChargeResult, error)
+	Capture(ctx context.Context, req CaptureRequest) (*CaptureResult, error)
+	VoidAuthorization(ctx context.Context, authorization *models.Transaction) (*models.Transaction, error)
+}
+
+// PaymentSourceType says what a ChargeRequest pays with. Supporting a new
+// source means adding a type here and a branch in callGateway.
+type PaymentSourceType string
+
+const (
+	// PaymentSourceSavedCard charges the gateway token of the card in CardID
+	PaymentSourceSavedCard PaymentSourceType = "saved_card"
+	// PaymentSourceCard charges the card number and security code in Card
+	PaymentSourceCard PaymentSourceType = "card"
+	// PaymentSourceWalletToken sends WalletToken for the gateway to decrypt
+	PaymentSourceWalletToken PaymentSourceType = "wallet_token"
+	// PaymentSourceDevicePayment charges a wallet payment the merchant has
+	// already decrypted: the device PAN and cryptogram in Card
+	PaymentSourceDevicePayment PaymentSourceType = "device_payment"
+)
+
+// ChargeRequest describes a payment or authorization from any source
+type ChargeRequest struct {
+	UserID   uuid.UUID
+	Source   PaymentSourceType
+	Amount   string
+	Currency string
+
+	// Source details; which are used depends on Source
+	CardID      uuid.NullUUID
+	Card        *CardDetails
+	WalletToken string
+
+	// Merchant's own order number, sent to the gateway as order.reference
+	MerchantReference string
+
+	// Currency the merchant settles in when it differs from Currency
+	SettlementCurrency string
+
+	// Merchant metadata; routing_key or sub_merchant picks a PaymentRoute
+	Metadata map[string]string
+
+	// Wallet payments record their method and device data on the transaction.
+	// WalletProvider picks the wallet for PaymentSourceWalletToken, and a
+	// saved card must be of PaymentMethodType when it is set.
+	PaymentMethodType string
+	WalletProvider    string
+	DevicePaymentData map[string]interface{}
+}
+
+// CardDetails are card details supplied with the request. Wallet payments
+// carry a device cryptogram and ECI instead of a security code.
+type CardDetails struct {
+	Number      string
+	ExpiryMonth string
+	ExpiryYear  string
+	CVV         string
+	Cryptogram  string
+	ECI         string
+}
+
+// ChargeResult is the gateway's answer and the transaction recorded for it.
+// Transaction.ID is unset if recording failed; the payment still went through.
+type ChargeResult struct {
+	Response    *PaymentResponse
+	Transaction *models.Transaction
+}
+
+// PaymentDeclinedError is returned when the gateway declines a payment
+type PaymentDeclinedError struct {
+	Result      string
+	GatewayCode string
+}
+
+func (e *PaymentDeclinedError) Error() string {
+	return fmt.Sprintf("payment declined: %s", e.GatewayCode)
+}
+
+type paymentService struct {
+	mastercardService MastercardService
+	userRepo          repositories.UserRepository
+	cardRepo          repositories.CardRepository
+	transactionRepo   repositories.TransactionRepository
+	fxRates           FXRateSource
+	router            PaymentRouter
+	capturePolicy     CapturePolicy
+	amountLimits      AmountLimits
+	currencies        SupportedCurrencies
+}
+
+func NewPaymentService(
+	mastercardService MastercardService,
+	userRepo repositories.UserRepository,
+	cardRepo repositories.CardRepository,
+	transactionRepo repositories.TransactionRepository,
+	fxRates FXRateSource,
+	router PaymentRouter,
+	capturePolicy CapturePolicy,
+	amountLimits AmountLimits,
+	currencies SupportedCurrencies,
+) PaymentService {
+	return &paymentService{
+		mastercardService: mastercardService,
+		userRepo:          userRepo,
+		cardRepo:          cardRepo,
+		transactionRepo:   transactionRepo,
+		fxRates:           fxRates,
+		router:            router,
+		capturePolicy:     capturePolicy,
+		amountLimits:      amountLimits,
+		currencies:        currencies,
+	}
+}
+
+func (s *paymentService) Charge(ctx context.Context, req ChargeRequest) (*ChargeResult, error) {
+	return s.process(ctx, req, models.TransactionTypeManual)
+}
+
+func (s *paymentService) Authorize(ctx context.Context, req ChargeRequest) (*ChargeResult, error) {
+	return s.process(ctx, req, models.TransactionTypeAuthorization)
+}
+
+func (s *paymentService) process(ctx context.Context, req ChargeRequest, transactionType models.TransactionType) (*ChargeResult, error) {
+	// 1. Validate user and card
+	if _, err := s.userRepo.GetUserByID(ctx, req.UserID); err != nil {
+		if _, ok := err.(*repositories.NotFoundError); ok {
+			return nil, &NotFoundError{Message: "user not found"}
+		}
+		return nil, err
+	}
+
+	if err := validateSource(req); err != nil {
+		return nil, err
+	}
+
+	if err := s.currencies.Check("currency", req.Currency); err != nil {
+		return nil, err
+	}
+	if err := s.amountLimits.Check("amount", utils.MustParseFloat(req.Amount), req.Currency); err != nil {
+		return nil, err
+	}
+
+	if err := validateMetadata(req.Metadata); err != nil {
+		return nil, err
+	}
+	route, err := resolveRoute(s.router, req.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	if route != nil && (req.Source == PaymentSourceWalletToken || req.Source == PaymentSourceDevicePayment) {
+		return nil, &ValidationError{Field: "metadata", Message: "wallet payments can't be routed"}
+	}
+
+	var card *models.Card
+	if req.Source == PaymentSourceSavedCard {
+		var err error
+		if card, err = s.savedCard(ctx, req); err != nil {
+			return nil, err
+		}
+	}
+
+	// 2. Work out the settlement amount before charging so an unknown
+	// currency pair is rejected up front
+	var settlementAmount, fxRate float64
+	if req.SettlementCurrency != "" {
+		var err error
+		settlementAmount, fxRate, err = ConvertAmount(ctx, s.fxRates, utils.MustParseFloat(req.Amount), req.Currency, req.SettlementCurrency)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 3. Call the gateway
+	resp, err := s.callGateway(ctx, req, card, route, transactionType)
+	if err != nil {
+		var mismatch *AmountMismatchError
+		if errors.As(err, &mismatch) && mismatch.Response != nil {
+			// The gateway processed the order for another amount, so money
+			// may have moved. Record it as pending for manual reconciliation
+			// instead of dropping it.
+			s.recordTransaction(ctx, req, transactionType, card, route, mismatch.Response, models.TransactionStatusPending, settlementAmount, fxRate)
+		}
+		return nil, err
+	}
+	if resp.Result != "SUCCESS" && resp.GatewayCode != "APPROVED" {
+		return nil, &PaymentDeclinedError{Result: resp.Result, GatewayCode: resp.GatewayCode}
+	}
+
+	// 4. Record the transaction. An approved payment the gateway recommends
+	// against is recorded too, so the void that reverses it has a parent.
+	transaction := s.recordTransaction(ctx, req, transactionType, card, route, resp, resp.Transaction.Status, settlementAmount, fxRate)
+	if resp.NotRecommended() {
+		s.reverseNotRecommended(ctx, transaction)
+		return nil, &PaymentDeclinedError{Result: resp.Result, GatewayCode: GatewayRecommendationDoNotProceed}
+	}
+
+	// Cards saved before stored-credential tracking have no reference yet;
+	// the first cardholder-initiated charge becomes the initial transaction
+	traceID := resp.AuthorizationResponse.TransactionIdentifier
+	if card != nil && transactionType == models.TransactionTypeManual && card.StoredCredentialReference == "" && traceID != "" {
+		if err := s.cardRepo.SetStoredCredentialReference(ctx, card.ID, traceID); err != nil {
+			fmt.Printf("Warning: Failed to save stored credential reference: %v\n", err)
+		}
+	}
+
+	return &ChargeResult{Response: resp, Transaction: transaction}, nil
+}
+
+// recordTransaction saves the gateway's response to req as a transaction with
+// the given status. A failure to save is only logged, since the gateway has
+// already processed the payment.
+func (s *paymentService) recordTransaction(ctx context.Context, req ChargeRequest, transactionType models.TransactionType, card *models.Card, route *PaymentRoute, resp *PaymentResponse, status string, settlementAmount, fxRate float64) *models.Transaction {
+	transaction := &models.Transaction{
+		UserID:               req.UserID,
+		Amount:               utils.MustParseFloat(req.Amount),
+		Currency:             req.Currency,
+		Status:               status,
+		GatewayTransactionID: resp.Transaction.ID,
+		GatewayOrderID:       resp.Order.ID,
+		GatewayResponse:      resp.Raw,
+		CorrelationID:        resp.CorrelationID,
+		GatewayCorrelationID: resp.GatewayCorrelationID,
+		Type:                 transactionType,
+		MerchantReference:    req.MerchantReference,
+		WalletProvider:       req.WalletProvider,
+		PaymentMethodType:    req.PaymentMethodType,
+		DevicePaymentData:    req.DevicePaymentData,
+		SettlementCurrency:   req.SettlementCurrency,
+		SettlementAmount:     settlementAmount,
+		FXRate:               fxRate,
+	}
+	if route != nil {
+		transaction.RoutingKey = route.Key
+		transaction.StatementDescriptor = route.StatementDescriptor
+	}
+
+	if req.WalletProvider != "" {
+		if transaction.DevicePaymentData == nil {
+			transaction.DevicePaymentData = map[string]interface{}{}
+		}
+		if _, ok := transaction.DevicePaymentData["is_simulated"]; !ok {
+			// Without the Device Payments privilege the gateway treats wallet
+			// payments as plain card payments and echoes no wallet provider
+			transaction.DevicePaymentData["is_simulated"] = strings.Contains(resp.Result, "simulated") || resp.Order.WalletProvider == ""
+		}
+	}
+
+	if card != nil {
+		transaction.CardID = card.ID
+	}
+
+	if err := s.transactionRepo.CreateTransaction(ctx, transaction); err != nil {
+		// The payment went through even if recording it failed
+		fmt.Printf("Warning: Failed to save %s transaction to database: %v\n", transactionType, err)
+	}
+
+	return transaction
+}
+
+// savedCard loads the request's saved card and checks it may be used
+func (s *paymentService) savedCard(ctx context.Context, req ChargeRequest) (*models.Card, error) {
+	card, err := s.cardRepo.GetCardByID(ctx, req.CardID.UUID)
+	if err != nil {
+		if _, ok := err.(*repositories.NotFoundError); ok {
+			return nil, &NotFoundError{Message: "card not found"}
+		}
+		return nil, err
+	}
+
+	if card.UserID != req.UserID {
+		return nil, &ForbiddenError{Message: "card does not belong to user"}
+	}
+	if req.PaymentMethodType != "" && card.PaymentMethodType != req.PaymentMethodType {
+		return nil, &ValidationError{
+			Field:   "card_id",
+			Message: fmt.Sprintf("card is not a %s payment method", req.PaymentMethodType),
+		}
+	}
+
+	return card, nil
+}
+
+// validateSource checks the request carries what its source needs
+func validateSource(req ChargeRequest) error {
+	hasCard := req.Card != nil && req.Card.Number != "" && req.Card.ExpiryMonth != "" && req.Card.ExpiryYear != ""
+
+	switch req.Source {
+	case PaymentSourceSavedCard:
+		if !req.CardID.Valid {
+			return &ValidationError{Field: "card_id", Message: "card ID is required"}
+		}
+	case PaymentSourceCard:
+		if !hasCard || req.Card.CVV == "" {
+			return &ValidationError{Field: "card_number", Message: "card details required when not using saved card"}
+		}
+		if err := ValidateCardNumber(req.Card.Number); err != nil {
+			return err
+		}
+		return ValidateSecurityCode(req.Card.Number, req.Card.CVV)
+	case PaymentSourceDevicePayment:
+		if !hasCard || req.Card.Cryptogram == "" {
+			return &ValidationError{Field: "card_number", Message: "card details and cryptogram required for device payments"}
+		}
+		return ValidateCardNumber(req.Card.Number)
+	case PaymentSourceWalletToken:
+		if req.WalletToken == "" {
+			return &ValidationError{Field: "payment_token", Message: "payment token is required"}
+		}
+		if req.WalletProvider != models.WalletProviderGooglePay && req.WalletProvider != models.WalletProviderApplePay {
+			return &ValidationError{Field: "wallet_provider", Message: fmt.Sprintf("unsupported wallet provider %q", req.WalletProvider)}
+		}
+	default:
+		return &ValidationError{Field: "source", Message: fmt.Sprintf("unsupported payment source %q", req.Source)}
+	}
+	return nil
+}
+
+// callGateway picks the MastercardService operation for the payment source.
+// route, when set, supplies the statement descriptor for card payments.
+func (s *paymentService) callGateway(ctx context.Context, req ChargeRequest, card *models.Card, route *PaymentRoute, transactionType models.TransactionType) (*PaymentResponse, error) {
+	authorize := transactionType == models.TransactionTypeAuthorization
+
+	var descriptor string
+	if route != nil {
+		descriptor = route.StatementDescriptor
+	}
+
+	switch req.Source {
+	case PaymentSourceSavedCard:
+		if authorize {
+			return s.mastercardService.AuthorizeWithToken(card.GatewayToken, req.Amount, req.Currency, req.MerchantReference, descriptor)
+		}
+		return s.mastercardService.PayWithToken(ctx, card.GatewayToken, req.Amount, req.Currency, req.MerchantReference, descriptor)
+
+	case PaymentSourceDevicePayment:
+		if authorize {
+			return s.mastercardService.AuthorizeWithGooglePay(req.Card.Number, req.Card.ExpiryMonth, req.Card.ExpiryYear,
+				req.Card.Cryptogram, req.Card.ECI, req.Amount, req.Currency)
+		}
+		return s.mastercardService.PayWithGooglePay(req.Card.Number, req.Card.ExpiryMonth, req.Card.ExpiryYear,
+			req.Card.Cryptogram, req.Card.ECI, req.Amount, req.Currency)
+
+	case PaymentSourceWalletToken:
+		switch {
+		case req.WalletProvider == models.WalletProviderApplePay && authorize:
+			return s.mastercardService.AuthorizeWithApplePayToken(req.WalletToken, req.Amount, req.Currency)
+		case req.WalletProvider == models.WalletProviderApplePay:
+			return s.mastercardService.PayWithApplePayToken(req.WalletToken, req.Amount, req.Currency)
+		case authorize:
+			return s.mastercardService.AuthorizeWithGooglePayToken(req.WalletToken, req.Amount, req.Currency)
+		default:
+			return s.mastercardService.PayWithGooglePayToken(req.WalletToken, req.Amount, req.Currency)
+		}
+
+	default:
+		if authorize {
+			return s.mastercardService.AuthorizeWithCard(req.Card.Number, req.Card.ExpiryMonth, req.Card.ExpiryYear,
+				req.Card.CVV, req.Amount, req.Currency, req.MerchantReference, descriptor)
+		}
+		return s.mastercardService.PayWithCard(req.Card.Number, req.Card.ExpiryMonth, req.Card.ExpiryYear,
+			req.Card.CVV, req.Amount, req.Currency, req.MerchantReference, descriptor)
+	}
+}
+
+// reverseNotRecommended voids an approved payment the gateway recommends not
+// proceeding with, so the payer isn't charged and no funds stay held. The void
+// is recorded against the payment. A failed void is only logged: the payment
+// is declined either way, and the order has to be reversed by hand.
+func (s *paymentService) reverseNotRecommended(ctx context.Context, payment *models.Transaction) {
+	if _, err := s.VoidAuthorization(ctx, payment); err != nil {
+		log.Printf("ERROR: failed to void order %s the gateway recommended not proceeding with, reverse it manually: %v", payment.GatewayOrderID, err)
+	}
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"pg-backend/internal/config"
+	"pg-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+func TestChargeWithAmountMismatchIsRecordedForReview(t *testing.T) {
+	gateway := newTestGateway(t, func(t *testing.T, r *http.Request, body map[string]interface{}) (int, string) {
+		return http.StatusOK, `{"result":"SUCCESS","gatewayCode":"APPROVED","order":{"id":"order-1","amount":"250.00","currency":"USD"},"transaction":{"id":"1","status":"CAPTURED"}}`
+	})
+
+	userID := uuid.New()
+	card := &models.Card{ID: uuid.New(), UserID: userID, GatewayToken: "9000000000000001", StoredCredentialReference: "trace"}
+	transactions := &fakeTransactionRepo{}
+	cfg := &config.Config{}
+	service := NewPaymentService(gateway, fakeUserRepo{}, newFakeCardRepo(card), transactions, nil, nil, CapturePolicy{},
+		NewAmountLimits(cfg), NewSupportedCurrencies(cfg))
+
+	_, err := service.Charge(context.Background(), ChargeRequest{
+		UserID:   userID,
+		Source:   PaymentSourceSavedCard,
+		CardID:   uuid.NullUUID{UUID: card.ID, Valid: true},
+		Amount:   "25.00",
+		Currency: "USD",
+	})
+	var mismatch *AmountMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("Charge error = %v, want an AmountMismatchError", err)
+	}
+
+	if len(transactions.transactions) != 1 {
+		t.Fatalf("recorded %d transactions, want the mismatched order", len(transactions.transactions))
+	}
+	transaction := transactions.transactions[0]
+	if transaction.Status != models.TransactionStatusPending {
+		t.Errorf("transaction status = %s, want pending", transaction.Status)
+	}
+	if transaction.GatewayOrderID != "order-1" || transaction.GatewayTransactionID != "1" {
+		t.Errorf("transaction order = %s/%s, want order-1/1", transaction.GatewayOrderID, transaction.GatewayTransactionID)
+	}
+	if len(transaction.GatewayResponse) == 0 {
+		t.Error("transaction has no gateway response to reconcile against")
+	}
+}
+
+func TestNotRecommendedPaymentIsRecordedWithItsVoid(t *testing.T) {
+	gateway := newTestGateway(t, func(t *testing.T, r *http.Request, body map[string]interface{}) (int, string) {
+		if body["apiOperation"] == "VOID" {
+			return http.StatusOK, `{"result":"SUCCESS","gatewayCode":"APPROVED","transaction":{"id":"2","status":"VOIDED"}}`
+		}
+		return http.StatusOK, `{"result":"SUCCESS","gatewayCode":"APPROVED","response":{"gatewayRecommendation":"DO_NOT_PROCEED"},"order":{"id":"order-1","amount":"25.00","currency":"USD"},"transaction":{"id":"1","status":"APPROVED"}}`
+	})
+
+	userID := uuid.New()
+	card := &models.Card{ID: uuid.New(), UserID: userID, GatewayToken: "9000000000000001", StoredCredentialReference: "trace"}
+	transactions := &fakeTransactionRepo{}
+	cfg := &config.Config{}
+	service := NewPaymentService(gateway, fakeUserRepo{}, newFakeCardRepo(card), transactions, nil, nil, CapturePolicy{},
+		NewAmountLimits(cfg), NewSupportedCurrencies(cfg))
+
+	_, err := service.Charge(context.Background(), ChargeRequest{
+		UserID:   userID,
+		Source:   PaymentSourceSavedCard,
+		CardID:   uuid.NullUUID{UUID: card.ID, Valid: true},
+		Amount:   "25.00",
+		Currency: "USD",
+	})
+	var declined *PaymentDeclinedError
+	if !errors.As(err, &declined) || declined.GatewayCode != GatewayRecommendationDoNotProceed {
+		t.Fatalf("Charge error = %v, want a DO_NOT_PROCEED decline", err)
+	}
+
+	if len(transactions.transactions) != 2 {
+		t.Fatalf("recorded %d transactions, want the payment and its void", len(transactions.transactions))
+	}
+	payment, void := transactions.transactions[0], transactions.transactions[1]
+	if payment.GatewayOrderID != "order-1" || payment.Type != models.TransactionTypeManual {
+		t.Errorf("payment = %s on %s, want a manual charge on order-1", payment.Type, payment.GatewayOrderID)
+	}
+	if void.Type != models.TransactionTypeVoid || void.ParentTransactionID.UUID != payment.ID {
+		t.Errorf("void = %s with parent %v, want a void of %v", void.Type, void.ParentTransactionID.UUID, payment.ID)
+	}
+}
@@ -2,34 +2,52 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"pg-backend/internal/models"
 	"pg-backend/internal/repositories"
+	"regexp"
+	"strings"
 
 	"github.com/google/uuid"
 )
 
 type PlanService interface {
 	CreatePlan(ctx context.Context, plan *models.Plan) error
+	CreatePlans(ctx context.Context, plans []*models.Plan, allOrNothing bool) ([]PlanCreateResult, error)
 	GetPlan(ctx context.Context, id uuid.UUID) (*models.Plan, error)
 	GetPlanByName(ctx context.Context, name string) (*models.Plan, error)
 	GetAllPlans(ctx context.Context, activeOnly bool) ([]models.Plan, error)
 	UpdatePlan(ctx context.Context, plan *models.Plan) error
+	SetPlanActive(ctx context.Context, id uuid.UUID, active bool) (*models.Plan, error)
 	DeletePlan(ctx context.Context, id uuid.UUID) error
 	GetPlansByCurrency(ctx context.Context, currency string) ([]models.Plan, error)
 }
 
 type planService struct {
-	planRepo repositories.PlanRepository
+	planRepo     repositories.PlanRepository
+	amountLimits AmountLimits
+	currencies   SupportedCurrencies
 }
 
-func NewPlanService(planRepo repositories.PlanRepository) PlanService {
+func NewPlanService(planRepo repositories.PlanRepository, amountLimits AmountLimits, currencies SupportedCurrencies) PlanService {
 	return &planService{
-		planRepo: planRepo,
+		planRepo:     planRepo,
+		amountLimits: amountLimits,
+		currencies:   currencies,
 	}
 }
 
 func (s *planService) CreatePlan(ctx context.Context, plan *models.Plan) error {
+	if err := s.prepareNewPlan(plan); err != nil {
+		return err
+	}
+
+	return s.planRepo.CreatePlan(ctx, plan)
+}
+
+// prepareNewPlan validates a plan about to be created and fills in defaults
+func (s *planService) prepareNewPlan(plan *models.Plan) error {
 	// Validate interval
 	if !isValidInterval(plan.Interval) {
 		return fmt.Errorf("invalid interval. Must be one of: day, week, month, year")
@@ -45,17 +63,119 @@ func (s *planService) CreatePlan(ctx context.Context, plan *models.Plan) error {
 		return fmt.Errorf("trial period days cannot be negative")
 	}
 
+	if err := normalizeStatementDescriptor(plan); err != nil {
+		return err
+	}
+
+	if err := validateMetadata(plan.Metadata); err != nil {
+		return err
+	}
+
 	// Default currency to LKR if not specified
 	if plan.Currency == "" {
 		plan.Currency = "LKR"
 	}
 
+	// Every charge for the plan has to fit the merchant's limits
+	if err := s.currencies.Check("currency", plan.Currency); err != nil {
+		return err
+	}
+	if err := s.amountLimits.Check("amount", plan.Amount, plan.Currency); err != nil {
+		return err
+	}
+
 	// Set default active status
 	if !plan.IsActive {
 		plan.IsActive = true
 	}
 
-	return s.planRepo.CreatePlan(ctx, plan)
+	return nil
+}
+
+// PlanCreateResult reports the outcome of a single plan in a bulk create
+type PlanCreateResult struct {
+	Index  int        `json:"index"`
+	Status string     `json:"status"` // "created", "conflict", "failed" or "not_created"
+	PlanID *uuid.UUID `json:"plan_id,omitempty"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// CreatePlans creates a catalog of plans, validating each one as CreatePlan
+// does. With allOrNothing the plans are created in one transaction and none
+// are created if any is invalid or its name is taken; otherwise each valid
+// plan is created on its own and the rest are reported without stopping.
+func (s *planService) CreatePlans(ctx context.Context, plans []*models.Plan, allOrNothing bool) ([]PlanCreateResult, error) {
+	results := make([]PlanCreateResult, len(plans))
+	valid := make([]*models.Plan, 0, len(plans))
+	validIndex := make([]int, 0, len(plans))
+	seen := make(map[string]int)
+	failed := false
+
+	for i, plan := range plans {
+		results[i] = PlanCreateResult{Index: i, Status: "not_created"}
+
+		if err := s.prepareNewPlan(plan); err != nil {
+			results[i].Status = "failed"
+			results[i].Error = err.Error()
+			failed = true
+			continue
+		}
+
+		// Plan names are unique, including within one batch
+		if first, ok := seen[plan.Name]; ok {
+			results[i].Status = "conflict"
+			results[i].Error = fmt.Sprintf("duplicate of plan %d", first)
+			failed = true
+			continue
+		}
+		seen[plan.Name] = i
+
+		valid = append(valid, plan)
+		validIndex = append(validIndex, i)
+	}
+
+	if !allOrNothing {
+		for j, plan := range valid {
+			i := validIndex[j]
+			if err := s.planRepo.CreatePlan(ctx, plan); err != nil {
+				if _, ok := err.(*repositories.DuplicateError); !ok {
+					return nil, fmt.Errorf("failed to create plan %d: %w", i, err)
+				}
+				results[i].Status = "conflict"
+				results[i].Error = err.Error()
+				continue
+			}
+			id := plan.ID
+			results[i].Status = "created"
+			results[i].PlanID = &id
+		}
+		return results, nil
+	}
+
+	if failed {
+		return results, &ValidationError{Message: "one or more plans failed validation; nothing was created"}
+	}
+
+	if err := s.planRepo.CreatePlans(ctx, valid); err != nil {
+		var batchErr *repositories.BatchError
+		if errors.As(err, &batchErr) {
+			if _, ok := batchErr.Err.(*repositories.DuplicateError); ok {
+				i := validIndex[batchErr.Index]
+				results[i].Status = "conflict"
+				results[i].Error = batchErr.Err.Error()
+				return results, &ConflictError{Message: "one or more plans already exist; nothing was created"}
+			}
+		}
+		return nil, fmt.Errorf("failed to create plans: %w", err)
+	}
+
+	for j, plan := range valid {
+		id := plan.ID
+		results[validIndex[j]].Status = "created"
+		results[validIndex[j]].PlanID = &id
+	}
+
+	return results, nil
 }
 
 func (s *planService) GetPlan(ctx context.Context, id uuid.UUID) (*models.Plan, error) {
@@ -81,6 +201,21 @@ func (s *planService) UpdatePlan(ctx context.Context, plan *models.Plan) error {
 		return fmt.Errorf("amount must be greater than 0")
 	}
 
+	if err := normalizeStatementDescriptor(plan); err != nil {
+		return err
+	}
+
+	if err := validateMetadata(plan.Metadata); err != nil {
+		return err
+	}
+
+	if err := s.currencies.Check("currency", plan.Currency); err != nil {
+		return err
+	}
+	if err := s.amountLimits.Check("amount", plan.Amount, plan.Currency); err != nil {
+		return err
+	}
+
 	existingPlan, err := s.planRepo.GetPlanByID(ctx, plan.ID)
 	if err != nil {
 		return fmt.Errorf("plan not found: %w", err)
@@ -93,22 +228,48 @@ func (s *planService) UpdatePlan(ctx context.Context, plan *models.Plan) error {
 		fmt.Printf("Warning: Changing plan currency from %s to %s\n", existingPlan.Currency, plan.Currency)
 	}
 
-	return s.planRepo.UpdatePlan(ctx, plan)
+	// plan.UpdatedAt, when set, is the version the caller edited; refuse to
+	// overwrite changes made since
+	versioned := !plan.UpdatedAt.IsZero()
+	if versioned && !plan.UpdatedAt.Equal(existingPlan.UpdatedAt) {
+		return errPlanChanged
+	}
+
+	if err := s.planRepo.UpdatePlan(ctx, plan); err != nil {
+		// The plan changed between the read above and the update
+		if _, ok := err.(*repositories.NotFoundError); ok && versioned {
+			return errPlanChanged
+		}
+		return err
+	}
+	return nil
 }
 
-func (s *planService) DeletePlan(ctx context.Context, id uuid.UUID) error {
-	// In production, you would check if there are active subscriptions
-	// before deleting. For now, we'll just deactivate.
-	plan, err := s.planRepo.GetPlanByID(ctx, id)
+// errPlanChanged rejects an update made against an out-of-date plan
+var errPlanChanged = &ConflictError{Message: "plan was changed since it was read, reload it and try again"}
+
+// SetPlanActive archives or restores a plan. Only the active flag changes,
+// so existing subscriptions keep billing on an archived plan.
+func (s *planService) SetPlanActive(ctx context.Context, id uuid.UUID, active bool) (*models.Plan, error) {
+	plan, err := s.planRepo.SetActive(ctx, id, active)
 	if err != nil {
-		return fmt.Errorf("plan not found: %w", err)
+		if _, ok := err.(*repositories.NotFoundError); ok {
+			return nil, &NotFoundError{Message: "plan not found"}
+		}
+		return nil, fmt.Errorf("failed to update plan: %w", err)
 	}
+	return plan, nil
+}
 
-	// Instead of deleting, deactivate the plan
-	plan.IsActive = false
-	return s.planRepo.UpdatePlan(ctx, plan)
+func (s *planService) DeletePlan(ctx context.Context, id uuid.UUID) error {
+	// In production, you would check if there are active subscriptions
+	// before deleting. For now, we'll just deactivate.
+	_, err := s.SetPlanActive(ctx, id, false)
+	return err
 }
 
+// GetPlansByCurrency returns the active plans priced in currency, matched
+// case-insensitively
 func (s *planService) GetPlansByCurrency(ctx context.Context, currency string) ([]models.Plan, error) {
 	allPlans, err := s.planRepo.GetAllPlans(ctx, true)
 	if err != nil {
@@ -117,7 +278,7 @@ func (s *planService) GetPlansByCurrency(ctx context.Context, currency string) (
 
 	var filteredPlans []models.Plan
 	for _, plan := range allPlans {
-		if plan.Currency == currency {
+		if strings.EqualFold(plan.Currency, currency) {
 			filteredPlans = append(filteredPlans, plan)
 		}
 	}
@@ -134,3 +295,39 @@ func isValidInterval(interval string) bool {
 	}
 	return validIntervals[interval]
 }
+
+const (
+	minStatementDescriptorLength = 5
+	maxStatementDescriptorLength = 22
+)
+
+// statementDescriptorPattern is the character set card networks accept on
+// statements: letters, digits, spaces and a few punctuation marks
+var statementDescriptorPattern = regexp.MustCompile(`^[A-Za-z0-9 .,*&#/-]+$`)
+var statementDescriptorLetter = regexp.MustCompile(`[A-Za-z]`)
+
+// normalizeStatementDescriptor trims the plan's statement descriptor and checks
+// it can be printed on a card statement. An empty descriptor is allowed and
+// means charges use the merchant default.
+func normalizeStatementDescriptor(plan *models.Plan) error {
+	plan.StatementDescriptor = strings.TrimSpace(plan.StatementDescriptor)
+	descriptor := plan.StatementDescriptor
+	if descriptor == "" {
+		return nil
+	}
+
+	if len(descriptor) < minStatementDescriptorLength || len(descriptor) > maxStatementDescriptorLength {
+		return &ValidationError{Field: "statement_descriptor", Message: fmt.Sprintf(
+			"statement descriptor must be between %d and %d characters",
+			minStatementDescriptorLength, maxStatementDescriptorLength,
+		)}
+	}
+	if !statementDescriptorPattern.MatchString(descriptor) {
+		return &ValidationError{Field: "statement_descriptor", Message: "statement descriptor may only contain letters, digits, spaces and . , * & # / -"}
+	}
+	if !statementDescriptorLetter.MatchString(descriptor) {
+		return &ValidationError{Field: "statement_descriptor", Message: "statement descriptor must contain at least one letter"}
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"pg-backend/internal/config"
+	"pg-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// racingPlanRepo applies edit to the stored plan right after each read, as a
+// second admin saving between the service's read and its update would
+type racingPlanRepo struct {
+	*fakePlanRepo
+	edit func(plan *models.Plan)
+}
+
+func (r *racingPlanRepo) GetPlanByID(ctx context.Context, id uuid.UUID) (*models.Plan, error) {
+	plan, err := r.fakePlanRepo.GetPlanByID(ctx, id)
+	if err == nil && r.edit != nil {
+		stored := *r.plans[id]
+		r.edit(&stored)
+		if err := r.fakePlanRepo.UpdatePlan(ctx, &stored); err != nil {
+			return nil, err
+		}
+	}
+	return plan, err
+}
+
+func TestUpdatePlanConcurrentEdit(t *testing.T) {
+	cfg := &config.Config{}
+	version := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+	newPlan := func() *models.Plan {
+		return &models.Plan{ID: uuid.New(), Name: "Basic", Amount: 10, Currency: "USD", Interval: "month", IsActive: true, UpdatedAt: version}
+	}
+
+	t.Run("second admin saves a stale copy", func(t *testing.T) {
+		stored := newPlan()
+		repo := newFakePlanRepo(stored)
+		service := NewPlanService(repo, NewAmountLimits(cfg), NewSupportedCurrencies(cfg))
+
+		// Both admins read the same version
+		first, second := *stored, *stored
+		first.Amount = 12
+		second.Name = "Basic Plus"
+
+		if err := service.UpdatePlan(context.Background(), &first); err != nil {
+			t.Fatalf("first UpdatePlan: %v", err)
+		}
+		if !first.UpdatedAt.After(version) {
+			t.Errorf("updated_at = %v, want it moved past %v", first.UpdatedAt, version)
+		}
+
+		err := service.UpdatePlan(context.Background(), &second)
+		if _, ok := err.(*ConflictError); !ok {
+			t.Fatalf("second UpdatePlan error = %v, want a ConflictError", err)
+		}
+		if saved := repo.plans[stored.ID]; saved.Amount != 12 || saved.Name != "Basic" {
+			t.Errorf("saved plan = %s at %v, want the first admin's edit kept", saved.Name, saved.Amount)
+		}
+
+		// Reloading and retrying succeeds
+		reloaded := *repo.plans[stored.ID]
+		reloaded.Name = "Basic Plus"
+		if err := service.UpdatePlan(context.Background(), &reloaded); err != nil {
+			t.Errorf("UpdatePlan after a reload: %v", err)
+		}
+	})
+
+	t.Run("edit lands between the read and the update", func(t *testing.T) {
+		stored := newPlan()
+		repo := &racingPlanRepo{fakePlanRepo: newFakePlanRepo(stored)}
+		repo.edit = func(plan *models.Plan) { plan.Amount = 15 }
+		service := NewPlanService(repo, NewAmountLimits(cfg), NewSupportedCurrencies(cfg))
+
+		edit := *stored
+		edit.Name = "Basic Plus"
+		err := service.UpdatePlan(context.Background(), &edit)
+		if _, ok := err.(*ConflictError); !ok {
+			t.Fatalf("UpdatePlan error = %v, want a ConflictError", err)
+		}
+		if saved := repo.plans[stored.ID]; saved.Amount != 15 || saved.Name != "Basic" {
+			t.Errorf("saved plan = %s at %v, want the concurrent edit kept", saved.Name, saved.Amount)
+		}
+	})
+
+	t.Run("unversioned update overwrites", func(t *testing.T) {
+		stored := newPlan()
+		repo := newFakePlanRepo(stored)
+		service := NewPlanService(repo, NewAmountLimits(cfg), NewSupportedCurrencies(cfg))
+
+		edit := *stored
+		edit.UpdatedAt = time.Time{}
+		edit.Amount = 20
+		if err := service.UpdatePlan(context.Background(), &edit); err != nil {
+			t.Fatalf("UpdatePlan: %v", err)
+		}
+		if repo.plans[stored.ID].Amount != 20 {
+			t.Errorf("amount = %v, want 20", repo.plans[stored.ID].Amount)
+		}
+	})
+}
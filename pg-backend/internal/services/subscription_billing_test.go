@@ -0,0 +1,370 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"pg-backend/internal/config"
+	"pg-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// billingFixture is a subscription due for billing, with the service and
+// fakes needed to charge it through the mock gateway
+type billingFixture struct {
+	service       *subscriptionService
+	subscriptions *fakeSubscriptionRepo
+	billing       *fakeBillingRepo
+	subscription  *models.Subscription
+}
+
+// newBillingFixture returns a monthly subscription for amount, due now. The
+// mock gateway picks the outcome from the amount (see MockAmountDeclined).
+func newBillingFixture(t *testing.T, amount float64) *billingFixture {
+	t.Helper()
+
+	userID := uuid.New()
+	card := &models.Card{ID: uuid.New(), UserID: userID, GatewayToken: "9000000000000001", LastFour: "0001"}
+	subscription := &models.Subscription{
+		ID:            uuid.New(),
+		UserID:        userID,
+		CardID:        uuid.NullUUID{UUID: card.ID, Valid: true},
+		Amount:        amount,
+		Currency:      "USD",
+		Status:        models.SubscriptionStatusActive,
+		Interval:      "month",
+		NextBillingAt: time.Now().Add(-time.Minute),
+	}
+
+	cfg := &config.Config{}
+	fixture := &billingFixture{
+		subscriptions: newFakeSubscriptionRepo(subscription),
+		billing:       newFakeBillingRepo(),
+		subscription:  subscription,
+	}
+	fixture.service = NewSubscriptionService(
+		fixture.subscriptions,
+		newFakePlanRepo(),
+		newFakeCardRepo(card),
+		fixture.billing,
+		&fakeTransactionRepo{},
+		&fakeCreditRepo{},
+		NewMockMastercardService(cfg),
+		&fakeEventService{},
+		cfg,
+	).(*subscriptionService)
+
+	return fixture
+}
+
+// processDue runs one ProcessDueSubscriptions pass, ignoring charge failures
+func (f *billingFixture) processDue(t *testing.T) {
+	t.Helper()
+	if _, err := f.service.ProcessDueSubscriptions(context.Background(), 10); err != nil {
+		t.Fatalf("ProcessDueSubscriptions: %v", err)
+	}
+}
+
+func TestFailedChargeIsBilledOnlyByRetry(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount float64
+	}{
+		{"gateway error without a decline code", 10.00 + MockAmountGatewayError/100.0},
+		{"retryable decline", 10.00 + MockAmountDeclined/100.0},
+	}
+
+	// Only insufficient funds is final, so card_declined gets a retry
+	t.Setenv("NON_RETRYABLE_DECLINE_CODES", DeclineCodeInsufficientFunds)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newBillingFixture(t, tt.amount)
+			f.processDue(t)
+
+			attempts := f.billing.forSubscription(f.subscription.ID)
+			if len(attempts) != 1 || attempts[0].Status != models.BillingAttemptStatusFailed {
+				t.Fatalf("want one failed attempt, got %+v", attempts)
+			}
+			if status := f.subscriptions.get(f.subscription.ID).Status; status != models.SubscriptionStatusPastDue {
+				t.Fatalf("subscription status = %s, want past_due", status)
+			}
+
+			retried, err := f.service.RetryFailedBilling(context.Background(), []time.Duration{0, 0, 0})
+			if err != nil {
+				t.Fatalf("RetryFailedBilling: %v", err)
+			}
+			if retried != 1 {
+				t.Fatalf("RetryFailedBilling scheduled %d retries, want 1", retried)
+			}
+
+			// The due-subscription pass must not charge the period again
+			f.processDue(t)
+
+			attempts = f.billing.forSubscription(f.subscription.ID)
+			if len(attempts) != 2 {
+				t.Fatalf("want 2 attempts (failure and one retry), got %d", len(attempts))
+			}
+			if attempts[1].AttemptNumber != 2 || attempts[1].Status != models.BillingAttemptStatusPending {
+				t.Errorf("second attempt = #%d %s, want #2 pending", attempts[1].AttemptNumber, attempts[1].Status)
+			}
+		})
+	}
+}
+
+func TestInsufficientFundsIsNeverRetried(t *testing.T) {
+	f := newBillingFixture(t, 10.00+MockAmountInsufficientFunds/100.0)
+	f.processDue(t)
+
+	attempts := f.billing.forSubscription(f.subscription.ID)
+	if len(attempts) != 1 {
+		t.Fatalf("want one attempt, got %d", len(attempts))
+	}
+	if code := attempts[0].ErrorCode.String; code != DeclineCodeInsufficientFunds {
+		t.Fatalf("error code = %q, want %q", code, DeclineCodeInsufficientFunds)
+	}
+
+	retried, err := f.service.RetryFailedBilling(context.Background(), []time.Duration{0, 0, 0})
+	if err != nil {
+		t.Fatalf("RetryFailedBilling: %v", err)
+	}
+	if retried != 0 || len(f.billing.forSubscription(f.subscription.ID)) != 1 {
+		t.Errorf("insufficient funds was retried")
+	}
+}
+
+// doerFunc lets a test stand in for the gateway's HTTP client
+type doerFunc func(req *http.Request) (*http.Response, error)
+
+func (f doerFunc) Do(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestOpenBreakerLeavesAttemptPending(t *testing.T) {
+	t.Setenv("GATEWAY_BREAKER_THRESHOLD", "1")
+
+	f := newBillingFixture(t, 10.00)
+	breaker := NewGatewayBreaker(f.service.cfg)
+	breaker.record(errors.New("connection refused"))
+	f.service.mastercardService = NewMastercardServiceWithClient(f.service.cfg, &BasicAuthenticator{}, breaker, doerFunc(func(req *http.Request) (*http.Response, error) {
+		t.Errorf("request sent to %s while the breaker is open", req.URL)
+		return nil, errors.New("unexpected request")
+	}))
+
+	dueAt := f.subscription.NextBillingAt
+	orderID := subscriptionOrderID(f.subscription, 1)
+
+	_, err := f.service.ProcessDueSubscriptions(context.Background(), 10)
+	if !errors.Is(err, ErrGatewayUnavailable) {
+		t.Fatalf("ProcessDueSubscriptions error = %v, want ErrGatewayUnavailable", err)
+	}
+
+	attempts := f.billing.forSubscription(f.subscription.ID)
+	if len(attempts) != 1 {
+		t.Fatalf("want one attempt, got %d", len(attempts))
+	}
+	if attempts[0].Status != models.BillingAttemptStatusPending {
+		t.Errorf("attempt status = %s, want pending", attempts[0].Status)
+	}
+	if attempts[0].GatewayOrderID.String != orderID {
+		t.Errorf("attempt order = %q, want %q", attempts[0].GatewayOrderID.String, orderID)
+	}
+
+	subscription := f.subscriptions.get(f.subscription.ID)
+	if subscription.Status != models.SubscriptionStatusActive {
+		t.Errorf("subscription status = %s, want active", subscription.Status)
+	}
+	if !subscription.NextBillingAt.After(dueAt) {
+		t.Errorf("next billing at %v was not advanced past %v", subscription.NextBillingAt, dueAt)
+	}
+
+	// Neither the retry pass nor the next due pass may add an attempt
+	retried, err := f.service.RetryFailedBilling(context.Background(), []time.Duration{0, 0, 0})
+	if err != nil {
+		t.Fatalf("RetryFailedBilling: %v", err)
+	}
+	if retried != 0 {
+		t.Errorf("RetryFailedBilling scheduled %d retries, want 0", retried)
+	}
+	f.processDue(t)
+	if n := len(f.billing.forSubscription(f.subscription.ID)); n != 1 {
+		t.Errorf("want one attempt after another pass, got %d", n)
+	}
+}
+
+func TestCustomRetrySchedule(t *testing.T) {
+	t.Setenv("BILLING_RETRY_SCHEDULE", "0h,24h,72h,168h,336h")
+	schedule := (&config.Config{}).BillingRetrySchedule()
+	want := []time.Duration{0, 24 * time.Hour, 72 * time.Hour, 168 * time.Hour, 336 * time.Hour}
+	if len(schedule) != len(want) {
+		t.Fatalf("schedule = %v, want %v", schedule, want)
+	}
+	for i := range want {
+		if schedule[i] != want[i] {
+			t.Fatalf("schedule = %v, want %v", schedule, want)
+		}
+	}
+
+	f := newBillingFixture(t, 10.00+MockAmountGatewayError/100.0)
+	f.processDue(t)
+
+	for number := 2; number <= len(schedule); number++ {
+		retried, err := f.service.RetryFailedBilling(context.Background(), schedule)
+		if err != nil {
+			t.Fatalf("RetryFailedBilling: %v", err)
+		}
+		if retried != 1 {
+			t.Fatalf("RetryFailedBilling scheduled %d retries before attempt #%d, want 1", retried, number)
+		}
+
+		attempts := f.billing.forSubscription(f.subscription.ID)
+		failed, retry := attempts[len(attempts)-2], attempts[len(attempts)-1]
+		if retry.AttemptNumber != number {
+			t.Fatalf("retry is attempt #%d, want #%d", retry.AttemptNumber, number)
+		}
+		if wantAt := failed.ProcessedAt.Time.Add(schedule[number-1]); !retry.ScheduledAt.Equal(wantAt) {
+			t.Errorf("attempt #%d scheduled %v after the failure, want %v",
+				number, retry.ScheduledAt.Sub(failed.ProcessedAt.Time), schedule[number-1])
+		}
+
+		// Fail the retry as the gateway would
+		retry.Status = models.BillingAttemptStatusFailed
+		retry.ProcessedAt = sql.NullTime{Time: time.Now().Add(-time.Millisecond), Valid: true}
+		if err := f.billing.UpdateBillingAttempt(context.Background(), &retry); err != nil {
+			t.Fatalf("UpdateBillingAttempt: %v", err)
+		}
+	}
+
+	// The schedule allows five attempts in all
+	retried, err := f.service.RetryFailedBilling(context.Background(), schedule)
+	if err != nil {
+		t.Fatalf("RetryFailedBilling: %v", err)
+	}
+	if retried != 0 {
+		t.Errorf("RetryFailedBilling scheduled %d retries after attempt #%d, want 0", retried, len(schedule))
+	}
+	if n := len(f.billing.forSubscription(f.subscription.ID)); n != len(schedule) {
+		t.Errorf("got %d attempts, want %d", n, len(schedule))
+	}
+}
+
+// approvingGateway approves every recurring charge, as the gateway does once
+// the customer has fixed the card
+type approvingGateway struct {
+	MastercardService
+}
+
+func (approvingGateway) PayWithTokenRecurring(ctx context.Context, token, orderID, amount, currency, agreementID, initialTraceID, descriptor string) (*PaymentResponse, error) {
+	resp := &PaymentResponse{Result: "SUCCESS", GatewayCode: "APPROVED"}
+	resp.Order.ID = orderID
+	resp.Order.Amount = GatewayAmount(amount)
+	resp.Order.Currency = currency
+	resp.Transaction.ID = "1"
+	resp.Transaction.Status = "CAPTURED"
+	return resp, nil
+}
+
+func TestSuccessfulRetryReactivatesSubscription(t *testing.T) {
+	f := newBillingFixture(t, 10.00+MockAmountGatewayError/100.0)
+	dueAt := f.subscription.NextBillingAt
+	f.processDue(t)
+
+	if status := f.subscriptions.get(f.subscription.ID).Status; status != models.SubscriptionStatusPastDue {
+		t.Fatalf("subscription status = %s, want past_due after the failed charge", status)
+	}
+	if retried, err := f.service.RetryFailedBilling(context.Background(), []time.Duration{0, 0, 0}); err != nil || retried != 1 {
+		t.Fatalf("RetryFailedBilling = %d, %v, want one retry", retried, err)
+	}
+
+	events := &fakeEventService{}
+	billing := NewBillingService(f.service.transactionRepo, f.billing, f.service.cardRepo, f.subscriptions,
+		f.service.planRepo, nil, f.service.creditRepo, approvingGateway{}, events, f.service.cfg)
+	if _, err := billing.ProcessPendingBillingAttempts(context.Background(), 10); err != nil {
+		t.Fatalf("ProcessPendingBillingAttempts: %v", err)
+	}
+
+	attempts := f.billing.forSubscription(f.subscription.ID)
+	if last := attempts[len(attempts)-1]; last.AttemptNumber != 2 || last.Status != models.BillingAttemptStatusSucceeded {
+		t.Fatalf("retry = #%d %s, want #2 succeeded", last.AttemptNumber, last.Status)
+	}
+
+	subscription := f.subscriptions.get(f.subscription.ID)
+	if subscription.Status != models.SubscriptionStatusActive {
+		t.Errorf("subscription status = %s, want active", subscription.Status)
+	}
+	if want := calculateNextBillingDate(dueAt, "month", sql.NullTime{Time: dueAt, Valid: true}); !subscription.NextBillingAt.Equal(want) {
+		t.Errorf("next billing at %v, want the following period at %v", subscription.NextBillingAt, want)
+	}
+	if !subscription.CurrentPeriodStart.Time.Equal(dueAt) {
+		t.Errorf("current period starts %v, want %v", subscription.CurrentPeriodStart.Time, dueAt)
+	}
+	if len(events.events) != 1 || events.events[0] != models.EventSubscriptionStatusChanged {
+		t.Errorf("events = %v, want one status change", events.events)
+	}
+
+	// The next due pass bills the following period, not this one again
+	f.processDue(t)
+	if n := len(f.billing.forSubscription(f.subscription.ID)); n != 2 {
+		t.Errorf("got %d attempts after another due pass, want 2", n)
+	}
+}
+
+// mismatchingGateway replies to every request with an approved order for
+// another amount than the one charged
+func mismatchingGateway(cfg *config.Config) MastercardService {
+	return NewMastercardServiceWithClient(cfg, &BasicAuthenticator{}, nil, doerFunc(func(req *http.Request) (*http.Response, error) {
+		body := `{"result":"SUCCESS","gatewayCode":"APPROVED","order":{"id":"order-1","amount":"999.00","currency":"USD"},"transaction":{"id":"1","status":"CAPTURED"}}`
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: http.Header{}}, nil
+	}))
+}
+
+func TestAmountMismatchIsHeldForReview(t *testing.T) {
+	f := newBillingFixture(t, 10.00)
+	f.service.mastercardService = mismatchingGateway(f.service.cfg)
+	dueAt := f.subscription.NextBillingAt
+
+	f.processDue(t)
+
+	attempts := f.billing.forSubscription(f.subscription.ID)
+	if len(attempts) != 1 {
+		t.Fatalf("want one attempt, got %d", len(attempts))
+	}
+	attempt := attempts[0]
+	if attempt.Status != models.BillingAttemptStatusRequiresAction || attempt.ErrorCode.String != DeclineCodeAmountMismatch {
+		t.Errorf("attempt = %s (%s), want requires_action (%s)", attempt.Status, attempt.ErrorCode.String, DeclineCodeAmountMismatch)
+	}
+	if attempt.GatewayOrderID.String != "order-1" {
+		t.Errorf("attempt order = %q, want order-1", attempt.GatewayOrderID.String)
+	}
+
+	subscription := f.subscriptions.get(f.subscription.ID)
+	if subscription.Status != models.SubscriptionStatusActive {
+		t.Errorf("subscription status = %s, want active", subscription.Status)
+	}
+	if !subscription.NextBillingAt.After(dueAt) {
+		t.Errorf("next billing at %v was not advanced past %v", subscription.NextBillingAt, dueAt)
+	}
+
+	// Neither a retry nor reconciliation may charge it again
+	retried, err := f.service.RetryFailedBilling(context.Background(), []time.Duration{0, 0, 0})
+	if err != nil {
+		t.Fatalf("RetryFailedBilling: %v", err)
+	}
+	if retried != 0 {
+		t.Errorf("RetryFailedBilling scheduled %d retries, want 0", retried)
+	}
+
+	billing := NewBillingService(f.service.transactionRepo, f.billing, f.service.cardRepo, f.subscriptions,
+		f.service.planRepo, nil, f.service.creditRepo, approvingGateway{}, &fakeEventService{}, f.service.cfg).(*billingService)
+	if err := billing.reconcileBillingAttempt(context.Background(), &attempt); err == nil {
+		t.Error("reconcileBillingAttempt completed an attempt held for review")
+	}
+	if status := f.billing.forSubscription(f.subscription.ID)[0].Status; status != models.BillingAttemptStatusRequiresAction {
+		t.Errorf("attempt status = %s after reconciliation, want requires_action", status)
+	}
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"pg-backend/internal/config"
+	"pg-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// importFixture is a user with a tokenized card and an active monthly plan
+type importFixture struct {
+	service       *subscriptionService
+	subscriptions *fakeSubscriptionRepo
+	userID        uuid.UUID
+	card          *models.Card
+	walletCard    *models.Card
+	plan          *models.Plan
+	otherPlan     *models.Plan
+}
+
+func newImportFixture(t *testing.T) *importFixture {
+	t.Helper()
+
+	userID := uuid.New()
+	f := &importFixture{
+		subscriptions: newFakeSubscriptionRepo(),
+		userID:        userID,
+		card:          &models.Card{ID: uuid.New(), UserID: userID, GatewayToken: "9000000000000001"},
+		walletCard:    &models.Card{ID: uuid.New(), UserID: userID, PaymentMethodType: models.PaymentMethodTypeApplePay},
+		plan:          &models.Plan{ID: uuid.New(), Name: "Basic", Amount: 10, Currency: "USD", Interval: "month", IsActive: true},
+		otherPlan:     &models.Plan{ID: uuid.New(), Name: "Pro", Amount: 20, Currency: "USD", Interval: "month", IsActive: true},
+	}
+	cfg := &config.Config{}
+	f.service = NewSubscriptionService(
+		f.subscriptions,
+		newFakePlanRepo(f.plan, f.otherPlan),
+		newFakeCardRepo(f.card, f.walletCard),
+		newFakeBillingRepo(),
+		&fakeTransactionRepo{},
+		&fakeCreditRepo{},
+		NewMockMastercardService(cfg),
+		&fakeEventService{},
+		cfg,
+	).(*subscriptionService)
+	return f
+}
+
+// row returns an active import row for a monthly period of plan. The period
+// starts mid-month so that adding months never lands past a month's end.
+func (f *importFixture) row(plan *models.Plan) SubscriptionImportRow {
+	start := time.Date(2024, time.May, 15, 9, 0, 0, 0, time.UTC)
+	return SubscriptionImportRow{
+		UserID:             f.userID,
+		PlanID:             plan.ID,
+		CardID:             f.card.ID,
+		Status:             models.SubscriptionStatusActive,
+		CurrentPeriodStart: start,
+		CurrentPeriodEnd:   start.AddDate(0, 1, 0),
+		NextBillingAt:      start.AddDate(0, 1, 0),
+	}
+}
+
+func TestImportSubscriptionsAppliesCreateChecks(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		existing bool // the user already has an active subscription
+		rows     func(f *importFixture) []SubscriptionImportRow
+	}{
+		{
+			name: "unsupported currency",
+			env:  map[string]string{"SUPPORTED_CURRENCIES": "LKR"},
+			rows: func(f *importFixture) []SubscriptionImportRow {
+				return []SubscriptionImportRow{f.row(f.plan)}
+			},
+		},
+		{
+			name: "card that can't be charged on a schedule",
+			rows: func(f *importFixture) []SubscriptionImportRow {
+				row := f.row(f.plan)
+				row.CardID = f.walletCard.ID
+				return []SubscriptionImportRow{row}
+			},
+		},
+		{
+			name:     "user already at the subscription cap",
+			env:      map[string]string{"MAX_ACTIVE_SUBSCRIPTIONS_PER_USER": "1"},
+			existing: true,
+			rows: func(f *importFixture) []SubscriptionImportRow {
+				return []SubscriptionImportRow{f.row(f.plan)}
+			},
+		},
+		{
+			name: "batch that takes the user past the cap",
+			env:  map[string]string{"MAX_ACTIVE_SUBSCRIPTIONS_PER_USER": "1"},
+			rows: func(f *importFixture) []SubscriptionImportRow {
+				return []SubscriptionImportRow{f.row(f.plan), f.row(f.otherPlan)}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			f := newImportFixture(t)
+			if tt.existing {
+				f.subscriptions.CreateSubscription(context.Background(), &models.Subscription{
+					UserID: f.userID,
+					Status: models.SubscriptionStatusActive,
+				})
+			}
+
+			rows := tt.rows(f)
+			results, err := f.service.ImportSubscriptions(context.Background(), rows)
+			if _, ok := err.(*ValidationError); !ok {
+				t.Fatalf("error = %v, want a ValidationError", err)
+			}
+			if got := results[len(rows)-1]; got.Status != "failed" || got.Error == "" {
+				t.Errorf("last row = %+v, want it failed with a reason", got)
+			}
+			if len(f.subscriptions.imported) != 0 {
+				t.Errorf("imported %d subscriptions, want none", len(f.subscriptions.imported))
+			}
+		})
+	}
+}
+
+func TestImportSubscriptionsInitialCharge(t *testing.T) {
+	tests := []struct {
+		name       string
+		skip       bool
+		wantCharge bool
+		wantMonths int // months next_billing_at moves past the imported value
+	}{
+		{"charged now", false, true, 1},
+		{"charge skipped", true, false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newImportFixture(t)
+			row := f.row(f.plan)
+			row.SkipInitialCharge = tt.skip
+
+			if _, err := f.service.ImportSubscriptions(context.Background(), []SubscriptionImportRow{row}); err != nil {
+				t.Fatalf("ImportSubscriptions: %v", err)
+			}
+			if len(f.subscriptions.imported) != 1 {
+				t.Fatalf("imported %d subscriptions, want 1", len(f.subscriptions.imported))
+			}
+			item := f.subscriptions.imported[0]
+
+			if (item.InitialAttempt != nil) != tt.wantCharge {
+				t.Errorf("initial attempt = %+v, want one: %v", item.InitialAttempt, tt.wantCharge)
+			}
+			want := row.NextBillingAt.AddDate(0, tt.wantMonths, 0)
+			if got := item.Subscription.NextBillingAt; !got.Equal(want) {
+				t.Errorf("next billing at %v, want %v", got, want)
+			}
+			if end := item.Subscription.CurrentPeriodEnd.Time; !end.Equal(item.Subscription.NextBillingAt) {
+				t.Errorf("current period ends %v, want it to match next billing %v", end, item.Subscription.NextBillingAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"testing"
+	"time"
+
+	"pg-backend/internal/config"
+	"pg-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+func TestChangePlanAcrossIntervals(t *testing.T) {
+	day := 24 * time.Hour
+	monthly := &models.Plan{ID: uuid.New(), Name: "Monthly", Amount: 10, Currency: "USD", Interval: "month", IsActive: true}
+	yearly := &models.Plan{ID: uuid.New(), Name: "Yearly", Amount: 120, Currency: "USD", Interval: "year", IsActive: true}
+
+	tests := []struct {
+		name        string
+		from, to    *models.Plan
+		status      models.SubscriptionStatus
+		elapsed     time.Duration // into the current period
+		period      time.Duration
+		wantCredit  float64
+		wantCharged bool
+	}{
+		{
+			name: "month to year", from: monthly, to: yearly,
+			status: models.SubscriptionStatusActive, elapsed: 10 * day, period: 30 * day,
+			wantCredit: 6.67, wantCharged: true,
+		},
+		{
+			name: "year to month", from: yearly, to: monthly,
+			status: models.SubscriptionStatusActive, elapsed: 73 * day, period: 365 * day,
+			wantCredit: 96, wantCharged: true,
+		},
+		{
+			name: "trialing month to year", from: monthly, to: yearly,
+			status: models.SubscriptionStatusTrialing, elapsed: 3 * day, period: 14 * day,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now().Add(-tt.elapsed)
+			subscription := &models.Subscription{
+				ID:                 uuid.New(),
+				UserID:             uuid.New(),
+				PlanID:             uuid.NullUUID{UUID: tt.from.ID, Valid: true},
+				PlanName:           tt.from.Name,
+				Amount:             tt.from.Amount,
+				Currency:           tt.from.Currency,
+				Status:             tt.status,
+				Interval:           models.SubscriptionInterval(tt.from.Interval),
+				CurrentPeriodStart: sql.NullTime{Time: start, Valid: true},
+				CurrentPeriodEnd:   sql.NullTime{Time: start.Add(tt.period), Valid: true},
+				NextBillingAt:      start.Add(tt.period),
+			}
+
+			subscriptions := newFakeSubscriptionRepo(subscription)
+			billing := newFakeBillingRepo()
+			credits := &fakeCreditRepo{}
+			cfg := &config.Config{}
+			service := NewSubscriptionService(subscriptions, newFakePlanRepo(monthly, yearly), newFakeCardRepo(),
+				billing, &fakeTransactionRepo{}, credits, NewMockMastercardService(cfg), &fakeEventService{}, cfg,
+			).(*subscriptionService)
+
+			before := time.Now()
+			changed, err := service.ChangePlan(context.Background(), subscription.ID, tt.to.ID)
+			after := time.Now()
+			if err != nil {
+				t.Fatalf("ChangePlan: %v", err)
+			}
+
+			if changed.Amount != tt.to.Amount || string(changed.Interval) != tt.to.Interval || changed.PlanName != tt.to.Name {
+				t.Errorf("subscription is %v %s on %q, want %v %s on %q",
+					changed.Amount, changed.Interval, changed.PlanName, tt.to.Amount, tt.to.Interval, tt.to.Name)
+			}
+
+			attempts := billing.forSubscription(subscription.ID)
+			if !tt.wantCharged {
+				if len(attempts) != 0 || len(credits.entries) != 0 {
+					t.Errorf("trial plan change charged %d attempts and %d credits, want none", len(attempts), len(credits.entries))
+				}
+				if !changed.NextBillingAt.Equal(subscription.NextBillingAt) {
+					t.Errorf("trial end moved from %v to %v", subscription.NextBillingAt, changed.NextBillingAt)
+				}
+				return
+			}
+
+			// A fresh period on the new plan's interval starts now
+			earliest := calculateNextBillingDate(before, tt.to.Interval, sql.NullTime{})
+			latest := calculateNextBillingDate(after, tt.to.Interval, sql.NullTime{})
+			if changed.NextBillingAt.Before(earliest) || changed.NextBillingAt.After(latest) {
+				t.Errorf("next billing at %v, want one %s from now (%v)", changed.NextBillingAt, tt.to.Interval, earliest)
+			}
+			if !changed.CurrentPeriodEnd.Time.Equal(changed.NextBillingAt) {
+				t.Errorf("current period ends %v, want %v", changed.CurrentPeriodEnd.Time, changed.NextBillingAt)
+			}
+
+			if len(attempts) != 1 || attempts[0].Amount != tt.to.Amount || attempts[0].Status != models.BillingAttemptStatusPending {
+				t.Errorf("attempts = %+v, want one pending charge of %v", attempts, tt.to.Amount)
+			}
+			if len(credits.entries) != 1 {
+				t.Fatalf("got %d credit entries, want 1", len(credits.entries))
+			}
+			if credit := credits.entries[0].Amount; math.Abs(credit-tt.wantCredit) > 0.01 {
+				t.Errorf("credit for unused time = %v, want %v", credit, tt.wantCredit)
+			}
+		})
+	}
+}
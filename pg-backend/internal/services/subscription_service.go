@@ -2,23 +2,38 @@ package services
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"math"
+	"pg-backend/internal/config"
 	"pg-backend/internal/models"
 	"pg-backend/internal/repositories"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 type SubscriptionService interface {
-	CreateSubscription(ctx context.Context, userID, planID, cardID uuid.UUID, metadata map[string]string) (*models.Subscription, error)
+	CreateSubscription(ctx context.Context, userID, planID uuid.UUID, cardID uuid.NullUUID, metadata map[string]string, billingCycleAnchor sql.NullTime) (*models.Subscription, error)
+	ImportSubscriptions(ctx context.Context, rows []SubscriptionImportRow) ([]SubscriptionImportResult, error)
 	GetSubscription(ctx context.Context, subscriptionID uuid.UUID) (*models.Subscription, error)
+	GetExpandedSubscription(ctx context.Context, subscriptionID uuid.UUID, expandPlan, expandCard bool) (*models.ExpandedSubscription, error)
 	GetUserSubscriptions(ctx context.Context, userID uuid.UUID, status string) ([]models.Subscription, error)
-	CancelSubscription(ctx context.Context, subscriptionID uuid.UUID, cancelAtPeriodEnd bool) error
+	GetSubscriptionStats(ctx context.Context) (*models.SubscriptionStats, error)
+	PreviewUpcomingBilling(ctx context.Context, lookAhead time.Duration, limit int) (*models.UpcomingBillingPreview, error)
+	CancelSubscription(ctx context.Context, subscriptionID uuid.UUID, cancelAtPeriodEnd bool, reason models.CancellationReason, comment string) error
 	UpdateSubscriptionCard(ctx context.Context, subscriptionID, cardID uuid.UUID) error
+	UpdateMetadata(ctx context.Context, subscriptionID uuid.UUID, changes map[string]*string) (*models.Subscription, error)
+	UpdateCardForUser(ctx context.Context, userID, cardID uuid.UUID) (int, error)
+	ChangePlan(ctx context.Context, subscriptionID, planID uuid.UUID) (*models.Subscription, error)
 	ProcessDueSubscriptions(ctx context.Context, limit int) (int, error)
-	RetryFailedBilling(ctx context.Context, maxAttempts int) (int, error)
+	ExpireIncompleteSubscriptions(ctx context.Context) (int, error)
+	SendRenewalReminders(ctx context.Context, limit int) (int, error)
+	RetryFailedBilling(ctx context.Context, schedule []time.Duration) (int, error)
 }
 
 type subscriptionService struct {
@@ -27,7 +42,10 @@ type subscriptionService struct {
 	cardRepo          repositories.CardRepository
 	billingRepo       repositories.BillingRepository
 	transactionRepo   repositories.TransactionRepository
+	creditRepo        repositories.CreditRepository
 	mastercardService MastercardService
+	eventService      EventService
+	cfg               *config.Config
 }
 
 func NewSubscriptionService(
@@ -36,7 +54,10 @@ func NewSubscriptionService(
 	cardRepo repositories.CardRepository,
 	billingRepo repositories.BillingRepository,
 	transactionRepo repositories.TransactionRepository,
+	creditRepo repositories.CreditRepository,
 	mastercardService MastercardService,
+	eventService EventService,
+	cfg *config.Config,
 ) SubscriptionService {
 	return &subscriptionService{
 		subscriptionRepo:  subscriptionRepo,
@@ -44,11 +65,26 @@ func NewSubscriptionService(
 		cardRepo:          cardRepo,
 		billingRepo:       billingRepo,
 		transactionRepo:   transactionRepo,
+		creditRepo:        creditRepo,
 		mastercardService: mastercardService,
+		eventService:      eventService,
+		cfg:               cfg,
 	}
 }
 
-func (s *subscriptionService) CreateSubscription(ctx context.Context, userID, planID, cardID uuid.UUID, metadata map[string]string) (*models.Subscription, error) {
+// CreateSubscription subscribes a user to a plan. A billing cycle anchor
+// (e.g. the 1st of next month) makes the first full period start then; the
+// time until the anchor is prorated or free depending on
+// config.AnchoredInitialCharge. A trial, when the user gets one, takes
+// precedence over the anchor.
+func (s *subscriptionService) CreateSubscription(ctx context.Context, userID, planID uuid.UUID, cardID uuid.NullUUID, metadata map[string]string, billingCycleAnchor sql.NullTime) (*models.Subscription, error) {
+	if err := validateMetadata(metadata); err != nil {
+		return nil, err
+	}
+	if billingCycleAnchor.Valid && !billingCycleAnchor.Time.After(time.Now()) {
+		return nil, &ValidationError{Field: "billing_cycle_anchor", Message: "billing cycle anchor must be in the future"}
+	}
+
 	// 1. Validate plan exists and is active
 	plan, err := s.planRepo.GetPlanByID(ctx, planID)
 	if err != nil {
@@ -57,14 +93,23 @@ func (s *subscriptionService) CreateSubscription(ctx context.Context, userID, pl
 	if !plan.IsActive {
 		return nil, fmt.Errorf("plan is not active")
 	}
-
-	// 2. Validate card belongs to user
-	card, err := s.cardRepo.GetCardByID(ctx, cardID)
-	if err != nil {
-		return nil, fmt.Errorf("invalid card: %w", err)
+	if err := NewSupportedCurrencies(s.cfg).Check("plan_id", plan.Currency); err != nil {
+		return nil, err
 	}
-	if card.UserID != userID {
-		return nil, fmt.Errorf("card does not belong to user")
+
+	// 2. Validate card belongs to user. A trial may start without a card, to
+	// be collected before the trial ends.
+	if cardID.Valid {
+		card, err := s.cardRepo.GetCardByID(ctx, cardID.UUID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid card: %w", err)
+		}
+		if card.UserID != userID {
+			return nil, fmt.Errorf("card does not belong to user")
+		}
+		if err := checkRecurringCard(card); err != nil {
+			return nil, err
+		}
 	}
 
 	// 3. Check if user already has active subscription for this plan
@@ -77,12 +122,32 @@ func (s *subscriptionService) CreateSubscription(ctx context.Context, userID, pl
 		}
 	}
 
-	// 4. Calculate dates
+	// 4. Enforce the per-user cap on live subscriptions
+	if limit := s.cfg.MaxActiveSubscriptionsPerUser(); limit > 0 {
+		count, err := s.subscriptionRepo.CountActiveSubscriptionsByUserID(ctx, userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count subscriptions: %w", err)
+		}
+		if count >= limit {
+			return nil, &ConflictError{Message: fmt.Sprintf("user already has the maximum of %d active subscriptions", limit)}
+		}
+	}
+
+	// 5. Only give the plan's trial to users who haven't had one already
+	trialDays, err := s.trialPeriodDays(ctx, userID, plan)
+	if err != nil {
+		return nil, err
+	}
+	if trialDays == 0 && !cardID.Valid {
+		return nil, &ValidationError{Field: "card_id", Message: "card_id is required unless the subscription starts with a trial"}
+	}
+
+	// 6. Calculate dates
 	now := time.Now()
 	subscription := &models.Subscription{
 		UserID:    userID,
 		PlanID:    uuid.NullUUID{UUID: planID, Valid: true},
-		CardID:    uuid.NullUUID{UUID: cardID, Valid: true},
+		CardID:    cardID,
 		PlanName:  plan.Name,
 		Amount:    plan.Amount,
 		Currency:  plan.Currency,
@@ -92,30 +157,57 @@ func (s *subscriptionService) CreateSubscription(ctx context.Context, userID, pl
 		CreatedAt: now,
 	}
 
-	// 5. Handle trial period
-	if plan.TrialPeriodDays > 0 {
+	if billingCycleAnchor.Valid && billingCycleAnchor.Time.After(calculateNextBillingDate(now, plan.Interval, sql.NullTime{})) {
+		return nil, &ValidationError{Field: "billing_cycle_anchor", Message: "billing cycle anchor must be within one billing interval"}
+	}
+
+	// 7. Handle trial period
+	initialCharge := plan.Amount
+	if trialDays > 0 {
+		initialCharge = 0
 		subscription.Status = models.SubscriptionStatusTrialing
 		subscription.TrialStart = sql.NullTime{Time: now, Valid: true}
-		subscription.TrialEnd = sql.NullTime{Time: now.AddDate(0, 0, plan.TrialPeriodDays), Valid: true}
+		subscription.TrialEnd = sql.NullTime{Time: now.AddDate(0, 0, trialDays), Valid: true}
+		subscription.BillingCycleAnchor = subscription.TrialEnd
 		subscription.NextBillingAt = subscription.TrialEnd.Time
 	} else {
-		// No trial - set first billing cycle
+		// No trial - incomplete until the first charge succeeds
+		subscription.Status = models.SubscriptionStatusIncomplete
 		subscription.CurrentPeriodStart = sql.NullTime{Time: now, Valid: true}
 		subscription.BillingCycleAnchor = sql.NullTime{Time: now, Valid: true}
-		subscription.NextBillingAt = s.calculateNextBillingDate(now, plan.Interval)
+		subscription.NextBillingAt = calculateNextBillingDate(now, plan.Interval, sql.NullTime{})
 		subscription.CurrentPeriodEnd = sql.NullTime{Time: subscription.NextBillingAt, Valid: true}
 	}
 
-	// 6. Create subscription in database
+	// With an anchor, the first period is cut short to end at the anchor
+	if trialDays == 0 && billingCycleAnchor.Valid {
+		fullPeriod := subscription.NextBillingAt.Sub(now)
+		subscription.BillingCycleAnchor = billingCycleAnchor
+		subscription.NextBillingAt = billingCycleAnchor.Time
+		subscription.CurrentPeriodEnd = billingCycleAnchor
+
+		initialCharge = 0
+		if s.cfg.AnchoredInitialCharge() == config.AnchoredChargeProrate {
+			initialCharge = roundAmount(plan.Amount * billingCycleAnchor.Time.Sub(now).Seconds() / fullPeriod.Seconds())
+		}
+		if initialCharge <= 0 {
+			// Nothing to collect before the anchor
+			subscription.Status = models.SubscriptionStatusActive
+		}
+	}
+
+	// 8. Create subscription in database
 	if err := s.subscriptionRepo.CreateSubscription(ctx, subscription); err != nil {
 		return nil, fmt.Errorf("failed to create subscription: %w", err)
 	}
 
-	// 7. If no trial, create first billing attempt immediately
-	if plan.TrialPeriodDays == 0 {
+	publishSubscriptionStatusChange(ctx, s.eventService, subscription, "")
+
+	// 9. Charge for the first period straight away unless it is free
+	if initialCharge > 0 {
 		billingAttempt := &models.BillingAttempt{
 			SubscriptionID: subscription.ID,
-			Amount:         plan.Amount,
+			Amount:         initialCharge,
 			Currency:       plan.Currency,
 			Status:         models.BillingAttemptStatusPending,
 			AttemptNumber:  1,
@@ -130,16 +222,461 @@ func (s *subscriptionService) CreateSubscription(ctx context.Context, userID, pl
 	return subscription, nil
 }
 
+// trialPeriodDays returns the trial a new subscription to plan gets. Users
+// who already trialed or paid - for this plan, or any plan under the per-user
+// policy - get no trial and are charged straight away.
+func (s *subscriptionService) trialPeriodDays(ctx context.Context, userID uuid.UUID, plan *models.Plan) (int, error) {
+	if plan.TrialPeriodDays <= 0 {
+		return 0, nil
+	}
+
+	planID := uuid.NullUUID{UUID: plan.ID, Valid: true}
+	if s.cfg.TrialEligibility() == config.TrialPerUser {
+		planID = uuid.NullUUID{}
+	}
+
+	hadTrial, err := s.subscriptionRepo.HasHadTrialOrPaidPeriod(ctx, userID, planID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check trial eligibility: %w", err)
+	}
+	if hadTrial {
+		return 0, nil
+	}
+
+	return plan.TrialPeriodDays, nil
+}
+
+// SubscriptionImportRow describes one subscription migrated from another processor
+type SubscriptionImportRow struct {
+	UserID             uuid.UUID
+	PlanID             uuid.UUID
+	CardID             uuid.UUID
+	Status             models.SubscriptionStatus
+	CurrentPeriodStart time.Time
+	CurrentPeriodEnd   time.Time
+	NextBillingAt      time.Time
+	TrialEnd           sql.NullTime
+	Metadata           map[string]string
+	SkipInitialCharge  bool // otherwise the period due at NextBillingAt is charged now
+}
+
+// SubscriptionImportResult reports the outcome of a single import row
+type SubscriptionImportResult struct {
+	Index          int        `json:"index"`
+	Status         string     `json:"status"` // "imported", "failed" or "not_imported"
+	SubscriptionID *uuid.UUID `json:"subscription_id,omitempty"`
+	Error          string     `json:"error,omitempty"`
+}
+
+// ImportSubscriptions validates every row before inserting anything. If any
+// row is invalid, nothing is imported and the per-row results explain why.
+func (s *subscriptionService) ImportSubscriptions(ctx context.Context, rows []SubscriptionImportRow) ([]SubscriptionImportResult, error) {
+	results := make([]SubscriptionImportResult, len(rows))
+	imports := make([]repositories.SubscriptionImport, 0, len(rows))
+	seen := make(map[string]int)
+	perUser := make(map[uuid.UUID]int) // rows accepted so far for each user
+	failed := false
+
+	for i, row := range rows {
+		results[i] = SubscriptionImportResult{Index: i, Status: "not_imported"}
+
+		subscription, err := s.buildImportedSubscription(ctx, row)
+		if err == nil {
+			// Reject the same user/plan pair appearing twice in one batch
+			key := row.UserID.String() + ":" + row.PlanID.String()
+			if first, ok := seen[key]; ok {
+				err = fmt.Errorf("duplicate of row %d", first)
+			} else {
+				seen[key] = i
+			}
+		}
+		if err == nil {
+			err = s.checkImportLimit(ctx, row.UserID, perUser[row.UserID])
+		}
+		if err != nil {
+			results[i].Status = "failed"
+			results[i].Error = err.Error()
+			failed = true
+			continue
+		}
+
+		perUser[row.UserID]++
+
+		item := repositories.SubscriptionImport{Subscription: subscription}
+		if !row.SkipInitialCharge {
+			// The charge queued now pays for the period due at next_billing_at,
+			// so the subscription moves on a period and isn't billed for it again
+			advanceBillingPeriod(subscription)
+			item.InitialAttempt = &models.BillingAttempt{
+				Amount:        subscription.Amount,
+				Currency:      subscription.Currency,
+				Status:        models.BillingAttemptStatusPending,
+				AttemptNumber: 1,
+				ScheduledAt:   time.Now(),
+			}
+		}
+		imports = append(imports, item)
+	}
+
+	if failed {
+		return results, &ValidationError{Message: "one or more rows failed validation; nothing was imported"}
+	}
+
+	if err := s.subscriptionRepo.ImportSubscriptions(ctx, imports); err != nil {
+		return nil, fmt.Errorf("failed to import subscriptions: %w", err)
+	}
+
+	for i := range imports {
+		id := imports[i].Subscription.ID
+		results[i].Status = "imported"
+		results[i].SubscriptionID = &id
+	}
+
+	return results, nil
+}
+
+// buildImportedSubscription validates an import row against the plan and card
+// and returns the subscription exactly as it existed at the previous processor
+func (s *subscriptionService) buildImportedSubscription(ctx context.Context, row SubscriptionImportRow) (*models.Subscription, error) {
+	if err := validateMetadata(row.Metadata); err != nil {
+		return nil, err
+	}
+
+	plan, err := s.planRepo.GetPlanByID(ctx, row.PlanID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid plan: %w", err)
+	}
+	if !plan.IsActive {
+		return nil, fmt.Errorf("plan is not active")
+	}
+	if err := NewSupportedCurrencies(s.cfg).Check("plan_id", plan.Currency); err != nil {
+		return nil, err
+	}
+
+	card, err := s.cardRepo.GetCardByID(ctx, row.CardID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid card: %w", err)
+	}
+	if card.UserID != row.UserID {
+		return nil, fmt.Errorf("card does not belong to user")
+	}
+	if err := checkRecurringCard(card); err != nil {
+		return nil, err
+	}
+
+	status := row.Status
+	if status == "" {
+		status = models.SubscriptionStatusActive
+	}
+	switch status {
+	case models.SubscriptionStatusActive, models.SubscriptionStatusPastDue:
+	case models.SubscriptionStatusTrialing:
+		if !row.TrialEnd.Valid {
+			return nil, fmt.Errorf("trial_end is required for trialing subscriptions")
+		}
+	default:
+		return nil, fmt.Errorf("status must be one of: active, trialing, past_due")
+	}
+
+	if !row.CurrentPeriodEnd.After(row.CurrentPeriodStart) {
+		return nil, fmt.Errorf("current_period_end must be after current_period_start")
+	}
+	if row.NextBillingAt.Before(row.CurrentPeriodStart) {
+		return nil, fmt.Errorf("next_billing_at must not be before current_period_start")
+	}
+
+	existingSubs, err := s.subscriptionRepo.GetSubscriptionsByUserID(ctx, row.UserID, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to check existing subscriptions: %w", err)
+	}
+	for _, sub := range existingSubs {
+		if sub.PlanID.UUID == row.PlanID &&
+			(sub.Status == models.SubscriptionStatusActive || sub.Status == models.SubscriptionStatusTrialing) {
+			return nil, fmt.Errorf("user already has active subscription for this plan")
+		}
+	}
+
+	subscription := &models.Subscription{
+		UserID:             row.UserID,
+		PlanID:             uuid.NullUUID{UUID: row.PlanID, Valid: true},
+		CardID:             uuid.NullUUID{UUID: row.CardID, Valid: true},
+		PlanName:           plan.Name,
+		Amount:             plan.Amount,
+		Currency:           plan.Currency,
+		Status:             status,
+		Interval:           models.SubscriptionInterval(plan.Interval),
+		CurrentPeriodStart: sql.NullTime{Time: row.CurrentPeriodStart, Valid: true},
+		CurrentPeriodEnd:   sql.NullTime{Time: row.CurrentPeriodEnd, Valid: true},
+		TrialEnd:           row.TrialEnd,
+		Metadata:           row.Metadata,
+		BillingCycleAnchor: sql.NullTime{Time: row.CurrentPeriodStart, Valid: true},
+		NextBillingAt:      row.NextBillingAt,
+	}
+	if status == models.SubscriptionStatusTrialing {
+		subscription.TrialStart = sql.NullTime{Time: row.CurrentPeriodStart, Valid: true}
+	}
+
+	return subscription, nil
+}
+
+// checkImportLimit applies the per-user subscription cap to an import row,
+// counting the rows for the same user accepted earlier in the batch
+func (s *subscriptionService) checkImportLimit(ctx context.Context, userID uuid.UUID, pending int) error {
+	limit := s.cfg.MaxActiveSubscriptionsPerUser()
+	if limit <= 0 {
+		return nil
+	}
+
+	count, err := s.subscriptionRepo.CountActiveSubscriptionsByUserID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to count subscriptions: %w", err)
+	}
+	if count+pending >= limit {
+		return &ConflictError{Message: fmt.Sprintf("user already has the maximum of %d active subscriptions", limit)}
+	}
+	return nil
+}
+
 func (s *subscriptionService) GetSubscription(ctx context.Context, subscriptionID uuid.UUID) (*models.Subscription, error) {
-	return s.subscriptionRepo.GetSubscriptionByID(ctx, subscriptionID)
+	subscription, err := s.subscriptionRepo.GetSubscriptionByID(ctx, subscriptionID)
+	if _, ok := err.(*repositories.NotFoundError); ok {
+		return nil, &NotFoundError{Message: "subscription not found"}
+	}
+	return subscription, err
+}
+
+// GetExpandedSubscription loads a subscription together with its plan and/or
+// card. A plan or card that has since been deleted is left out rather than
+// failing the lookup.
+func (s *subscriptionService) GetExpandedSubscription(ctx context.Context, subscriptionID uuid.UUID, expandPlan, expandCard bool) (*models.ExpandedSubscription, error) {
+	subscription, err := s.GetSubscription(ctx, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	expanded := &models.ExpandedSubscription{Subscription: *subscription}
+
+	if expandPlan && subscription.PlanID.Valid {
+		plan, err := s.planRepo.GetPlanByID(ctx, subscription.PlanID.UUID)
+		if err == nil {
+			expanded.Plan = plan
+		} else if _, ok := err.(*repositories.NotFoundError); !ok {
+			return nil, fmt.Errorf("failed to load plan: %w", err)
+		}
+	}
+
+	if expandCard && subscription.CardID.Valid {
+		card, err := s.cardRepo.GetCardByID(ctx, subscription.CardID.UUID)
+		if err == nil {
+			expanded.Card = models.NewCardSummary(card)
+		} else if _, ok := err.(*repositories.NotFoundError); !ok {
+			return nil, fmt.Errorf("failed to load card: %w", err)
+		}
+	}
+
+	return expanded, nil
 }
 
 func (s *subscriptionService) GetUserSubscriptions(ctx context.Context, userID uuid.UUID, status string) ([]models.Subscription, error) {
 	return s.subscriptionRepo.GetSubscriptionsByUserID(ctx, userID, status)
 }
 
-func (s *subscriptionService) CancelSubscription(ctx context.Context, subscriptionID uuid.UUID, cancelAtPeriodEnd bool) error {
-	return s.subscriptionRepo.CancelSubscription(ctx, subscriptionID, cancelAtPeriodEnd)
+// GetSubscriptionStats counts subscriptions by status for the merchant
+// dashboard. The main statuses are always present, with zero if unused.
+func (s *subscriptionService) GetSubscriptionStats(ctx context.Context) (*models.SubscriptionStats, error) {
+	counts, err := s.subscriptionRepo.CountByStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, status := range []models.SubscriptionStatus{
+		models.SubscriptionStatusActive,
+		models.SubscriptionStatusTrialing,
+		models.SubscriptionStatusPastDue,
+		models.SubscriptionStatusCanceled,
+	} {
+		if _, ok := counts[status]; !ok {
+			counts[status] = 0
+		}
+	}
+
+	stats := &models.SubscriptionStats{Counts: counts}
+	for _, count := range counts {
+		stats.Total += count
+	}
+	return stats, nil
+}
+
+// PreviewUpcomingBilling forecasts the charges the billing worker would make
+// for subscriptions falling due within lookAhead, without charging anything.
+// Each user's account credit is set against their charges in due order, as
+// it would be when they are billed.
+func (s *subscriptionService) PreviewUpcomingBilling(ctx context.Context, lookAhead time.Duration, limit int) (*models.UpcomingBillingPreview, error) {
+	until := time.Now().Add(lookAhead)
+	subscriptions, err := s.subscriptionRepo.GetSubscriptionsDueForBilling(ctx, until, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get due subscriptions: %w", err)
+	}
+
+	preview := &models.UpcomingBillingPreview{
+		Until:            until,
+		Charges:          make([]models.UpcomingCharge, 0, len(subscriptions)),
+		TotalsByCurrency: make(map[string]float64),
+	}
+
+	// Remaining credit per user and currency
+	credits := make(map[uuid.UUID]map[string]float64)
+	for _, subscription := range subscriptions {
+		balances, ok := credits[subscription.UserID]
+		if !ok {
+			userBalances, err := s.creditRepo.GetBalances(ctx, subscription.UserID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get credit balance: %w", err)
+			}
+			balances = make(map[string]float64, len(userBalances))
+			for _, balance := range userBalances {
+				balances[balance.Currency] = balance.Balance
+			}
+			credits[subscription.UserID] = balances
+		}
+
+		credit := roundAmount(math.Max(math.Min(balances[subscription.Currency], subscription.Amount), 0))
+		balances[subscription.Currency] = roundAmount(balances[subscription.Currency] - credit)
+
+		charge := models.UpcomingCharge{
+			SubscriptionID:  subscription.ID,
+			UserID:          subscription.UserID,
+			PlanName:        subscription.PlanName,
+			Amount:          subscription.Amount,
+			Credit:          credit,
+			ProjectedAmount: roundAmount(subscription.Amount - credit),
+			Currency:        subscription.Currency,
+			NextBillingAt:   subscription.NextBillingAt,
+		}
+		preview.Charges = append(preview.Charges, charge)
+		preview.TotalsByCurrency[charge.Currency] = roundAmount(preview.TotalsByCurrency[charge.Currency] + charge.ProjectedAmount)
+	}
+
+	return preview, nil
+}
+
+// CancelSubscription cancels now or at period end, recording the customer's
+// reason for leaving. A comment is required when the reason is "other".
+func (s *subscriptionService) CancelSubscription(ctx context.Context, subscriptionID uuid.UUID, cancelAtPeriodEnd bool, reason models.CancellationReason, comment string) error {
+	if reason == models.CancellationReasonOther && strings.TrimSpace(comment) == "" {
+		return &ValidationError{Message: "comment is required when reason is other"}
+	}
+
+	err := s.subscriptionRepo.CancelSubscription(ctx, subscriptionID, cancelAtPeriodEnd, reason, strings.TrimSpace(comment))
+	if _, ok := err.(*repositories.NotFoundError); ok {
+		return &NotFoundError{Message: "subscription not found"}
+	}
+	return err
+}
+
+// ChangePlan moves a subscription to another plan, which may bill on a
+// different interval. A trialing subscription keeps its trial and bills the
+// new plan when the trial ends. An active subscription is credited for the
+// unused part of its current period, starts a new period at the new plan's
+// interval today and is charged for it straight away; the credit is spent on
+// that charge.
+func (s *subscriptionService) ChangePlan(ctx context.Context, subscriptionID, planID uuid.UUID) (*models.Subscription, error) {
+	subscription, err := s.subscriptionRepo.GetSubscriptionByID(ctx, subscriptionID)
+	if err != nil {
+		if _, ok := err.(*repositories.NotFoundError); ok {
+			return nil, &NotFoundError{Message: "subscription not found"}
+		}
+		return nil, err
+	}
+
+	plan, err := s.planRepo.GetPlanByID(ctx, planID)
+	if err != nil {
+		if _, ok := err.(*repositories.NotFoundError); ok {
+			return nil, &NotFoundError{Message: "plan not found"}
+		}
+		return nil, err
+	}
+
+	switch {
+	case subscription.Status != models.SubscriptionStatusActive && subscription.Status != models.SubscriptionStatusTrialing:
+		return nil, &ConflictError{Message: fmt.Sprintf("cannot change the plan of a %s subscription", subscription.Status)}
+	case subscription.PlanID.Valid && subscription.PlanID.UUID == plan.ID:
+		return nil, &ValidationError{Field: "plan_id", Message: "subscription is already on this plan"}
+	case !plan.IsActive:
+		return nil, &ValidationError{Field: "plan_id", Message: "plan is not active"}
+	case plan.Currency != subscription.Currency:
+		return nil, &ValidationError{Field: "plan_id", Message: "plan currency must match the subscription currency"}
+	}
+
+	now := time.Now()
+	var credit float64
+	if subscription.Status == models.SubscriptionStatusActive {
+		if subscription.CurrentPeriodStart.Valid && subscription.CurrentPeriodEnd.Valid {
+			credit = unusedPeriodCredit(subscription.Amount, subscription.CurrentPeriodStart.Time, subscription.CurrentPeriodEnd.Time, now)
+		}
+
+		// Start a fresh period on the new plan's interval
+		subscription.CurrentPeriodStart = sql.NullTime{Time: now, Valid: true}
+		subscription.BillingCycleAnchor = sql.NullTime{Time: now, Valid: true}
+		subscription.NextBillingAt = calculateNextBillingDate(now, plan.Interval, sql.NullTime{})
+		subscription.CurrentPeriodEnd = sql.NullTime{Time: subscription.NextBillingAt, Valid: true}
+	}
+
+	subscription.PlanID = uuid.NullUUID{UUID: plan.ID, Valid: true}
+	subscription.PlanName = plan.Name
+	subscription.Amount = plan.Amount
+	subscription.Interval = models.SubscriptionInterval(plan.Interval)
+
+	if err := s.subscriptionRepo.UpdateSubscription(ctx, subscription); err != nil {
+		return nil, fmt.Errorf("failed to update subscription: %w", err)
+	}
+
+	if subscription.Status != models.SubscriptionStatusActive {
+		return subscription, nil
+	}
+
+	if credit > 0 {
+		entry := &models.CreditEntry{
+			UserID:         subscription.UserID,
+			Amount:         credit,
+			Currency:       subscription.Currency,
+			Type:           models.CreditEntryTypeCredit,
+			Description:    "Unused time on previous plan",
+			SubscriptionID: uuid.NullUUID{UUID: subscription.ID, Valid: true},
+		}
+		if err := s.creditRepo.CreateCreditEntry(ctx, entry); err != nil {
+			return nil, fmt.Errorf("failed to credit unused time: %w", err)
+		}
+	}
+
+	billingAttempt := &models.BillingAttempt{
+		SubscriptionID: subscription.ID,
+		Amount:         plan.Amount,
+		Currency:       plan.Currency,
+		Status:         models.BillingAttemptStatusPending,
+		AttemptNumber:  1,
+		ScheduledAt:    now,
+	}
+	if err := s.billingRepo.CreateBillingAttempt(ctx, billingAttempt); err != nil {
+		return nil, fmt.Errorf("failed to schedule charge for new plan: %w", err)
+	}
+
+	return subscription, nil
+}
+
+// unusedPeriodCredit is the share of amount covering the time left between now
+// and periodEnd, e.g. 11 unused months of a yearly plan
+func unusedPeriodCredit(amount float64, periodStart, periodEnd, now time.Time) float64 {
+	period := periodEnd.Sub(periodStart)
+	if period <= 0 || !now.Before(periodEnd) {
+		return 0
+	}
+	if now.Before(periodStart) {
+		return roundAmount(amount)
+	}
+
+	unused := periodEnd.Sub(now)
+	return roundAmount(amount * float64(unused) / float64(period))
 }
 
 func (s *subscriptionService) UpdateSubscriptionCard(ctx context.Context, subscriptionID, cardID uuid.UUID) error {
@@ -157,23 +694,94 @@ func (s *subscriptionService) UpdateSubscriptionCard(ctx context.Context, subscr
 	if card.UserID != subscription.UserID {
 		return fmt.Errorf("card does not belong to user")
 	}
+	if err := checkRecurringCard(card); err != nil {
+		return err
+	}
 
 	// 3. Update subscription with new card
 	subscription.CardID = uuid.NullUUID{UUID: cardID, Valid: true}
 	return s.subscriptionRepo.UpdateSubscription(ctx, subscription)
 }
 
+// UpdateMetadata merges changes into the subscription's metadata: keys with a
+// value are set and keys with a nil value are removed. The merged metadata
+// must stay within the usual metadata limits.
+func (s *subscriptionService) UpdateMetadata(ctx context.Context, subscriptionID uuid.UUID, changes map[string]*string) (*models.Subscription, error) {
+	subscription, err := s.subscriptionRepo.GetSubscriptionByID(ctx, subscriptionID)
+	if err != nil {
+		if _, ok := err.(*repositories.NotFoundError); ok {
+			return nil, &NotFoundError{Message: "subscription not found"}
+		}
+		return nil, err
+	}
+
+	metadata := make(map[string]string, len(subscription.Metadata)+len(changes))
+	for key, value := range subscription.Metadata {
+		metadata[key] = value
+	}
+	for key, value := range changes {
+		if value == nil {
+			delete(metadata, key)
+			continue
+		}
+		metadata[key] = *value
+	}
+
+	if err := validateMetadata(metadata); err != nil {
+		return nil, err
+	}
+
+	subscription.Metadata = metadata
+	if err := s.subscriptionRepo.UpdateSubscription(ctx, subscription); err != nil {
+		return nil, fmt.Errorf("failed to update subscription: %w", err)
+	}
+
+	return subscription, nil
+}
+
+// UpdateCardForUser switches every active and past-due subscription of the
+// user to a new card, for when the user's card has been replaced
+func (s *subscriptionService) UpdateCardForUser(ctx context.Context, userID, cardID uuid.UUID) (int, error) {
+	card, err := s.cardRepo.GetCardByID(ctx, cardID)
+	if err != nil {
+		if _, ok := err.(*repositories.NotFoundError); ok {
+			return 0, &NotFoundError{Message: "card not found"}
+		}
+		return 0, fmt.Errorf("invalid card: %w", err)
+	}
+	if card.UserID != userID {
+		return 0, fmt.Errorf("card does not belong to user")
+	}
+	if err := checkRecurringCard(card); err != nil {
+		return 0, err
+	}
+
+	updated, err := s.subscriptionRepo.UpdateCardForUser(ctx, userID, cardID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update subscriptions: %w", err)
+	}
+
+	return updated, nil
+}
+
 func (s *subscriptionService) ProcessDueSubscriptions(ctx context.Context, limit int) (int, error) {
 	// Get subscriptions due for billing
-	cutoffTime := time.Now().Add(5 * time.Minute) // Process items due in next 5 minutes
-	subscriptions, err := s.subscriptionRepo.GetSubscriptionsDueForBilling(ctx, cutoffTime)
+	cutoffTime := time.Now().Add(s.cfg.BillingLookAhead()) // Include items falling due shortly
+	subscriptions, err := s.subscriptionRepo.GetSubscriptionsDueForBilling(ctx, cutoffTime, limit)
 	if err != nil {
 		return 0, fmt.Errorf("failed to get due subscriptions: %w", err)
 	}
 
 	processedCount := 0
 	for _, subscription := range subscriptions {
-		if err := s.processSingleSubscription(ctx, &subscription); err != nil {
+		itemCtx, cancel := context.WithTimeout(ctx, s.cfg.BillingItemTimeout())
+		err := s.processSingleSubscription(itemCtx, &subscription)
+		cancel()
+		if errors.Is(err, ErrGatewayUnavailable) {
+			// The rest would fail the same way; pick them up next cycle
+			return processedCount, err
+		}
+		if err != nil {
 			fmt.Printf("Failed to process subscription %s: %v\n", subscription.ID, err)
 			continue
 		}
@@ -188,6 +796,10 @@ func (s *subscriptionService) ProcessDueSubscriptions(ctx context.Context, limit
 }
 
 func (s *subscriptionService) processSingleSubscription(ctx context.Context, subscription *models.Subscription) error {
+	if !subscription.CardID.Valid {
+		return s.expireTrialWithoutCard(ctx, subscription)
+	}
+
 	// 1. Create billing attempt
 	billingAttempt := &models.BillingAttempt{
 		SubscriptionID: subscription.ID,
@@ -197,7 +809,9 @@ func (s *subscriptionService) processSingleSubscription(ctx context.Context, sub
 		AttemptNumber:  1,
 		ScheduledAt:    time.Now(),
 		ProcessedAt:    sql.NullTime{Time: time.Now(), Valid: true},
+		GatewayOrderID: sql.NullString{String: subscriptionOrderID(subscription, 1), Valid: true},
 	}
+	billingAttempt.ProcessingStartedAt = billingAttempt.ProcessedAt
 
 	if err := s.billingRepo.CreateBillingAttempt(ctx, billingAttempt); err != nil {
 		return fmt.Errorf("failed to create billing attempt: %w", err)
@@ -209,81 +823,270 @@ func (s *subscriptionService) processSingleSubscription(ctx context.Context, sub
 		billingAttempt.Status = models.BillingAttemptStatusFailed
 		billingAttempt.ErrorMessage = sql.NullString{String: "Card not found", Valid: true}
 		s.billingRepo.UpdateBillingAttempt(ctx, billingAttempt)
+		s.markPastDue(ctx, subscription)
 		return fmt.Errorf("card not found: %w", err)
 	}
 
-	// 3. Process payment via Mastercard
-	amountStr := fmt.Sprintf("%.2f", subscription.Amount)
-	paymentResp, err := s.mastercardService.PayWithToken(
+	// 3. Apply account credit before charging the card
+	credit, chargeAmount, err := applyAccountCredit(ctx, s.creditRepo, subscription.UserID, billingAttempt)
+	if err != nil {
+		billingAttempt.Status = models.BillingAttemptStatusFailed
+		billingAttempt.ErrorMessage = sql.NullString{String: err.Error(), Valid: true}
+		s.billingRepo.UpdateBillingAttempt(ctx, billingAttempt)
+		s.markPastDue(ctx, subscription)
+		return err
+	}
+	if chargeAmount <= 0 {
+		// Fully covered by credit; nothing to send to the gateway
+		return s.completeSubscriptionCharge(ctx, subscription, billingAttempt, nil, credit)
+	}
+
+	// 4. Process payment via Mastercard
+	amountStr := fmt.Sprintf("%.2f", chargeAmount)
+	paymentResp, err := s.mastercardService.PayWithTokenRecurring(
+		ctx,
 		card.GatewayToken,
+		billingAttempt.GatewayOrderID.String,
 		amountStr,
 		subscription.Currency,
+		subscription.ID.String(),
+		initialTraceID(subscription, card),
+		statementDescriptor(ctx, s.planRepo, s.cfg, subscription),
 	)
 	if err != nil {
+		var mismatch *AmountMismatchError
+		if errors.As(err, &mismatch) {
+			// The gateway charged a different amount. Hold the attempt for
+			// review and move the subscription on, as for an unknown outcome,
+			// so the period is not charged again.
+			bgCtx := context.WithoutCancel(ctx)
+			markAmountMismatch(bgCtx, s.billingRepo, billingAttempt, mismatch)
+
+			advanceBillingPeriod(subscription)
+			if err := s.subscriptionRepo.UpdateSubscription(bgCtx, subscription); err != nil {
+				fmt.Printf("Warning: Failed to advance subscription %s: %v\n", subscription.ID, err)
+			}
+			return fmt.Errorf("payment held for review: %w", err)
+		}
+		var ambiguous *AmbiguousPaymentError
+		if errors.As(err, &ambiguous) {
+			// The charge may still complete at the gateway. Park the attempt for
+			// reconciliation and move the subscription on, so the next cycle
+			// does not charge this period a second time.
+			bgCtx := context.WithoutCancel(ctx)
+			billingAttempt.Status = models.BillingAttemptStatusRequiresAction
+			billingAttempt.GatewayOrderID = sql.NullString{String: ambiguous.OrderID, Valid: true}
+			billingAttempt.ErrorMessage = sql.NullString{String: "payment outcome unknown; awaiting reconciliation", Valid: true}
+			s.billingRepo.UpdateBillingAttempt(bgCtx, billingAttempt)
+
+			advanceBillingPeriod(subscription)
+			if err := s.subscriptionRepo.UpdateSubscription(bgCtx, subscription); err != nil {
+				fmt.Printf("Warning: Failed to advance subscription %s: %v\n", subscription.ID, err)
+			}
+			return fmt.Errorf("payment outcome unknown: %w", err)
+		}
+		if errors.Is(err, ErrGatewayUnavailable) {
+			// Nothing reached the gateway. Leave the attempt pending so the
+			// pending-attempt pass charges it under the same order once the
+			// breaker closes, and move the subscription on so this cycle
+			// doesn't create a second attempt for the period.
+			releaseAccountCredit(ctx, s.creditRepo, billingAttempt)
+			billingAttempt.Status = models.BillingAttemptStatusPending
+			s.billingRepo.UpdateBillingAttempt(ctx, billingAttempt)
+
+			advanceBillingPeriod(subscription)
+			if err := s.subscriptionRepo.UpdateSubscription(ctx, subscription); err != nil {
+				fmt.Printf("Warning: Failed to advance subscription %s: %v\n", subscription.ID, err)
+			}
+			return err
+		}
 		billingAttempt.Status = models.BillingAttemptStatusFailed
 		billingAttempt.ErrorMessage = sql.NullString{String: err.Error(), Valid: true}
 		s.billingRepo.UpdateBillingAttempt(ctx, billingAttempt)
+		releaseAccountCredit(ctx, s.creditRepo, billingAttempt)
+		s.markPastDue(ctx, subscription)
 		return fmt.Errorf("payment failed: %w", err)
 	}
 
-	// 4. Check payment result
+	// 5. Check payment result
 	if paymentResp.Result != "SUCCESS" || paymentResp.GatewayCode != "APPROVED" {
-		billingAttempt.Status = models.BillingAttemptStatusFailed
-		billingAttempt.ErrorCode = sql.NullString{String: paymentResp.GatewayCode, Valid: true}
-		billingAttempt.ErrorMessage = sql.NullString{String: paymentResp.Result, Valid: true}
-		s.billingRepo.UpdateBillingAttempt(ctx, billingAttempt)
-
-		// Update subscription status if payment failed
-		if subscription.Status == models.SubscriptionStatusActive {
-			subscription.Status = models.SubscriptionStatusPastDue
-			s.subscriptionRepo.UpdateSubscription(ctx, subscription)
+		releaseAccountCredit(ctx, s.creditRepo, billingAttempt)
+		if requiresAuthentication(paymentResp.GatewayCode) {
+			// Not retried: the attempt waits for the customer to authenticate
+			markRequiresAuthentication(ctx, s.billingRepo, s.eventService, subscription, billingAttempt, paymentResp)
+		} else {
+			billingAttempt.Status = models.BillingAttemptStatusFailed
+			billingAttempt.ErrorCode = sql.NullString{String: normalizeDeclineCode(paymentResp.GatewayCode), Valid: true}
+			billingAttempt.ErrorMessage = sql.NullString{String: declineErrorMessage(paymentResp.Result, paymentResp.GatewayCode), Valid: true}
+			s.billingRepo.UpdateBillingAttempt(ctx, billingAttempt)
 		}
+
+		s.markPastDue(ctx, subscription)
 		return fmt.Errorf("payment declined: %s", paymentResp.GatewayCode)
 	}
 
-	// 5. Payment succeeded - update billing attempt
+	// 6. Payment succeeded
+	recordInitialTraceID(ctx, s.subscriptionRepo, subscription, paymentResp)
+	return s.completeSubscriptionCharge(ctx, subscription, billingAttempt, paymentResp, credit)
+}
+
+// markPastDue moves a subscription whose charge failed to past_due, which
+// takes it out of ProcessDueSubscriptions so that RetryFailedBilling is the
+// only path that charges the period again. A trial is over once its first
+// charge has been tried.
+func (s *subscriptionService) markPastDue(ctx context.Context, subscription *models.Subscription) {
+	switch subscription.Status {
+	case models.SubscriptionStatusActive:
+		subscription.Status = models.SubscriptionStatusPastDue
+		if err := s.subscriptionRepo.UpdateSubscription(ctx, subscription); err != nil {
+			fmt.Printf("Warning: Failed to mark subscription %s past due: %v\n", subscription.ID, err)
+		}
+	case models.SubscriptionStatusTrialing:
+		subscription.Status = models.SubscriptionStatusPastDue
+		if err := s.subscriptionRepo.UpdateSubscription(ctx, subscription); err != nil {
+			fmt.Printf("Warning: Failed to end trial for subscription %s: %v\n", subscription.ID, err)
+		} else {
+			publishTrialEnded(ctx, s.eventService, subscription)
+		}
+	}
+}
+
+// expireTrialWithoutCard ends a trial that was started without a card and
+// never had one attached, so there is nothing to charge. The subscription
+// expires and an event prompts the customer to add a card.
+func (s *subscriptionService) expireTrialWithoutCard(ctx context.Context, subscription *models.Subscription) error {
+	if subscription.Status != models.SubscriptionStatusTrialing {
+		return fmt.Errorf("subscription %s has no card to charge", subscription.ID)
+	}
+
+	subscription.Status = models.SubscriptionStatusIncompleteExpired
+	if err := s.subscriptionRepo.UpdateSubscription(ctx, subscription); err != nil {
+		return fmt.Errorf("failed to expire subscription: %w", err)
+	}
+
+	publishTrialEnded(ctx, s.eventService, subscription)
+	s.eventService.Publish(ctx, models.EventSubscriptionPaymentMethodRequired, "subscription", subscription.ID, map[string]interface{}{
+		"user_id":   subscription.UserID,
+		"trial_end": subscription.TrialEnd.Time,
+	})
+	return nil
+}
+
+// completeSubscriptionCharge records a successful charge, which may have been
+// paid partly or entirely from account credit, and advances the billing period.
+// paymentResp is nil when credit covered the whole amount.
+func (s *subscriptionService) completeSubscriptionCharge(ctx context.Context, subscription *models.Subscription, billingAttempt *models.BillingAttempt, paymentResp *PaymentResponse, credit float64) error {
+	// Update billing attempt
 	billingAttempt.Status = models.BillingAttemptStatusSucceeded
-	billingAttempt.GatewayTransactionID = sql.NullString{String: paymentResp.Transaction.ID, Valid: true}
+	if paymentResp != nil {
+		billingAttempt.GatewayTransactionID = sql.NullString{String: paymentResp.Transaction.ID, Valid: true}
+	}
 	if err := s.billingRepo.UpdateBillingAttempt(ctx, billingAttempt); err != nil {
 		return fmt.Errorf("failed to update billing attempt: %w", err)
 	}
 
-	// 6. Record transaction
-	transaction := &models.Transaction{
-		UserID:               subscription.UserID,
-		CardID:               subscription.CardID.UUID,
-		Amount:               subscription.Amount,
-		Currency:             subscription.Currency,
-		Status:               paymentResp.Transaction.Status,
-		GatewayTransactionID: paymentResp.Transaction.ID,
-		Type:                 "recurring",
-		InvoiceID:            sql.NullString{String: fmt.Sprintf("INV-%d", time.Now().Unix()), Valid: true},
-	}
+	// Record transactions
+	recordCreditTransaction(ctx, s.transactionRepo, subscription, billingAttempt, credit)
+	if paymentResp != nil {
+		transaction := &models.Transaction{
+			UserID:               subscription.UserID,
+			CardID:               subscription.CardID.UUID,
+			Amount:               roundAmount(billingAttempt.Amount - credit),
+			Currency:             subscription.Currency,
+			Status:               paymentResp.Transaction.Status,
+			GatewayTransactionID: paymentResp.Transaction.ID,
+			GatewayOrderID:       paymentResp.Order.ID,
+			GatewayResponse:      paymentResp.Raw,
+			CorrelationID:        paymentResp.CorrelationID,
+			GatewayCorrelationID: paymentResp.GatewayCorrelationID,
+			Type:                 models.TransactionTypeRecurring,
+			InvoiceID:            sql.NullString{String: fmt.Sprintf("INV-%d", time.Now().Unix()), Valid: true},
+		}
 
-	if err := s.transactionRepo.CreateSubscriptionTransaction(
-		ctx, transaction, subscription.ID, billingAttempt.ID,
-	); err != nil {
-		fmt.Printf("Warning: Failed to record transaction: %v\n", err)
+		if err := s.transactionRepo.CreateSubscriptionTransaction(
+			ctx, transaction, subscription.ID, billingAttempt.ID,
+		); err != nil {
+			fmt.Printf("Warning: Failed to record transaction: %v\n", err)
+		}
 	}
 
-	// 7. Update subscription dates for next billing
-	subscription.CurrentPeriodStart = sql.NullTime{Time: subscription.NextBillingAt, Valid: true}
-	subscription.NextBillingAt = s.calculateNextBillingDate(subscription.NextBillingAt, string(subscription.Interval))
-	subscription.CurrentPeriodEnd = sql.NullTime{Time: subscription.NextBillingAt, Valid: true}
+	// Update subscription dates for next billing. The first paid period
+	// starts when the trial ended, not when the worker got to the charge.
+	previousStatus := subscription.Status
+	if previousStatus == models.SubscriptionStatusTrialing && subscription.TrialEnd.Valid {
+		subscription.NextBillingAt = subscription.TrialEnd.Time
+	}
+	advanceBillingPeriod(subscription)
 
-	// If subscription was past_due, set back to active
-	if subscription.Status == models.SubscriptionStatusPastDue {
+	// If subscription was past_due or trialing, it is now active
+	if previousStatus == models.SubscriptionStatusPastDue || previousStatus == models.SubscriptionStatusTrialing {
 		subscription.Status = models.SubscriptionStatusActive
 	}
 
-	return s.subscriptionRepo.UpdateSubscription(ctx, subscription)
+	if err := s.subscriptionRepo.UpdateSubscription(ctx, subscription); err != nil {
+		return err
+	}
+	if previousStatus == models.SubscriptionStatusTrialing {
+		publishTrialEnded(ctx, s.eventService, subscription)
+	}
+	return nil
+}
+
+// ExpireIncompleteSubscriptions expires new subscriptions whose first charge
+// hasn't succeeded within the configured window
+func (s *subscriptionService) ExpireIncompleteSubscriptions(ctx context.Context) (int, error) {
+	createdBefore := time.Now().Add(-s.cfg.IncompleteSubscriptionExpiry())
+	ids, err := s.subscriptionRepo.ExpireIncompleteSubscriptions(ctx, createdBefore)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire incomplete subscriptions: %w", err)
+	}
+
+	for _, id := range ids {
+		s.eventService.Publish(ctx, models.EventSubscriptionStatusChanged, "subscription", id, map[string]interface{}{
+			"from_status": models.SubscriptionStatusIncomplete,
+			"to_status":   models.SubscriptionStatusIncompleteExpired,
+		})
+	}
+
+	return len(ids), nil
+}
+
+// SendRenewalReminders publishes a renewal reminder for up to limit
+// subscriptions on the configured intervals that renew within the configured
+// window, once per renewal
+func (s *subscriptionService) SendRenewalReminders(ctx context.Context, limit int) (int, error) {
+	window := s.cfg.RenewalReminderWindow()
+	intervals := s.cfg.RenewalReminderIntervals()
+	if window <= 0 || len(intervals) == 0 {
+		return 0, nil
+	}
+
+	subscriptions, err := s.subscriptionRepo.ClaimRenewalReminders(ctx, intervals, window, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get subscriptions to remind: %w", err)
+	}
+
+	for _, subscription := range subscriptions {
+		s.eventService.Publish(ctx, models.EventSubscriptionRenewalReminder, "subscription", subscription.ID, map[string]interface{}{
+			"user_id":         subscription.UserID,
+			"plan_name":       subscription.PlanName,
+			"amount":          subscription.Amount,
+			"currency":        subscription.Currency,
+			"interval":        subscription.Interval,
+			"next_billing_at": subscription.NextBillingAt,
+		})
+	}
+
+	return len(subscriptions), nil
 }
 
-// internal/services/subscription_service.go (Update existing method)
-func (s *subscriptionService) RetryFailedBilling(ctx context.Context, maxAttempts int) (int, error) {
-	// Get failed billing attempts older than appropriate times based on attempt number
-	olderThan := time.Now().Add(-24 * time.Hour)
-	attempts, err := s.billingRepo.GetFailedBillingAttemptsForRetry(ctx, maxAttempts, olderThan)
+// RetryFailedBilling schedules the next attempt for failed subscription
+// charges following schedule (see config.BillingRetrySchedule): attempt N+1
+// runs schedule[N] after attempt N failed, and no charge is attempted more
+// than len(schedule) times.
+func (s *subscriptionService) RetryFailedBilling(ctx context.Context, schedule []time.Duration) (int, error) {
+	nonRetryable := normalizeDeclineCodes(s.cfg.NonRetryableDeclineCodes())
+	attempts, err := s.billingRepo.GetFailedBillingAttemptsForRetry(ctx, len(schedule), time.Now(), nonRetryable)
 	if err != nil {
 		return 0, fmt.Errorf("failed to get failed attempts: %w", err)
 	}
@@ -297,24 +1100,21 @@ func (s *subscriptionService) RetryFailedBilling(ctx context.Context, maxAttempt
 			continue
 		}
 
-		// Check if subscription is still active
+		// Check if subscription is still active, or still awaiting its first charge
 		if subscription.Status != models.SubscriptionStatusActive &&
-			subscription.Status != models.SubscriptionStatusPastDue {
+			subscription.Status != models.SubscriptionStatusPastDue &&
+			subscription.Status != models.SubscriptionStatusIncomplete {
 			continue // Don't retry for canceled/inactive subscriptions
 		}
 
-		// Calculate exponential backoff: immediate, 3 days, 7 days
-		var retryDelay time.Duration
-		switch attempt.AttemptNumber {
-		case 1:
-			retryDelay = 0 // Immediate retry
-		case 2:
-			retryDelay = 72 * time.Hour // 3 days
-		case 3:
-			retryDelay = 168 * time.Hour // 7 days
-		default:
+		// The next attempt waits its schedule entry from when this one failed
+		if attempt.AttemptNumber < 1 || attempt.AttemptNumber >= len(schedule) {
 			continue // No more retries
 		}
+		failedAt := time.Now()
+		if attempt.ProcessedAt.Valid {
+			failedAt = attempt.ProcessedAt.Time
+		}
 
 		// Create new billing attempt
 		newAttempt := &models.BillingAttempt{
@@ -323,7 +1123,7 @@ func (s *subscriptionService) RetryFailedBilling(ctx context.Context, maxAttempt
 			Currency:       attempt.Currency,
 			Status:         models.BillingAttemptStatusPending,
 			AttemptNumber:  attempt.AttemptNumber + 1,
-			ScheduledAt:    time.Now().Add(retryDelay),
+			ScheduledAt:    failedAt.Add(schedule[attempt.AttemptNumber]),
 		}
 
 		if err := s.billingRepo.CreateBillingAttempt(ctx, newAttempt); err != nil {
@@ -345,18 +1145,137 @@ func (s *subscriptionService) RetryFailedBilling(ctx context.Context, maxAttempt
 	return retryCount, nil
 }
 
-// Helper function to calculate next billing date
-func (s *subscriptionService) calculateNextBillingDate(from time.Time, interval string) time.Time {
+// advanceBillingPeriod moves the subscription into the period starting at its
+// current NextBillingAt. Billing stays aligned to the billing cycle anchor;
+// subscriptions without one are anchored on the period being started.
+func advanceBillingPeriod(subscription *models.Subscription) {
+	if !subscription.BillingCycleAnchor.Valid {
+		subscription.BillingCycleAnchor = sql.NullTime{Time: subscription.NextBillingAt, Valid: true}
+	}
+	subscription.CurrentPeriodStart = sql.NullTime{Time: subscription.NextBillingAt, Valid: true}
+	subscription.NextBillingAt = calculateNextBillingDate(subscription.NextBillingAt, string(subscription.Interval), subscription.BillingCycleAnchor)
+	subscription.CurrentPeriodEnd = sql.NullTime{Time: subscription.NextBillingAt, Valid: true}
+}
+
+// calculateNextBillingDate returns the billing date one interval after from.
+// Monthly and yearly billing stays on the anchor's day of the month (from's
+// own day when there is no anchor); in months too short for that day it
+// falls on the last day and returns to the anchor day after, e.g. an anchor
+// on the 31st bills Jan 31, Feb 28, Mar 31.
+func calculateNextBillingDate(from time.Time, interval string, anchor sql.NullTime) time.Time {
 	switch interval {
 	case "day":
 		return from.AddDate(0, 0, 1)
 	case "week":
 		return from.AddDate(0, 0, 7)
-	case "month":
-		return from.AddDate(0, 1, 0)
 	case "year":
-		return from.AddDate(1, 0, 0)
-	default:
-		return from.AddDate(0, 1, 0) // Default to monthly
+		return addAnchoredMonths(from, 12, anchor)
+	default: // "month", and monthly for anything else
+		return addAnchoredMonths(from, 1, anchor)
+	}
+}
+
+// addAnchoredMonths moves from forward by months, landing on the anchor's day
+// of the month or the last day of a shorter month. The time of day is kept.
+func addAnchoredMonths(from time.Time, months int, anchor sql.NullTime) time.Time {
+	day := from.Day()
+	if anchor.Valid {
+		day = anchor.Time.In(from.Location()).Day()
+	}
+
+	// Day 0 of the following month is the last day of the target month
+	firstOfTarget := time.Date(from.Year(), from.Month()+time.Month(months), 1, 0, 0, 0, 0, from.Location())
+	if last := firstOfTarget.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+
+	return time.Date(firstOfTarget.Year(), firstOfTarget.Month(), day,
+		from.Hour(), from.Minute(), from.Second(), from.Nanosecond(), from.Location())
+}
+
+// checkRecurringCard rejects cards that can't be charged on a schedule, so a
+// subscription doesn't fail at its first renewal instead of when it is set up
+func checkRecurringCard(card *models.Card) error {
+	if card.SupportsRecurring() {
+		return nil
+	}
+	return &ValidationError{
+		Field:   "card_id",
+		Message: "recurring billing requires a card tokenized at the gateway; simulated wallet cards and Apple Pay cards can't be used for subscriptions",
+	}
+}
+
+// initialTraceID returns the reference that links a recurring charge to the
+// cardholder's original consent: the subscription's first charge if it has
+// one, otherwise the transaction that stored the card
+func initialTraceID(subscription *models.Subscription, card *models.Card) string {
+	if subscription.InitialTraceID != "" {
+		return subscription.InitialTraceID
+	}
+	return card.StoredCredentialReference
+}
+
+// subscriptionOrderID derives the gateway order ID for a subscription charge
+// from the subscription, the billing period and the attempt number. Charging
+// the same attempt again submits the same order, which the gateway won't
+// charge twice; a retry after a decline is a new attempt and a new order.
+func subscriptionOrderID(subscription *models.Subscription, attemptNumber int) string {
+	key := fmt.Sprintf("%s|%d|%d", subscription.ID, subscription.NextBillingAt.Unix(), attemptNumber)
+	sum := sha256.Sum256([]byte(key))
+	return "SUB-" + hex.EncodeToString(sum[:16])
+}
+
+// statementDescriptor returns the text to print on the cardholder's statement
+// for a subscription charge: the plan's descriptor if it sets one, otherwise
+// the merchant default. An empty result leaves the gateway profile's default.
+func statementDescriptor(ctx context.Context, planRepo repositories.PlanRepository, cfg *config.Config, subscription *models.Subscription) string {
+	if subscription.PlanID.Valid {
+		plan, err := planRepo.GetPlanByID(ctx, subscription.PlanID.UUID)
+		if err == nil && plan.StatementDescriptor != "" {
+			return plan.StatementDescriptor
+		}
+	}
+	return cfg.StatementDescriptor()
+}
+
+// publishSubscriptionStatusChange records a subscription moving into its
+// current status from the given one, which is empty for a new subscription
+func publishSubscriptionStatusChange(ctx context.Context, eventService EventService, subscription *models.Subscription, from models.SubscriptionStatus) {
+	data := map[string]interface{}{
+		"to_status": subscription.Status,
+	}
+	if from != "" {
+		data["from_status"] = from
+	}
+	eventService.Publish(ctx, models.EventSubscriptionStatusChanged, "subscription", subscription.ID, data)
+}
+
+// publishTrialEnded records a trialing subscription moving into its current
+// status once the first charge after its trial has been tried
+func publishTrialEnded(ctx context.Context, eventService EventService, subscription *models.Subscription) {
+	publishSubscriptionStatusChange(ctx, eventService, subscription, models.SubscriptionStatusTrialing)
+
+	data := map[string]interface{}{
+		"status":    subscription.Status,
+		"trial_end": subscription.TrialEnd.Time,
+	}
+	if subscription.CurrentPeriodStart.Valid {
+		data["current_period_start"] = subscription.CurrentPeriodStart.Time
+	}
+	eventService.Publish(ctx, models.EventSubscriptionTrialEnded, "subscription", subscription.ID, data)
+}
+
+// recordInitialTraceID stores the scheme trace ID of a subscription's first
+// successful charge so later recurring charges can reference it
+func recordInitialTraceID(ctx context.Context, subscriptionRepo repositories.SubscriptionRepository, subscription *models.Subscription, paymentResp *PaymentResponse) {
+	traceID := paymentResp.AuthorizationResponse.TransactionIdentifier
+	if subscription.InitialTraceID != "" || traceID == "" {
+		return
+	}
+
+	if err := subscriptionRepo.SetInitialTraceID(ctx, subscription.ID, traceID); err != nil {
+		fmt.Printf("Warning: Failed to record initial trace ID for subscription %s: %v\n", subscription.ID, err)
+		return
 	}
+	subscription.InitialTraceID = traceID
 }
@@ -0,0 +1,30 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"pg-backend/internal/config"
+)
+
+// SupportedCurrencies are the currencies charges and plans may use, as
+// settled by our acquirer (see config.SupportedCurrencies). An empty list
+// allows every currency.
+type SupportedCurrencies []string
+
+func NewSupportedCurrencies(cfg *config.Config) SupportedCurrencies {
+	return cfg.SupportedCurrencies()
+}
+
+// Check returns a ValidationError on field when currency isn't supported
+func (c SupportedCurrencies) Check(field, currency string) error {
+	if len(c) == 0 {
+		return nil
+	}
+	for _, supported := range c {
+		if strings.EqualFold(supported, currency) {
+			return nil
+		}
+	}
+	return &ValidationError{Field: field, Message: fmt.Sprintf("currency %s is not supported, use one of %s", strings.ToUpper(currency), strings.Join(c, ", "))}
+}
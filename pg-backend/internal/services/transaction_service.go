@@ -0,0 +1,237 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+
+	"pg-backend/internal/models"
+	"pg-backend/internal/repositories"
+
+	"github.com/google/uuid"
+)
+
+type TransactionService interface {
+	UpdateTransactionStatus(ctx context.Context, transactionID uuid.UUID, status, changedBy, reason string) (*models.Transaction, error)
+	GetGatewayResponse(ctx context.Context, transactionID uuid.UUID) (json.RawMessage, error)
+	GetTimeline(ctx context.Context, transactionID uuid.UUID) ([]models.TimelineEvent, error)
+	GetRefundableTransactions(ctx context.Context, userID uuid.UUID) ([]models.RefundableTransaction, error)
+	ExportTransactions(ctx context.Context, userID uuid.UUID, from, to sql.NullTime, write func([]models.ExportedTransaction) error) error
+	GetPaymentMethodVolume(ctx context.Context, userID uuid.UUID) ([]models.PaymentMethodVolume, error)
+}
+
+// exportBatchSize is how many transactions an export reads from the database
+// at a time
+const exportBatchSize = 500
+
+type transactionService struct {
+	transactionRepo repositories.TransactionRepository
+	disputeRepo     repositories.DisputeRepository
+	eventService    EventService
+}
+
+func NewTransactionService(
+	transactionRepo repositories.TransactionRepository,
+	disputeRepo repositories.DisputeRepository,
+	eventService EventService,
+) TransactionService {
+	return &transactionService{
+		transactionRepo: transactionRepo,
+		disputeRepo:     disputeRepo,
+		eventService:    eventService,
+	}
+}
+
+// UpdateTransactionStatus manually overrides a transaction's status after
+// reconciliation, recording who made the change and why
+func (s *transactionService) UpdateTransactionStatus(ctx context.Context, transactionID uuid.UUID, status, changedBy, reason string) (*models.Transaction, error) {
+	transaction, err := s.transactionRepo.GetTransactionByID(ctx, transactionID)
+	if err != nil {
+		if _, ok := err.(*repositories.NotFoundError); ok {
+			return nil, &NotFoundError{Message: "transaction not found"}
+		}
+		return nil, err
+	}
+
+	if !models.CanTransitionTransactionStatus(transaction.Status, status) {
+		return nil, &ConflictError{
+			Message: fmt.Sprintf("cannot change transaction status from %q to %q", transaction.Status, status),
+		}
+	}
+
+	audit := &models.TransactionStatusAudit{
+		TransactionID: transaction.ID,
+		FromStatus:    transaction.Status,
+		ToStatus:      status,
+		ChangedBy:     changedBy,
+		Reason:        reason,
+	}
+	if err := s.transactionRepo.UpdateStatus(ctx, audit); err != nil {
+		if _, ok := err.(*repositories.ConflictError); ok {
+			return nil, &ConflictError{Message: err.Error()}
+		}
+		return nil, fmt.Errorf("failed to update transaction status: %w", err)
+	}
+
+	transaction.Status = status
+
+	s.eventService.Publish(ctx, models.EventTransactionStatusChanged, "transaction", transaction.ID, map[string]interface{}{
+		"from_status": audit.FromStatus,
+		"to_status":   audit.ToStatus,
+		"changed_by":  audit.ChangedBy,
+		"reason":      audit.Reason,
+		"audit_id":    audit.ID,
+	})
+
+	return transaction, nil
+}
+
+// GetGatewayResponse returns the redacted gateway payload stored with a
+// transaction, for chargeback and dispute investigations
+func (s *transactionService) GetGatewayResponse(ctx context.Context, transactionID uuid.UUID) (json.RawMessage, error) {
+	response, err := s.transactionRepo.GetGatewayResponse(ctx, transactionID)
+	if err != nil {
+		if _, ok := err.(*repositories.NotFoundError); ok {
+			return nil, &NotFoundError{Message: "transaction not found"}
+		}
+		return nil, err
+	}
+	if response == nil {
+		return nil, &NotFoundError{Message: "no gateway response recorded for this transaction"}
+	}
+
+	return response, nil
+}
+
+// GetTimeline assembles the lifecycle of a payment - authorization, captures,
+// voids, refunds and disputes - into a chronological list of events
+func (s *transactionService) GetTimeline(ctx context.Context, transactionID uuid.UUID) ([]models.TimelineEvent, error) {
+	transaction, err := s.transactionRepo.GetTransactionByID(ctx, transactionID)
+	if err != nil {
+		if _, ok := err.(*repositories.NotFoundError); ok {
+			return nil, &NotFoundError{Message: "transaction not found"}
+		}
+		return nil, err
+	}
+
+	related, err := s.transactionRepo.GetRelatedTransactions(ctx, transaction)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load related transactions: %w", err)
+	}
+
+	timeline := make([]models.TimelineEvent, 0, len(related))
+	for _, t := range related {
+		event := models.TimelineEvent{
+			Type:                 string(t.Type),
+			OccurredAt:           t.CreatedAt,
+			TransactionID:        t.ID,
+			GatewayTransactionID: t.GatewayTransactionID,
+			Amount:               t.Amount,
+			Currency:             t.Currency,
+			Status:               t.Status,
+		}
+		if t.ParentTransactionID.Valid {
+			parentID := t.ParentTransactionID.UUID
+			event.ParentTransactionID = &parentID
+		}
+		timeline = append(timeline, event)
+
+		disputes, err := s.disputeRepo.GetDisputesByTransactionID(ctx, t.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load disputes: %w", err)
+		}
+		for _, dispute := range disputes {
+			disputeID := dispute.ID
+			timeline = append(timeline, models.TimelineEvent{
+				Type:          "dispute",
+				OccurredAt:    dispute.CreatedAt,
+				TransactionID: t.ID,
+				DisputeID:     &disputeID,
+				Amount:        dispute.Amount,
+				Currency:      dispute.Currency,
+				Status:        dispute.Status,
+				Reason:        dispute.Reason,
+			})
+		}
+	}
+
+	sort.SliceStable(timeline, func(i, j int) bool {
+		return timeline[i].OccurredAt.Before(timeline[j].OccurredAt)
+	})
+
+	return timeline, nil
+}
+
+// GetRefundableTransactions lists the user's payments and captures that still
+// have money left to refund. Refunds are recorded against the gateway order,
+// so when an order has several captures they are set against the oldest
+// capture first.
+func (s *transactionService) GetRefundableTransactions(ctx context.Context, userID uuid.UUID) ([]models.RefundableTransaction, error) {
+	charges, err := s.transactionRepo.GetRefundableCharges(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	orderIDs := make([]string, 0, len(charges))
+	for _, charge := range charges {
+		orderIDs = append(orderIDs, charge.GatewayOrderID)
+	}
+	unallocated, err := s.transactionRepo.GetRefundedAmountsByOrder(ctx, orderIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	refundable := []models.RefundableTransaction{}
+	for _, charge := range charges {
+		refunded := math.Min(unallocated[charge.GatewayOrderID], charge.Amount)
+		unallocated[charge.GatewayOrderID] = roundAmount(unallocated[charge.GatewayOrderID] - refunded)
+
+		remaining := roundAmount(charge.Amount - refunded)
+		if remaining < 0.005 {
+			continue
+		}
+		refundable = append(refundable, models.RefundableTransaction{
+			Transaction:      charge,
+			RefundedAmount:   roundAmount(refunded),
+			RefundableAmount: remaining,
+		})
+	}
+
+	return refundable, nil
+}
+
+// ExportTransactions passes a user's transactions created in [from, to) to
+// write, oldest first, a batch at a time, so an export is never held in
+// memory as a whole. Either bound may be unset.
+func (s *transactionService) ExportTransactions(ctx context.Context, userID uuid.UUID, from, to sql.NullTime, write func([]models.ExportedTransaction) error) error {
+	if from.Valid && to.Valid && !from.Time.Before(to.Time) {
+		return &ValidationError{Field: "to", Message: "must be after from"}
+	}
+
+	var after *models.ExportedTransaction
+	for {
+		batch, err := s.transactionRepo.GetTransactionsForExport(ctx, userID, from, to, after, exportBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := write(batch); err != nil {
+			return err
+		}
+		if len(batch) < exportBatchSize {
+			return nil
+		}
+		after = &batch[len(batch)-1]
+	}
+}
+
+// GetPaymentMethodVolume breaks the user's successful charges down by payment
+// method (card, Google Pay, Apple Pay) and currency
+func (s *transactionService) GetPaymentMethodVolume(ctx context.Context, userID uuid.UUID) ([]models.PaymentMethodVolume, error) {
+	return s.transactionRepo.CountByPaymentMethod(ctx, userID)
+}
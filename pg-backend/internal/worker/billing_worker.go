@@ -2,41 +2,114 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"pg-backend/internal/config"
+	"pg-backend/internal/repositories"
 	"pg-backend/internal/services"
 )
 
 type BillingWorker struct {
 	subscriptionService services.SubscriptionService
 	billingService      services.BillingService
+	locks               repositories.LockRepository
+	breaker             *services.GatewayBreaker
 	cfg                 *config.Config
 	logger              *log.Logger
-	stopChan            chan bool
+
+	// paused skips billing cycles without stopping the ticker
+	paused atomic.Bool
+
+	// cycleMu keeps scheduled and on-demand cycles from overlapping
+	cycleMu sync.Mutex
+
+	mu        sync.Mutex
+	running   bool
+	stopChan  chan bool
+	done      chan struct{}
+	lastRunAt time.Time
+	cycles    int
 }
 
 func NewBillingWorker(
 	subscriptionService services.SubscriptionService,
 	billingService services.BillingService,
+	locks repositories.LockRepository,
+	breaker *services.GatewayBreaker,
 	cfg *config.Config,
 ) *BillingWorker {
 	return &BillingWorker{
 		subscriptionService: subscriptionService,
 		billingService:      billingService,
+		locks:               locks,
+		breaker:             breaker,
 		cfg:                 cfg,
 		logger:              log.New(log.Writer(), "[BILLING-WORKER] ", log.LstdFlags|log.Lshortfile),
-		stopChan:            make(chan bool),
 	}
 }
 
-// Start begins the billing worker with specified interval
+// Start begins the billing worker and blocks until ctx is cancelled or Stop is
+// called. A stopped worker can be started again.
 func (w *BillingWorker) Start(ctx context.Context) error {
+	return w.start(ctx, nil)
+}
+
+// start is Start with an optional channel that is closed once the worker is
+// marked running, so callers can wait for it without waiting on the first cycle
+func (w *BillingWorker) start(ctx context.Context, started chan<- struct{}) error {
+	w.mu.Lock()
+	if w.running {
+		w.mu.Unlock()
+		if started != nil {
+			close(started)
+		}
+		return fmt.Errorf("billing worker is already running")
+	}
+	w.running = true
+	w.stopChan = make(chan bool)
+	w.done = make(chan struct{})
+	stopChan, done := w.stopChan, w.done
+	w.mu.Unlock()
+
+	if started != nil {
+		close(started)
+	}
+
+	defer func() {
+		w.mu.Lock()
+		w.running = false
+		w.stopChan = nil
+		w.mu.Unlock()
+		close(done)
+	}()
+
 	w.logger.Println("Starting billing worker...")
 
-	// Run immediately on startup
+	// Stagger the first cycle, so instances restarted together by a deploy
+	// don't all start billing at the same moment
+	if delay := w.startupDelay(); delay > 0 {
+		w.logger.Printf("Waiting %v before the first billing cycle", delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			w.logger.Println("Stopping billing worker due to context cancellation")
+			return ctx.Err()
+
+		case <-stopChan:
+			timer.Stop()
+			w.logger.Println("Stopping billing worker on request")
+			return nil
+
+		case <-timer.C:
+		}
+	}
 	w.runBillingCycle(ctx)
 
 	// Schedule periodic runs
@@ -49,7 +122,7 @@ func (w *BillingWorker) Start(ctx context.Context) error {
 			w.logger.Println("Stopping billing worker due to context cancellation")
 			return ctx.Err()
 
-		case <-w.stopChan:
+		case <-stopChan:
 			w.logger.Println("Stopping billing worker on request")
 			return nil
 
@@ -59,48 +132,182 @@ func (w *BillingWorker) Start(ctx context.Context) error {
 	}
 }
 
-// Stop gracefully stops the billing worker
+// startupDelay is the configured startup delay plus a random share of the
+// configured jitter
+func (w *BillingWorker) startupDelay() time.Duration {
+	delay := w.cfg.BillingStartupDelay()
+	if jitter := w.cfg.BillingStartupJitter(); jitter > 0 {
+		delay += rand.N(jitter)
+	}
+	return delay
+}
+
+// Stop gracefully stops the billing worker and waits for any in-flight cycle
+// to finish. Stopping a worker that is not running is a no-op.
 func (w *BillingWorker) Stop() {
+	w.mu.Lock()
+	if !w.running || w.stopChan == nil {
+		w.mu.Unlock()
+		return
+	}
 	w.logger.Println("Shutting down billing worker...")
 	close(w.stopChan)
+	w.stopChan = nil
+	done := w.done
+	w.mu.Unlock()
+
+	<-done
+}
+
+// IsRunning reports whether the worker loop is active
+func (w *BillingWorker) IsRunning() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.running
+}
+
+// Name identifies the worker in status output and admin routes
+func (w *BillingWorker) Name() string {
+	return "billing"
+}
+
+// Pause makes the worker skip billing cycles until Resume is called
+func (w *BillingWorker) Pause() {
+	if !w.paused.Swap(true) {
+		w.logger.Println("Billing worker paused")
+	}
+}
+
+// Resume re-enables billing cycles after Pause
+func (w *BillingWorker) Resume() {
+	if w.paused.Swap(false) {
+		w.logger.Println("Billing worker resumed")
+	}
+}
+
+// IsPaused reports whether billing cycles are currently being skipped
+func (w *BillingWorker) IsPaused() bool {
+	return w.paused.Load()
+}
+
+// ErrCycleInProgress is returned by RunCycle when another billing cycle,
+// scheduled or on demand, is still running on this or another instance
+var ErrCycleInProgress = errors.New("a billing cycle is already in progress")
+
+// billingCycleLockKey names the database lock held for a billing cycle
+const billingCycleLockKey int64 = 0x62696c6c696e67 // "billing"
+
+// CycleResult counts the work done by one billing cycle
+type CycleResult struct {
+	DueSubscriptions     int       `json:"due_subscriptions"`
+	PendingAttempts      int       `json:"pending_attempts"`
+	StuckAttempts        int       `json:"stuck_attempts"`
+	Retries              int       `json:"retries"`
+	ExpiredSubscriptions int       `json:"expired_subscriptions"`
+	RenewalReminders     int       `json:"renewal_reminders"`
+	GatewayUnavailable   bool      `json:"gateway_unavailable,omitempty"` // charges skipped while the gateway breaker is open
+	Errors               []string  `json:"errors,omitempty"`
+	StartedAt            time.Time `json:"started_at"`
+	Duration             string    `json:"duration"`
+}
+
+// RunCycle runs a single billing cycle immediately, even while the worker is
+// paused or stopped. It fails with ErrCycleInProgress instead of overlapping
+// a cycle that is already running.
+func (w *BillingWorker) RunCycle(ctx context.Context) (*CycleResult, error) {
+	return w.runCycle(ctx)
 }
 
-// runBillingCycle executes all billing tasks
+// runBillingCycle executes all billing tasks on the worker's schedule
 func (w *BillingWorker) runBillingCycle(ctx context.Context) {
+	if w.paused.Load() {
+		w.logger.Println("Billing worker is paused, skipping billing cycle")
+		return
+	}
+
+	if _, err := w.runCycle(ctx); err == ErrCycleInProgress {
+		w.logger.Println("Previous billing cycle still running, skipping billing cycle")
+	} else if err != nil {
+		w.logger.Printf("Skipping billing cycle: %v", err)
+	}
+}
+
+func (w *BillingWorker) runCycle(ctx context.Context) (*CycleResult, error) {
+	if !w.cycleMu.TryLock() {
+		return nil, ErrCycleInProgress
+	}
+	defer w.cycleMu.Unlock()
+
+	// Other instances share the database, so only one of them bills at a time
+	if w.locks != nil && w.cfg.BillingCycleLock() {
+		unlock, acquired, err := w.locks.TryLock(ctx, billingCycleLockKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to take billing cycle lock: %w", err)
+		}
+		if !acquired {
+			return nil, ErrCycleInProgress
+		}
+		defer unlock()
+	}
+
 	startTime := time.Now()
 	w.logger.Println("Starting billing cycle at", startTime.Format("2006-01-02 15:04:05"))
 
+	result := &CycleResult{StartedAt: startTime}
+
 	// Execute tasks sequentially
 	tasks := []struct {
-		name string
-		fn   func(context.Context) (int, error)
+		name    string
+		fn      func(context.Context) (int, error)
+		count   *int
+		charges bool // calls the gateway
 	}{
-		{"Process Due Subscriptions", w.processDueSubscriptions},
-		{"Process Pending Billing Attempts", w.processPendingBillingAttempts},
-		{"Retry Failed Payments", w.retryFailedPayments},
+		{"Process Due Subscriptions", w.processDueSubscriptions, &result.DueSubscriptions, true},
+		{"Release Stuck Billing Attempts", w.releaseStuckBillingAttempts, &result.StuckAttempts, false},
+		{"Process Pending Billing Attempts", w.processPendingBillingAttempts, &result.PendingAttempts, true},
+		{"Retry Failed Payments", w.retryFailedPayments, &result.Retries, false},
+		{"Expire Incomplete Subscriptions", w.expireIncompleteSubscriptions, &result.ExpiredSubscriptions, false},
+		{"Send Renewal Reminders", w.sendRenewalReminders, &result.RenewalReminders, false},
 	}
 
 	totalProcessed := 0
 	for _, task := range tasks {
+		// Back off while the gateway is down; the work stays due for a later cycle
+		if task.charges && w.breaker.IsOpen() {
+			w.logger.Printf("Gateway circuit breaker is open, skipping %s", task.name)
+			result.GatewayUnavailable = true
+			continue
+		}
+
 		processed, err := task.fn(ctx)
 		if err != nil {
 			w.logger.Printf("Error in task %s: %v", task.name, err)
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", task.name, err))
 		} else {
 			w.logger.Printf("%s: Processed %d items", task.name, processed)
+			*task.count = processed
 			totalProcessed += processed
 		}
 	}
 
+	w.mu.Lock()
+	w.lastRunAt = startTime
+	w.cycles++
+	w.mu.Unlock()
+
 	duration := time.Since(startTime)
+	result.Duration = duration.String()
 	w.logger.Printf("Billing cycle completed in %v. Total processed: %d\n", duration, totalProcessed)
+
+	return result, nil
 }
 
 // processDueSubscriptions finds and processes subscriptions due for billing
 func (w *BillingWorker) processDueSubscriptions(ctx context.Context) (int, error) {
 	w.logger.Println("Processing due subscriptions...")
 
-	// Process up to 100 subscriptions at a time
-	processed, err := w.subscriptionService.ProcessDueSubscriptions(ctx, 100)
+	// Process up to BillingDueBatchSize subscriptions at a time
+	processed, err := w.subscriptionService.ProcessDueSubscriptions(ctx, w.cfg.BillingDueBatchSize())
 	if err != nil {
 		return 0, fmt.Errorf("failed to process due subscriptions: %w", err)
 	}
@@ -129,12 +336,27 @@ func (w *BillingWorker) processPendingBillingAttempts(ctx context.Context) (int,
 	return processed, nil
 }
 
+// releaseStuckBillingAttempts requeues attempts a crashed worker left in
+// processing, ahead of processing the pending ones
+func (w *BillingWorker) releaseStuckBillingAttempts(ctx context.Context) (int, error) {
+	released, err := w.billingService.ReleaseStuckBillingAttempts(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if released > 0 {
+		w.logger.Printf("Released %d billing attempts stuck in processing", released)
+	}
+
+	return released, nil
+}
+
 // retryFailedPayments retries failed billing attempts with exponential backoff
 func (w *BillingWorker) retryFailedPayments(ctx context.Context) (int, error) {
 	w.logger.Println("Retrying failed payments...")
 
-	// Retry failed attempts (max 3 attempts total)
-	retried, err := w.subscriptionService.RetryFailedBilling(ctx, 3)
+	// Retry failed attempts on the configured dunning schedule
+	retried, err := w.subscriptionService.RetryFailedBilling(ctx, w.cfg.BillingRetrySchedule())
 	if err != nil {
 		return 0, fmt.Errorf("failed to retry failed payments: %w", err)
 	}
@@ -146,10 +368,58 @@ func (w *BillingWorker) retryFailedPayments(ctx context.Context) (int, error) {
 	return retried, nil
 }
 
+// expireIncompleteSubscriptions expires new subscriptions whose first
+// payment never succeeded
+func (w *BillingWorker) expireIncompleteSubscriptions(ctx context.Context) (int, error) {
+	expired, err := w.subscriptionService.ExpireIncompleteSubscriptions(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if expired > 0 {
+		w.logger.Printf("Expired %d incomplete subscriptions", expired)
+	}
+
+	return expired, nil
+}
+
+// sendRenewalReminders tells customers on long billing intervals that their
+// subscription renews soon
+func (w *BillingWorker) sendRenewalReminders(ctx context.Context) (int, error) {
+	sent, err := w.subscriptionService.SendRenewalReminders(ctx, w.cfg.BillingDueBatchSize())
+	if err != nil {
+		return 0, err
+	}
+
+	if sent > 0 {
+		w.logger.Printf("Sent %d renewal reminders", sent)
+	}
+
+	return sent, nil
+}
+
 // HealthCheck returns worker status
 func (w *BillingWorker) HealthCheck() map[string]interface{} {
-	return map[string]interface{}{
-		"status":    "running",
-		"timestamp": time.Now().Format(time.RFC3339),
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	status := "stopped"
+	if w.running {
+		status = "running"
+	}
+
+	health := map[string]interface{}{
+		"name":             w.Name(),
+		"status":           status,
+		"paused":           w.paused.Load(),
+		"cycles_completed": w.cycles,
+		"timestamp":        time.Now().Format(time.RFC3339),
+	}
+	if !w.lastRunAt.IsZero() {
+		health["last_run_at"] = w.lastRunAt.Format(time.RFC3339)
+	}
+	if w.breaker != nil {
+		health["gateway_breaker"] = w.breaker.State()
 	}
+	return health
 }
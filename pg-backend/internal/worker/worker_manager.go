@@ -2,25 +2,22 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
-	"time"
 )
 
 type WorkerManager struct {
 	workers []*BillingWorker
 	wg      sync.WaitGroup
-	ctx     context.Context
+	mu      sync.Mutex
 	cancel  context.CancelFunc
 }
 
 func NewWorkerManager() *WorkerManager {
-	ctx, cancel := context.WithCancel(context.Background())
 	return &WorkerManager{
 		workers: make([]*BillingWorker, 0),
-		ctx:     ctx,
-		cancel:  cancel,
 	}
 }
 
@@ -29,33 +26,77 @@ func (m *WorkerManager) RegisterWorker(worker *BillingWorker) {
 	m.workers = append(m.workers, worker)
 }
 
+// GetWorker returns the registered worker with the given name
+func (m *WorkerManager) GetWorker(name string) (*BillingWorker, bool) {
+	for _, worker := range m.workers {
+		if worker.Name() == name {
+			return worker, true
+		}
+	}
+	return nil, false
+}
+
 // StartAll starts all registered workers
 func (m *WorkerManager) StartAll() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.startAll()
+}
+
+// StopAll gracefully stops all workers and waits for them to exit
+func (m *WorkerManager) StopAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stopAll()
+}
+
+// RestartWorkers stops every registered worker, waits for in-flight cycles to
+// finish, starts them again and returns their new status
+func (m *WorkerManager) RestartWorkers() (map[string]interface{}, error) {
+	m.mu.Lock()
+	m.stopAll()
+	err := m.startAll()
+	m.mu.Unlock()
+
+	return m.GetWorkerStatus(), err
+}
+
+func (m *WorkerManager) startAll() error {
+	if m.cancel != nil {
+		return fmt.Errorf("workers are already running")
+	}
+
+	// Each run gets a fresh context so workers can be started again after StopAll
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+
 	for _, worker := range m.workers {
+		started := make(chan struct{})
 		m.wg.Add(1)
 		go func(w *BillingWorker) {
 			defer m.wg.Done()
-			if err := w.Start(m.ctx); err != nil {
+			if err := w.start(ctx, started); err != nil && !errors.Is(err, context.Canceled) {
 				// Log error but continue with other workers
 				log.Printf("Worker error: %v", err)
 			}
 		}(worker)
+		<-started
 	}
 
 	return nil
 }
 
-// StopAll gracefully stops all workers
-func (m *WorkerManager) StopAll() {
-	m.cancel()
-	m.wg.Wait()
-
-	// Give workers time to clean up
-	time.Sleep(2 * time.Second)
+func (m *WorkerManager) stopAll() {
+	if m.cancel == nil {
+		return
+	}
 
+	m.cancel()
 	for _, worker := range m.workers {
 		worker.Stop()
 	}
+	m.wg.Wait()
+	m.cancel = nil
 }
 
 // GetWorkerStatus returns status of all workers
@@ -0,0 +1,88 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"pg-backend/internal/config"
+	"pg-backend/internal/services"
+)
+
+// countingSubscriptionService reports each billing cycle's due-subscription
+// pass on cycles and has nothing to bill
+type countingSubscriptionService struct {
+	services.SubscriptionService
+	cycles chan struct{}
+}
+
+func (s *countingSubscriptionService) ProcessDueSubscriptions(ctx context.Context, limit int) (int, error) {
+	s.cycles <- struct{}{}
+	return 0, nil
+}
+
+func (s *countingSubscriptionService) RetryFailedBilling(ctx context.Context, schedule []time.Duration) (int, error) {
+	return 0, nil
+}
+
+func (s *countingSubscriptionService) ExpireIncompleteSubscriptions(ctx context.Context) (int, error) {
+	return 0, nil
+}
+
+func (s *countingSubscriptionService) SendRenewalReminders(ctx context.Context, limit int) (int, error) {
+	return 0, nil
+}
+
+type idleBillingService struct {
+	services.BillingService
+}
+
+func (idleBillingService) ProcessPendingBillingAttempts(ctx context.Context, limit int) (int, error) {
+	return 0, nil
+}
+
+func (idleBillingService) ReleaseStuckBillingAttempts(ctx context.Context) (int, error) {
+	return 0, nil
+}
+
+// waitForCycle fails t unless a billing cycle starts within a few seconds
+func waitForCycle(t *testing.T, cycles <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-cycles:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no billing cycle ran")
+	}
+}
+
+func TestRestartWorkersRunsACycle(t *testing.T) {
+	t.Setenv("BILLING_STARTUP_DELAY", "0")
+	t.Setenv("BILLING_STARTUP_JITTER", "0")
+
+	subscriptions := &countingSubscriptionService{cycles: make(chan struct{}, 1)}
+	w := NewBillingWorker(subscriptions, idleBillingService{}, nil, nil, &config.Config{})
+
+	manager := NewWorkerManager()
+	manager.RegisterWorker(w)
+	if err := manager.StartAll(); err != nil {
+		t.Fatalf("StartAll: %v", err)
+	}
+	t.Cleanup(manager.StopAll)
+	waitForCycle(t, subscriptions.cycles)
+
+	if _, err := manager.RestartWorkers(); err != nil {
+		t.Fatalf("RestartWorkers: %v", err)
+	}
+	if !w.IsRunning() {
+		t.Fatal("worker is not running after a restart")
+	}
+	waitForCycle(t, subscriptions.cycles)
+
+	manager.StopAll()
+	if w.IsRunning() {
+		t.Error("worker is still running after StopAll")
+	}
+	if cycles := w.HealthCheck()["cycles_completed"]; cycles != 2 {
+		t.Errorf("cycles_completed = %v, want 2", cycles)
+	}
+}
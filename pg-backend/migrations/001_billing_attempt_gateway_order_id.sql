@@ -0,0 +1,4 @@
+-- Gateway order submitted for a billing attempt, so attempts whose outcome
+-- was unknown (timeouts, dropped connections) can be reconciled with
+-- RetrieveOrder before any new charge is made.
+ALTER TABLE billing_attempts ADD COLUMN IF NOT EXISTS gateway_order_id VARCHAR(64);
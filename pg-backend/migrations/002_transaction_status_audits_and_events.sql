@@ -0,0 +1,26 @@
+-- Audit trail for manual transaction status overrides
+CREATE TABLE IF NOT EXISTS transaction_status_audits (
+    id             UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    transaction_id UUID NOT NULL REFERENCES transactions(id),
+    from_status    VARCHAR(50) NOT NULL,
+    to_status      VARCHAR(50) NOT NULL,
+    changed_by     VARCHAR(255) NOT NULL,
+    reason         TEXT NOT NULL,
+    created_at     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_transaction_status_audits_transaction_id
+    ON transaction_status_audits (transaction_id);
+
+-- Domain events consumed by webhooks and reporting
+CREATE TABLE IF NOT EXISTS events (
+    id            UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    type          VARCHAR(100) NOT NULL,
+    resource_type VARCHAR(50) NOT NULL,
+    resource_id   UUID NOT NULL,
+    data          JSONB NOT NULL DEFAULT '{}',
+    created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_events_resource ON events (resource_type, resource_id);
+CREATE INDEX IF NOT EXISTS idx_events_created_at ON events (created_at);
@@ -0,0 +1,6 @@
+-- Merchant's own order number, sent to the gateway as order.reference so
+-- payments can be found in the merchant portal and looked up here.
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS merchant_reference VARCHAR(40);
+
+CREATE INDEX IF NOT EXISTS idx_transactions_merchant_reference
+    ON transactions (merchant_reference);
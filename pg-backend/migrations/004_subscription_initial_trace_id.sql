@@ -0,0 +1,3 @@
+-- Scheme trace ID of a subscription's first successful charge, sent as
+-- transaction.acquirer.traceId on later merchant-initiated recurring charges.
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS initial_trace_id VARCHAR(64);
@@ -0,0 +1,3 @@
+-- Scheme reference (authorizationResponse.transactionIdentifier) of the
+-- transaction that first stored the card, quoted on later card-on-file charges.
+ALTER TABLE cards ADD COLUMN IF NOT EXISTS stored_credential_reference VARCHAR(64);
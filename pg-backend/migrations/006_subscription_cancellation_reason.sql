@@ -0,0 +1,6 @@
+-- Why a customer cancelled, captured by POST /subscriptions/:id/cancel
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS cancellation_reason VARCHAR(50);
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS cancellation_comment TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_subscriptions_cancellation_reason
+    ON subscriptions (cancellation_reason) WHERE cancellation_reason IS NOT NULL;
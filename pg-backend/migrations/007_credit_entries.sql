@@ -0,0 +1,20 @@
+-- Account credit ledger. Granted credit is positive, credit applied to a
+-- billing attempt is negative; a user's balance is the sum of unreleased
+-- entries per currency. Applied credit is released if the charge fails.
+CREATE TABLE IF NOT EXISTS credit_entries (
+    id                 UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id            UUID NOT NULL REFERENCES users(id),
+    amount             DECIMAL(10, 2) NOT NULL,
+    currency           VARCHAR(3) NOT NULL,
+    type               VARCHAR(20) NOT NULL,
+    description        TEXT,
+    subscription_id    UUID REFERENCES subscriptions(id),
+    billing_attempt_id UUID REFERENCES billing_attempts(id),
+    released_at        TIMESTAMP,
+    created_at         TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_credit_entries_user_currency
+    ON credit_entries (user_id, currency) WHERE released_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_credit_entries_billing_attempt_id
+    ON credit_entries (billing_attempt_id);
@@ -0,0 +1,4 @@
+-- Per-plan text for the cardholder's statement on subscription charges.
+-- NULL means the merchant default is used.
+ALTER TABLE plans
+    ADD COLUMN IF NOT EXISTS statement_descriptor VARCHAR(22);
@@ -0,0 +1,4 @@
+-- Redacted gateway payload for the call that created each transaction, kept
+-- for chargeback and dispute investigations.
+ALTER TABLE transactions
+    ADD COLUMN IF NOT EXISTS gateway_response JSONB;
@@ -0,0 +1,25 @@
+-- Gateway order each transaction belongs to, used to match dispute
+-- notifications to the disputed charge
+ALTER TABLE transactions
+    ADD COLUMN IF NOT EXISTS gateway_order_id VARCHAR(64);
+
+CREATE INDEX IF NOT EXISTS idx_transactions_gateway_order_id
+    ON transactions (gateway_order_id);
+
+-- Chargebacks raised against transactions, kept in sync from gateway
+-- dispute notifications
+CREATE TABLE IF NOT EXISTS disputes (
+    id                 UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    transaction_id     UUID NOT NULL REFERENCES transactions(id),
+    gateway_dispute_id VARCHAR(100) NOT NULL UNIQUE,
+    reason             TEXT,
+    status             VARCHAR(20) NOT NULL,
+    amount             DECIMAL(10, 2) NOT NULL,
+    currency           VARCHAR(3) NOT NULL,
+    evidence_due       TIMESTAMP,
+    created_at         TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at         TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_disputes_transaction_id ON disputes (transaction_id);
+CREATE INDEX IF NOT EXISTS idx_disputes_status ON disputes (status, updated_at);
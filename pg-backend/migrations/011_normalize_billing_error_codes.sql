@@ -0,0 +1,24 @@
+-- Failed billing attempts now store canonical decline codes; convert codes
+-- recorded straight from the gateway so retry filtering treats them alike.
+UPDATE billing_attempts
+SET error_code = CASE error_code
+        WHEN 'DECLINED'                THEN 'card_declined'
+        WHEN 'DECLINED_AVS'            THEN 'card_declined'
+        WHEN 'DECLINED_CSC'            THEN 'card_declined'
+        WHEN 'DECLINED_AVS_CSC'        THEN 'card_declined'
+        WHEN 'DECLINED_PAYMENT_PLAN'   THEN 'card_declined'
+        WHEN 'DECLINED_DO_NOT_CONTACT' THEN 'do_not_contact'
+        WHEN 'INSUFFICIENT_FUNDS'      THEN 'insufficient_funds'
+        WHEN 'EXPIRED_CARD'            THEN 'expired_card'
+        WHEN 'INVALID_CSC'             THEN 'invalid_card'
+        WHEN 'BLOCKED'                 THEN 'blocked'
+        WHEN 'REFERRED'                THEN 'referred'
+        WHEN 'TIMED_OUT'               THEN 'timed_out'
+        WHEN 'ACQUIRER_SYSTEM_ERROR'   THEN 'system_error'
+        WHEN 'SYSTEM_ERROR'            THEN 'system_error'
+        WHEN 'UNSPECIFIED_FAILURE'     THEN 'unspecified_failure'
+        WHEN 'UNKNOWN'                 THEN 'unspecified_failure'
+        ELSE LOWER(error_code)
+    END
+WHERE error_code IS NOT NULL
+  AND error_code <> LOWER(error_code);
@@ -0,0 +1,7 @@
+-- Links captures and voids to the authorization they were made against, so
+-- an authorization can be captured in several parts
+ALTER TABLE transactions
+    ADD COLUMN IF NOT EXISTS parent_transaction_id UUID REFERENCES transactions(id);
+
+CREATE INDEX IF NOT EXISTS idx_transactions_parent_transaction_id
+    ON transactions (parent_transaction_id);
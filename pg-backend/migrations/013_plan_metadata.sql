@@ -0,0 +1,4 @@
+-- Free-form key/value data merchants attach to a plan for their own use,
+-- stored the same way as subscriptions.metadata
+ALTER TABLE plans
+    ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}';
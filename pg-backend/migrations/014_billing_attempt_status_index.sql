@@ -0,0 +1,4 @@
+-- Lets support list billing attempts by status, e.g. every failed charge
+-- across all subscriptions, in schedule order
+CREATE INDEX IF NOT EXISTS idx_billing_attempts_status_scheduled_at
+    ON billing_attempts (status, scheduled_at);
@@ -0,0 +1,6 @@
+-- Charges presented to the customer in one currency (transactions.currency)
+-- but settled in another record the settlement amount and the rate used
+ALTER TABLE transactions
+    ADD COLUMN IF NOT EXISTS settlement_currency VARCHAR(3),
+    ADD COLUMN IF NOT EXISTS settlement_amount DECIMAL(12, 3),
+    ADD COLUMN IF NOT EXISTS fx_rate NUMERIC(18, 8);
@@ -0,0 +1,5 @@
+-- Marketplace charges record the route their metadata selected and the
+-- statement descriptor it applied
+ALTER TABLE transactions
+    ADD COLUMN IF NOT EXISTS routing_key VARCHAR(100),
+    ADD COLUMN IF NOT EXISTS statement_descriptor VARCHAR(22);
@@ -0,0 +1,4 @@
+-- 3-D Secure authentication the customer must complete before a billing
+-- attempt in requires_action can be charged. Attempts parked for
+-- reconciliation leave it NULL.
+ALTER TABLE billing_attempts ADD COLUMN IF NOT EXISTS challenge_reference VARCHAR(64);
@@ -0,0 +1,5 @@
+-- Correlation ID sent with the gateway request that created a transaction,
+-- and the gateway's own trace ID when it returned one, for support cases
+ALTER TABLE transactions
+    ADD COLUMN IF NOT EXISTS correlation_id VARCHAR(64),
+    ADD COLUMN IF NOT EXISTS gateway_correlation_id VARCHAR(128);
@@ -0,0 +1,15 @@
+-- A user has at most one default card. Concurrent card saves could each
+-- mark their card as the first, so keep the oldest default per user before
+-- enforcing it.
+UPDATE cards c
+SET is_default = false
+WHERE c.is_default
+  AND EXISTS (
+      SELECT 1 FROM cards older
+      WHERE older.user_id = c.user_id
+        AND older.is_default
+        AND (older.created_at, older.id) < (c.created_at, c.id)
+  );
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_cards_one_default_per_user
+    ON cards (user_id) WHERE is_default;
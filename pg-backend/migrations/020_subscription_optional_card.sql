@@ -0,0 +1,3 @@
+-- A trial can start before the customer adds a card, see
+-- POST /subscriptions without card_id
+ALTER TABLE subscriptions ALTER COLUMN card_id DROP NOT NULL;
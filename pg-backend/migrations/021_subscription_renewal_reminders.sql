@@ -0,0 +1,3 @@
+-- When the customer was last reminded of an upcoming renewal, so each
+-- renewal is announced once
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS renewal_reminded_at TIMESTAMP;
@@ -0,0 +1,7 @@
+-- When a billing attempt last moved to processing, so attempts left there by
+-- a crashed worker can be found and released
+ALTER TABLE billing_attempts ADD COLUMN IF NOT EXISTS processing_started_at TIMESTAMP;
+
+UPDATE billing_attempts
+SET processing_started_at = COALESCE(processed_at, created_at)
+WHERE status = 'processing' AND processing_started_at IS NULL;